@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/appliedres/cloudy/keyvalue"
+	"github.com/urfave/cli/v2"
+)
+
+// Edits encrypted secrets files created by keyvalue.EncryptedFileKeyValueStore
+//
+//	cloudy-secrets keygen -o ~/.arkloud/secrets.key
+//	cloudy-secrets -f secrets.json set db.password
+//	cloudy-secrets -f secrets.json edit
+func main() {
+	app := &cli.App{
+		Name:  "cloudy-secrets",
+		Usage: "Manage encrypted secrets files",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "secrets file",
+				EnvVars: []string{"CLOUDY_SECRETS_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "key-file",
+				Aliases: []string{"k"},
+				Usage:   "private key file, defaults to $" + keyvalue.SecretsKeyEnv + " or $" + keyvalue.SecretsKeyFileEnv,
+			},
+		},
+		Commands: []*cli.Command{
+			keygenCmd(),
+			listCmd(),
+			getCmd(),
+			setCmd(),
+			deleteCmd(),
+			editCmd(),
+			recipientsCmd(),
+			addRecipientCmd(),
+			removeRecipientCmd(),
+			rotateCmd(),
+		},
+		EnableBashCompletion: true,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR : %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func openStore(c *cli.Context) (*keyvalue.EncryptedFileKeyValueStore, error) {
+	filename := c.String("file")
+	if filename == "" {
+		return nil, fmt.Errorf("no secrets file, use --file")
+	}
+	key, err := keyvalue.LoadSecretsKey(c.String("key-file"))
+	if err != nil {
+		return nil, err
+	}
+	return keyvalue.NewEncryptedFileKeyValueStore(filename, key)
+}
+
+func keygenCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "keygen",
+		Usage: "keygen [-o <key file>] prints or saves a new private key and prints its public key",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			private, public, err := keyvalue.GenerateSecretsKey()
+			if err != nil {
+				return err
+			}
+
+			out := c.String("output")
+			if out == "" {
+				fmt.Printf("private: %v\n", private)
+			} else {
+				if err := os.MkdirAll(filepath.Dir(out), 0700); err != nil {
+					return err
+				}
+				f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					return err
+				}
+				_, err = fmt.Fprintln(f, private)
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					return err
+				}
+			}
+			fmt.Printf("public: %v\n", public)
+			return nil
+		},
+	}
+}
+
+func listCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "list",
+		Usage: "list prints the keys, never the values",
+		Action: func(c *cli.Context) error {
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			all, err := store.GetAll()
+			if err != nil {
+				return err
+			}
+			for _, k := range sortedKeys(all) {
+				fmt.Println(k)
+			}
+			return nil
+		},
+	}
+}
+
+func getCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "get",
+		Usage:     "get <key> prints the value",
+		ArgsUsage: "<key>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("usage: get <key>")
+			}
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			v, err := store.Get(c.Args().First())
+			if err != nil {
+				return err
+			}
+			fmt.Println(v)
+			return nil
+		},
+	}
+}
+
+func setCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "set",
+		Usage:     "set <key> [value] sets the value, read from stdin when not given so it stays out of the shell history",
+		ArgsUsage: "<key> [value]",
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 || c.NArg() > 2 {
+				return fmt.Errorf("usage: set <key> [value]")
+			}
+			value := c.Args().Get(1)
+			if c.NArg() == 1 {
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+				value = strings.TrimRight(string(data), "\r\n")
+			}
+
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			return store.Set(c.Args().First(), value)
+		},
+	}
+}
+
+func deleteCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "delete",
+		Usage:     "delete <key> removes the value",
+		ArgsUsage: "<key>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("usage: delete <key>")
+			}
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			return store.Delete(c.Args().First())
+		},
+	}
+}
+
+func editCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "edit",
+		Usage: "edit opens the decrypted values in $EDITOR and saves them when the editor exits",
+		Action: func(c *cli.Context) error {
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			all, err := store.GetAll()
+			if err != nil {
+				return err
+			}
+			return editValues(store, all)
+		},
+	}
+}
+
+// editValues writes the plaintext to a private temp file, runs the editor and
+// stores the result. The temp file is overwritten before it is removed.
+func editValues(store *keyvalue.EncryptedFileKeyValueStore, values map[string]string) error {
+	dir, err := os.MkdirTemp("", "cloudy-secrets-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "secrets.json")
+	original, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, original, 0600); err != nil {
+		return err
+	}
+	defer wipe(filename)
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], filename)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed, nothing was saved: %w", err)
+	}
+
+	edited, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, original) {
+		fmt.Println("No changes")
+		return nil
+	}
+
+	var updated map[string]string
+	if err := json.Unmarshal(edited, &updated); err != nil {
+		return fmt.Errorf("invalid JSON, nothing was saved: %w", err)
+	}
+	if reflect.DeepEqual(updated, values) {
+		fmt.Println("No changes")
+		return nil
+	}
+	return store.Replace(updated)
+}
+
+// wipe overwrites the file before removing it
+func wipe(filename string) {
+	info, err := os.Stat(filename)
+	if err == nil {
+		_ = os.WriteFile(filename, make([]byte, info.Size()), 0600)
+	}
+	_ = os.Remove(filename)
+}
+
+func recipientsCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "recipients",
+		Usage: "recipients lists the public keys that can open the file",
+		Action: func(c *cli.Context) error {
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			recipients := store.Recipients()
+			for _, id := range sortedKeys(recipients) {
+				fmt.Printf("%v %v\n", id, recipients[id])
+			}
+			return nil
+		},
+	}
+}
+
+func addRecipientCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "add-recipient",
+		Usage:     "add-recipient <public key> lets the matching private key open the file",
+		ArgsUsage: "<public key>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("usage: add-recipient <public key>")
+			}
+			public, err := base64.StdEncoding.DecodeString(c.Args().First())
+			if err != nil {
+				return keyvalue.ErrInvalidSecretsKey
+			}
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			if err := store.AddRecipient(public); err != nil {
+				return err
+			}
+			fmt.Printf("added %v\n", keyvalue.SecretsKeyID(public))
+			return nil
+		},
+	}
+}
+
+func removeRecipientCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "remove-recipient",
+		Usage:     "remove-recipient <id> removes a key and rotates the data key",
+		ArgsUsage: "<id>",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("usage: remove-recipient <id>")
+			}
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			return store.RemoveRecipient(c.Args().First())
+		},
+	}
+}
+
+func rotateCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "rotate",
+		Usage: "rotate encrypts every value with a new data key",
+		Action: func(c *cli.Context) error {
+			store, err := openStore(c)
+			if err != nil {
+				return err
+			}
+			return store.RotateDataKey()
+		},
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
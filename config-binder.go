@@ -0,0 +1,546 @@
+package cloudy
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedactedValue replaces secret values in binding reports
+const RedactedValue = "********"
+
+var (
+	ErrConfigRequired   = errors.New("required configuration value is missing")
+	ErrConfigInvalid    = errors.New("invalid configuration value")
+	ErrConfigOutOfRange = errors.New("configuration value is out of range")
+	ErrConfigNotAllowed = errors.New("configuration value is not one of the allowed values")
+	ErrConfigTarget     = errors.New("configuration target must be a pointer to a struct")
+)
+
+// ConfigSource looks up configuration values for a Binder. The path is the
+// nested field names, e.g. ["database", "host"]; each source maps it to its own
+// key format. The source string names where the value was found.
+type ConfigSource interface {
+	Lookup(path []string) (value string, source string, found bool)
+}
+
+// ByteSize is a number of bytes that binds from values like "512", "64KB" or "1.5GiB"
+type ByteSize int64
+
+// BoundValue records how one field was filled
+type BoundValue struct {
+	Key     string // Dotted path, e.g. "database.port"
+	Field   string // Go field path, e.g. "Database.Port"
+	Value   string // Raw value, RedactedValue when Secret
+	Source  string // Where the value came from, "default" or "" when unset
+	Secret  bool
+	Default bool
+}
+
+// BindResult is the effective configuration after binding
+type BindResult struct {
+	Values []*BoundValue
+}
+
+// Effective returns the bound values by key, with secrets redacted
+func (r *BindResult) Effective() map[string]string {
+	rtn := make(map[string]string, len(r.Values))
+	for _, v := range r.Values {
+		rtn[v.Key] = v.Value
+	}
+	return rtn
+}
+
+// Dump writes the effective configuration and where each value came from.
+// Secrets are redacted.
+func (r *BindResult) Dump(w io.Writer) {
+	for _, v := range r.Values {
+		source := v.Source
+		if source == "" {
+			source = "unset"
+		}
+		fmt.Fprintf(w, "%s = %s (%s)\n", v.Key, v.Value, source)
+	}
+}
+
+// ConfigFieldError is a validation error for one field
+type ConfigFieldError struct {
+	Key   string
+	Field string
+	Err   error
+}
+
+func (e *ConfigFieldError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %v", e.Key, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): %v", e.Key, e.Field, e.Err)
+}
+
+func (e *ConfigFieldError) Unwrap() error {
+	return e.Err
+}
+
+// configTag is the parsed `config` struct tag:
+//
+//	Host     string        `config:"host,required"`
+//	Port     int           `config:"port,default=8080,min=1,max=65535"`
+//	Password string        `config:"password,required,secret"`
+//	Mode     string        `config:"mode,default=dev,enum=dev|test|prod"`
+//	Timeout  time.Duration `config:"timeout,default=30s,max=5m"`
+//	Upload   ByteSize      `config:"upload-limit,alt=max-upload|upload-size,default=10MB"`
+//	Database DatabaseConfig `config:"database"`
+//
+// The name defaults to the field name. Nested structs add their name to the path.
+// Use `config:"-"` to skip a field.
+type configTag struct {
+	name       string
+	alternates []string
+	def        string
+	hasDefault bool
+	required   bool
+	secret     bool
+	size       bool
+	min        string
+	max        string
+	enum       []string
+}
+
+// display returns the value to show in errors, RedactedValue for secrets
+func (tag *configTag) display(value string) string {
+	if tag.secret {
+		return RedactedValue
+	}
+	return value
+}
+
+func parseConfigTag(field reflect.StructField) (*configTag, bool) {
+	raw, hasTag := field.Tag.Lookup("config")
+	if raw == "-" {
+		return nil, false
+	}
+
+	tag := &configTag{name: field.Name}
+	if !hasTag {
+		return tag, true
+	}
+
+	parts := strings.Split(raw, ",")
+	if parts[0] != "" {
+		tag.name = parts[0]
+	}
+	for _, opt := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch key {
+		case "required":
+			tag.required = true
+		case "secret":
+			tag.secret = true
+		case "size":
+			tag.size = true
+		case "default":
+			tag.def = value
+			tag.hasDefault = true
+		case "alt":
+			tag.alternates = strings.Split(value, "|")
+		case "min":
+			tag.min = value
+		case "max":
+			tag.max = value
+		case "enum":
+			tag.enum = strings.Split(value, "|")
+		}
+	}
+	return tag, true
+}
+
+// Bind fills the struct that v points to from the source, using the `config`
+// struct tags. Every field is checked and all the problems are returned together
+// in a MultiErrors of ConfigFieldError. The result records the source of each value.
+func Bind(src ConfigSource, v any, prefix ...string) (*BindResult, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, ErrConfigTarget
+	}
+
+	b := &binder{
+		src:    src,
+		result: &BindResult{},
+		errs:   MultiError(),
+	}
+	b.bindStruct(rv.Elem(), prefix, "")
+	return b.result, b.errs.AsErr()
+}
+
+type binder struct {
+	src    ConfigSource
+	result *BindResult
+	errs   *MultiErrors
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	byteSizeType        = reflect.TypeOf(ByteSize(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (b *binder) bindStruct(rv reflect.Value, path []string, fieldPath string) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := parseConfigTag(field)
+		if !ok {
+			continue
+		}
+
+		fv := rv.Field(i)
+		name := joinFieldPath(fieldPath, field.Name)
+
+		// Nested configuration
+		if isNestedConfig(field.Type) {
+			if field.Type.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					fv.Set(reflect.New(field.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			nested := path
+			if !field.Anonymous || field.Tag.Get("config") != "" {
+				nested = appendPath(path, tag.name)
+			}
+			b.bindStruct(fv, nested, name)
+			continue
+		}
+
+		b.bindField(fv, field, tag, appendPath(path, tag.name), name)
+	}
+}
+
+func (b *binder) bindField(fv reflect.Value, field reflect.StructField, tag *configTag, path []string, fieldName string) {
+	key := strings.Join(path, ".")
+	bound := &BoundValue{Key: key, Field: fieldName, Secret: tag.secret}
+	b.result.Values = append(b.result.Values, bound)
+
+	fail := func(err error) {
+		b.errs.Append(&ConfigFieldError{Key: key, Field: fieldName, Err: err})
+	}
+
+	value, source, found := b.src.Lookup(path)
+	for _, alt := range tag.alternates {
+		if found {
+			break
+		}
+		value, source, found = b.src.Lookup(appendPath(path[:len(path)-1], alt))
+	}
+	if !found && tag.hasDefault {
+		value, source, found = tag.def, "default", true
+		bound.Default = true
+	}
+	if !found {
+		if tag.required {
+			fail(ErrConfigRequired)
+		}
+		return
+	}
+
+	bound.Source = source
+	bound.Value = value
+	if tag.secret {
+		bound.Value = RedactedValue
+	}
+
+	if err := setConfigValue(fv, value, tag); err != nil {
+		fail(err)
+		return
+	}
+	if err := checkConfigValue(fv, value, tag); err != nil {
+		fail(err)
+	}
+}
+
+func isNestedConfig(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	// Structs that parse themselves are values, e.g. time.Time
+	return !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+func appendPath(path []string, name string) []string {
+	rtn := make([]string, 0, len(path)+1)
+	rtn = append(rtn, path...)
+	return append(rtn, name)
+}
+
+func joinFieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// setConfigValue parses the value into the field
+func setConfigValue(fv reflect.Value, value string, tag *configTag) error {
+	if fv.Kind() == reflect.Pointer {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := setConfigValue(ptr.Elem(), value, tag); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+
+	trimmed := strings.TrimSpace(value)
+	switch {
+	case fv.Type() == durationType:
+		d, err := time.ParseDuration(trimmed)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a duration", ErrConfigInvalid, tag.display(value))
+		}
+		fv.SetInt(int64(d))
+		return nil
+	case fv.Type() == byteSizeType || (tag.size && isIntKind(fv.Kind())):
+		n, err := ParseByteSize(trimmed)
+		if err != nil {
+			if tag.secret {
+				return fmt.Errorf("%w: %q is not a size", ErrConfigInvalid, RedactedValue)
+			}
+			return err
+		}
+		if fv.OverflowInt(n) {
+			return fmt.Errorf("%w: %q is too large", ErrConfigOutOfRange, tag.display(value))
+		}
+		fv.SetInt(n)
+		return nil
+	case fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType):
+		if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			if tag.secret {
+				// The unmarshaler's error may quote the value
+				return fmt.Errorf("%w: %q is not a valid %v", ErrConfigInvalid, RedactedValue, fv.Type())
+			}
+			return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+		}
+		return nil
+	}
+
+	value = trimmed
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a boolean", ErrConfigInvalid, tag.display(value))
+		}
+		fv.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%w: %q is not an integer", ErrConfigInvalid, tag.display(value))
+		}
+		fv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%w: %q is not an unsigned integer", ErrConfigInvalid, tag.display(value))
+		}
+		fv.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a number", ErrConfigInvalid, tag.display(value))
+		}
+		fv.SetFloat(v)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("%w: unsupported type %v", ErrConfigInvalid, fv.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(items), len(items))
+		for i, item := range items {
+			slice.Index(i).SetString(item)
+		}
+		fv.Set(slice)
+	default:
+		return fmt.Errorf("%w: unsupported type %v", ErrConfigInvalid, fv.Type())
+	}
+	return nil
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+// checkConfigValue applies the min, max and enum rules. Numbers compare by
+// value, strings and lists by length.
+func checkConfigValue(fv reflect.Value, raw string, tag *configTag) error {
+	if fv.Kind() == reflect.Pointer {
+		fv = fv.Elem()
+	}
+
+	if len(tag.enum) > 0 {
+		allowed := false
+		for _, e := range tag.enum {
+			if strings.EqualFold(strings.TrimSpace(raw), e) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %q, expected one of %s", ErrConfigNotAllowed, tag.display(raw), strings.Join(tag.enum, ", "))
+		}
+	}
+
+	if tag.min == "" && tag.max == "" {
+		return nil
+	}
+
+	var actual float64
+	parseLimit := func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	}
+
+	switch {
+	case fv.Type() == durationType:
+		actual = float64(fv.Int())
+		parseLimit = func(s string) (float64, error) {
+			d, err := time.ParseDuration(s)
+			return float64(d), err
+		}
+	case fv.Type() == byteSizeType || (tag.size && isIntKind(fv.Kind())):
+		actual = float64(fv.Int())
+		parseLimit = func(s string) (float64, error) {
+			n, err := ParseByteSize(s)
+			return float64(n), err
+		}
+	case isIntKind(fv.Kind()):
+		actual = float64(fv.Int())
+	case fv.Kind() >= reflect.Uint && fv.Kind() <= reflect.Uint64:
+		actual = float64(fv.Uint())
+	case fv.Kind() == reflect.Float32 || fv.Kind() == reflect.Float64:
+		actual = fv.Float()
+	case fv.Kind() == reflect.String || fv.Kind() == reflect.Slice:
+		actual = float64(fv.Len())
+	default:
+		return nil
+	}
+
+	if tag.min != "" {
+		limit, err := parseLimit(tag.min)
+		if err != nil {
+			return fmt.Errorf("%w: bad min %q", ErrConfigInvalid, tag.min)
+		}
+		if actual < limit {
+			return fmt.Errorf("%w: %q is below the minimum of %s", ErrConfigOutOfRange, tag.display(raw), tag.min)
+		}
+	}
+	if tag.max != "" {
+		limit, err := parseLimit(tag.max)
+		if err != nil {
+			return fmt.Errorf("%w: bad max %q", ErrConfigInvalid, tag.max)
+		}
+		if actual > limit {
+			return fmt.Errorf("%w: %q is above the maximum of %s", ErrConfigOutOfRange, tag.display(raw), tag.max)
+		}
+	}
+	return nil
+}
+
+// ParseByteSize parses sizes like "512", "64K", "64KB", "10MiB" or "1.5G". The
+// units are binary, so "1KB" is 1024 bytes.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := s, ""
+	if i >= 0 {
+		number, unit = s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || number == "" {
+		return 0, fmt.Errorf("%w: %q is not a size", ErrConfigInvalid, s)
+	}
+
+	multipliers := map[string]float64{
+		"": 1, "B": 1,
+		"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+		"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+		"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+		"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+	}
+	m, ok := multipliers[unit]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown size unit %q", ErrConfigInvalid, unit)
+	}
+	return int64(n * m), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (s *ByteSize) UnmarshalText(text []byte) error {
+	n, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = ByteSize(n)
+	return nil
+}
+
+// environmentSource adapts an Environment to a ConfigSource
+type environmentSource struct {
+	env *Environment
+}
+
+// EnvironmentConfigSource looks up values in the environment, using the
+// environment naming, e.g. ["database", "host"] reads DATABASE_HOST.
+func EnvironmentConfigSource(env *Environment) ConfigSource {
+	return &environmentSource{env: env}
+}
+
+func (s *environmentSource) Lookup(path []string) (string, string, bool) {
+	name := EnvJoin(path...)
+	v := s.env.Get(name)
+	if v == "" {
+		return "", "", false
+	}
+
+	return v, fmt.Sprintf("env:%T", environmentTier(s.env.EnvSvc, name)), true
+}
+
+// environmentTier returns the service that provides a name, looking through
+// segments and tiered environments
+func environmentTier(svc EnvironmentService, name string) EnvironmentService {
+	switch env := svc.(type) {
+	case *HierarchicalEnvironment:
+		return environmentTier(env.environ, EnvJoin(env.prefix, NormalizeEnvName(name)))
+	case *TieredEnvironment:
+		for _, tier := range env.Sources {
+			if _, err := tier.Get(name); err == nil {
+				return environmentTier(tier, name)
+			}
+		}
+	}
+	return svc
+}
+
+// Bind fills the struct from the environment, see Bind
+func (env *Environment) Bind(v any) (*BindResult, error) {
+	return Bind(EnvironmentConfigSource(env), v)
+}
@@ -0,0 +1,156 @@
+package cloudy
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDatabaseConfig struct {
+	Host     string `config:"host,required"`
+	Port     int    `config:"port,default=5432,min=1,max=65535"`
+	Password string `config:"password,required,secret"`
+}
+
+type testServiceConfig struct {
+	Mode     string             `config:"mode,default=dev,enum=dev|test|prod"`
+	Timeout  time.Duration      `config:"timeout,default=30s,max=5m"`
+	Upload   ByteSize           `config:"upload-limit,alt=max-upload,default=10MB"`
+	Cache    int64              `config:"cache,size"`
+	Debug    bool               `config:"debug"`
+	Ratio    *float64           `config:"ratio"`
+	Tags     []string           `config:"tags"`
+	Ignored  string             `config:"-"`
+	Database testDatabaseConfig `config:"database"`
+}
+
+func TestBind(t *testing.T) {
+	envSvc := NewMapEnvironment()
+	envSvc.Set("DATABASE_HOST", "db.local")
+	envSvc.Set("DATABASE_PASSWORD", "hunter2")
+	envSvc.Set("MAX_UPLOAD", "1.5GiB")
+	envSvc.Set("CACHE", "64K")
+	envSvc.Set("DEBUG", "true")
+	envSvc.Set("RATIO", "0.25")
+	envSvc.Set("TAGS", "a, b,,c")
+	env := NewEnvironment(NewTieredEnvironment(NewMapEnvironment(), envSvc))
+
+	cfg := &testServiceConfig{Ignored: "keep"}
+	result, err := env.Bind(cfg)
+	require.Nil(t, err)
+
+	assert.Equal(t, "dev", cfg.Mode)
+	assert.Equal(t, 30*time.Second, cfg.Timeout)
+	assert.Equal(t, ByteSize(3<<29), cfg.Upload)
+	assert.Equal(t, int64(64<<10), cfg.Cache)
+	assert.True(t, cfg.Debug)
+	require.NotNil(t, cfg.Ratio)
+	assert.Equal(t, 0.25, *cfg.Ratio)
+	assert.Equal(t, []string{"a", "b", "c"}, cfg.Tags)
+	assert.Equal(t, "keep", cfg.Ignored)
+	assert.Equal(t, "db.local", cfg.Database.Host)
+	assert.Equal(t, 5432, cfg.Database.Port)
+	assert.Equal(t, "hunter2", cfg.Database.Password)
+
+	// Secrets never show up in the report
+	effective := result.Effective()
+	assert.Equal(t, RedactedValue, effective["database.password"])
+	assert.Equal(t, "db.local", effective["database.host"])
+
+	var buf bytes.Buffer
+	result.Dump(&buf)
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.Contains(t, buf.String(), "database.port = 5432 (default)")
+	assert.Contains(t, buf.String(), "database.host = db.local (env:*cloudy.MapEnvironment)")
+}
+
+func TestBind_SourceThroughSegments(t *testing.T) {
+	t.Setenv("APP_DATABASE_HOST", "os.local")
+	settings := NewMapEnvironment()
+	settings.Set("APP_DATABASE_PASSWORD", "hunter2")
+	root := NewEnvironment(NewHierarchicalEnvironment(NewTieredEnvironment(NewOsEnvironmentService(), settings)))
+
+	result, err := root.Segment("app").Bind(&testServiceConfig{})
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	result.Dump(&buf)
+	assert.Contains(t, buf.String(), "database.host = os.local (env:*cloudy.SystemEnvironmentVariables)")
+	assert.Contains(t, buf.String(), "(env:*cloudy.MapEnvironment)")
+	assert.NotContains(t, buf.String(), "HierarchicalEnvironment")
+}
+
+func TestBind_Validation(t *testing.T) {
+	envSvc := NewMapEnvironment()
+	envSvc.Set("MODE", "staging")
+	envSvc.Set("TIMEOUT", "10m")
+	envSvc.Set("DATABASE_PORT", "abc")
+	envSvc.Set("UPLOAD_LIMIT", "10XB")
+	env := NewEnvironment(envSvc)
+
+	_, err := env.Bind(&testServiceConfig{})
+	require.NotNil(t, err)
+
+	// Every problem is reported at once
+	var merr *MultiErrors
+	require.True(t, errors.As(err, &merr))
+	assert.Equal(t, 6, merr.Len())
+	assert.ErrorIs(t, err, ErrConfigRequired)
+	assert.ErrorIs(t, err, ErrConfigNotAllowed)
+	assert.ErrorIs(t, err, ErrConfigOutOfRange)
+	assert.ErrorIs(t, err, ErrConfigInvalid)
+
+	_, err = env.Bind(testServiceConfig{})
+	assert.ErrorIs(t, err, ErrConfigTarget)
+}
+
+type testSecretConfig struct {
+	Password string   `config:"password,secret,min=12"`
+	Key      string   `config:"key,secret,enum=alpha|beta"`
+	Pin      int      `config:"pin,secret"`
+	Quota    ByteSize `config:"quota,secret"`
+}
+
+func TestBind_SecretRedacted(t *testing.T) {
+	envSvc := NewMapEnvironment()
+	envSvc.Set("PASSWORD", "hunter2")
+	envSvc.Set("KEY", "gamma-secret")
+	envSvc.Set("PIN", "12ab-secret")
+	envSvc.Set("QUOTA", "10XB-secret")
+	env := NewEnvironment(envSvc)
+
+	_, err := env.Bind(&testSecretConfig{})
+	require.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrConfigOutOfRange)
+	assert.ErrorIs(t, err, ErrConfigNotAllowed)
+	assert.ErrorIs(t, err, ErrConfigInvalid)
+	assert.NotContains(t, err.Error(), "hunter2")
+	assert.NotContains(t, err.Error(), "secret")
+	assert.Contains(t, err.Error(), RedactedValue)
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"512":    512,
+		"1B":     1,
+		"2k":     2048,
+		"64KB":   64 << 10,
+		"10MiB":  10 << 20,
+		"1.5 GB": 3 << 29,
+		"1T":     1 << 40,
+	}
+	for in, expected := range tests {
+		n, err := ParseByteSize(in)
+		require.Nil(t, err, in)
+		assert.Equal(t, expected, n, in)
+	}
+
+	_, err := ParseByteSize("MB")
+	assert.ErrorIs(t, err, ErrConfigInvalid)
+	_, err = ParseByteSize("10 parsecs")
+	assert.ErrorIs(t, err, ErrConfigInvalid)
+}
@@ -0,0 +1,320 @@
+package cloudy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoConfigSchema = errors.New("driver does not publish a configuration schema")
+
+// Configuration key types
+const (
+	ConfigTypeString   = "string"
+	ConfigTypeInt      = "int"
+	ConfigTypeFloat    = "float"
+	ConfigTypeBool     = "bool"
+	ConfigTypeDuration = "duration"
+	ConfigTypeSize     = "size"
+	ConfigTypeList     = "list"
+)
+
+// ConfigKey describes one configuration value of a driver. The name is relative
+// to the driver's environment segment, e.g. "TENANT_ID".
+type ConfigKey struct {
+	Name        string
+	Type        string
+	Required    bool
+	Default     string
+	Secret      bool
+	Description string
+	Enum        []string
+}
+
+// ConfigSchema describes the configuration a driver reads from its environment
+type ConfigSchema struct {
+	Description string
+	Keys        []ConfigKey
+}
+
+// ConfigSchemaProvider is implemented by factories that publish their configuration
+type ConfigSchemaProvider interface {
+	ConfigSchema() *ConfigSchema
+}
+
+// FactorySchema returns the schema of a factory. Factories can publish one with
+// ConfigSchemaProvider, otherwise it is derived from the `config` tags of the
+// struct returned by NewConfig (see ProviderFactory2).
+func FactorySchema(factory any) (*ConfigSchema, error) {
+	if p, ok := factory.(ConfigSchemaProvider); ok {
+		return p.ConfigSchema(), nil
+	}
+	if p, ok := factory.(interface{ NewConfig() interface{} }); ok {
+		return SchemaFromStruct(p.NewConfig()), nil
+	}
+	return nil, ErrNoConfigSchema
+}
+
+// SchemaFromStruct builds a schema from the fields of a struct, using the same
+// `config` tags as Bind. The `description` tag documents the key.
+func SchemaFromStruct(v any) *ConfigSchema {
+	schema := &ConfigSchema{}
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return schema
+	}
+	schema.addFields(t, nil)
+	return schema
+}
+
+func (s *ConfigSchema) addFields(t reflect.Type, path []string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := parseConfigTag(field)
+		if !ok {
+			continue
+		}
+
+		if isNestedConfig(field.Type) {
+			nested := field.Type
+			if nested.Kind() == reflect.Pointer {
+				nested = nested.Elem()
+			}
+			if field.Anonymous && field.Tag.Get("config") == "" {
+				s.addFields(nested, path)
+			} else {
+				s.addFields(nested, appendPath(path, tag.name))
+			}
+			continue
+		}
+
+		kind := configKeyType(field.Type, tag)
+		if kind == "" {
+			continue
+		}
+		s.Keys = append(s.Keys, ConfigKey{
+			Name:        EnvJoin(appendPath(path, tag.name)...),
+			Type:        kind,
+			Required:    tag.required,
+			Default:     tag.def,
+			Secret:      tag.secret,
+			Description: field.Tag.Get("description"),
+			Enum:        tag.enum,
+		})
+	}
+}
+
+// configKeyType maps a field type to a schema type, "" for fields that cannot be configured
+func configKeyType(t reflect.Type, tag *configTag) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == durationType:
+		return ConfigTypeDuration
+	case t == byteSizeType || (tag.size && isIntKind(t.Kind())):
+		return ConfigTypeSize
+	case reflect.PointerTo(t).Implements(textUnmarshalerType):
+		return ConfigTypeString
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return ConfigTypeString
+	case reflect.Bool:
+		return ConfigTypeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ConfigTypeInt
+	case reflect.Float32, reflect.Float64:
+		return ConfigTypeFloat
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return ConfigTypeList
+		}
+	}
+	return ""
+}
+
+// Check validates a value against the key's type and allowed values
+func (k *ConfigKey) Check(value string) error {
+	value = strings.TrimSpace(value)
+	var err error
+	switch k.Type {
+	case ConfigTypeInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case ConfigTypeFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case ConfigTypeBool:
+		_, err = strconv.ParseBool(value)
+	case ConfigTypeDuration:
+		_, err = time.ParseDuration(value)
+	case ConfigTypeSize:
+		_, err = ParseByteSize(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: expected a %s", ErrConfigInvalid, k.Type)
+	}
+
+	if len(k.Enum) > 0 {
+		for _, e := range k.Enum {
+			if strings.EqualFold(value, e) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: expected one of %s", ErrConfigNotAllowed, strings.Join(k.Enum, ", "))
+	}
+	return nil
+}
+
+// Validate checks the environment segment against the schema and returns every
+// missing or invalid key together. Values are never included in the errors.
+func (s *ConfigSchema) Validate(env *Environment) error {
+	prefix := ""
+	if h, ok := env.EnvSvc.(*HierarchicalEnvironment); ok {
+		prefix = h.Prefix()
+	}
+
+	errs := MultiError()
+	for i := range s.Keys {
+		key := &s.Keys[i]
+		full := EnvJoin(prefix, key.Name)
+
+		value := env.Get(key.Name)
+		if value == "" {
+			if key.Required && key.Default == "" {
+				errs.Append(&ConfigFieldError{Key: full, Err: ErrConfigRequired})
+			}
+			continue
+		}
+		if err := key.Check(value); err != nil {
+			errs.Append(&ConfigFieldError{Key: full, Err: err})
+		}
+	}
+	return errs.AsErr()
+}
+
+// SampleEnv writes an example .env file for the schema. Secrets are left empty.
+func (s *ConfigSchema) SampleEnv(w io.Writer, prefix ...string) {
+	if s.Description != "" {
+		fmt.Fprintf(w, "# %s\n", s.Description)
+	}
+	for _, key := range s.Keys {
+		var notes []string
+		notes = append(notes, key.Type)
+		if key.Required {
+			notes = append(notes, "required")
+		}
+		if key.Secret {
+			notes = append(notes, "secret")
+		}
+		if len(key.Enum) > 0 {
+			notes = append(notes, "one of "+strings.Join(key.Enum, "|"))
+		}
+
+		comment := strings.Join(notes, ", ")
+		if key.Description != "" {
+			comment = key.Description + " (" + comment + ")"
+		}
+		fmt.Fprintf(w, "# %s\n", comment)
+
+		value := key.Default
+		if key.Secret {
+			value = ""
+		}
+		fmt.Fprintf(w, "%s=%s\n", EnvJoin(append(prefix, key.Name)...), value)
+	}
+}
+
+// Schema returns the configuration schema of a driver
+func (pr *ProvidersRegistry[T]) Schema(driver string) (*ConfigSchema, error) {
+	factory, ok := MapKey(pr.Providers, driver, true)
+	if !ok {
+		return nil, ErrDriverNotFound
+	}
+	return FactorySchema(factory)
+}
+
+// Validate checks the environment segment against the schema of the driver named
+// by the driver key. Drivers without a schema are not checked.
+func (pr *ProvidersRegistry[T]) Validate(env *Environment, driverKey string) error {
+	driver := env.Get(driverKey)
+	if driver == "" {
+		return &ConfigFieldError{Key: driverKey, Err: ErrConfigRequired}
+	}
+	return pr.ValidateWith(env, driver)
+}
+
+// ValidateWith checks the environment segment against the schema of the driver
+func (pr *ProvidersRegistry[T]) ValidateWith(env *Environment, driver string) error {
+	schema, err := pr.Schema(driver)
+	if errors.Is(err, ErrNoConfigSchema) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return schema.Validate(env)
+}
+
+// validateBeforeCreate logs and returns the configuration problems of a driver
+func (pr *ProvidersRegistry[T]) validateBeforeCreate(env *Environment, driver string) error {
+	err := pr.ValidateWith(env, driver)
+	if err != nil {
+		Error(context.Background(), "Invalid configuration for driver %v: %v", driver, err)
+	}
+	return err
+}
+
+// Describe writes the drivers and the keys each one reads
+func (pr *ProvidersRegistry[T]) Describe(w io.Writer) {
+	drivers := make([]string, 0, len(pr.Providers))
+	for name := range pr.Providers {
+		drivers = append(drivers, name)
+	}
+	sort.Strings(drivers)
+
+	for _, name := range drivers {
+		schema, err := FactorySchema(pr.Providers[name])
+		if err != nil {
+			fmt.Fprintf(w, "%s\n  (no schema)\n", name)
+			continue
+		}
+		if schema.Description != "" {
+			fmt.Fprintf(w, "%s: %s\n", name, schema.Description)
+		} else {
+			fmt.Fprintf(w, "%s\n", name)
+		}
+		for _, key := range schema.Keys {
+			var flags []string
+			if key.Required {
+				flags = append(flags, "required")
+			}
+			if key.Secret {
+				flags = append(flags, "secret")
+			}
+			if key.Default != "" {
+				flags = append(flags, "default="+key.Default)
+			}
+			line := fmt.Sprintf("  %s %s", key.Name, key.Type)
+			if len(flags) > 0 {
+				line += " [" + strings.Join(flags, ", ") + "]"
+			}
+			if key.Description != "" {
+				line += " " + key.Description
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cloudy
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSchemaConfig struct {
+	TenantID string        `config:"tenant-id,required" description:"Directory tenant"`
+	Secret   string        `config:"client-secret,required,secret"`
+	Timeout  time.Duration `config:"timeout,default=30s"`
+	Mode     string        `config:"mode,enum=dev|prod"`
+	Retry    struct {
+		Count int `config:"count,default=3"`
+	} `config:"retry"`
+}
+
+type testSchemaFactory struct{}
+
+func (f *testSchemaFactory) NewConfig() interface{} {
+	return &testSchemaConfig{}
+}
+
+func (f *testSchemaFactory) Create(cfg interface{}) (string, error) {
+	return cfg.(*testSchemaConfig).TenantID, nil
+}
+
+func (f *testSchemaFactory) FromEnv(env *Environment) (interface{}, error) {
+	cfg := &testSchemaConfig{}
+	_, err := env.Bind(cfg)
+	return cfg, err
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	schema, err := FactorySchema(&testSchemaFactory{})
+	require.Nil(t, err)
+	assert.Equal(t, []ConfigKey{
+		{Name: "TENANT_ID", Type: ConfigTypeString, Required: true, Description: "Directory tenant"},
+		{Name: "CLIENT_SECRET", Type: ConfigTypeString, Required: true, Secret: true},
+		{Name: "TIMEOUT", Type: ConfigTypeDuration, Default: "30s"},
+		{Name: "MODE", Type: ConfigTypeString, Enum: []string{"dev", "prod"}},
+		{Name: "RETRY_COUNT", Type: ConfigTypeInt, Default: "3"},
+	}, schema.Keys)
+
+	var buf bytes.Buffer
+	schema.SampleEnv(&buf, "users")
+	assert.Contains(t, buf.String(), "# Directory tenant (string, required)\nUSERS_TENANT_ID=\n")
+	assert.Contains(t, buf.String(), "# string, required, secret\nUSERS_CLIENT_SECRET=\n")
+	assert.Contains(t, buf.String(), "USERS_RETRY_COUNT=3\n")
+}
+
+func TestRegistryValidate(t *testing.T) {
+	registry := NewProviderRegistry[string]()
+	registry.Register("graph", &testSchemaFactory{})
+
+	envSvc := NewMapEnvironment()
+	envSvc.Set("APP_USERS_DRIVER", "graph")
+	envSvc.Set("APP_USERS_TIMEOUT", "soon")
+	envSvc.Set("APP_USERS_MODE", "test")
+	env := NewEnvironment(NewHierarchicalEnvironment(envSvc, "app")).Segment("users")
+
+	// Every problem is listed with the full key name
+	err := registry.Validate(env, "DRIVER")
+	require.NotNil(t, err)
+	var merr *MultiErrors
+	require.True(t, errors.As(err, &merr))
+	assert.Equal(t, 4, merr.Len())
+	assert.Contains(t, err.Error(), "APP_USERS_TENANT_ID: required")
+	assert.Contains(t, err.Error(), "APP_USERS_CLIENT_SECRET: required")
+	assert.Contains(t, err.Error(), "APP_USERS_TIMEOUT: invalid configuration value: expected a duration")
+	assert.Contains(t, err.Error(), "APP_USERS_MODE: configuration value is not one of the allowed values")
+
+	_, err = registry.NewFromEnv(env, "DRIVER")
+	assert.ErrorIs(t, err, ErrConfigRequired)
+
+	envSvc.Set("APP_USERS_TENANT_ID", "tenant")
+	envSvc.Set("APP_USERS_CLIENT_SECRET", "secret")
+	envSvc.Set("APP_USERS_TIMEOUT", "1m")
+	envSvc.Set("APP_USERS_MODE", "prod")
+	created, err := registry.NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+	assert.Equal(t, "tenant", created)
+
+	var buf bytes.Buffer
+	registry.Describe(&buf)
+	assert.Contains(t, buf.String(), "graph\n  TENANT_ID string [required] Directory tenant\n")
+	assert.Contains(t, buf.String(), "  CLIENT_SECRET string [required, secret]\n")
+}
@@ -40,6 +40,17 @@ func (f *FilesystemStoreFactory) Create(cfg interface{}) (BinaryDataStore, error
 	}, nil
 }
 
+func (f *FilesystemStoreFactory) ConfigSchema() *cloudy.ConfigSchema {
+	return &cloudy.ConfigSchema{
+		Description: "Stores each item as a file in a directory",
+		Keys: []cloudy.ConfigKey{
+			{Name: "FS_DIR", Type: cloudy.ConfigTypeString, Required: true, Description: "Directory for the files"},
+			{Name: "FS_EXT", Type: cloudy.ConfigTypeString, Required: true, Description: "File extension"},
+			{Name: "FS_PERMS", Type: cloudy.ConfigTypeInt, Default: "0600", Description: "File permissions"},
+		},
+	}
+}
+
 func (f *FilesystemStoreFactory) FromEnv(env *cloudy.Environment) (interface{}, error) {
 
 	cfg := &FilesystemStoreConfig{}
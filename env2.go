@@ -11,6 +11,9 @@ var EnvironmentProviders = NewProviderRegistry[EnvironmentService]()
 type Environment struct {
 	EnvSvc      EnvironmentService
 	Credentials *CredentialManager
+
+	// Interpolator expands ${...} references in values when set, see EnableInterpolation
+	Interpolator *Interpolator
 }
 
 func NewEnvironment(envSvc EnvironmentService) *Environment {
@@ -34,8 +37,35 @@ func (env *Environment) GetCredential(sourceName string) interface{} {
 	return env.Credentials.Get(sourceName)
 }
 
-func (env *Environment) Get(name string) string {
+// EnableInterpolation expands ${...} references in the values. Plain references
+// are read from this environment, ${secret:key} from the secrets when given.
+// Segments share the interpolator, so references always use the same root.
+func (env *Environment) EnableInterpolation(secrets SecretSource) *Interpolator {
+	svc := env.EnvSvc
+	env.Interpolator = NewInterpolator(svc.Get)
+	if secrets != nil {
+		env.Interpolator.WithSecrets(secrets)
+	}
+	return env.Interpolator
+}
+
+// lookup reads and resolves a value. Resolution errors are logged by name and
+// the value is treated as missing.
+func (env *Environment) lookup(name string) (string, error) {
 	v, err := env.EnvSvc.Get(name)
+	if err != nil || v == "" || env.Interpolator == nil {
+		return v, err
+	}
+	resolved, err := env.Interpolator.ResolveKey(context.Background(), name, v)
+	if err != nil {
+		Warn(context.Background(), "Environment could not resolve %s: %v", name, err)
+		return "", err
+	}
+	return resolved, nil
+}
+
+func (env *Environment) Get(name string) string {
+	v, err := env.lookup(name)
 	if err == nil && v != "" {
 		return v
 	}
@@ -43,7 +73,7 @@ func (env *Environment) Get(name string) string {
 }
 
 func (env *Environment) GetInt(name string) (int, bool) {
-	v, err := env.EnvSvc.Get(name)
+	v, err := env.lookup(name)
 	if err == nil && v != "" {
 		vi, err := strconv.Atoi(v)
 		if err != nil {
@@ -64,7 +94,7 @@ func (env *Environment) Force(name ...string) string {
 
 	//looks for multlpe key values
 	for _, n := range name {
-		v, err := env.EnvSvc.Get(n)
+		v, err := env.lookup(n)
 		if err == nil && v != "" {
 			return v
 		}
@@ -93,6 +123,7 @@ func (env *Environment) Segment(name ...string) *Environment {
 		rtn = NewEnvironment(NewHierarchicalEnvironment(svc, name...))
 	}
 	rtn.Credentials = env.Credentials
+	rtn.Interpolator = env.Interpolator
 	return rtn
 }
 
@@ -105,6 +136,7 @@ func (env *Environment) SegmentWithCreds(creds *CredentialManager, name ...strin
 		rtn = NewEnvironment(NewHierarchicalEnvironment(svc, name...))
 	}
 	rtn.Credentials = creds
+	rtn.Interpolator = env.Interpolator
 	return rtn
 }
 
@@ -127,6 +159,11 @@ func NewHierarchicalEnvironment(env EnvironmentService, segments ...string) *Hie
 	return h
 }
 
+// Prefix returns the normalized prefix of the segment, e.g. "ARKLOUD_SERVICE"
+func (segEnv *HierarchicalEnvironment) Prefix() string {
+	return segEnv.prefix
+}
+
 func (segEnv *HierarchicalEnvironment) S(name ...string) *HierarchicalEnvironment {
 
 	nameReal := segEnv.prefix
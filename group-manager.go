@@ -2,12 +2,18 @@ package cloudy
 
 import (
 	"context"
+	"errors"
 
 	"github.com/appliedres/cloudy/models"
 )
 
 var GroupProviders = NewProviderRegistry[GroupManager]()
 
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group already exists")
+)
+
 // Manages groups that users are part of.This can be seperate
 // from the user manager or it can be the same.
 type GroupManager interface {
@@ -0,0 +1,1004 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/datastore"
+	"github.com/appliedres/cloudy/models"
+	"github.com/google/uuid"
+)
+
+const DatastoreIdentityID = "datastore"
+
+var (
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	ErrResetTokenExpired = errors.New("password reset token has expired")
+	ErrGroupCycle        = errors.New("group would contain itself")
+)
+
+func init() {
+	cloudy.UserProviders.Register(DatastoreIdentityID, &DatastoreIdentityFactory[cloudy.UserManager]{})
+	cloudy.GroupProviders.Register(DatastoreIdentityID, &DatastoreIdentityFactory[cloudy.GroupManager]{})
+}
+
+var (
+	_ cloudy.UserManager          = (*DatastoreIdentityProvider)(nil)
+	_ cloudy.GroupManager         = (*DatastoreIdentityProvider)(nil)
+	_ cloudy.StandardFilterLister = (*DatastoreIdentityProvider)(nil)
+	_ cloudy.AvatarManager        = (*DatastoreIdentityProvider)(nil)
+	_ PasswordAuthenticator       = (*DatastoreIdentityProvider)(nil)
+)
+
+// UserRecord is how a user is stored. Only the password hash and the hash of
+// the reset token are kept.
+type UserRecord struct {
+	ID                 string      `json:"id"`
+	User               models.User `json:"user"`
+	PasswordHash       string      `json:"passwordHash,omitempty"`
+	MustChangePassword bool        `json:"mustChangePassword,omitempty"`
+	ResetTokenHash     string      `json:"resetTokenHash,omitempty"`
+	ResetTokenExpires  time.Time   `json:"resetTokenExpires,omitempty"`
+	Created            time.Time   `json:"created"`
+	Updated            time.Time   `json:"updated"`
+	PasswordChanged    *time.Time  `json:"passwordChanged,omitempty"`
+}
+
+// GroupRecord is how a group is stored. Groups can contain users and other groups.
+type GroupRecord struct {
+	ID      string       `json:"id"`
+	Group   models.Group `json:"group"`
+	Members []string     `json:"members,omitempty"` // User IDs
+	Groups  []string     `json:"groups,omitempty"`  // Nested group IDs
+}
+
+// AvatarRecord is a user's profile picture
+type AvatarRecord struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// NewUserDatatype stores users in the datastore
+func NewUserDatatype(store datastore.JsonDataStore[UserRecord]) *datastore.Datatype[UserRecord] {
+	return &datastore.Datatype[UserRecord]{Name: "User", Prefix: "usr", DataStore: store}
+}
+
+// NewGroupDatatype stores groups in the datastore
+func NewGroupDatatype(store datastore.JsonDataStore[GroupRecord]) *datastore.Datatype[GroupRecord] {
+	return &datastore.Datatype[GroupRecord]{Name: "Group", Prefix: "grp", DataStore: store}
+}
+
+// NewAvatarDatatype stores profile pictures in the datastore
+func NewAvatarDatatype(store datastore.JsonDataStore[AvatarRecord]) *datastore.Datatype[AvatarRecord] {
+	return &datastore.Datatype[AvatarRecord]{Name: "Avatar", Prefix: "avt", DataStore: store}
+}
+
+// DatastoreIdentityConfig configures a DatastoreIdentityProvider
+type DatastoreIdentityConfig struct {
+	Users   *datastore.Datatype[UserRecord]   `config:"-"`
+	Groups  *datastore.Datatype[GroupRecord]  `config:"-"`
+	Avatars *datastore.Datatype[AvatarRecord] `config:"-"` // Optional
+
+	// TablePrefix is put in front of the table names when the tables are created from the environment
+	TablePrefix string `config:"table_prefix" description:"Prefix of the user, group and avatar tables"`
+
+	// UserDomain is added to user names without a domain
+	UserDomain string `config:"user_domain" description:"Domain added to user names"`
+
+	// Domains limits the email domains of users, any domain is allowed when empty
+	Domains []string `config:"user_domains" description:"Allowed email domains of users"`
+
+	// UsernameStrategy generates user names for new users without one: first.last or flast
+	UsernameStrategy string `config:"username_strategy,default=first.last,enum=first.last|flast" description:"How user names are generated"`
+
+	PasswordHash string `config:"password_hash,default=argon2id,enum=argon2id|bcrypt" description:"Password hash algorithm"`
+	PasswordSettings
+
+	ResetTokenTTL time.Duration `config:"reset_token_ttl,default=1h" description:"How long password reset tokens are valid"`
+
+	// Hasher overrides PasswordHash
+	Hasher PasswordHasher `config:"-"`
+}
+
+// NewDatastoreIdentityConfig returns a configuration with the defaults for the datatypes
+func NewDatastoreIdentityConfig(users *datastore.Datatype[UserRecord], groups *datastore.Datatype[GroupRecord], avatars *datastore.Datatype[AvatarRecord]) *DatastoreIdentityConfig {
+	return &DatastoreIdentityConfig{
+		Users:            users,
+		Groups:           groups,
+		Avatars:          avatars,
+		UsernameStrategy: "first.last",
+		PasswordHash:     HashArgon2id,
+		PasswordSettings: DefaultPasswordSettings(),
+		ResetTokenTTL:    time.Hour,
+	}
+}
+
+// DatastoreIdentityFactory creates a DatastoreIdentityProvider. From the
+// environment the tables are created with datastore.CreateJsonDatastore, using
+// the STORE segment, e.g. STORE_DRIVER.
+type DatastoreIdentityFactory[T any] struct{}
+
+func (f *DatastoreIdentityFactory[T]) Create(cfg interface{}) (T, error) {
+	var zero T
+	config, ok := cfg.(*DatastoreIdentityConfig)
+	if !ok || config == nil {
+		return zero, cloudy.ErrInvalidConfiguration
+	}
+	p, err := NewDatastoreIdentityProvider(config)
+	if err != nil {
+		return zero, err
+	}
+	return any(p).(T), nil
+}
+
+func (f *DatastoreIdentityFactory[T]) FromEnv(env *cloudy.Environment) (interface{}, error) {
+	ctx := context.Background()
+	cfg := NewDatastoreIdentityConfig(nil, nil, nil)
+	if _, err := env.Bind(cfg); err != nil {
+		return nil, err
+	}
+
+	storeEnv := env.Segment("store")
+	users, err := datastore.CreateJsonDatastore[UserRecord](ctx, "users", cfg.TablePrefix, "id", storeEnv)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := datastore.CreateJsonDatastore[GroupRecord](ctx, "groups", cfg.TablePrefix, "id", storeEnv)
+	if err != nil {
+		return nil, err
+	}
+	avatars, err := datastore.CreateJsonDatastore[AvatarRecord](ctx, "avatars", cfg.TablePrefix, "id", storeEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Users = NewUserDatatype(users)
+	cfg.Groups = NewGroupDatatype(groups)
+	cfg.Avatars = NewAvatarDatatype(avatars)
+	return cfg, nil
+}
+
+func (f *DatastoreIdentityFactory[T]) ConfigSchema() *cloudy.ConfigSchema {
+	schema := cloudy.SchemaFromStruct(&DatastoreIdentityConfig{})
+	schema.Description = "Users and groups stored in a JSON datastore"
+	schema.Keys = append([]cloudy.ConfigKey{
+		{Name: "STORE_DRIVER", Type: cloudy.ConfigTypeString, Required: true, Description: "JSON datastore driver"},
+	}, schema.Keys...)
+	return schema
+}
+
+// DatastoreIdentityProvider keeps users, groups and profile pictures in
+// datastore Datatypes. Passwords are stored as argon2id or bcrypt hashes.
+//
+// Groups can contain other groups: GetGroupMembers returns the users of the
+// nested groups too and GetUserGroups returns the groups a user is in through
+// nesting. Users are found by ID, username or email. Lookups other than by ID
+// read all users, and the uniqueness checks are only serialized within the
+// process, so one instance should own the tables.
+type DatastoreIdentityProvider struct {
+	cfg    *DatastoreIdentityConfig
+	hasher PasswordHasher
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+func NewDatastoreIdentityProvider(cfg *DatastoreIdentityConfig) (*DatastoreIdentityProvider, error) {
+	if cfg.Users == nil || cfg.Groups == nil {
+		return nil, cloudy.ErrInvalidConfiguration
+	}
+	hasher := cfg.Hasher
+	if hasher == nil {
+		var err error
+		hasher, err = NewPasswordHasher(cfg.PasswordHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DatastoreIdentityProvider{cfg: cfg, hasher: hasher, now: time.Now}, nil
+}
+
+// findUser looks a user up by ID, username or email, nil when there is none
+func (p *DatastoreIdentityProvider) findUser(ctx context.Context, id string) (*UserRecord, error) {
+	if id == "" {
+		return nil, nil
+	}
+	rec, err := p.cfg.Users.Get(ctx, id)
+	if err != nil || rec != nil {
+		return rec, err
+	}
+	all, err := p.cfg.Users.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range all {
+		if strings.EqualFold(r.User.Username, id) || strings.EqualFold(r.User.Email, id) {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *DatastoreIdentityProvider) mustFindUser(ctx context.Context, id string) (*UserRecord, error) {
+	rec, err := p.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, id)
+	}
+	return rec, nil
+}
+
+func (p *DatastoreIdentityProvider) checkUnique(ctx context.Context, usr *models.User) error {
+	all, err := p.cfg.Users.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range all {
+		if r.ID == usr.UID {
+			continue
+		}
+		if usr.Username != "" && strings.EqualFold(r.User.Username, usr.Username) {
+			return fmt.Errorf("%w: %s", cloudy.ErrUserExists, usr.Username)
+		}
+		if usr.Email != "" && strings.EqualFold(r.User.Email, usr.Email) {
+			return fmt.Errorf("%w: %s", cloudy.ErrUserExists, usr.Email)
+		}
+	}
+	return nil
+}
+
+// ForceUserName normalizes the name with the username helpers, adding the
+// configured domain, and reports whether the user exists
+func (p *DatastoreIdentityProvider) ForceUserName(ctx context.Context, name string) (string, bool, error) {
+	username, err := cloudy.FindMatchingUser(ctx, cloudy.FixName(name), cloudy.UserAny, p, p.domainFor(name))
+	if err != nil {
+		return "", false, err
+	}
+	u, err := p.GetUser(ctx, username)
+	if err != nil {
+		return "", false, err
+	}
+	return username, u != nil, nil
+}
+
+// domainFor returns the domain to add to a user name, none when it has one
+func (p *DatastoreIdentityProvider) domainFor(name string) string {
+	if strings.Contains(name, "@") {
+		return ""
+	}
+	return p.cfg.UserDomain
+}
+
+// guessUsername picks an unused user name with the configured strategy
+func (p *DatastoreIdentityProvider) guessUsername(ctx context.Context, u *models.User) (string, error) {
+	if u.FirstName == "" || u.LastName == "" {
+		return "", ErrMissingUserIdentity
+	}
+	candidate := *u
+	candidate.FirstName = cloudy.FixName(u.FirstName)
+	candidate.LastName = cloudy.FixName(u.LastName)
+
+	if p.cfg.UsernameStrategy == "flast" {
+		strategy := &cloudy.FirstInitialLastUsernameStrategy{}
+		return strategy.GuessUsername(ctx, &candidate, cloudy.UserDoesNotExist, p, p.cfg.UserDomain)
+	}
+	strategy := &cloudy.FirstDotLastUsernameStrategy{}
+	return strategy.GuessUsername(ctx, &candidate, cloudy.UserDoesNotExist, p, p.cfg.UserDomain)
+}
+
+// UsesStandardFilters implements cloudy.StandardFilterLister
+func (p *DatastoreIdentityProvider) UsesStandardFilters() {}
+
+func (p *DatastoreIdentityProvider) ListUsers(ctx context.Context, filter string, attrs []string) (*[]models.User, error) {
+	f, err := cloudy.ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	all, err := p.cfg.Users.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := []models.User{}
+	for _, r := range all {
+		if f.MatchUser(&r.User) {
+			rtn = append(rtn, *cloudy.FilterUserAttributes(copyUser(&r.User), attrs))
+		}
+	}
+	sortUsers(rtn)
+	return &rtn, nil
+}
+
+// GetUser returns the user with the ID, username or email, nil when there is none
+func (p *DatastoreIdentityProvider) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	rec, err := p.findUser(ctx, uid)
+	if rec == nil || err != nil {
+		return nil, err
+	}
+	return copyUser(&rec.User), nil
+}
+
+func (p *DatastoreIdentityProvider) GetUserByEmail(ctx context.Context, email string, opts *cloudy.UserOptions) (*models.User, error) {
+	all, err := p.cfg.Users.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range all {
+		if strings.EqualFold(r.User.Email, email) {
+			return copyUser(&r.User), nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *DatastoreIdentityProvider) GetUserWithAttributes(ctx context.Context, uid string, attrs []string) (*models.User, error) {
+	u, err := p.GetUser(ctx, uid)
+	if u == nil || err != nil {
+		return u, err
+	}
+	return cloudy.FilterUserAttributes(u, attrs), nil
+}
+
+// NewUser stores an enabled user. Without a username one is generated from the
+// first and last name with the username strategy.
+func (p *DatastoreIdentityProvider) NewUser(ctx context.Context, newUser *models.User) (*models.User, error) {
+	if err := checkEmailDomain(p.cfg.Domains, newUser.Email); err != nil {
+		return nil, err
+	}
+	usr := copyUser(newUser)
+	if usr.Username == "" && usr.Email == "" {
+		username, err := p.guessUsername(ctx, usr)
+		if err != nil {
+			return nil, err
+		}
+		usr.Username = username
+	}
+	if usr.UID == "" {
+		usr.UID = uuid.NewString()
+	}
+	usr.Enabled = true
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	exists, err := p.cfg.Users.Exists(ctx, usr.UID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrUserExists, usr.UID)
+	}
+	if err := p.checkUnique(ctx, usr); err != nil {
+		return nil, err
+	}
+
+	now := p.now()
+	rec := &UserRecord{ID: usr.UID, User: *usr, Created: now, Updated: now}
+	if _, err := p.cfg.Users.Save(ctx, rec); err != nil {
+		return nil, err
+	}
+	return copyUser(usr), nil
+}
+
+// UpdateUser replaces the user's details, the enabled state is not changed
+func (p *DatastoreIdentityProvider) UpdateUser(ctx context.Context, usr *models.User) error {
+	if err := checkEmailDomain(p.cfg.Domains, usr.Email); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustFindUser(ctx, usr.UID)
+	if err != nil {
+		return err
+	}
+	updated := copyUser(usr)
+	updated.UID = rec.ID
+	updated.Enabled = rec.User.Enabled
+	if err := p.checkUnique(ctx, updated); err != nil {
+		return err
+	}
+	rec.User = *updated
+	return p.saveUser(ctx, rec)
+}
+
+func (p *DatastoreIdentityProvider) saveUser(ctx context.Context, rec *UserRecord) error {
+	rec.Updated = p.now()
+	_, err := p.cfg.Users.Save(ctx, rec)
+	return err
+}
+
+func (p *DatastoreIdentityProvider) updateUser(ctx context.Context, uid string, update func(rec *UserRecord) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if err := update(rec); err != nil {
+		return err
+	}
+	return p.saveUser(ctx, rec)
+}
+
+func (p *DatastoreIdentityProvider) Enable(ctx context.Context, uid string) error {
+	return p.updateUser(ctx, uid, func(rec *UserRecord) error {
+		rec.User.Enabled = true
+		return nil
+	})
+}
+
+func (p *DatastoreIdentityProvider) Disable(ctx context.Context, uid string) error {
+	return p.updateUser(ctx, uid, func(rec *UserRecord) error {
+		rec.User.Enabled = false
+		return nil
+	})
+}
+
+// DeleteUser removes the user, its group memberships and its profile picture
+func (p *DatastoreIdentityProvider) DeleteUser(ctx context.Context, uid string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	groups, err := p.cfg.Groups.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if cloudy.ArrayIncludes(g.Members, rec.ID) {
+			g.Members = cloudy.ArrayRemoveAll(g.Members, func(m string) bool { return m == rec.ID })
+			if _, err := p.cfg.Groups.Save(ctx, g); err != nil {
+				return err
+			}
+		}
+	}
+	if p.cfg.Avatars != nil {
+		if err := p.cfg.Avatars.Delete(ctx, rec.ID); err != nil {
+			return err
+		}
+	}
+	return p.cfg.Users.Delete(ctx, rec.ID)
+}
+
+func (p *DatastoreIdentityProvider) setPassword(rec *UserRecord, pwd string, mustChange bool) error {
+	hash, err := p.hasher.Hash(pwd)
+	if err != nil {
+		return err
+	}
+	now := p.now()
+	rec.PasswordHash = hash
+	rec.MustChangePassword = mustChange
+	rec.PasswordChanged = &now
+	rec.ResetTokenHash = ""
+	rec.ResetTokenExpires = time.Time{}
+	return nil
+}
+
+// SetUserPassword checks the password against the password policy and stores its hash
+func (p *DatastoreIdentityProvider) SetUserPassword(ctx context.Context, uid string, pwd string, mustChange bool) error {
+	if err := p.cfg.CheckPassword(pwd); err != nil {
+		return err
+	}
+	return p.updateUser(ctx, uid, func(rec *UserRecord) error {
+		return p.setPassword(rec, pwd, mustChange)
+	})
+}
+
+// Authenticate implements PasswordAuthenticator
+func (p *DatastoreIdentityProvider) Authenticate(ctx context.Context, uid string, pwd string) (*models.User, error) {
+	rec, err := p.findUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || !rec.User.Enabled || rec.PasswordHash == "" {
+		return nil, ErrInvalidCredentials
+	}
+	ok, err := VerifyPassword(pwd, rec.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return copyUser(&rec.User), nil
+}
+
+// MustChangePassword implements PasswordAuthenticator
+func (p *DatastoreIdentityProvider) MustChangePassword(ctx context.Context, uid string) (bool, error) {
+	rec, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return false, err
+	}
+	return rec.MustChangePassword, nil
+}
+
+// CreatePasswordResetToken creates a token that can set the user's password
+// once before it expires. Only a hash of the token is stored, a new token
+// replaces the previous one.
+func (p *DatastoreIdentityProvider) CreatePasswordResetToken(ctx context.Context, uid string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	var token string
+	err := p.updateUser(ctx, uid, func(rec *UserRecord) error {
+		rec.ResetTokenHash = hashResetToken(encoded)
+		rec.ResetTokenExpires = p.now().Add(p.cfg.ResetTokenTTL)
+		token = rec.ID + "." + encoded
+		return nil
+	})
+	return token, err
+}
+
+// ResetPassword sets the password with a reset token and clears the token
+func (p *DatastoreIdentityProvider) ResetPassword(ctx context.Context, token string, pwd string) error {
+	idx := strings.LastIndex(token, ".")
+	if idx <= 0 {
+		return ErrInvalidResetToken
+	}
+	uid, secret := token[:idx], token[idx+1:]
+
+	if err := p.cfg.CheckPassword(pwd); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.cfg.Users.Get(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.ResetTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(rec.ResetTokenHash), []byte(hashResetToken(secret))) != 1 {
+		return ErrInvalidResetToken
+	}
+	if p.now().After(rec.ResetTokenExpires) {
+		return ErrResetTokenExpired
+	}
+	if err := p.setPassword(rec, pwd, false); err != nil {
+		return err
+	}
+	return p.saveUser(ctx, rec)
+}
+
+func hashResetToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetProfilePicture implements cloudy.AvatarManager, it returns
+// cloudy.ErrOperationNotImplemented without an avatar table
+func (p *DatastoreIdentityProvider) GetProfilePicture(ctx context.Context, uid string) ([]byte, error) {
+	if p.cfg.Avatars == nil {
+		return nil, cloudy.ErrOperationNotImplemented
+	}
+	rec, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	avatar, err := p.cfg.Avatars.Get(ctx, rec.ID)
+	if avatar == nil || err != nil {
+		return nil, err
+	}
+	return avatar.Data, nil
+}
+
+// UploadProfilePicture implements cloudy.AvatarManager, it returns
+// cloudy.ErrOperationNotImplemented without an avatar table
+func (p *DatastoreIdentityProvider) UploadProfilePicture(ctx context.Context, uid string, picture []byte) error {
+	if p.cfg.Avatars == nil {
+		return cloudy.ErrOperationNotImplemented
+	}
+	rec, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if len(picture) == 0 {
+		return p.cfg.Avatars.Delete(ctx, rec.ID)
+	}
+	_, err = p.cfg.Avatars.Save(ctx, &AvatarRecord{ID: rec.ID, Data: append([]byte(nil), picture...)})
+	return err
+}
+
+func (p *DatastoreIdentityProvider) ListGroups(ctx context.Context, filter string, attrs []string) (*[]models.Group, error) {
+	f, err := cloudy.ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	all, err := p.cfg.Groups.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := []models.Group{}
+	for _, g := range all {
+		if f.MatchGroup(&g.Group) {
+			rtn = append(rtn, g.Group)
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool { return rtn[i].Name < rtn[j].Name })
+	return &rtn, nil
+}
+
+func (p *DatastoreIdentityProvider) mustGetGroup(ctx context.Context, id string) (*GroupRecord, error) {
+	rec, err := p.cfg.Groups.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, id)
+	}
+	return rec, nil
+}
+
+func (p *DatastoreIdentityProvider) GetGroup(ctx context.Context, id string) (*models.Group, error) {
+	rec, err := p.mustGetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	grp := rec.Group
+	return &grp, nil
+}
+
+func (p *DatastoreIdentityProvider) findGroupByName(ctx context.Context, name string) (*GroupRecord, error) {
+	all, err := p.cfg.Groups.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range all {
+		if strings.EqualFold(g.Group.Name, name) {
+			return g, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *DatastoreIdentityProvider) GetGroupId(ctx context.Context, name string) (string, error) {
+	g, err := p.findGroupByName(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if g == nil {
+		return "", fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, name)
+	}
+	return g.ID, nil
+}
+
+// GetUserGroups returns the groups the user is in, directly or through nested groups
+func (p *DatastoreIdentityProvider) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	rec, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	all, err := p.cfg.Groups.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start with the direct groups and walk up to the groups containing them
+	in := make(map[string]bool)
+	var pending []string
+	for _, g := range all {
+		if cloudy.ArrayIncludes(g.Members, rec.ID) {
+			in[g.ID] = true
+			pending = append(pending, g.ID)
+		}
+	}
+	for len(pending) > 0 {
+		child := pending[0]
+		pending = pending[1:]
+		for _, g := range all {
+			if !in[g.ID] && cloudy.ArrayIncludes(g.Groups, child) {
+				in[g.ID] = true
+				pending = append(pending, g.ID)
+			}
+		}
+	}
+
+	rtn := []*models.Group{}
+	for _, g := range all {
+		if in[g.ID] {
+			grp := g.Group
+			rtn = append(rtn, &grp)
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool { return rtn[i].Name < rtn[j].Name })
+	return rtn, nil
+}
+
+// NewGroup stores a group, an ID is generated when it is empty. Names are unique.
+func (p *DatastoreIdentityProvider) NewGroup(ctx context.Context, grp *models.Group) (*models.Group, error) {
+	if grp.Name == "" {
+		return nil, ErrMissingGroupName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g := *grp
+	if g.ID == "" {
+		g.ID = uuid.NewString()
+	}
+	exists, err := p.cfg.Groups.Exists(ctx, g.ID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrGroupExists, g.ID)
+	}
+	other, err := p.findGroupByName(ctx, g.Name)
+	if err != nil {
+		return nil, err
+	}
+	if other != nil {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrGroupExists, g.Name)
+	}
+
+	if _, err := p.cfg.Groups.Save(ctx, &GroupRecord{ID: g.ID, Group: g}); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// UpdateGroup replaces the group's details and reports whether anything changed
+func (p *DatastoreIdentityProvider) UpdateGroup(ctx context.Context, grp *models.Group) (bool, error) {
+	if grp.Name == "" {
+		return false, ErrMissingGroupName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustGetGroup(ctx, grp.ID)
+	if err != nil {
+		return false, err
+	}
+	other, err := p.findGroupByName(ctx, grp.Name)
+	if err != nil {
+		return false, err
+	}
+	if other != nil && other.ID != rec.ID {
+		return false, fmt.Errorf("%w: %s", cloudy.ErrGroupExists, grp.Name)
+	}
+
+	if !groupChanged(&rec.Group, grp) {
+		return false, nil
+	}
+	rec.Group = *grp
+	_, err = p.cfg.Groups.Save(ctx, rec)
+	return err == nil, err
+}
+
+// GetGroupMembers returns the users of the group and of its nested groups
+func (p *DatastoreIdentityProvider) GetGroupMembers(ctx context.Context, grpId string) ([]*models.User, error) {
+	rec, err := p.mustGetGroup(ctx, grpId)
+	if err != nil {
+		return nil, err
+	}
+
+	visited := map[string]bool{rec.ID: true}
+	seen := make(map[string]bool)
+	var uids []string
+	pending := []*GroupRecord{rec}
+	for len(pending) > 0 {
+		g := pending[0]
+		pending = pending[1:]
+		for _, uid := range g.Members {
+			if !seen[uid] {
+				seen[uid] = true
+				uids = append(uids, uid)
+			}
+		}
+		for _, childId := range g.Groups {
+			if visited[childId] {
+				continue
+			}
+			visited[childId] = true
+			child, err := p.cfg.Groups.Get(ctx, childId)
+			if err != nil {
+				return nil, err
+			}
+			if child != nil {
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	rtn := make([]*models.User, 0, len(uids))
+	for _, uid := range uids {
+		u, err := p.cfg.Users.Get(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			rtn = append(rtn, copyUser(&u.User))
+		}
+	}
+	return rtn, nil
+}
+
+func (p *DatastoreIdentityProvider) resolveUsers(ctx context.Context, userIds []string) ([]string, error) {
+	uids := make([]string, 0, len(userIds))
+	for _, id := range userIds {
+		rec, err := p.mustFindUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, rec.ID)
+	}
+	return uids, nil
+}
+
+// AddMembers adds the users to the group. Nothing is added when a user is unknown.
+func (p *DatastoreIdentityProvider) AddMembers(ctx context.Context, groupId string, userIds []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustGetGroup(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	uids, err := p.resolveUsers(ctx, userIds)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if !cloudy.ArrayIncludes(rec.Members, uid) {
+			rec.Members = append(rec.Members, uid)
+		}
+	}
+	_, err = p.cfg.Groups.Save(ctx, rec)
+	return err
+}
+
+// RemoveMembers removes the users from the group. Nothing is removed when a user is unknown.
+func (p *DatastoreIdentityProvider) RemoveMembers(ctx context.Context, groupId string, userIds []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustGetGroup(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	uids, err := p.resolveUsers(ctx, userIds)
+	if err != nil {
+		return err
+	}
+	rec.Members = cloudy.ArrayRemoveAll(rec.Members, func(m string) bool { return cloudy.ArrayIncludes(uids, m) })
+	_, err = p.cfg.Groups.Save(ctx, rec)
+	return err
+}
+
+// AddGroups nests the groups in the group. A group cannot contain itself,
+// directly or through other groups.
+func (p *DatastoreIdentityProvider) AddGroups(ctx context.Context, groupId string, childIds []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustGetGroup(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	for _, childId := range childIds {
+		if _, err := p.mustGetGroup(ctx, childId); err != nil {
+			return err
+		}
+		contains, err := p.containsGroup(ctx, childId, groupId)
+		if err != nil {
+			return err
+		}
+		if contains {
+			return fmt.Errorf("%w: %s in %s", ErrGroupCycle, childId, groupId)
+		}
+	}
+	for _, childId := range childIds {
+		if !cloudy.ArrayIncludes(rec.Groups, childId) {
+			rec.Groups = append(rec.Groups, childId)
+		}
+	}
+	_, err = p.cfg.Groups.Save(ctx, rec)
+	return err
+}
+
+// RemoveGroups removes nested groups from the group
+func (p *DatastoreIdentityProvider) RemoveGroups(ctx context.Context, groupId string, childIds []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec, err := p.mustGetGroup(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	rec.Groups = cloudy.ArrayRemoveAll(rec.Groups, func(g string) bool { return cloudy.ArrayIncludes(childIds, g) })
+	_, err = p.cfg.Groups.Save(ctx, rec)
+	return err
+}
+
+// GetNestedGroups returns the groups directly nested in the group
+func (p *DatastoreIdentityProvider) GetNestedGroups(ctx context.Context, groupId string) ([]*models.Group, error) {
+	rec, err := p.mustGetGroup(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	rtn := make([]*models.Group, 0, len(rec.Groups))
+	for _, childId := range rec.Groups {
+		child, err := p.cfg.Groups.Get(ctx, childId)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			grp := child.Group
+			rtn = append(rtn, &grp)
+		}
+	}
+	return rtn, nil
+}
+
+// containsGroup reports whether the group is, or contains, the target
+func (p *DatastoreIdentityProvider) containsGroup(ctx context.Context, groupId string, target string) (bool, error) {
+	visited := make(map[string]bool)
+	pending := []string{groupId}
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+		if id == target {
+			return true, nil
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		g, err := p.cfg.Groups.Get(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if g != nil {
+			pending = append(pending, g.Groups...)
+		}
+	}
+	return false, nil
+}
+
+// DeleteGroup removes the group and its nesting in other groups
+func (p *DatastoreIdentityProvider) DeleteGroup(ctx context.Context, groupId string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.mustGetGroup(ctx, groupId); err != nil {
+		return err
+	}
+	all, err := p.cfg.Groups.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, g := range all {
+		if cloudy.ArrayIncludes(g.Groups, groupId) {
+			g.Groups = cloudy.ArrayRemoveAll(g.Groups, func(id string) bool { return id == groupId })
+			if _, err := p.cfg.Groups.Save(ctx, g); err != nil {
+				return err
+			}
+		}
+	}
+	return p.cfg.Groups.Delete(ctx, groupId)
+}
@@ -0,0 +1,258 @@
+package identity
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/datastore"
+	"github.com/appliedres/cloudy/models"
+	"github.com/appliedres/cloudy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newDatastoreProvider(t *testing.T, configure ...func(cfg *DatastoreIdentityConfig)) *DatastoreIdentityProvider {
+	cfg := NewDatastoreIdentityConfig(
+		NewUserDatatype(datastore.NewInMemoryTypedStore[UserRecord]()),
+		NewGroupDatatype(datastore.NewInMemoryTypedStore[GroupRecord]()),
+		NewAvatarDatatype(datastore.NewInMemoryTypedStore[AvatarRecord]()),
+	)
+	// Keep the tests fast
+	cfg.Hasher = &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	p, err := NewDatastoreIdentityProvider(cfg)
+	require.Nil(t, err)
+	return p
+}
+
+func TestDatastoreIdentitySuites(t *testing.T) {
+	t.Setenv("USER_DOMAIN", "example.com")
+	p := newDatastoreProvider(t, func(cfg *DatastoreIdentityConfig) {
+		cfg.Domains = []string{"example.com"}
+	})
+
+	testutil.TestUserManager(t, p)
+	testutil.TestGroupManager(t, p, p)
+}
+
+func TestDatastoreIdentityPasswords(t *testing.T) {
+	ctx := context.Background()
+	p := newDatastoreProvider(t)
+
+	u, err := p.NewUser(ctx, &models.User{Username: "jane", Email: "jane@example.com"})
+	require.Nil(t, err)
+
+	assert.ErrorIs(t, p.SetUserPassword(ctx, u.UID, "weak", false), cloudy.ErrInvalidPassword)
+	require.Nil(t, p.SetUserPassword(ctx, "jane", "Secret!123", true))
+
+	rec, err := p.cfg.Users.Get(ctx, u.UID)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(rec.PasswordHash, "$argon2id$"))
+	assert.NotContains(t, rec.PasswordHash, "Secret!123")
+
+	mustChange, err := p.MustChangePassword(ctx, u.UID)
+	require.Nil(t, err)
+	assert.True(t, mustChange)
+
+	_, err = p.Authenticate(ctx, "jane@example.com", "Secret!123")
+	assert.Nil(t, err)
+	_, err = p.Authenticate(ctx, "jane", "Secret!124")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	require.Nil(t, p.Disable(ctx, "jane"))
+	_, err = p.Authenticate(ctx, "jane", "Secret!123")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestDatastoreIdentityBcrypt(t *testing.T) {
+	ctx := context.Background()
+	p := newDatastoreProvider(t, func(cfg *DatastoreIdentityConfig) {
+		cfg.Hasher = &BcryptHasher{Cost: bcrypt.MinCost}
+	})
+
+	_, err := p.NewUser(ctx, &models.User{Username: "jane"})
+	require.Nil(t, err)
+	require.Nil(t, p.SetUserPassword(ctx, "jane", "Secret!123", false))
+
+	rec, err := p.findUser(ctx, "jane")
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(rec.PasswordHash, "$2a$"))
+
+	_, err = p.Authenticate(ctx, "jane", "Secret!123")
+	assert.Nil(t, err)
+}
+
+func TestDatastoreIdentityResetToken(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := newDatastoreProvider(t)
+	p.now = func() time.Time { return now }
+
+	_, err := p.NewUser(ctx, &models.User{Username: "jane"})
+	require.Nil(t, err)
+	require.Nil(t, p.SetUserPassword(ctx, "jane", "Secret!123", true))
+
+	token, err := p.CreatePasswordResetToken(ctx, "jane")
+	require.Nil(t, err)
+
+	rec, _ := p.findUser(ctx, "jane")
+	assert.NotContains(t, token, rec.ResetTokenHash, "only the hash is stored")
+
+	assert.ErrorIs(t, p.ResetPassword(ctx, token+"x", "Changed!456"), ErrInvalidResetToken)
+	assert.ErrorIs(t, p.ResetPassword(ctx, "garbage", "Changed!456"), ErrInvalidResetToken)
+	assert.ErrorIs(t, p.ResetPassword(ctx, token, "weak"), cloudy.ErrInvalidPassword)
+
+	require.Nil(t, p.ResetPassword(ctx, token, "Changed!456"))
+	_, err = p.Authenticate(ctx, "jane", "Changed!456")
+	assert.Nil(t, err)
+	mustChange, _ := p.MustChangePassword(ctx, "jane")
+	assert.False(t, mustChange)
+
+	// Tokens can only be used once
+	assert.ErrorIs(t, p.ResetPassword(ctx, token, "Again!789"), ErrInvalidResetToken)
+
+	expired, err := p.CreatePasswordResetToken(ctx, "jane")
+	require.Nil(t, err)
+	now = now.Add(time.Hour + time.Second)
+	assert.ErrorIs(t, p.ResetPassword(ctx, expired, "Again!789"), ErrResetTokenExpired)
+}
+
+func TestDatastoreIdentityUsernames(t *testing.T) {
+	ctx := context.Background()
+	p := newDatastoreProvider(t, func(cfg *DatastoreIdentityConfig) {
+		cfg.UserDomain = "example.com"
+	})
+
+	u1, err := p.NewUser(ctx, &models.User{FirstName: "Jane", LastName: "Doe"})
+	require.Nil(t, err)
+	assert.Equal(t, "jane.doe@example.com", u1.Username)
+
+	u2, err := p.NewUser(ctx, &models.User{FirstName: "Jane", LastName: "Doe"})
+	require.Nil(t, err)
+	assert.Equal(t, "jane.doe.2@example.com", u2.Username)
+
+	_, err = p.NewUser(ctx, &models.User{FirstName: "Jane"})
+	assert.ErrorIs(t, err, ErrMissingUserIdentity)
+
+	name, exists, err := p.ForceUserName(ctx, " Jane.Doe ")
+	require.Nil(t, err)
+	assert.Equal(t, "jane.doe@example.com", name)
+	assert.True(t, exists)
+
+	name, exists, err = p.ForceUserName(ctx, "bob@other.com")
+	require.Nil(t, err)
+	assert.Equal(t, "bob@other.com", name)
+	assert.False(t, exists)
+
+	flast := newDatastoreProvider(t, func(cfg *DatastoreIdentityConfig) {
+		cfg.UsernameStrategy = "flast"
+	})
+	u3, err := flast.NewUser(ctx, &models.User{FirstName: "Jane", LastName: "Doe"})
+	require.Nil(t, err)
+	assert.Equal(t, "jdoe", u3.Username)
+}
+
+func TestDatastoreIdentityNestedGroups(t *testing.T) {
+	ctx := context.Background()
+	p := newDatastoreProvider(t)
+
+	jane, err := p.NewUser(ctx, &models.User{Username: "jane"})
+	require.Nil(t, err)
+	bob, err := p.NewUser(ctx, &models.User{Username: "bob"})
+	require.Nil(t, err)
+
+	staff, err := p.NewGroup(ctx, &models.Group{Name: "staff"})
+	require.Nil(t, err)
+	eng, err := p.NewGroup(ctx, &models.Group{Name: "engineering"})
+	require.Nil(t, err)
+	backend, err := p.NewGroup(ctx, &models.Group{Name: "backend"})
+	require.Nil(t, err)
+
+	require.Nil(t, p.AddMembers(ctx, staff.ID, []string{"bob"}))
+	require.Nil(t, p.AddMembers(ctx, backend.ID, []string{"jane", "bob"}))
+	require.Nil(t, p.AddGroups(ctx, staff.ID, []string{eng.ID}))
+	require.Nil(t, p.AddGroups(ctx, eng.ID, []string{backend.ID}))
+
+	assert.ErrorIs(t, p.AddGroups(ctx, backend.ID, []string{staff.ID}), ErrGroupCycle)
+	assert.ErrorIs(t, p.AddGroups(ctx, staff.ID, []string{staff.ID}), ErrGroupCycle)
+
+	members, err := p.GetGroupMembers(ctx, staff.ID)
+	require.Nil(t, err)
+	require.Len(t, members, 2, "users are listed once")
+	assert.Equal(t, bob.UID, members[0].UID)
+	assert.Equal(t, jane.UID, members[1].UID)
+
+	groups, err := p.GetUserGroups(ctx, "jane")
+	require.Nil(t, err)
+	require.Len(t, groups, 3)
+	assert.Equal(t, "backend", groups[0].Name)
+	assert.Equal(t, "engineering", groups[1].Name)
+	assert.Equal(t, "staff", groups[2].Name)
+
+	nested, err := p.GetNestedGroups(ctx, staff.ID)
+	require.Nil(t, err)
+	require.Len(t, nested, 1)
+	assert.Equal(t, eng.ID, nested[0].ID)
+
+	// Deleting a group removes it from the groups containing it
+	require.Nil(t, p.DeleteGroup(ctx, eng.ID))
+	members, err = p.GetGroupMembers(ctx, staff.ID)
+	require.Nil(t, err)
+	assert.Len(t, members, 1)
+
+	require.Nil(t, p.DeleteUser(ctx, "bob"))
+	members, err = p.GetGroupMembers(ctx, staff.ID)
+	require.Nil(t, err)
+	assert.Empty(t, members)
+}
+
+func TestDatastoreIdentityAvatars(t *testing.T) {
+	ctx := context.Background()
+	p := newDatastoreProvider(t)
+
+	_, err := p.NewUser(ctx, &models.User{Username: "jane"})
+	require.Nil(t, err)
+
+	pic, err := p.GetProfilePicture(ctx, "jane")
+	require.Nil(t, err)
+	assert.Nil(t, pic)
+
+	require.Nil(t, p.UploadProfilePicture(ctx, "jane", []byte("picture")))
+	pic, err = p.GetProfilePicture(ctx, "jane")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("picture"), pic)
+
+	_, err = p.GetProfilePicture(ctx, "nobody")
+	assert.ErrorIs(t, err, cloudy.ErrUserNotFound)
+
+	noAvatars := newDatastoreProvider(t, func(cfg *DatastoreIdentityConfig) { cfg.Avatars = nil })
+	_, err = noAvatars.GetProfilePicture(ctx, "jane")
+	assert.ErrorIs(t, err, cloudy.ErrOperationNotImplemented)
+}
+
+func TestDatastoreIdentityListUsers(t *testing.T) {
+	ctx := context.Background()
+	p := newDatastoreProvider(t)
+
+	_, err := p.NewUser(ctx, &models.User{Username: "jane", Attributes: map[string]string{"department": "eng"}})
+	require.Nil(t, err)
+	_, err = p.NewUser(ctx, &models.User{Username: "bob"})
+	require.Nil(t, err)
+	require.Nil(t, p.Disable(ctx, "bob"))
+
+	users, err := p.ListUsers(ctx, `enabled eq false`, nil)
+	require.Nil(t, err)
+	require.Len(t, *users, 1)
+	assert.Equal(t, "bob", (*users)[0].Username)
+
+	users, err = p.ListUsers(ctx, `attributes.department pr`, nil)
+	require.Nil(t, err)
+	require.Len(t, *users, 1)
+	assert.Equal(t, "jane", (*users)[0].Username)
+}
@@ -0,0 +1,99 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+)
+
+var (
+	ErrDomainNotAllowed    = errors.New("email domain is not allowed")
+	ErrInvalidCredentials  = errors.New("invalid user name or password")
+	ErrMissingUserIdentity = errors.New("user needs a username or an email")
+	ErrMissingGroupName    = errors.New("group needs a name")
+)
+
+// PasswordAuthenticator checks the passwords the identity providers in this
+// package store
+type PasswordAuthenticator interface {
+	// Authenticate returns the enabled user with the password, ErrInvalidCredentials otherwise
+	Authenticate(ctx context.Context, uid string, pwd string) (*models.User, error)
+
+	// MustChangePassword reports whether the password was set with mustChange
+	MustChangePassword(ctx context.Context, uid string) (bool, error)
+}
+
+// PasswordSettings is the password policy shared by the identity provider configurations
+type PasswordSettings struct {
+	PasswordMinLength   int  `config:"password_min_length,default=8" description:"Minimum password length"`
+	PasswordSpecialChar bool `config:"password_special_char,default=true" description:"Passwords need a special character"`
+}
+
+// DefaultPasswordSettings returns the policy the configuration defaults to
+func DefaultPasswordSettings() PasswordSettings {
+	return PasswordSettings{PasswordMinLength: 8, PasswordSpecialChar: true}
+}
+
+// PasswordPolicy returns the options passwords are checked with
+func (s PasswordSettings) PasswordPolicy() cloudy.PasswordOptions {
+	return cloudy.PasswordOptions{
+		Length:         s.PasswordMinLength,
+		HasUpperCase:   true,
+		HasNum:         true,
+		HasSpecialChar: s.PasswordSpecialChar,
+	}
+}
+
+// CheckPassword returns cloudy.ErrInvalidPassword when the password does not meet the policy
+func (s PasswordSettings) CheckPassword(pwd string) error {
+	policy := s.PasswordPolicy()
+	if len(pwd) < policy.Length || !cloudy.IsValidPasswordWithOptions(pwd, policy) {
+		return cloudy.ErrInvalidPassword
+	}
+	return nil
+}
+
+// groupChanged reports whether an update changes any stored field of the group
+func groupChanged(old *models.Group, grp *models.Group) bool {
+	return old.Name != grp.Name || old.Source != grp.Source ||
+		old.Type != grp.Type || fmt.Sprint(old.Extra) != fmt.Sprint(grp.Extra)
+}
+
+// checkEmailDomain makes sure the email is in one of the domains, when there are any
+func checkEmailDomain(domains []string, email string) error {
+	if len(domains) == 0 || email == "" {
+		return nil
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	for _, d := range domains {
+		if strings.EqualFold(domain, d) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDomainNotAllowed, domain)
+}
+
+func copyUser(u *models.User) *models.User {
+	rtn := *u
+	if u.Attributes != nil {
+		rtn.Attributes = make(map[string]string, len(u.Attributes))
+		for k, v := range u.Attributes {
+			rtn.Attributes[k] = v
+		}
+	}
+	return &rtn
+}
+
+func sortUsers(users []models.User) {
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Username != users[j].Username {
+			return users[i].Username < users[j].Username
+		}
+		return users[i].UID < users[j].UID
+	})
+}
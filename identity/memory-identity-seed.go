@@ -0,0 +1,102 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/appliedres/cloudy/models"
+)
+
+// MemoryIdentitySeed is the content of a seed file:
+//
+//	{
+//	  "users": [
+//	    {"uid": "1", "username": "jane", "email": "jane@example.com", "password": "Secret!123"},
+//	    {"username": "bob", "email": "bob@example.com", "disabled": true}
+//	  ],
+//	  "groups": [
+//	    {"Name": "admins", "members": ["jane"]}
+//	  ]
+//	}
+//
+// Avatars are base64 encoded. Members can be referred to by UID, username or email.
+type MemoryIdentitySeed struct {
+	Users  []*SeedUser  `json:"users"`
+	Groups []*SeedGroup `json:"groups"`
+}
+
+type SeedUser struct {
+	models.User
+	Password           string `json:"password,omitempty"`
+	MustChangePassword bool   `json:"mustChangePassword,omitempty"`
+	Disabled           bool   `json:"disabled,omitempty"`
+	Avatar             []byte `json:"avatar,omitempty"`
+}
+
+type SeedGroup struct {
+	models.Group
+	Members []string `json:"members,omitempty"`
+}
+
+// SeedFile adds the users and groups of a JSON seed file
+func (p *MemoryIdentityProvider) SeedFile(ctx context.Context, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := p.SeedFrom(ctx, f); err != nil {
+		return fmt.Errorf("seed %v: %w", filename, err)
+	}
+	return nil
+}
+
+// SeedFrom adds the users and groups of a JSON seed
+func (p *MemoryIdentityProvider) SeedFrom(ctx context.Context, r io.Reader) error {
+	seed := &MemoryIdentitySeed{}
+	if err := json.NewDecoder(r).Decode(seed); err != nil {
+		return err
+	}
+	return p.Seed(ctx, seed)
+}
+
+// Seed adds the users and groups. Users go through the same checks as NewUser
+// and SetUserPassword.
+func (p *MemoryIdentityProvider) Seed(ctx context.Context, seed *MemoryIdentitySeed) error {
+	for _, su := range seed.Users {
+		u, err := p.NewUser(ctx, &su.User)
+		if err != nil {
+			return err
+		}
+		if su.Password != "" {
+			if err := p.SetUserPassword(ctx, u.UID, su.Password, su.MustChangePassword); err != nil {
+				return fmt.Errorf("user %v: %w", u.UID, err)
+			}
+		}
+		if len(su.Avatar) > 0 {
+			if err := p.UploadProfilePicture(ctx, u.UID, su.Avatar); err != nil {
+				return err
+			}
+		}
+		if su.Disabled {
+			if err := p.Disable(ctx, u.UID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, sg := range seed.Groups {
+		g, err := p.NewGroup(ctx, &sg.Group)
+		if err != nil {
+			return err
+		}
+		if err := p.AddMembers(ctx, g.ID, sg.Members); err != nil {
+			return fmt.Errorf("group %v: %w", g.Name, err)
+		}
+	}
+	return nil
+}
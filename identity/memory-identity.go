@@ -0,0 +1,793 @@
+package identity
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+	"github.com/google/uuid"
+)
+
+const MemoryIdentityID = "memory"
+
+var (
+	ErrNoMailer = errors.New("no mailer configured for invitations")
+)
+
+func init() {
+	cloudy.UserProviders.Register2(MemoryIdentityID, &MemoryIdentityFactory[cloudy.UserManager]{})
+	cloudy.GroupProviders.Register2(MemoryIdentityID, &MemoryIdentityFactory[cloudy.GroupManager]{})
+	cloudy.InviteProviders.Register2(MemoryIdentityID, &MemoryIdentityFactory[cloudy.InviteManager]{})
+}
+
+var (
+	_ cloudy.UserManager          = (*MemoryIdentityProvider)(nil)
+	_ cloudy.GroupManager         = (*MemoryIdentityProvider)(nil)
+	_ cloudy.StandardFilterLister = (*MemoryIdentityProvider)(nil)
+	_ cloudy.AvatarManager        = (*MemoryIdentityProvider)(nil)
+	_ cloudy.InviteManager        = (*MemoryIdentityProvider)(nil)
+	_ PasswordAuthenticator       = (*MemoryIdentityProvider)(nil)
+)
+
+// DefaultInviteEmail is sent for invitations when no InviteEmail is configured.
+// The templates get the Invitation.
+var DefaultInviteEmail = &cloudy.TemplatedEmail{
+	SubjectTemplate: template.Must(template.New("subject").Parse("You have been invited")),
+	BodyTemplate: template.Must(template.New("body").Parse(
+		"Hello {{.User.DisplayName}},\n\nYou have been invited. Accept the invitation at {{.RedirectURL}}\n")),
+}
+
+// MemoryIdentityConfig configures a MemoryIdentityProvider
+type MemoryIdentityConfig struct {
+	// Name identifies a shared provider, the user, group and invite drivers
+	// created with the same name see the same users
+	Name string `config:"name,default=default" description:"Name of the shared identity store"`
+
+	// SeedFile is a JSON file with the initial users and groups
+	SeedFile string `config:"seed_file" description:"JSON file with the initial users and groups"`
+
+	// Domains limits the email domains of new users, any domain is allowed when empty
+	Domains []string `config:"user_domains" description:"Allowed email domains of new users"`
+
+	PasswordSettings
+
+	// InviteFrom is the sender of invitation emails
+	InviteFrom string `config:"invite_from" description:"Sender of invitation emails"`
+
+	// Mailer sends the invitation emails, defaults to cloudy.DefaultEmailer
+	Mailer *cloudy.Mailer `config:"-"`
+
+	// InviteEmail is the invitation email, defaults to DefaultInviteEmail
+	InviteEmail *cloudy.TemplatedEmail `config:"-"`
+}
+
+// MemoryIdentityFactory creates the shared MemoryIdentityProvider named in the
+// configuration as any of the interfaces it implements
+type MemoryIdentityFactory[T any] struct{}
+
+func (f *MemoryIdentityFactory[T]) NewConfig() interface{} {
+	return &MemoryIdentityConfig{PasswordSettings: DefaultPasswordSettings()}
+}
+
+func (f *MemoryIdentityFactory[T]) New(ctx context.Context, cfg interface{}) (T, error) {
+	var zero T
+	config, ok := cfg.(*MemoryIdentityConfig)
+	if !ok || config == nil {
+		return zero, cloudy.ErrInvalidConfiguration
+	}
+	p, err := OpenMemoryIdentity(ctx, config)
+	if err != nil {
+		return zero, err
+	}
+	return any(p).(T), nil
+}
+
+var (
+	sharedMu         sync.Mutex
+	sharedIdentities = make(map[string]*MemoryIdentityProvider)
+)
+
+// OpenMemoryIdentity returns the shared provider with the configured name,
+// creating and seeding it the first time
+func OpenMemoryIdentity(ctx context.Context, cfg *MemoryIdentityConfig) (*MemoryIdentityProvider, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	if p, ok := sharedIdentities[cfg.Name]; ok {
+		return p, nil
+	}
+	p, err := NewMemoryIdentityProvider(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	sharedIdentities[cfg.Name] = p
+	return p, nil
+}
+
+// CloseMemoryIdentity forgets the shared provider with the name
+func CloseMemoryIdentity(name string) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	delete(sharedIdentities, name)
+}
+
+// Invitation is an invitation created by CreateInvitation
+type Invitation struct {
+	User        *models.User
+	RedirectURL string
+	Emailed     bool
+	Created     time.Time
+
+	// SendError is why the invitation email could not be sent
+	SendError string
+}
+
+type memoryUser struct {
+	user               models.User
+	password           string
+	mustChangePassword bool
+	avatar             []byte
+}
+
+type memoryGroup struct {
+	group   models.Group
+	members []string // UIDs
+}
+
+// MemoryIdentityProvider is an in-memory identity provider. It implements the
+// UserManager, GroupManager, AvatarManager and InviteManager interfaces over the
+// same users, so it can stand in for a real identity provider in tests and demos.
+//
+// Users can be referred to by UID, username or email. Enabling and disabling
+// users goes through Enable / Disable, UpdateUser keeps the enabled state.
+type MemoryIdentityProvider struct {
+	cfg *MemoryIdentityConfig
+
+	mu          sync.RWMutex
+	users       map[string]*memoryUser
+	groups      map[string]*memoryGroup
+	invitations []*Invitation
+
+	// outbox holds the invitation emails not sent yet, outboxDone is closed
+	// when the running sender finds it empty
+	outbox     []*Invitation
+	outboxDone chan struct{}
+}
+
+// NewMemoryIdentityProvider creates a provider that is not shared, seeded from
+// the configured seed file
+func NewMemoryIdentityProvider(ctx context.Context, cfg *MemoryIdentityConfig) (*MemoryIdentityProvider, error) {
+	if cfg == nil {
+		cfg = (&MemoryIdentityFactory[any]{}).NewConfig().(*MemoryIdentityConfig)
+	}
+	p := &MemoryIdentityProvider{
+		cfg:    cfg,
+		users:  make(map[string]*memoryUser),
+		groups: make(map[string]*memoryGroup),
+	}
+	if cfg.SeedFile != "" {
+		if err := p.SeedFile(ctx, cfg.SeedFile); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// findUserLocked looks a user up by UID, username or email
+func (p *MemoryIdentityProvider) findUserLocked(id string) *memoryUser {
+	if u, ok := p.users[id]; ok {
+		return u
+	}
+	if id == "" {
+		return nil
+	}
+	for _, u := range p.users {
+		if strings.EqualFold(u.user.Username, id) || strings.EqualFold(u.user.Email, id) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (p *MemoryIdentityProvider) checkDomain(email string) error {
+	return checkEmailDomain(p.cfg.Domains, email)
+}
+
+// checkUniqueLocked makes sure no other user has the username or email
+func (p *MemoryIdentityProvider) checkUniqueLocked(usr *models.User) error {
+	for uid, u := range p.users {
+		if uid == usr.UID {
+			continue
+		}
+		if usr.Username != "" && strings.EqualFold(u.user.Username, usr.Username) {
+			return fmt.Errorf("%w: %s", cloudy.ErrUserExists, usr.Username)
+		}
+		if usr.Email != "" && strings.EqualFold(u.user.Email, usr.Email) {
+			return fmt.Errorf("%w: %s", cloudy.ErrUserExists, usr.Email)
+		}
+	}
+	return nil
+}
+
+func (p *MemoryIdentityProvider) ForceUserName(ctx context.Context, name string) (string, bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return name, p.findUserLocked(name) != nil, nil
+}
+
+// UsesStandardFilters implements cloudy.StandardFilterLister
+func (p *MemoryIdentityProvider) UsesStandardFilters() {}
+
+func (p *MemoryIdentityProvider) ListUsers(ctx context.Context, filter string, attrs []string) (*[]models.User, error) {
+	f, err := cloudy.ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rtn := []models.User{}
+	for _, u := range p.users {
+		if f.MatchUser(&u.user) {
+			rtn = append(rtn, *cloudy.FilterUserAttributes(copyUser(&u.user), attrs))
+		}
+	}
+	sortUsers(rtn)
+	return &rtn, nil
+}
+
+// GetUser returns the user with the UID, username or email, nil when there is none
+func (p *MemoryIdentityProvider) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return nil, nil
+	}
+	return copyUser(&u.user), nil
+}
+
+func (p *MemoryIdentityProvider) GetUserByEmail(ctx context.Context, email string, opts *cloudy.UserOptions) (*models.User, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, u := range p.users {
+		if strings.EqualFold(u.user.Email, email) {
+			return copyUser(&u.user), nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *MemoryIdentityProvider) GetUserWithAttributes(ctx context.Context, uid string, attrs []string) (*models.User, error) {
+	u, err := p.GetUser(ctx, uid)
+	if u == nil || err != nil {
+		return u, err
+	}
+	return cloudy.FilterUserAttributes(u, attrs), nil
+}
+
+// NewUser adds an enabled user. A UID is generated when it is empty.
+func (p *MemoryIdentityProvider) NewUser(ctx context.Context, newUser *models.User) (*models.User, error) {
+	if err := p.checkDomain(newUser.Email); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.newUserLocked(newUser)
+}
+
+func (p *MemoryIdentityProvider) newUserLocked(newUser *models.User) (*models.User, error) {
+	if newUser.Username == "" && newUser.Email == "" {
+		return nil, ErrMissingUserIdentity
+	}
+
+	usr := copyUser(newUser)
+	if usr.UID == "" {
+		usr.UID = uuid.NewString()
+	}
+	if _, exists := p.users[usr.UID]; exists {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrUserExists, usr.UID)
+	}
+	if err := p.checkUniqueLocked(usr); err != nil {
+		return nil, err
+	}
+	usr.Enabled = true
+
+	p.users[usr.UID] = &memoryUser{user: *usr}
+	return copyUser(usr), nil
+}
+
+// UpdateUser replaces the user's details, the enabled state is not changed
+func (p *MemoryIdentityProvider) UpdateUser(ctx context.Context, usr *models.User) error {
+	if err := p.checkDomain(usr.Email); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := p.findUserLocked(usr.UID)
+	if existing == nil {
+		return fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, usr.UID)
+	}
+	updated := copyUser(usr)
+	updated.UID = existing.user.UID
+	updated.Enabled = existing.user.Enabled
+	if err := p.checkUniqueLocked(updated); err != nil {
+		return err
+	}
+	existing.user = *updated
+	return nil
+}
+
+func (p *MemoryIdentityProvider) setEnabled(uid string, enabled bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	u.user.Enabled = enabled
+	return nil
+}
+
+func (p *MemoryIdentityProvider) Enable(ctx context.Context, uid string) error {
+	return p.setEnabled(uid, true)
+}
+
+func (p *MemoryIdentityProvider) Disable(ctx context.Context, uid string) error {
+	return p.setEnabled(uid, false)
+}
+
+// DeleteUser removes the user, its group memberships and its avatar
+func (p *MemoryIdentityProvider) DeleteUser(ctx context.Context, uid string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	delete(p.users, u.user.UID)
+	for _, g := range p.groups {
+		g.members = cloudy.ArrayRemoveAll(g.members, func(m string) bool { return m == u.user.UID })
+	}
+	return nil
+}
+
+// SetUserPassword sets the password after checking it against the password policy
+func (p *MemoryIdentityProvider) SetUserPassword(ctx context.Context, uid string, pwd string, mustChange bool) error {
+	if err := p.cfg.CheckPassword(pwd); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	u.password = pwd
+	u.mustChangePassword = mustChange
+	return nil
+}
+
+// Authenticate implements PasswordAuthenticator
+func (p *MemoryIdentityProvider) Authenticate(ctx context.Context, uid string, pwd string) (*models.User, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil || !u.user.Enabled || u.password == "" ||
+		subtle.ConstantTimeCompare([]byte(u.password), []byte(pwd)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return copyUser(&u.user), nil
+}
+
+// MustChangePassword implements PasswordAuthenticator
+func (p *MemoryIdentityProvider) MustChangePassword(ctx context.Context, uid string) (bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return false, fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	return u.mustChangePassword, nil
+}
+
+// GetProfilePicture implements cloudy.AvatarManager
+func (p *MemoryIdentityProvider) GetProfilePicture(ctx context.Context, uid string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	if u.avatar == nil {
+		return nil, nil
+	}
+	return append([]byte(nil), u.avatar...), nil
+}
+
+// UploadProfilePicture implements cloudy.AvatarManager
+func (p *MemoryIdentityProvider) UploadProfilePicture(ctx context.Context, uid string, picture []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	if len(picture) == 0 {
+		u.avatar = nil
+		return nil
+	}
+	u.avatar = append([]byte(nil), picture...)
+	return nil
+}
+
+func (p *MemoryIdentityProvider) ListGroups(ctx context.Context, filter string, attrs []string) (*[]models.Group, error) {
+	f, err := cloudy.ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rtn := []models.Group{}
+	for _, g := range p.groups {
+		if f.MatchGroup(&g.group) {
+			rtn = append(rtn, g.group)
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool { return rtn[i].Name < rtn[j].Name })
+	return &rtn, nil
+}
+
+func (p *MemoryIdentityProvider) GetGroup(ctx context.Context, id string) (*models.Group, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	g, ok := p.groups[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, id)
+	}
+	grp := g.group
+	return &grp, nil
+}
+
+func (p *MemoryIdentityProvider) GetGroupId(ctx context.Context, name string) (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if g := p.findGroupByNameLocked(name); g != nil {
+		return g.group.ID, nil
+	}
+	return "", fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, name)
+}
+
+func (p *MemoryIdentityProvider) findGroupByNameLocked(name string) *memoryGroup {
+	for _, g := range p.groups {
+		if strings.EqualFold(g.group.Name, name) {
+			return g
+		}
+	}
+	return nil
+}
+
+func (p *MemoryIdentityProvider) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	u := p.findUserLocked(uid)
+	if u == nil {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	rtn := []*models.Group{}
+	for _, g := range p.groups {
+		if cloudy.ArrayIncludes(g.members, u.user.UID) {
+			grp := g.group
+			rtn = append(rtn, &grp)
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool { return rtn[i].Name < rtn[j].Name })
+	return rtn, nil
+}
+
+// NewGroup adds a group, an ID is generated when it is empty. Names are unique.
+func (p *MemoryIdentityProvider) NewGroup(ctx context.Context, grp *models.Group) (*models.Group, error) {
+	if grp.Name == "" {
+		return nil, ErrMissingGroupName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g := *grp
+	if g.ID == "" {
+		g.ID = uuid.NewString()
+	}
+	if _, exists := p.groups[g.ID]; exists {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrGroupExists, g.ID)
+	}
+	if p.findGroupByNameLocked(g.Name) != nil {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrGroupExists, g.Name)
+	}
+	p.groups[g.ID] = &memoryGroup{group: g}
+	return &g, nil
+}
+
+// UpdateGroup replaces the group's details and reports whether anything changed
+func (p *MemoryIdentityProvider) UpdateGroup(ctx context.Context, grp *models.Group) (bool, error) {
+	if grp.Name == "" {
+		return false, ErrMissingGroupName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g, ok := p.groups[grp.ID]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, grp.ID)
+	}
+	if other := p.findGroupByNameLocked(grp.Name); other != nil && other != g {
+		return false, fmt.Errorf("%w: %s", cloudy.ErrGroupExists, grp.Name)
+	}
+	changed := groupChanged(&g.group, grp)
+	g.group = *grp
+	return changed, nil
+}
+
+func (p *MemoryIdentityProvider) GetGroupMembers(ctx context.Context, grpId string) ([]*models.User, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	g, ok := p.groups[grpId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, grpId)
+	}
+	rtn := make([]*models.User, 0, len(g.members))
+	for _, uid := range g.members {
+		if u, ok := p.users[uid]; ok {
+			rtn = append(rtn, copyUser(&u.user))
+		}
+	}
+	return rtn, nil
+}
+
+// resolveUsersLocked maps user references to UIDs, failing on the first unknown user
+func (p *MemoryIdentityProvider) resolveUsersLocked(userIds []string) ([]string, error) {
+	uids := make([]string, 0, len(userIds))
+	for _, id := range userIds {
+		u := p.findUserLocked(id)
+		if u == nil {
+			return nil, fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, id)
+		}
+		uids = append(uids, u.user.UID)
+	}
+	return uids, nil
+}
+
+// AddMembers adds the users to the group. Nothing is added when a user is unknown.
+func (p *MemoryIdentityProvider) AddMembers(ctx context.Context, groupId string, userIds []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g, ok := p.groups[groupId]
+	if !ok {
+		return fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, groupId)
+	}
+	uids, err := p.resolveUsersLocked(userIds)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if !cloudy.ArrayIncludes(g.members, uid) {
+			g.members = append(g.members, uid)
+		}
+	}
+	return nil
+}
+
+// RemoveMembers removes the users from the group. Nothing is removed when a user is unknown.
+func (p *MemoryIdentityProvider) RemoveMembers(ctx context.Context, groupId string, userIds []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g, ok := p.groups[groupId]
+	if !ok {
+		return fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, groupId)
+	}
+	uids, err := p.resolveUsersLocked(userIds)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		g.members = cloudy.ArrayRemoveAll(g.members, func(m string) bool { return m == uid })
+	}
+	return nil
+}
+
+func (p *MemoryIdentityProvider) DeleteGroup(ctx context.Context, groupId string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.groups[groupId]; !ok {
+		return fmt.Errorf("%w: %s", cloudy.ErrGroupNotFound, groupId)
+	}
+	delete(p.groups, groupId)
+	return nil
+}
+
+// CreateInvitation adds the user when it does not exist yet and records the
+// invitation. Invited users are usually external, so the allowed domains are
+// not checked. With emailInvite the invitation email is queued and sent
+// through the Mailer in the background, a failed send is recorded on the
+// invitation. FlushInvitations waits for the queue.
+func (p *MemoryIdentityProvider) CreateInvitation(ctx context.Context, user *models.User, emailInvite bool, inviteRedirectUrl string) error {
+	if user == nil {
+		return ErrMissingUserIdentity
+	}
+	if emailInvite && p.mailer() == nil {
+		return ErrNoMailer
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	invited := p.findUserLocked(user.UID)
+	if invited == nil {
+		invited = p.findUserLocked(user.Email)
+	}
+	if emailInvite {
+		email := user.Email
+		if invited != nil {
+			email = invited.user.Email
+		}
+		if email == "" {
+			return fmt.Errorf("%w: invitation emails need an email", ErrMissingUserIdentity)
+		}
+	}
+	var usr *models.User
+	if invited != nil {
+		usr = copyUser(&invited.user)
+	} else {
+		created, err := p.newUserLocked(user)
+		if err != nil {
+			return err
+		}
+		usr = created
+	}
+	invitation := &Invitation{
+		User:        usr,
+		RedirectURL: inviteRedirectUrl,
+		Created:     time.Now(),
+	}
+	p.invitations = append(p.invitations, invitation)
+
+	if emailInvite {
+		p.queueInvitationLocked(context.WithoutCancel(ctx), invitation)
+	}
+	return nil
+}
+
+// queueInvitationLocked adds the invitation to the outbox, starting a sender
+// when none is running
+func (p *MemoryIdentityProvider) queueInvitationLocked(ctx context.Context, invitation *Invitation) {
+	p.outbox = append(p.outbox, invitation)
+	if p.outboxDone != nil {
+		return
+	}
+	done := make(chan struct{})
+	p.outboxDone = done
+	go func() {
+		defer close(done)
+		for {
+			p.mu.Lock()
+			if len(p.outbox) == 0 {
+				p.outboxDone = nil
+				p.mu.Unlock()
+				return
+			}
+			next := p.outbox[0]
+			p.outbox = p.outbox[1:]
+			p.mu.Unlock()
+
+			err := p.sendInvitation(ctx, next)
+			if err != nil {
+				cloudy.Warn(ctx, "Unable to send the invitation to %s: %v", next.User.UID, err)
+			}
+
+			p.mu.Lock()
+			next.Emailed = err == nil
+			next.SendError = ""
+			if err != nil {
+				next.SendError = err.Error()
+			}
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// FlushInvitations waits until the queued invitation emails are sent
+func (p *MemoryIdentityProvider) FlushInvitations(ctx context.Context) error {
+	for {
+		p.mu.RLock()
+		done := p.outboxDone
+		p.mu.RUnlock()
+		if done == nil {
+			return nil
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *MemoryIdentityProvider) mailer() *cloudy.Mailer {
+	if p.cfg.Mailer != nil {
+		return p.cfg.Mailer
+	}
+	return cloudy.DefaultEmailer
+}
+
+func (p *MemoryIdentityProvider) sendInvitation(ctx context.Context, invitation *Invitation) error {
+	mailer := p.mailer()
+	if mailer == nil {
+		return ErrNoMailer
+	}
+
+	tmpl := p.cfg.InviteEmail
+	if tmpl == nil {
+		tmpl = DefaultInviteEmail
+	}
+	body, subject, err := tmpl.RenderRaw(ctx, invitation)
+	if err != nil {
+		return err
+	}
+
+	from := tmpl.From
+	if p.cfg.InviteFrom != "" {
+		from = p.cfg.InviteFrom
+	}
+	return mailer.Send(ctx, &cloudy.Email{
+		To:      []string{invitation.User.Email},
+		From:    from,
+		Subject: subject,
+		Body:    body,
+		HTML:    tmpl.HTML,
+	})
+}
+
+// Invitations returns the invitations created so far
+func (p *MemoryIdentityProvider) Invitations() []*Invitation {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rtn := make([]*Invitation, len(p.invitations))
+	for i, inv := range p.invitations {
+		c := *inv
+		rtn[i] = &c
+	}
+	return rtn
+}
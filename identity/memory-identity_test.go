@@ -0,0 +1,326 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+	"github.com/appliedres/cloudy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/gomail.v2"
+)
+
+type capturingEmailer struct {
+	mu   sync.Mutex
+	sent []*gomail.Message
+}
+
+func (e *capturingEmailer) Send(ctx context.Context, message *gomail.Message) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sent = append(e.sent, message)
+	return nil
+}
+
+func newSeededProvider(t *testing.T) *MemoryIdentityProvider {
+	cfg := (&MemoryIdentityFactory[any]{}).NewConfig().(*MemoryIdentityConfig)
+	cfg.SeedFile = "testdata/seed.json"
+	p, err := NewMemoryIdentityProvider(context.Background(), cfg)
+	require.Nil(t, err)
+	return p
+}
+
+func TestMemoryIdentitySuites(t *testing.T) {
+	t.Setenv("USER_DOMAIN", "example.com")
+
+	cfg := (&MemoryIdentityFactory[any]{}).NewConfig().(*MemoryIdentityConfig)
+	cfg.Domains = []string{"example.com"}
+	p, err := NewMemoryIdentityProvider(context.Background(), cfg)
+	require.Nil(t, err)
+
+	testutil.TestUserManager(t, p)
+	testutil.TestGroupManager(t, p, p)
+}
+
+func TestMemoryIdentityDriver(t *testing.T) {
+	envSvc := cloudy.NewMapEnvironment()
+	envSvc.Set("IDP_DRIVER", MemoryIdentityID)
+	envSvc.Set("IDP_NAME", "driver-test")
+	envSvc.Set("IDP_SEED_FILE", "testdata/seed.json")
+	defer CloseMemoryIdentity("driver-test")
+
+	env := cloudy.NewEnvironment(cloudy.NewHierarchicalEnvironment(envSvc)).Segment("idp")
+	users, err := cloudy.UserProviders.NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+	groups, err := cloudy.GroupProviders.NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+
+	// Both drivers share the seeded users
+	ctx := context.Background()
+	_, err = users.NewUser(ctx, &models.User{Username: "carol", Email: "carol@example.com"})
+	require.Nil(t, err)
+	gid, err := groups.GetGroupId(ctx, "admins")
+	require.Nil(t, err)
+	require.Nil(t, groups.AddMembers(ctx, gid, []string{"carol"}))
+
+	members, err := groups.GetGroupMembers(ctx, gid)
+	require.Nil(t, err)
+	assert.Len(t, members, 2)
+}
+
+func TestMemoryIdentitySeed(t *testing.T) {
+	ctx := context.Background()
+	p := newSeededProvider(t)
+
+	jane, err := p.GetUser(ctx, "jane.doe@example.com")
+	require.Nil(t, err)
+	require.NotNil(t, jane)
+	assert.Equal(t, "u-jane", jane.UID)
+	assert.True(t, jane.Enabled)
+
+	bob, err := p.GetUser(ctx, "bob")
+	require.Nil(t, err)
+	assert.False(t, bob.Enabled)
+
+	avatar, err := p.GetProfilePicture(ctx, "u-jane")
+	require.Nil(t, err)
+	assert.NotEmpty(t, avatar)
+
+	groups, err := p.GetUserGroups(ctx, "u-jane")
+	require.Nil(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "admins", groups[0].Name)
+	assert.Equal(t, "everyone", groups[1].Name)
+
+	_, err = p.Authenticate(ctx, "jane.doe", "Secret!123")
+	assert.Nil(t, err)
+}
+
+func TestMemoryIdentityListUsers(t *testing.T) {
+	ctx := context.Background()
+	p := newSeededProvider(t)
+
+	all, err := p.ListUsers(ctx, "", nil)
+	require.Nil(t, err)
+	assert.Len(t, *all, 2)
+
+	enabled, err := p.ListUsers(ctx, `enabled eq true and email ew "@example.com"`, nil)
+	require.Nil(t, err)
+	require.Len(t, *enabled, 1)
+	assert.Equal(t, "jane.doe", (*enabled)[0].Username)
+
+	withAttrs, err := p.ListUsers(ctx, `attributes.department eq "Engineering"`, []string{"title"})
+	require.Nil(t, err)
+	require.Len(t, *withAttrs, 1)
+	assert.Empty(t, (*withAttrs)[0].Attributes)
+
+	_, err = p.ListUsers(ctx, `username like "x"`, nil)
+	assert.ErrorIs(t, err, cloudy.ErrInvalidFilter)
+
+	admins, err := p.ListGroups(ctx, `name eq "admins"`, nil)
+	require.Nil(t, err)
+	require.Len(t, *admins, 1)
+	assert.Equal(t, "g-admins", (*admins)[0].ID)
+}
+
+func TestMemoryIdentityPasswords(t *testing.T) {
+	ctx := context.Background()
+	p := newSeededProvider(t)
+
+	require.Nil(t, p.SetUserPassword(ctx, "bob", "short1A!", false))
+	assert.ErrorIs(t, p.SetUserPassword(ctx, "bob", "Sh0rt!", false), cloudy.ErrInvalidPassword)
+	assert.ErrorIs(t, p.SetUserPassword(ctx, "bob", "nouppercase1!", false), cloudy.ErrInvalidPassword)
+	assert.ErrorIs(t, p.SetUserPassword(ctx, "nobody", "Secret!123", false), cloudy.ErrUserNotFound)
+
+	require.Nil(t, p.SetUserPassword(ctx, "u-jane", "Changed!456", true))
+	mustChange, err := p.MustChangePassword(ctx, "u-jane")
+	require.Nil(t, err)
+	assert.True(t, mustChange)
+
+	_, err = p.Authenticate(ctx, "u-jane", "Secret!123")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = p.Authenticate(ctx, "u-jane", "Changed!456")
+	assert.Nil(t, err)
+
+	// Disabled users cannot sign in
+	require.Nil(t, p.Disable(ctx, "u-jane"))
+	_, err = p.Authenticate(ctx, "u-jane", "Changed!456")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestMemoryIdentityUsers(t *testing.T) {
+	ctx := context.Background()
+	p := newSeededProvider(t)
+
+	_, err := p.NewUser(ctx, &models.User{Username: "JANE.DOE"})
+	assert.ErrorIs(t, err, cloudy.ErrUserExists)
+	_, err = p.NewUser(ctx, &models.User{DisplayName: "No name"})
+	assert.ErrorIs(t, err, ErrMissingUserIdentity)
+
+	err = p.UpdateUser(ctx, &models.User{UID: "u-bob", Username: "bob", Email: "jane.doe@example.com"})
+	assert.ErrorIs(t, err, cloudy.ErrUserExists)
+	err = p.UpdateUser(ctx, &models.User{UID: "nobody", Username: "nobody"})
+	assert.ErrorIs(t, err, cloudy.ErrUserNotFound)
+
+	name, exists, err := p.ForceUserName(ctx, "Jane.Doe")
+	require.Nil(t, err)
+	assert.Equal(t, "Jane.Doe", name)
+	assert.True(t, exists)
+
+	require.Nil(t, p.DeleteUser(ctx, "u-jane"))
+	members, err := p.GetGroupMembers(ctx, "g-admins")
+	require.Nil(t, err)
+	assert.Empty(t, members)
+	_, err = p.GetProfilePicture(ctx, "u-jane")
+	assert.ErrorIs(t, err, cloudy.ErrUserNotFound)
+}
+
+func TestMemoryIdentityGroups(t *testing.T) {
+	ctx := context.Background()
+	p := newSeededProvider(t)
+
+	_, err := p.NewGroup(ctx, &models.Group{Name: "ADMINS"})
+	assert.ErrorIs(t, err, cloudy.ErrGroupExists)
+
+	err = p.AddMembers(ctx, "g-admins", []string{"bob", "nobody"})
+	assert.ErrorIs(t, err, cloudy.ErrUserNotFound)
+	members, err := p.GetGroupMembers(ctx, "g-admins")
+	require.Nil(t, err)
+	assert.Len(t, members, 1, "nothing is added when a user is unknown")
+
+	changed, err := p.UpdateGroup(ctx, &models.Group{ID: "g-admins", Name: "admins"})
+	require.Nil(t, err)
+	assert.False(t, changed)
+	changed, err = p.UpdateGroup(ctx, &models.Group{ID: "g-admins", Name: "administrators"})
+	require.Nil(t, err)
+	assert.True(t, changed)
+
+	_, err = p.GetGroupId(ctx, "admins")
+	assert.ErrorIs(t, err, cloudy.ErrGroupNotFound)
+}
+
+func TestMemoryIdentityAvatars(t *testing.T) {
+	ctx := context.Background()
+	p := newSeededProvider(t)
+
+	pic, err := p.GetProfilePicture(ctx, "bob")
+	require.Nil(t, err)
+	assert.Nil(t, pic)
+
+	require.Nil(t, p.UploadProfilePicture(ctx, "bob", []byte("picture")))
+	pic, err = p.GetProfilePicture(ctx, "bob")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("picture"), pic)
+
+	require.Nil(t, p.UploadProfilePicture(ctx, "bob", nil))
+	pic, err = p.GetProfilePicture(ctx, "bob")
+	require.Nil(t, err)
+	assert.Nil(t, pic)
+}
+
+func TestMemoryIdentityInvitations(t *testing.T) {
+	ctx := context.Background()
+	emailer := &capturingEmailer{}
+
+	cfg := (&MemoryIdentityFactory[any]{}).NewConfig().(*MemoryIdentityConfig)
+	cfg.Domains = []string{"example.com"}
+	cfg.InviteFrom = "noreply@example.com"
+	cfg.Mailer = &cloudy.Mailer{Emailer: emailer}
+	p, err := NewMemoryIdentityProvider(ctx, cfg)
+	require.Nil(t, err)
+
+	guest := &models.User{Email: "guest@partner.com", DisplayName: "Guest"}
+	require.Nil(t, p.CreateInvitation(ctx, guest, true, "https://app/w"))
+	require.Nil(t, p.CreateInvitation(ctx, &models.User{Email: "quiet@partner.com"}, false, ""))
+
+	invited, err := p.GetUserByEmail(ctx, "guest@partner.com", nil)
+	require.Nil(t, err)
+	require.NotNil(t, invited, "invited users are created outside the allowed domains")
+
+	require.Nil(t, p.FlushInvitations(ctx))
+	invitations := p.Invitations()
+	require.Len(t, invitations, 2)
+	assert.True(t, invitations[0].Emailed)
+	assert.False(t, invitations[1].Emailed)
+
+	require.Len(t, emailer.sent, 1)
+	msg := emailer.sent[0]
+	assert.Equal(t, []string{"guest@partner.com"}, msg.GetHeader("To"))
+	assert.Equal(t, []string{"noreply@example.com"}, msg.GetHeader("From"))
+
+	var body strings.Builder
+	_, err = msg.WriteTo(&body)
+	require.Nil(t, err)
+	assert.Contains(t, body.String(), "https://app/w")
+
+	// Inviting an existing user does not add another one
+	require.Nil(t, p.CreateInvitation(ctx, &models.User{Email: "guest@partner.com"}, false, ""))
+	all, err := p.ListUsers(ctx, "", nil)
+	require.Nil(t, err)
+	assert.Len(t, *all, 2)
+}
+
+func TestMemoryIdentityInvitationWithoutMailer(t *testing.T) {
+	ctx := context.Background()
+	p, err := NewMemoryIdentityProvider(ctx, nil)
+	require.Nil(t, err)
+
+	saved := cloudy.DefaultEmailer
+	cloudy.DefaultEmailer = nil
+	defer func() { cloudy.DefaultEmailer = saved }()
+
+	err = p.CreateInvitation(ctx, &models.User{Email: "guest@partner.com"}, true, "")
+	assert.ErrorIs(t, err, ErrNoMailer)
+	assert.Empty(t, p.Invitations())
+	missing, err := p.GetUserByEmail(ctx, "guest@partner.com", nil)
+	require.Nil(t, err)
+	assert.Nil(t, missing, "nothing is created when the invitation cannot be emailed")
+}
+
+// failingEmailer fails the first send
+type failingEmailer struct {
+	capturingEmailer
+	failed bool
+}
+
+func (e *failingEmailer) Send(ctx context.Context, message *gomail.Message) error {
+	e.mu.Lock()
+	if !e.failed {
+		e.failed = true
+		e.mu.Unlock()
+		return errors.New("mail server unavailable")
+	}
+	e.mu.Unlock()
+	return e.capturingEmailer.Send(ctx, message)
+}
+
+func TestMemoryIdentityInvitationSendFailure(t *testing.T) {
+	ctx := context.Background()
+	emailer := &failingEmailer{}
+	cfg := (&MemoryIdentityFactory[any]{}).NewConfig().(*MemoryIdentityConfig)
+	cfg.Mailer = &cloudy.Mailer{Emailer: emailer}
+	p, err := NewMemoryIdentityProvider(ctx, cfg)
+	require.Nil(t, err)
+
+	// The call does not wait for the mail server, the failure is recorded
+	require.Nil(t, p.CreateInvitation(ctx, &models.User{Email: "guest@partner.com"}, true, ""))
+	require.Nil(t, p.FlushInvitations(ctx))
+	invitations := p.Invitations()
+	require.Len(t, invitations, 1)
+	assert.False(t, invitations[0].Emailed)
+	assert.Contains(t, invitations[0].SendError, "mail server unavailable")
+
+	// Inviting again sends another email to the same user
+	require.Nil(t, p.CreateInvitation(ctx, &models.User{Email: "guest@partner.com"}, true, ""))
+	require.Nil(t, p.FlushInvitations(ctx))
+	invitations = p.Invitations()
+	require.Len(t, invitations, 2)
+	assert.True(t, invitations[1].Emailed)
+	assert.Empty(t, invitations[1].SendError)
+	assert.Len(t, emailer.sent, 1)
+}
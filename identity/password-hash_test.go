@@ -0,0 +1,43 @@
+package identity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	argon := &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+	for _, hasher := range []PasswordHasher{argon, &BcryptHasher{Cost: bcrypt.MinCost}} {
+		hash, err := hasher.Hash("Secret!123")
+		require.Nil(t, err)
+		assert.NotContains(t, hash, "Secret!123")
+
+		ok, err := VerifyPassword("Secret!123", hash)
+		require.Nil(t, err)
+		assert.True(t, ok, hash)
+
+		ok, err = VerifyPassword("Secret!124", hash)
+		require.Nil(t, err)
+		assert.False(t, ok, hash)
+	}
+
+	// Salted, so the same password hashes differently
+	h1, _ := argon.Hash("Secret!123")
+	h2, _ := argon.Hash("Secret!123")
+	assert.NotEqual(t, h1, h2)
+	assert.True(t, strings.HasPrefix(h1, "$argon2id$v=19$m=1024,t=1,p=1$"))
+}
+
+func TestVerifyPasswordUnknownHash(t *testing.T) {
+	for _, hash := range []string{"", "plain", "$argon2id$v=19$bad", "$argon2id$v=1$m=1,t=1,p=1$AA$AA"} {
+		_, err := VerifyPassword("x", hash)
+		assert.ErrorIs(t, err, ErrUnknownPasswordHash, hash)
+	}
+
+	_, err := NewPasswordHasher("md5")
+	assert.ErrorIs(t, err, ErrUnknownPasswordHash)
+}
@@ -0,0 +1,222 @@
+package cloudy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInterpolationCycle   = errors.New("configuration reference cycle")
+	ErrUnresolvedReference  = errors.New("unresolved configuration reference")
+	ErrInterpolationSyntax  = errors.New("invalid configuration reference")
+	ErrUnknownReferenceType = errors.New("unknown configuration reference type")
+)
+
+// MaxInterpolationDepth limits how deep references can nest
+const MaxInterpolationDepth = 32
+
+// SecretSource provides the values for ${secret:key} references. Any
+// secrets.SecretProvider can be used.
+type SecretSource interface {
+	GetSecret(ctx context.Context, key string) (string, error)
+}
+
+// ReferenceResolver resolves the argument of a ${type:arg} reference
+type ReferenceResolver func(ctx context.Context, arg string) (string, error)
+
+// Interpolator expands references in configuration values:
+//
+//	${OTHER_KEY}             another configuration value
+//	${OTHER_KEY:-fallback}   with a default when missing or empty
+//	${env:HOME}              an OS environment variable
+//	${file:/run/secrets/x}   the contents of a file, without the trailing newline
+//	${secret:vault-key}      a secret, when a SecretSource is set
+//	$${NOT_A_REFERENCE}      the literal text ${NOT_A_REFERENCE}
+//
+// Defaults can contain references. Errors name the keys involved, never the values.
+type Interpolator struct {
+	lookup    func(key string) (string, error)
+	resolvers map[string]ReferenceResolver
+}
+
+// NewInterpolator creates an interpolator that reads plain references with the
+// lookup. A missing value is "" or ErrKeyNotFound.
+func NewInterpolator(lookup func(key string) (string, error)) *Interpolator {
+	i := &Interpolator{
+		lookup:    lookup,
+		resolvers: make(map[string]ReferenceResolver),
+	}
+	i.Register("env", func(ctx context.Context, arg string) (string, error) {
+		return os.Getenv(arg), nil
+	})
+	i.Register("file", func(ctx context.Context, arg string) (string, error) {
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	})
+	return i
+}
+
+// Register adds a reference type, e.g. "secret" for ${secret:key}
+func (i *Interpolator) Register(name string, resolver ReferenceResolver) {
+	i.resolvers[name] = resolver
+}
+
+// WithSecrets resolves ${secret:key} references with the source
+func (i *Interpolator) WithSecrets(secrets SecretSource) *Interpolator {
+	i.Register("secret", secrets.GetSecret)
+	return i
+}
+
+// Resolve expands the references in the value
+func (i *Interpolator) Resolve(ctx context.Context, value string) (string, error) {
+	return i.expand(ctx, value, nil)
+}
+
+// ResolveKey expands the references in the value of the key, so a value that
+// refers back to its own key is reported as a cycle
+func (i *Interpolator) ResolveKey(ctx context.Context, key string, value string) (string, error) {
+	return i.expand(ctx, value, []string{key})
+}
+
+func (i *Interpolator) expand(ctx context.Context, value string, stack []string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+	if len(stack) > MaxInterpolationDepth {
+		return "", fmt.Errorf("%w: %s", ErrInterpolationCycle, strings.Join(stack, " -> "))
+	}
+
+	var sb strings.Builder
+	for pos := 0; pos < len(value); {
+		start := strings.Index(value[pos:], "$")
+		if start < 0 {
+			sb.WriteString(value[pos:])
+			break
+		}
+		start += pos
+		sb.WriteString(value[pos:start])
+		rest := value[start:]
+
+		switch {
+		case strings.HasPrefix(rest, "$${"):
+			// Escaped
+			sb.WriteString("${")
+			pos = start + 3
+		case strings.HasPrefix(rest, "${"):
+			end := matchingBrace(value, start+2)
+			if end < 0 {
+				return "", fmt.Errorf("%w: missing } in %q", ErrInterpolationSyntax, refContext(stack))
+			}
+			resolved, err := i.reference(ctx, value[start+2:end], stack)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(resolved)
+			pos = end + 1
+		default:
+			sb.WriteByte('$')
+			pos = start + 1
+		}
+	}
+	return sb.String(), nil
+}
+
+// reference resolves the body of a ${...} reference
+func (i *Interpolator) reference(ctx context.Context, body string, stack []string) (string, error) {
+	name, def, hasDefault := splitDefault(body)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: empty reference in %q", ErrInterpolationSyntax, refContext(stack))
+	}
+
+	var value string
+	var err error
+	if kind, arg, typed := splitReferenceType(name); typed {
+		resolver, ok := i.resolvers[kind]
+		if !ok {
+			return "", fmt.Errorf("%w: %q", ErrUnknownReferenceType, kind)
+		}
+		value, err = resolver(ctx, arg)
+		if err != nil && !hasDefault {
+			return "", fmt.Errorf("%w: %s: %v", ErrUnresolvedReference, name, err)
+		}
+	} else {
+		for _, k := range stack {
+			if k == name {
+				return "", fmt.Errorf("%w: %s -> %s", ErrInterpolationCycle, strings.Join(stack, " -> "), name)
+			}
+		}
+		value, err = i.lookup(name)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return "", fmt.Errorf("%w: %s: %v", ErrUnresolvedReference, name, err)
+		}
+		if value != "" {
+			value, err = i.expand(ctx, value, append(stack[:len(stack):len(stack)], name))
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if value == "" {
+		if !hasDefault {
+			return "", fmt.Errorf("%w: %s", ErrUnresolvedReference, name)
+		}
+		return i.expand(ctx, def, stack)
+	}
+	return value, nil
+}
+
+// matchingBrace returns the index of the } that closes the reference starting at from
+func matchingBrace(value string, from int) int {
+	depth := 1
+	for j := from; j < len(value); j++ {
+		switch {
+		case strings.HasPrefix(value[j:], "${"):
+			depth++
+			j++
+		case value[j] == '}':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// splitDefault splits NAME:-default
+func splitDefault(body string) (string, string, bool) {
+	idx := strings.Index(body, ":-")
+	if idx < 0 {
+		return body, "", false
+	}
+	return body[:idx], body[idx+2:], true
+}
+
+// splitReferenceType splits type:arg, the type is a lowercase word
+func splitReferenceType(name string) (string, string, bool) {
+	kind, arg, found := strings.Cut(name, ":")
+	if !found || kind == "" {
+		return "", "", false
+	}
+	for _, c := range kind {
+		if c < 'a' || c > 'z' {
+			return "", "", false
+		}
+	}
+	return kind, arg, true
+}
+
+func refContext(stack []string) string {
+	if len(stack) == 0 {
+		return "value"
+	}
+	return stack[len(stack)-1]
+}
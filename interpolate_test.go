@@ -0,0 +1,90 @@
+package cloudy
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSecrets map[string]string
+
+func (s testSecrets) GetSecret(ctx context.Context, key string) (string, error) {
+	return s[key], nil
+}
+
+func TestInterpolator(t *testing.T) {
+	ctx := context.Background()
+	values := map[string]string{
+		"HOST":  "db.local",
+		"PORT":  "5432",
+		"URL":   "postgres://${HOST}:${PORT}/app",
+		"CYCLE": "${LOOP}",
+		"LOOP":  "${CYCLE}",
+		"SELF":  "x${SELF}",
+	}
+	lookup := func(key string) (string, error) {
+		v, ok := values[key]
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		return v, nil
+	}
+
+	secretFile := filepath.Join(t.TempDir(), "token")
+	require.Nil(t, os.WriteFile(secretFile, []byte("from-file\n"), 0600))
+	t.Setenv("CLOUDY_TEST_HOME", "/home/test")
+
+	i := NewInterpolator(lookup).WithSecrets(testSecrets{"vault-key": "s3cr3t"})
+	tests := map[string]string{
+		"plain":                          "plain",
+		"${URL}":                         "postgres://db.local:5432/app",
+		"${MISSING:-fallback}":           "fallback",
+		"${MISSING:-${HOST}}":            "db.local",
+		"${HOST:-unused}":                "db.local",
+		"${env:CLOUDY_TEST_HOME}/bin":    "/home/test/bin",
+		"${env:CLOUDY_TEST_UNSET:-/tmp}": "/tmp",
+		"${file:" + secretFile + "}":     "from-file",
+		"${secret:vault-key}":            "s3cr3t",
+		"$${HOST} costs $5":              "${HOST} costs $5",
+	}
+	for in, expected := range tests {
+		v, err := i.Resolve(ctx, in)
+		require.Nil(t, err, in)
+		assert.Equal(t, expected, v, in)
+	}
+
+	_, err := i.Resolve(ctx, "${CYCLE}")
+	assert.ErrorIs(t, err, ErrInterpolationCycle)
+	_, err = i.ResolveKey(ctx, "SELF", values["SELF"])
+	assert.ErrorIs(t, err, ErrInterpolationCycle)
+	_, err = i.Resolve(ctx, "${MISSING}")
+	assert.ErrorIs(t, err, ErrUnresolvedReference)
+	_, err = i.Resolve(ctx, "${HOST")
+	assert.ErrorIs(t, err, ErrInterpolationSyntax)
+	_, err = i.Resolve(ctx, "${vault:key}")
+	assert.ErrorIs(t, err, ErrUnknownReferenceType)
+}
+
+func TestEnvironmentInterpolation(t *testing.T) {
+	envSvc := NewMapEnvironment()
+	envSvc.Set("APP_DB_PASSWORD", "${secret:db-password}")
+	envSvc.Set("APP_DB_USER", "admin")
+	envSvc.Set("APP_DB_DSN", "${DB_USER}@db")
+	env := NewEnvironment(NewHierarchicalEnvironment(NewTieredEnvironment(envSvc), "APP"))
+	env.EnableInterpolation(testSecrets{"db-password": "hunter2"})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	db := env.Segment("db")
+	assert.Equal(t, "hunter2", db.Get("password"))
+	assert.Equal(t, "admin@db", db.Force("dsn"))
+	assert.NotContains(t, buf.String(), "hunter2")
+}
@@ -0,0 +1,56 @@
+package keyvalue
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+)
+
+// aggregatorSource adapts a KeyValueAggregator to a cloudy.ConfigSource
+type aggregatorSource struct {
+	kva *KeyValueAggregator
+}
+
+// ConfigSource looks up values in the stores in order, e.g. ["database", "host"]
+// reads "database.host". The source of each value names the store it came from.
+func (kva *KeyValueAggregator) ConfigSource() cloudy.ConfigSource {
+	return &aggregatorSource{kva: kva}
+}
+
+// Bind fills the struct from the stores, see cloudy.Bind
+func (kva *KeyValueAggregator) Bind(v any, prefix ...string) (*cloudy.BindResult, error) {
+	return cloudy.Bind(kva.ConfigSource(), v, prefix...)
+}
+
+func (s *aggregatorSource) Lookup(path []string) (string, string, bool) {
+	key := NormalizeKey(strings.Join(path, "."))
+	v, err := s.kva.Get(key)
+	if err != nil || v == "" {
+		return "", "", false
+	}
+
+	for i, store := range s.kva.Stores {
+		if raw, err := store.Get(key); err == nil && raw != "" {
+			return v, fmt.Sprintf("%d:%s", i, storeName(store)), true
+		}
+	}
+	return v, "", true
+}
+
+// storeName describes a store for binding reports
+func storeName(store KeyValueStore) string {
+	switch s := store.(type) {
+	case *FileKeyValueStore:
+		return "file " + s.Filename
+	case *EncryptedFileKeyValueStore:
+		return "encrypted-file " + s.Filename
+	case *DirectoryKeyValueStore:
+		return "directory " + s.Dir
+	case *OsEnvKeyValueStore:
+		return "os-env"
+	case *InMemoryKeyValueStore:
+		return "memory"
+	}
+	return fmt.Sprintf("%T", store)
+}
@@ -0,0 +1,67 @@
+package keyvalue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregatorBind(t *testing.T) {
+	type dbConfig struct {
+		Host     string `config:"host,required"`
+		Password string `config:"password,secret"`
+		Port     int    `config:"port,default=5432"`
+	}
+
+	overrides := NewDefaultInMemoryKeyValueStore()
+	require.Nil(t, overrides.Set("db.host", "override.local"))
+	base := NewDefaultInMemoryKeyValueStore()
+	require.Nil(t, base.SetMany(map[string]string{"db.host": "base.local", "db.password": "hunter2"}))
+	kva := NewKeyValueAggregator(overrides, base)
+
+	cfg := &dbConfig{}
+	result, err := kva.Bind(cfg, "db")
+	require.Nil(t, err)
+	assert.Equal(t, "override.local", cfg.Host)
+	assert.Equal(t, "hunter2", cfg.Password)
+	assert.Equal(t, 5432, cfg.Port)
+
+	sources := map[string]string{}
+	for _, v := range result.Values {
+		sources[v.Key] = v.Source
+	}
+	assert.Equal(t, "0:memory", sources["db.host"])
+	assert.Equal(t, "1:memory", sources["db.password"])
+	assert.Equal(t, "default", sources["db.port"])
+	assert.Equal(t, "********", result.Effective()["db.password"])
+}
+
+func TestAggregatorInterpolation(t *testing.T) {
+	secretStore := secrets.NewInMemorySecretProvider()
+	require.Nil(t, secretStore.SaveSecret(context.Background(), "db-password", "hunter2"))
+
+	store := NewDefaultInMemoryKeyValueStore()
+	require.Nil(t, store.SetMany(map[string]string{
+		"db.host":     "db.local",
+		"db.url":      "postgres://${DB_HOST}/app",
+		"db.password": "${secret:db-password}",
+		"db.loop":     "${db.loop}",
+	}))
+	kva := NewKeyValueAggregator(store)
+	kva.EnableInterpolation(secretStore)
+
+	v, err := kva.Get("db.url")
+	require.Nil(t, err)
+	assert.Equal(t, "postgres://db.local/app", v)
+
+	v, err = kva.Get("db.password")
+	require.Nil(t, err)
+	assert.Equal(t, "hunter2", v)
+
+	_, err = kva.Get("db.loop")
+	assert.ErrorIs(t, err, cloudy.ErrInterpolationCycle)
+}
@@ -0,0 +1,311 @@
+package keyvalue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/appliedres/cloudy"
+	"github.com/go-openapi/strfmt"
+	"gopkg.in/yaml.v3"
+)
+
+// Compiler assertions
+var _ FilteredKeyValueStore = (*ConfigFileKeyValueStore)(nil)
+var _ SecureKeyValueStore = (*ConfigFileKeyValueStore)(nil)
+var _ WatchableKeyValueStore = (*ConfigFileKeyValueStore)(nil)
+var _ cloudy.EnvironmentService = (*KeyValueEnvironmentService)(nil)
+
+var ErrUnsupportedConfigFormat = errors.New("unsupported configuration file format")
+
+// Configuration file formats
+const (
+	ConfigFormatTOML = "toml"
+	ConfigFormatYAML = "yaml"
+	ConfigFormatJSON = "json"
+)
+
+type ConfigFileKeyValueStoreFactory struct{}
+
+func (f *ConfigFileKeyValueStoreFactory) NewConfig() interface{} {
+	return &ConfigFileKeyValueStoreConfig{}
+}
+
+func (f *ConfigFileKeyValueStoreFactory) New(ctx context.Context, config any) (KeyValueStore, error) {
+	cfg := config.(*ConfigFileKeyValueStoreConfig)
+	return NewConfigFileKeyValueStore(cfg.Filename)
+}
+
+type ConfigFileKeyValueStoreConfig struct {
+	Filename string
+}
+
+// ConfigFileKeyValueStore is a read only store for nested TOML, YAML and JSON
+// configuration files. Sections are flattened into normalized keys, so
+//
+//	[user-manager]
+//	driver = "azure-msgraph"
+//	tenant-id = "abc"
+//
+// is read as "user.manager.driver" and "user.manager.tenant.id". Lists are
+// stored both by index ("hosts.0") and joined with commas ("hosts").
+type ConfigFileKeyValueStore struct {
+	Filename string
+	Format   string
+
+	lock sync.RWMutex
+	data map[string]string
+	feed changeFeed
+}
+
+// NewConfigFileKeyValueStore loads the file, the format comes from the extension
+func NewConfigFileKeyValueStore(filename string) (*ConfigFileKeyValueStore, error) {
+	fixed, err := fixFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	format, err := ConfigFormat(fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &ConfigFileKeyValueStore{
+		Filename: fixed,
+		Format:   format,
+	}
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// ConfigFormat returns the format for the file extension
+func ConfigFormat(filename string) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		return ConfigFormatTOML, nil
+	case ".yaml", ".yml":
+		return ConfigFormatYAML, nil
+	case ".json":
+		return ConfigFormatJSON, nil
+	}
+	return "", fmt.Errorf("%w: %v", ErrUnsupportedConfigFormat, filename)
+}
+
+// LoadConfigFile reads a TOML, YAML or JSON file into flattened, normalized keys
+func LoadConfigFile(filename string) (map[string]string, error) {
+	format, err := ConfigFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data, format)
+}
+
+// ParseConfig parses the document into flattened, normalized keys
+func ParseConfig(data []byte, format string) (map[string]string, error) {
+	var doc map[string]any
+	var err error
+	switch format {
+	case ConfigFormatTOML:
+		err = toml.Unmarshal(data, &doc)
+	case ConfigFormatYAML:
+		err = yaml.Unmarshal(data, &doc)
+	case ConfigFormatJSON:
+		err = json.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedConfigFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := make(map[string]string)
+	flattenConfig("", doc, rtn)
+	return rtn, nil
+}
+
+// flattenConfig adds the leaves of the value under the key
+func flattenConfig(key string, v any, out map[string]string) {
+	join := func(name string) string {
+		if key == "" {
+			return NormalizeKey(name)
+		}
+		return key + "." + NormalizeKey(name)
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			flattenConfig(join(k), child, out)
+		}
+	case map[any]any:
+		for k, child := range val {
+			flattenConfig(join(fmt.Sprintf("%v", k)), child, out)
+		}
+	case []map[string]any:
+		for i, child := range val {
+			flattenConfig(join(fmt.Sprintf("%d", i)), child, out)
+		}
+	case []any:
+		var scalars []string
+		for i, child := range val {
+			flattenConfig(join(fmt.Sprintf("%d", i)), child, out)
+			if s, ok := configScalar(child); ok {
+				scalars = append(scalars, s)
+			}
+		}
+		if len(scalars) == len(val) && key != "" {
+			out[key] = strings.Join(scalars, ",")
+		}
+	case nil:
+		if key != "" {
+			out[key] = ""
+		}
+	default:
+		if s, ok := configScalar(val); ok && key != "" {
+			out[key] = s
+		}
+	}
+}
+
+func configScalar(v any) (string, bool) {
+	switch v.(type) {
+	case map[string]any, map[any]any, []any, []map[string]any, nil:
+		return "", false
+	}
+	return fmt.Sprintf("%v", v), true
+}
+
+// Load reads the file again
+func (s *ConfigFileKeyValueStore) Load() error {
+	_, err := s.reload()
+	return err
+}
+
+func (s *ConfigFileKeyValueStore) reload() (map[string]string, error) {
+	data, err := LoadConfigFile(s.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data = make(map[string]string, len(data))
+	for k, v := range data {
+		s.data[k] = v
+	}
+	return data, nil
+}
+
+func (s *ConfigFileKeyValueStore) Get(key string) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.data[NormalizeKey(key)], nil
+}
+
+func (s *ConfigFileKeyValueStore) GetSecure(key string) (strfmt.Password, error) {
+	v, err := s.Get(key)
+	return strfmt.Password(v), err
+}
+
+func (s *ConfigFileKeyValueStore) GetAll() (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	all := make(map[string]string, len(s.data))
+	for k, v := range s.data {
+		all[k] = v
+	}
+	return all, nil
+}
+
+func (s *ConfigFileKeyValueStore) GetWithPrefix(prefix string) (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	mine := make(map[string]string)
+	for k, v := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			mine[k] = v
+		}
+	}
+	return mine, nil
+}
+
+// Sections returns the names of the top level sections
+func (s *ConfigFileKeyValueStore) Sections() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	seen := make(map[string]bool)
+	var sections []string
+	for k := range s.data {
+		section, _, nested := strings.Cut(k, ".")
+		if nested && !seen[section] {
+			seen[section] = true
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(sections)
+	return sections
+}
+
+// Watch reloads the file when it changes on disk and reports the changed keys
+func (s *ConfigFileKeyValueStore) Watch(ctx context.Context, prefix string) <-chan Change {
+	return s.feed.subscribe(ctx, prefix, func() (func(), error) {
+		match := func(name string) bool {
+			return filepath.Clean(name) == s.Filename
+		}
+		return watchPath(filepath.Dir(s.Filename), match, s.reload, &s.feed)
+	})
+}
+
+// KeyValueEnvironmentService adapts a KeyValueStore to a cloudy.EnvironmentService,
+// so files can be used with Environment.Segment and HierarchicalEnvironment.S.
+// Environment names such as "USER_MANAGER_DRIVER" normalize to the same key as
+// the "driver" entry of a "user-manager" section.
+type KeyValueEnvironmentService struct {
+	Store KeyValueStore
+}
+
+func NewKeyValueEnvironmentService(store KeyValueStore) *KeyValueEnvironmentService {
+	return &KeyValueEnvironmentService{Store: store}
+}
+
+// Get returns cloudy.ErrKeyNotFound for missing and empty values, so the next
+// tier of a TieredEnvironment is used
+func (s *KeyValueEnvironmentService) Get(name string) (string, error) {
+	v, err := s.Store.Get(NormalizeKey(name))
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", cloudy.ErrKeyNotFound
+	}
+	return v, nil
+}
+
+// NewConfigFileEnvironment loads the configuration file as an Environment. The
+// root prefix is optional, e.g. "arkloud" to read the [arkloud] section.
+func NewConfigFileEnvironment(filename string, prefix ...string) (*cloudy.Environment, error) {
+	store, err := NewConfigFileKeyValueStore(filename)
+	if err != nil {
+		return nil, err
+	}
+	svc := NewKeyValueEnvironmentService(store)
+	return cloudy.NewEnvironment(cloudy.NewHierarchicalEnvironment(svc, prefix...)), nil
+}
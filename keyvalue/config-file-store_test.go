@@ -0,0 +1,131 @@
+package keyvalue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigTOML = `
+name = "arkloud"
+
+[user-manager]
+driver = "azure-msgraph"
+tenant-id = "tenant"
+retries = 3
+
+[user-manager.cache]
+enabled = true
+
+[servers]
+hosts = ["a", "b"]
+`
+
+const testConfigYAML = `
+name: arkloud
+user-manager:
+  driver: azure-msgraph
+  tenant-id: tenant
+  retries: 3
+  cache:
+    enabled: true
+servers:
+  hosts:
+    - a
+    - b
+`
+
+const testConfigJSON = `{
+  "name": "arkloud",
+  "user-manager": {
+    "driver": "azure-msgraph",
+    "tenant-id": "tenant",
+    "retries": 3,
+    "cache": {"enabled": true}
+  },
+  "servers": {"hosts": ["a", "b"]}
+}`
+
+func TestConfigFileStore(t *testing.T) {
+	files := map[string]string{
+		"config.toml": testConfigTOML,
+		"config.yaml": testConfigYAML,
+		"config.json": testConfigJSON,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), name)
+			require.Nil(t, os.WriteFile(filename, []byte(content), 0600))
+
+			store, err := NewConfigFileKeyValueStore(filename)
+			require.Nil(t, err)
+
+			all, err := store.GetAll()
+			require.Nil(t, err)
+			assert.Equal(t, map[string]string{
+				"name":                       "arkloud",
+				"user.manager.driver":        "azure-msgraph",
+				"user.manager.tenant.id":     "tenant",
+				"user.manager.retries":       "3",
+				"user.manager.cache.enabled": "true",
+				"servers.hosts":              "a,b",
+				"servers.hosts.0":            "a",
+				"servers.hosts.1":            "b",
+			}, all)
+
+			v, err := store.Get("USER_MANAGER_TENANT_ID")
+			require.Nil(t, err)
+			assert.Equal(t, "tenant", v)
+			assert.Equal(t, []string{"servers", "user"}, store.Sections())
+		})
+	}
+}
+
+func TestConfigFileEnvironment(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.toml")
+	require.Nil(t, os.WriteFile(filename, []byte(testConfigTOML), 0600))
+
+	env, err := NewConfigFileEnvironment(filename)
+	require.Nil(t, err)
+
+	section := env.Segment("user-manager")
+	assert.Equal(t, "azure-msgraph", section.Force("driver"))
+	assert.Equal(t, "tenant", section.Get("tenantId"))
+	assert.Equal(t, "true", section.Segment("cache").Get("enabled"))
+	assert.Equal(t, "", section.Get("missing"))
+
+	// Missing keys fall through to the next tier
+	fallback := cloudy.NewMapEnvironment()
+	fallback.Set("USER_MANAGER_CLIENT_ID", "client")
+	store, err := NewConfigFileKeyValueStore(filename)
+	require.Nil(t, err)
+	tiered := cloudy.NewEnvironment(cloudy.NewHierarchicalEnvironment(
+		cloudy.NewTieredEnvironment(NewKeyValueEnvironmentService(store), fallback)))
+	assert.Equal(t, "client", tiered.Segment("user-manager").Get("client-id"))
+
+	// Providers are created from a section unchanged
+	registry := cloudy.NewProviderRegistry[string]()
+	registry.Register("azure-msgraph", &testSectionFactory{})
+	created, err := registry.NewFromEnv(section, "DRIVER")
+	require.Nil(t, err)
+	assert.Equal(t, "tenant", created)
+}
+
+type testSectionFactory struct{}
+
+func (f *testSectionFactory) Create(cfg interface{}) (string, error) {
+	return cfg.(string), nil
+}
+
+func (f *testSectionFactory) FromEnv(env *cloudy.Environment) (interface{}, error) {
+	return env.Force("TENANT_ID"), nil
+}
+
+func TestConfigFileStore_Unsupported(t *testing.T) {
+	_, err := NewConfigFileKeyValueStore(filepath.Join(t.TempDir(), "config.ini"))
+	assert.ErrorIs(t, err, ErrUnsupportedConfigFormat)
+}
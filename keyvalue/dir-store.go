@@ -17,6 +17,7 @@ var DefaultDir = "~/.arkloud/secrets"
 
 // Compile time interface checks
 var _ KeyValueStoreFactory = (*DirectoryKeyValueStoreFactory)(nil)
+var _ WatchableKeyValueStore = (*DirectoryKeyValueStore)(nil)
 
 // Factory
 type DirectoryKeyValueStoreFactory struct{}
@@ -34,6 +35,7 @@ type DirectoryKeyValueStore struct {
 	lastError error
 	Dir       string
 	loaded    bool
+	feed      changeFeed
 }
 
 func NewDirectoryKeyValueStore(ctx context.Context, config interface{}) (*DirectoryKeyValueStore, error) {
@@ -220,3 +222,14 @@ func (fs *DirectoryKeyValueStore) Save(key string, value string) error {
 	filename := fs.Filename(keyNorm)
 	return os.WriteFile(filename, []byte(value), 0600)
 }
+
+// Watch reports the keys whose files are created, changed or removed in the directory
+func (fs *DirectoryKeyValueStore) Watch(ctx context.Context, prefix string) <-chan Change {
+	fs.loadIfNeeded()
+	return fs.feed.subscribe(ctx, prefix, func() (func(), error) {
+		match := func(name string) bool {
+			return filepath.Dir(name) == fs.Dir
+		}
+		return watchPath(fs.Dir, match, fs.GetAll, &fs.feed)
+	})
+}
@@ -0,0 +1,609 @@
+package keyvalue
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+)
+
+// Environment variables that provide the private key used to open an encrypted
+// secrets file. Keys are created with GenerateSecretsKey.
+const (
+	SecretsKeyEnv     = "CLOUDY_SECRETS_KEY"
+	SecretsKeyFileEnv = "CLOUDY_SECRETS_KEYFILE"
+)
+
+const (
+	secretsFileVersion = 1
+	secretsValuePrefix = "ENC[AES256_GCM,"
+	secretsValueSuffix = "]"
+	secretsWrapInfo    = "cloudy-secrets data key"
+)
+
+var (
+	ErrNoSecretsKey        = errors.New("no secrets key configured")
+	ErrInvalidSecretsKey   = errors.New("secrets key must be a base64 encoded 32 byte X25519 key")
+	ErrNotARecipient       = errors.New("key is not a recipient of the secrets file")
+	ErrSecretsFileTampered = errors.New("secrets file failed the integrity check")
+	ErrLastRecipient       = errors.New("cannot remove the key the file is opened with")
+)
+
+// Compile time interface checks
+var _ WritableSecureKeyValueStore = (*EncryptedFileKeyValueStore)(nil)
+var _ FilteredKeyValueStore = (*EncryptedFileKeyValueStore)(nil)
+var _ KeyValueStoreFactory = (*EncryptedFileKeyValueStoreFactory)(nil)
+
+// Factory
+type EncryptedFileKeyValueStoreFactory struct{}
+
+func (f *EncryptedFileKeyValueStoreFactory) NewConfig() interface{} {
+	return &EncryptedFileKeyValueStoreConfig{}
+}
+
+func (f *EncryptedFileKeyValueStoreFactory) New(ctx context.Context, config any) (KeyValueStore, error) {
+	cfg := config.(*EncryptedFileKeyValueStoreConfig)
+	key, err := LoadSecretsKey(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedFileKeyValueStore(cfg.Filename, key)
+}
+
+// EncryptedFileKeyValueStoreConfig names the secrets file and the file holding
+// the private key. Without a key file the key is read from the environment.
+type EncryptedFileKeyValueStoreConfig struct {
+	Filename string
+	KeyFile  string
+}
+
+// secretsFile is the stored form. Keys stay readable so the file can be reviewed
+// and diffed, only the values are encrypted.
+type secretsFile struct {
+	Values map[string]string `json:"values"`
+	Meta   secretsFileMeta   `json:"cloudy"`
+}
+
+type secretsFileMeta struct {
+	Version      int                `json:"version"`
+	Recipients   []secretsRecipient `json:"recipients"`
+	MAC          string             `json:"mac"`
+	LastModified time.Time          `json:"lastModified"`
+}
+
+// secretsRecipient holds the data key encrypted for one public key. The wrapping
+// key is agreed between an ephemeral key and the recipient's key, like age.
+type secretsRecipient struct {
+	ID        string `json:"id"`
+	PublicKey string `json:"publicKey"`
+	Ephemeral string `json:"ephemeral"`
+	DataKey   string `json:"dataKey"`
+}
+
+// EncryptedFileKeyValueStore keeps secrets in a JSON file with readable keys and
+// AES-256-GCM encrypted values, in the style of SOPS:
+//
+//	{
+//	  "values": {
+//	    "db.password": "ENC[AES256_GCM,3q2+7w...]"
+//	  },
+//	  "cloudy": { "version": 1, "recipients": [...], "mac": "..." }
+//	}
+//
+// The values are encrypted with a random data key, which is stored once for every
+// recipient, encrypted to the recipient's X25519 public key. Anyone holding one of
+// the private keys can read and write the file, add recipients by their public key
+// and rotate the data key. Each value is bound to its key and a MAC over all the
+// values detects entries that were added, removed or swapped without a key.
+type EncryptedFileKeyValueStore struct {
+	Filename string
+
+	lock       sync.RWMutex
+	identity   *ecdh.PrivateKey
+	dataKey    []byte
+	recipients []secretsRecipient
+	values     map[string]string // Decrypted
+}
+
+// GenerateSecretsKey returns a new private key and its public key, base64 encoded
+func GenerateSecretsKey() (private string, public string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()),
+		base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// ParseSecretsKey decodes a base64 encoded key, private or public
+func ParseSecretsKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidSecretsKey
+	}
+	return key, nil
+}
+
+// SecretsPublicKey returns the base64 encoded public key of a private key
+func SecretsPublicKey(private []byte) (string, error) {
+	key, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return "", ErrInvalidSecretsKey
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// LoadSecretsKey reads the private key from the key file. Without a key file it
+// uses CLOUDY_SECRETS_KEY, then the file named by CLOUDY_SECRETS_KEYFILE.
+func LoadSecretsKey(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		if encoded := os.Getenv(SecretsKeyEnv); encoded != "" {
+			return ParseSecretsKey(encoded)
+		}
+		keyFile = os.Getenv(SecretsKeyFileEnv)
+	}
+	if keyFile == "" {
+		return nil, ErrNoSecretsKey
+	}
+
+	fixed, err := fixFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(fixed)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSecretsKey(string(data))
+}
+
+// SecretsKeyID is the short ID of a public key used in the recipient list
+func SecretsKeyID(public []byte) string {
+	sum := sha256.Sum256(public)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewEncryptedFileKeyValueStore opens the secrets file with the private key. A
+// missing file is created on the first write with the key as the only recipient.
+func NewEncryptedFileKeyValueStore(filename string, privateKey []byte) (*EncryptedFileKeyValueStore, error) {
+	identity, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, ErrInvalidSecretsKey
+	}
+	fixed, err := fixFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &EncryptedFileKeyValueStore{
+		Filename: fixed,
+		identity: identity,
+	}
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load reads and decrypts the file, discarding unsaved state
+func (s *EncryptedFileKeyValueStore) Load() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, err := os.ReadFile(s.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		s.recipients = nil
+		s.values = make(map[string]string)
+		return s.newDataKeyLocked([][]byte{s.identity.PublicKey().Bytes()})
+	}
+	if err != nil {
+		return err
+	}
+
+	var file secretsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid secrets file %v: %w", s.Filename, err)
+	}
+	if file.Meta.Version != secretsFileVersion {
+		return fmt.Errorf("unsupported secrets file version %v", file.Meta.Version)
+	}
+
+	id := SecretsKeyID(s.identity.PublicKey().Bytes())
+	var dataKey []byte
+	for _, recipient := range file.Meta.Recipients {
+		if recipient.ID == id {
+			dataKey, err = unwrapDataKey(s.identity, recipient)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrNotARecipient, err)
+			}
+			break
+		}
+	}
+	if dataKey == nil {
+		return ErrNotARecipient
+	}
+
+	if !hmac.Equal([]byte(file.Meta.MAC), []byte(secretsMAC(dataKey, file.Values))) {
+		return ErrSecretsFileTampered
+	}
+
+	values := make(map[string]string, len(file.Values))
+	for k, enc := range file.Values {
+		v, err := decryptValue(dataKey, k, enc)
+		if err != nil {
+			return fmt.Errorf("%w: %v: %v", ErrSecretsFileTampered, k, err)
+		}
+		values[k] = v
+	}
+
+	s.dataKey = dataKey
+	s.recipients = file.Meta.Recipients
+	s.values = values
+	return nil
+}
+
+// newDataKeyLocked creates a data key and shares it with the public keys
+func (s *EncryptedFileKeyValueStore) newDataKeyLocked(publicKeys [][]byte) error {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+
+	var recipients []secretsRecipient
+	for _, public := range publicKeys {
+		recipient, err := wrapDataKey(public, dataKey)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	s.dataKey = dataKey
+	s.recipients = recipients
+	return nil
+}
+
+// saveLocked encrypts every value and replaces the file
+func (s *EncryptedFileKeyValueStore) saveLocked() error {
+	file := secretsFile{
+		Values: make(map[string]string, len(s.values)),
+		Meta: secretsFileMeta{
+			Version:      secretsFileVersion,
+			Recipients:   s.recipients,
+			LastModified: time.Now().UTC(),
+		},
+	}
+	for k, v := range s.values {
+		enc, err := encryptValue(s.dataKey, k, v)
+		if err != nil {
+			return err
+		}
+		file.Values[k] = enc
+	}
+	file.Meta.MAC = secretsMAC(s.dataKey, file.Values)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the file in one step so readers never see a partial write
+	tmp, err := os.CreateTemp(filepath.Dir(s.Filename), filepath.Base(s.Filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.Filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *EncryptedFileKeyValueStore) Get(name string) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.values[NormalizeKey(name)], nil
+}
+
+func (s *EncryptedFileKeyValueStore) GetAll() (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	all := make(map[string]string, len(s.values))
+	for k, v := range s.values {
+		all[k] = v
+	}
+	return all, nil
+}
+
+func (s *EncryptedFileKeyValueStore) GetWithPrefix(prefix string) (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	mine := make(map[string]string)
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			mine[k] = v
+		}
+	}
+	return mine, nil
+}
+
+func (s *EncryptedFileKeyValueStore) GetSecure(name string) (strfmt.Password, error) {
+	v, err := s.Get(name)
+	return strfmt.Password(v), err
+}
+
+func (s *EncryptedFileKeyValueStore) Set(name string, value string) error {
+	return s.SetMany(map[string]string{name: value})
+}
+
+func (s *EncryptedFileKeyValueStore) SetSecure(name string, value strfmt.Password) error {
+	return s.Set(name, string(value))
+}
+
+func (s *EncryptedFileKeyValueStore) SetMany(many map[string]string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for k, v := range many {
+		s.values[NormalizeKey(k)] = v
+	}
+	return s.saveLocked()
+}
+
+func (s *EncryptedFileKeyValueStore) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.values, NormalizeKey(name))
+	return s.saveLocked()
+}
+
+// Replace sets the values to exactly the ones given, used by editors
+func (s *EncryptedFileKeyValueStore) Replace(values map[string]string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.values = make(map[string]string, len(values))
+	for k, v := range values {
+		s.values[NormalizeKey(k)] = v
+	}
+	return s.saveLocked()
+}
+
+// Recipients returns the public keys that can open the file by ID
+func (s *EncryptedFileKeyValueStore) Recipients() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ids := make(map[string]string, len(s.recipients))
+	for _, recipient := range s.recipients {
+		ids[recipient.ID] = recipient.PublicKey
+	}
+	return ids
+}
+
+// AddRecipient lets the private key of the public key open the file
+func (s *EncryptedFileKeyValueStore) AddRecipient(publicKey []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := SecretsKeyID(publicKey)
+	for _, recipient := range s.recipients {
+		if recipient.ID == id {
+			return nil
+		}
+	}
+
+	recipient, err := wrapDataKey(publicKey, s.dataKey)
+	if err != nil {
+		return err
+	}
+	s.recipients = append(s.recipients, recipient)
+	return s.saveLocked()
+}
+
+// RemoveRecipient stops the key with the ID from opening the file. The data key
+// is rotated so a copy of the old data key cannot read the file any more.
+func (s *EncryptedFileKeyValueStore) RemoveRecipient(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id == SecretsKeyID(s.identity.PublicKey().Bytes()) {
+		return ErrLastRecipient
+	}
+
+	var remaining [][]byte
+	found := false
+	for _, recipient := range s.recipients {
+		if recipient.ID == id {
+			found = true
+			continue
+		}
+		public, err := base64.StdEncoding.DecodeString(recipient.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %v: %w", recipient.ID, err)
+		}
+		remaining = append(remaining, public)
+	}
+	if !found {
+		return fmt.Errorf("%w: %v", ErrNotARecipient, id)
+	}
+
+	if err := s.newDataKeyLocked(remaining); err != nil {
+		return err
+	}
+	return s.saveLocked()
+}
+
+// RotateDataKey encrypts every value with a new data key and shares it with the
+// current recipients. The recipients keep their own keys.
+func (s *EncryptedFileKeyValueStore) RotateDataKey() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var publicKeys [][]byte
+	for _, recipient := range s.recipients {
+		public, err := base64.StdEncoding.DecodeString(recipient.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %v: %w", recipient.ID, err)
+		}
+		publicKeys = append(publicKeys, public)
+	}
+
+	if err := s.newDataKeyLocked(publicKeys); err != nil {
+		return err
+	}
+	return s.saveLocked()
+}
+
+// wrapDataKey encrypts the data key to the public key
+func wrapDataKey(publicKey []byte, dataKey []byte) (secretsRecipient, error) {
+	public, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return secretsRecipient{}, ErrInvalidSecretsKey
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return secretsRecipient{}, err
+	}
+
+	recipient := secretsRecipient{
+		ID:        SecretsKeyID(publicKey),
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+		Ephemeral: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+	}
+	wrapKey, err := deriveWrapKey(ephemeral, public, ephemeral.PublicKey().Bytes(), publicKey)
+	if err != nil {
+		return secretsRecipient{}, err
+	}
+	recipient.DataKey, err = seal(wrapKey, dataKey, []byte(recipient.ID))
+	return recipient, err
+}
+
+// unwrapDataKey decrypts the data key with the private key of the recipient
+func unwrapDataKey(identity *ecdh.PrivateKey, recipient secretsRecipient) ([]byte, error) {
+	ephemeralBytes, err := base64.StdEncoding.DecodeString(recipient.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapKey, err := deriveWrapKey(identity, ephemeral, ephemeralBytes, identity.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return open(wrapKey, recipient.DataKey, []byte(recipient.ID))
+}
+
+func deriveWrapKey(private *ecdh.PrivateKey, public *ecdh.PublicKey, ephemeral, recipient []byte) ([]byte, error) {
+	shared, err := private.ECDH(public)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte(nil), ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, secretsWrapInfo, 32)
+}
+
+// seal encrypts the plaintext with AES-256-GCM and returns nonce|ciphertext, base64 encoded
+func seal(key, plaintext, additional []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, additional)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open reverses seal
+func open(key []byte, encoded string, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptValue binds the value to its key so values cannot be swapped between keys
+func encryptValue(dataKey []byte, key, value string) (string, error) {
+	sealed, err := seal(dataKey, []byte(value), []byte(key))
+	if err != nil {
+		return "", err
+	}
+	return secretsValuePrefix + sealed + secretsValueSuffix, nil
+}
+
+func decryptValue(dataKey []byte, key, enc string) (string, error) {
+	if !strings.HasPrefix(enc, secretsValuePrefix) || !strings.HasSuffix(enc, secretsValueSuffix) {
+		return "", errors.New("value is not encrypted")
+	}
+	sealed := strings.TrimSuffix(strings.TrimPrefix(enc, secretsValuePrefix), secretsValueSuffix)
+	value, err := open(dataKey, sealed, []byte(key))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// secretsMAC covers the keys and the encrypted values, so removing, adding or
+// renaming entries without the data key is detected
+func secretsMAC(dataKey []byte, values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha256.New, dataKey)
+	for _, k := range keys {
+		fmt.Fprintf(mac, "%s=%s\n", k, values[k])
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,123 @@
+package keyvalue
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSecretsKey(t *testing.T) ([]byte, []byte) {
+	private, public, err := GenerateSecretsKey()
+	require.Nil(t, err)
+	privateKey, err := ParseSecretsKey(private)
+	require.Nil(t, err)
+	publicKey, err := ParseSecretsKey(public)
+	require.Nil(t, err)
+	return privateKey, publicKey
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "secrets.json")
+	key, _ := newSecretsKey(t)
+
+	store, err := NewEncryptedFileKeyValueStore(filename, key)
+	require.Nil(t, err)
+	TestWritableKVStore(t, store, TestStoreNormalForms)
+	TestWritableSecureKVStore(t, store)
+
+	// Keys are readable, values are not
+	require.Nil(t, store.Set("API_TOKEN", "s3cr3t-token"))
+	data, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	assert.Contains(t, string(data), `"api.token"`)
+	assert.NotContains(t, string(data), "s3cr3t-token")
+
+	reopened, err := NewEncryptedFileKeyValueStore(filename, key)
+	require.Nil(t, err)
+	TestKVStore(t, reopened, TestStoreNormalForms)
+}
+
+func TestEncryptedFileStore_Recipients(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "secrets.json")
+	alice, _ := newSecretsKey(t)
+	bob, bobPublic := newSecretsKey(t)
+
+	store, err := NewEncryptedFileKeyValueStore(filename, alice)
+	require.Nil(t, err)
+	require.Nil(t, store.Set("db.password", "hunter2"))
+
+	_, err = NewEncryptedFileKeyValueStore(filename, bob)
+	assert.ErrorIs(t, err, ErrNotARecipient)
+
+	require.Nil(t, store.AddRecipient(bobPublic))
+	assert.Len(t, store.Recipients(), 2)
+
+	bobStore, err := NewEncryptedFileKeyValueStore(filename, bob)
+	require.Nil(t, err)
+	v, err := bobStore.Get("db.password")
+	require.Nil(t, err)
+	assert.Equal(t, "hunter2", v)
+
+	// Rotation keeps every recipient
+	require.Nil(t, bobStore.RotateDataKey())
+	require.Nil(t, store.Load())
+	v, err = store.Get("db.password")
+	require.Nil(t, err)
+	assert.Equal(t, "hunter2", v)
+
+	require.Nil(t, store.RemoveRecipient(SecretsKeyID(bobPublic)))
+	_, err = NewEncryptedFileKeyValueStore(filename, bob)
+	assert.ErrorIs(t, err, ErrNotARecipient)
+
+	assert.ErrorIs(t, store.RemoveRecipient("unknown"), ErrNotARecipient)
+}
+
+func TestEncryptedFileStore_Tampering(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "secrets.json")
+	key, _ := newSecretsKey(t)
+
+	store, err := NewEncryptedFileKeyValueStore(filename, key)
+	require.Nil(t, err)
+	require.Nil(t, store.SetMany(map[string]string{"a": "1", "b": "2"}))
+
+	data, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	var file map[string]any
+	require.Nil(t, json.Unmarshal(data, &file))
+
+	// Swap the encrypted values of two keys
+	values := file["values"].(map[string]any)
+	values["a"], values["b"] = values["b"], values["a"]
+	swapped, err := json.Marshal(file)
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filename, swapped, 0600))
+
+	_, err = NewEncryptedFileKeyValueStore(filename, key)
+	assert.ErrorIs(t, err, ErrSecretsFileTampered)
+}
+
+func TestLoadSecretsKey(t *testing.T) {
+	private, _, err := GenerateSecretsKey()
+	require.Nil(t, err)
+
+	t.Setenv(SecretsKeyEnv, private)
+	key, err := LoadSecretsKey("")
+	require.Nil(t, err)
+	assert.Len(t, key, 32)
+
+	keyFile := filepath.Join(t.TempDir(), "secrets.key")
+	require.Nil(t, os.WriteFile(keyFile, []byte(private+"\n"), 0600))
+	t.Setenv(SecretsKeyEnv, "")
+	t.Setenv(SecretsKeyFileEnv, keyFile)
+	fromFile, err := LoadSecretsKey("")
+	require.Nil(t, err)
+	assert.Equal(t, key, fromFile)
+
+	_, err = ParseSecretsKey(strings.Repeat("A", 10))
+	assert.ErrorIs(t, err, ErrInvalidSecretsKey)
+}
@@ -2,6 +2,7 @@ package keyvalue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -19,6 +20,7 @@ var DefaultFilename = "~/.arkloud/config.json"
 
 // Compile time interface checks
 var _ WritableKeyValueStore = (*FileKeyValueStore)(nil)
+var _ WatchableKeyValueStore = (*FileKeyValueStore)(nil)
 var _ KeyValueStoreFactory = (*FileKeyValueStoreFactory)(nil)
 
 // Factory
@@ -39,6 +41,7 @@ type FileKeyValueStore struct {
 	loaded    bool
 	lock      sync.RWMutex
 	data      map[string]string
+	feed      changeFeed
 }
 
 func NewFileKeyValueStore(ctx context.Context, config interface{}) (*FileKeyValueStore, error) {
@@ -214,12 +217,52 @@ func (fs *FileKeyValueStore) Load(mustExist bool) error {
 		return nil
 	}
 
+	data, err := fs.readFile()
+	if err != nil {
+		return err
+	}
+	fs.data = data
+	return nil
+}
+
+func (fs *FileKeyValueStore) readFile() (map[string]string, error) {
 	if strings.HasSuffix(fs.Filename, ".json") {
 		return fs.loadFromJson()
 	}
 	return fs.loadFromEnv()
 }
 
+// Watch reloads the file when it changes on disk and reports the changed keys.
+// Changes made through Set are reported once they have been written.
+func (fs *FileKeyValueStore) Watch(ctx context.Context, prefix string) <-chan Change {
+	fs.loadIfNeeded()
+	return fs.feed.subscribe(ctx, prefix, func() (func(), error) {
+		match := func(name string) bool {
+			return filepath.Clean(name) == fs.Filename
+		}
+		return watchPath(filepath.Dir(fs.Filename), match, fs.reload, &fs.feed)
+	})
+}
+
+// reload replaces the values with the contents of the file
+func (fs *FileKeyValueStore) reload() (map[string]string, error) {
+	data, err := fs.readFile()
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.data = make(map[string]string, len(data))
+	for k, v := range data {
+		fs.data[k] = v
+	}
+	return data, nil
+}
+
 func (fs *FileKeyValueStore) Save() error {
 
 	if strings.HasSuffix(fs.Filename, ".json") {
@@ -278,15 +321,23 @@ func (fs *FileKeyValueStore) saveAsEnv() error {
 	return os.WriteFile(fs.Filename, []byte(sb.String()), 0600)
 }
 
-func (fs *FileKeyValueStore) loadFromJson() error {
-	container, err := gabs.ParseJSONFile(fs.Filename)
+func (fs *FileKeyValueStore) loadFromJson() (map[string]string, error) {
+	data, err := os.ReadFile(fs.Filename)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return make(map[string]string), nil
+	}
+
+	container, err := gabs.ParseJSON(data)
+	if err != nil {
+		return nil, err
 	}
 
 	src, err := container.Flatten()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	mapStr := make(map[string]string)
@@ -298,22 +349,16 @@ func (fs *FileKeyValueStore) loadFromJson() error {
 		normalKey := NormalizeKey(k)
 		mapStr[normalKey] = val
 	}
-	fs.data = mapStr
-	return nil
+	return mapStr, nil
 }
 
-func (fs *FileKeyValueStore) loadFromEnv() error {
+func (fs *FileKeyValueStore) loadFromEnv() (map[string]string, error) {
 	data, err := os.ReadFile(fs.Filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	m, err := LoadEnvFromString(string(data))
-	if err != nil {
-		return err
-	}
-	fs.data = m
-	return nil
+	return LoadEnvFromString(string(data))
 }
 
 type ReadOnlyFileStore struct {
@@ -1,13 +1,16 @@
 package keyvalue
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 
 	"github.com/Jeffail/gabs/v2"
+	"github.com/appliedres/cloudy"
 	"github.com/go-openapi/strfmt"
 	"github.com/hashicorp/go-multierror"
 )
@@ -16,6 +19,7 @@ import (
 var _ WritableKeyValueStore = (*KeyValueAggregator)(nil)
 var _ FilteredKeyValueStore = (*KeyValueAggregator)(nil)
 var _ WritableSecureKeyValueStore = (*KeyValueAggregator)(nil)
+var _ WatchableKeyValueStore = (*KeyValueAggregator)(nil)
 
 func NewKeyValueAggregator(stores ...KeyValueStore) *KeyValueAggregator {
 	return &KeyValueAggregator{Stores: stores}
@@ -29,6 +33,22 @@ func NewBasicKeyValueAggregator() *KeyValueAggregator {
 
 type KeyValueAggregator struct {
 	Stores []KeyValueStore
+
+	// Interpolator expands ${...} references in Get when set, see EnableInterpolation
+	Interpolator *cloudy.Interpolator
+}
+
+// EnableInterpolation expands ${...} references in the values returned by Get.
+// Plain references are read from the stores, ${secret:key} from the secrets when given.
+func (kva *KeyValueAggregator) EnableInterpolation(secrets cloudy.SecretSource) *cloudy.Interpolator {
+	kva.Interpolator = cloudy.NewInterpolator(func(key string) (string, error) {
+		v, _, err := kva.getInternal(NormalizeKey(key))
+		return v, err
+	})
+	if secrets != nil {
+		kva.Interpolator.WithSecrets(secrets)
+	}
+	return kva.Interpolator
 }
 
 func (kva *KeyValueAggregator) AddFirst(store KeyValueStore) {
@@ -89,7 +109,14 @@ func (kva *KeyValueAggregator) getInternal(key string) (string, KeyValueStore, e
 // timeout for a bit of time
 func (kva *KeyValueAggregator) Get(key string) (string, error) {
 	val, _, err := kva.getInternal(key)
-	return val, err
+	if val == "" || kva.Interpolator == nil {
+		return val, err
+	}
+	resolved, rerr := kva.Interpolator.ResolveKey(context.Background(), NormalizeKey(key), val)
+	if rerr != nil {
+		return "", rerr
+	}
+	return resolved, err
 }
 
 func (kva *KeyValueAggregator) GetAll() (map[string]string, error) {
@@ -109,6 +136,71 @@ func (kva *KeyValueAggregator) GetAll() (map[string]string, error) {
 	return all, merr.ErrorOrNil()
 }
 
+// Watch fans in the changes from every watchable layer. Each change is resolved
+// against all the layers, so a change hidden by a higher layer is not reported
+// and deleting a key from a higher layer reports the value from a lower one.
+func (kva *KeyValueAggregator) Watch(ctx context.Context, prefix string) <-chan Change {
+	out := make(chan Change)
+	in := make(chan Change)
+
+	// The values reported so far, so changes that keep the effective value are dropped
+	reported, err := kva.GetWithPrefix(NormalizeKey(prefix))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error in KeyValue store", slog.Any("err", err))
+	}
+	if reported == nil {
+		reported = make(map[string]string)
+	}
+
+	var wg sync.WaitGroup
+	for _, store := range kva.Stores {
+		watchable, is := store.(WatchableKeyValueStore)
+		if !is {
+			continue
+		}
+		wg.Add(1)
+		go func(changes <-chan Change) {
+			defer wg.Done()
+			for change := range changes {
+				select {
+				case in <- change:
+				case <-ctx.Done():
+				}
+			}
+		}(watchable.Watch(ctx, prefix))
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
+
+	go func() {
+		defer close(out)
+		for change := range in {
+			value, err := kva.Get(change.Key)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error in KeyValue store", slog.Any("err", err))
+			}
+
+			effective := Change{Type: ChangeSet, Key: change.Key, Value: value}
+			if value == "" {
+				effective.Type = ChangeDelete
+			}
+			if reported[change.Key] == value {
+				continue
+			}
+			reported[change.Key] = value
+
+			select {
+			case out <- effective:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return out
+}
+
 func (kva *KeyValueAggregator) GetWithPrefix2(prefix string, trim bool) ([]map[string]string, error) {
 	var merr *multierror.Error
 	var layers []map[string]string
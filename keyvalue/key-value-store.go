@@ -1,6 +1,8 @@
 package keyvalue
 
 import (
+	"context"
+
 	"github.com/appliedres/cloudy"
 	"github.com/go-openapi/strfmt"
 )
@@ -35,3 +37,28 @@ type WritableSecureKeyValueStore interface {
 	WritableKeyValueStore
 	SetSecure(key string, value strfmt.Password) error
 }
+
+// ChangeType is the kind of change reported by a WatchableKeyValueStore
+type ChangeType string
+
+const (
+	ChangeSet    ChangeType = "set"
+	ChangeDelete ChangeType = "delete"
+)
+
+// Change is a single key that was set or deleted. Keys are normalized.
+type Change struct {
+	Type  ChangeType
+	Key   string
+	Value string
+}
+
+// WatchableKeyValueStore reports changes to its keys so services can reload
+// configuration without restarting.
+type WatchableKeyValueStore interface {
+	KeyValueStore
+
+	// Watch returns the changes to keys starting with the prefix. The channel is
+	// closed when the context is done.
+	Watch(ctx context.Context, prefix string) <-chan Change
+}
@@ -14,6 +14,7 @@ import (
 
 // Compile time interface checks
 var _ WritableKeyValueStore = (*InMemoryKeyValueStore)(nil)
+var _ WatchableKeyValueStore = (*InMemoryKeyValueStore)(nil)
 
 // var _ KeyValueStoreFactory = (*InMemoryKeyValueStore)(nil)
 
@@ -32,6 +33,7 @@ var _ WritableKeyValueStore = (*InMemoryKeyValueStore)(nil)
 type InMemoryKeyValueStore struct {
 	lock sync.RWMutex
 	data map[string]string
+	feed changeFeed
 }
 
 func NewInMemoryKeyValueStore(ctx context.Context, config interface{}) (*InMemoryKeyValueStore, error) {
@@ -69,7 +71,7 @@ func (fs *InMemoryKeyValueStore) Set(name string, value string) error {
 	defer fs.lock.Unlock()
 
 	key := NormalizeKey(name)
-	fs.data[key] = value
+	fs.setLocked(key, value)
 
 	return nil
 }
@@ -80,7 +82,7 @@ func (fs *InMemoryKeyValueStore) SetMany(many map[string]string) error {
 
 	for k, value := range many {
 		key := NormalizeKey(k)
-		fs.data[key] = value
+		fs.setLocked(key, value)
 	}
 
 	return nil
@@ -91,11 +93,28 @@ func (fs *InMemoryKeyValueStore) Delete(name string) error {
 	defer fs.lock.Unlock()
 
 	key := NormalizeKey(name)
-	delete(fs.data, key)
+	if _, ok := fs.data[key]; ok {
+		delete(fs.data, key)
+		fs.feed.notify([]Change{{Type: ChangeDelete, Key: key}})
+	}
 
 	return nil
 }
 
+// setLocked stores the value and notifies the watchers if it changed
+func (fs *InMemoryKeyValueStore) setLocked(key string, value string) {
+	if prev, ok := fs.data[key]; ok && prev == value {
+		return
+	}
+	fs.data[key] = value
+	fs.feed.notify([]Change{{Type: ChangeSet, Key: key, Value: value}})
+}
+
+// Watch reports the changes made through Set, SetMany and Delete
+func (fs *InMemoryKeyValueStore) Watch(ctx context.Context, prefix string) <-chan Change {
+	return fs.feed.subscribe(ctx, prefix, nil)
+}
+
 func (fs *InMemoryKeyValueStore) SaveAsJson(filename string) error {
 	c := gabs.New()
 
@@ -0,0 +1,204 @@
+package keyvalue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appliedres/cloudy/logging"
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchDebounce is how long file stores wait for writes to settle before reloading
+var WatchDebounce = 100 * time.Millisecond
+
+// changeFeed delivers changes to the watchers of a store. Every watcher has its
+// own queue so a slow reader never blocks a writer or loses changes.
+type changeFeed struct {
+	lock sync.Mutex
+	subs map[*changeSubscriber]struct{}
+	stop func()
+}
+
+type changeSubscriber struct {
+	prefix  string
+	lock    sync.Mutex
+	pending []Change
+	signal  chan struct{}
+}
+
+// subscribe adds a watcher for the prefix until the context is done. The optional
+// start function is called when the first watcher subscribes and returns the
+// function that is called when the last one leaves.
+func (f *changeFeed) subscribe(ctx context.Context, prefix string, start func() (stop func(), err error)) <-chan Change {
+	sub := &changeSubscriber{
+		prefix: NormalizeKey(prefix),
+		signal: make(chan struct{}, 1),
+	}
+	out := make(chan Change)
+
+	f.lock.Lock()
+	if f.subs == nil {
+		f.subs = make(map[*changeSubscriber]struct{})
+	}
+	if len(f.subs) == 0 && start != nil {
+		stop, err := start()
+		if err != nil {
+			slog.ErrorContext(ctx, "Error starting key value watch", logging.WithError(err))
+		}
+		f.stop = stop
+	}
+	f.subs[sub] = struct{}{}
+	f.lock.Unlock()
+
+	go func() {
+		defer close(out)
+		defer f.unsubscribe(sub)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.signal:
+			}
+
+			sub.lock.Lock()
+			changes := sub.pending
+			sub.pending = nil
+			sub.lock.Unlock()
+
+			for _, change := range changes {
+				select {
+				case out <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+func (f *changeFeed) unsubscribe(sub *changeSubscriber) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.subs, sub)
+	if len(f.subs) == 0 && f.stop != nil {
+		f.stop()
+		f.stop = nil
+	}
+}
+
+// notify queues the changes for every watcher with a matching prefix
+func (f *changeFeed) notify(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for sub := range f.subs {
+		var matched []Change
+		for _, change := range changes {
+			if strings.HasPrefix(change.Key, sub.prefix) {
+				matched = append(matched, change)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		sub.lock.Lock()
+		sub.pending = append(sub.pending, matched...)
+		sub.lock.Unlock()
+
+		select {
+		case sub.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// diffMaps returns the changes that turn the old values into the new ones,
+// sorted by key
+func diffMaps(old, new map[string]string) []Change {
+	var changes []Change
+	for k, v := range new {
+		if prev, ok := old[k]; !ok || prev != v {
+			changes = append(changes, Change{Type: ChangeSet, Key: k, Value: v})
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			changes = append(changes, Change{Type: ChangeDelete, Key: k})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// watchPath reloads the values whenever a matching file in the directory changes
+// and reports the difference to the previous values. Events are debounced so a
+// file that is still being written is not read half way.
+func watchPath(dir string, match func(name string) bool, reload func() (map[string]string, error), feed *changeFeed) (func(), error) {
+	last, err := reload()
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the directory so files replaced by a rename are still seen
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("error watching %v: %w", dir, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if match(event.Name) {
+					timer = time.After(WatchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error(fmt.Sprintf("Error watching %v", dir), logging.WithError(err))
+			case <-timer:
+				timer = nil
+				current, err := reload()
+				if err != nil {
+					slog.Error(fmt.Sprintf("Error reloading %v", dir), logging.WithError(err))
+					continue
+				}
+				feed.notify(diffMaps(last, current))
+				last = current
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		watcher.Close()
+	}, nil
+}
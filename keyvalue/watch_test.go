@@ -0,0 +1,114 @@
+package keyvalue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextChange(t *testing.T, changes <-chan Change) Change {
+	t.Helper()
+	select {
+	case change, ok := <-changes:
+		require.True(t, ok, "watch closed")
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change")
+	}
+	return Change{}
+}
+
+func assertNoChange(t *testing.T, changes <-chan Change) {
+	t.Helper()
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected change %+v", change)
+	case <-time.After(3 * WatchDebounce):
+	}
+}
+
+func TestInMemoryStoreWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := NewDefaultInMemoryKeyValueStore()
+
+	changes := store.Watch(ctx, "db")
+	require.Nil(t, store.Set("DB_HOST", "localhost"))
+	require.Nil(t, store.Set("other", "ignored"))
+	require.Nil(t, store.Set("db.host", "localhost"))
+	require.Nil(t, store.Delete("db-host"))
+
+	assert.Equal(t, Change{Type: ChangeSet, Key: "db.host", Value: "localhost"}, nextChange(t, changes))
+	assert.Equal(t, Change{Type: ChangeDelete, Key: "db.host"}, nextChange(t, changes))
+
+	cancel()
+	_, ok := <-changes
+	assert.False(t, ok)
+}
+
+func TestFileStoreWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filename := filepath.Join(t.TempDir(), "config.env")
+	require.Nil(t, os.WriteFile(filename, []byte("APP_NAME=demo\nAPP_PORT=80\n"), 0600))
+	store := NewFileKeyValueStoreWFilename(filename)
+
+	changes := store.Watch(ctx, "app")
+
+	// An edit from outside the process
+	require.Nil(t, os.WriteFile(filename, []byte("APP_NAME=demo\nAPP_MODE=debug\n"), 0600))
+	assert.Equal(t, Change{Type: ChangeSet, Key: "app.mode", Value: "debug"}, nextChange(t, changes))
+	assert.Equal(t, Change{Type: ChangeDelete, Key: "app.port"}, nextChange(t, changes))
+
+	value, err := store.Get("APP_MODE")
+	require.Nil(t, err)
+	assert.Equal(t, "debug", value)
+
+	// A change made through the store
+	require.Nil(t, store.Set("app.name", "renamed"))
+	assert.Equal(t, Change{Type: ChangeSet, Key: "app.name", Value: "renamed"}, nextChange(t, changes))
+}
+
+func TestDirStoreWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	store := NewDirectoryKeyValueStoreWFilename(dir)
+	changes := store.Watch(ctx, "")
+
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "DB_PASSWORD"), []byte("secret"), 0600))
+	assert.Equal(t, Change{Type: ChangeSet, Key: "db.password", Value: "secret"}, nextChange(t, changes))
+
+	require.Nil(t, os.Remove(filepath.Join(dir, "DB_PASSWORD")))
+	assert.Equal(t, Change{Type: ChangeDelete, Key: "db.password"}, nextChange(t, changes))
+}
+
+func TestAggregatorWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	top := NewDefaultInMemoryKeyValueStore()
+	bottom := NewDefaultInMemoryKeyValueStore()
+	require.Nil(t, top.Set("log.level", "debug"))
+	require.Nil(t, bottom.Set("log.level", "info"))
+
+	kva := NewKeyValueAggregator(top, bottom)
+	changes := kva.Watch(ctx, "log")
+
+	// Hidden by the top layer
+	require.Nil(t, bottom.Set("log.level", "warn"))
+	assertNoChange(t, changes)
+
+	// Removing the override reveals the lower value
+	require.Nil(t, top.Delete("log.level"))
+	assert.Equal(t, Change{Type: ChangeSet, Key: "log.level", Value: "warn"}, nextChange(t, changes))
+
+	require.Nil(t, bottom.Delete("log.level"))
+	assert.Equal(t, Change{Type: ChangeDelete, Key: "log.level"}, nextChange(t, changes))
+}
@@ -0,0 +1,58 @@
+package license
+
+import (
+	"context"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+)
+
+var _ LicenseManager = (*ResilientLicenseManager)(nil)
+var _ cloudy.ProviderWrapper = (*ResilientLicenseManager)(nil)
+
+// ResilientLicenseManager guards a LicenseManager with a cloudy.Resilience
+//
+//	license.LicenseProviders.Use(cloudy.WithResilience(license.NewResilientLicenseManager))
+type ResilientLicenseManager struct {
+	Licenses   LicenseManager
+	Resilience *cloudy.Resilience
+}
+
+func NewResilientLicenseManager(licenses LicenseManager, r *cloudy.Resilience) LicenseManager {
+	return &ResilientLicenseManager{Licenses: licenses, Resilience: r}
+}
+
+// Unwrap returns the LicenseManager
+func (m *ResilientLicenseManager) Unwrap() any {
+	return m.Licenses
+}
+
+func (m *ResilientLicenseManager) AssignLicense(ctx context.Context, userdId string, licenseSkus ...string) error {
+	return m.Resilience.DoWrite(ctx, "AssignLicense", func(ctx context.Context) error {
+		return m.Licenses.AssignLicense(ctx, userdId, licenseSkus...)
+	})
+}
+
+func (m *ResilientLicenseManager) RemoveLicense(ctx context.Context, userdId string, licenseSkus ...string) error {
+	return m.Resilience.Do(ctx, "RemoveLicense", func(ctx context.Context) error {
+		return m.Licenses.RemoveLicense(ctx, userdId, licenseSkus...)
+	})
+}
+
+func (m *ResilientLicenseManager) GetUserAssigned(ctx context.Context, userdId string) ([]*LicenseDescription, error) {
+	return cloudy.ResilientCall(ctx, m.Resilience, "GetUserAssigned", func(ctx context.Context) ([]*LicenseDescription, error) {
+		return m.Licenses.GetUserAssigned(ctx, userdId)
+	})
+}
+
+func (m *ResilientLicenseManager) GetAssigned(ctx context.Context, licenseSku string) ([]*models.User, error) {
+	return cloudy.ResilientCall(ctx, m.Resilience, "GetAssigned", func(ctx context.Context) ([]*models.User, error) {
+		return m.Licenses.GetAssigned(ctx, licenseSku)
+	})
+}
+
+func (m *ResilientLicenseManager) ListLicenses(ctx context.Context) ([]*LicenseDescription, error) {
+	return cloudy.ResilientCall(ctx, m.Resilience, "ListLicenses", func(ctx context.Context) ([]*LicenseDescription, error) {
+		return m.Licenses.ListLicenses(ctx)
+	})
+}
@@ -90,6 +90,11 @@ func (e *MultiErrors) Error() string {
 	return e.String()
 }
 
+// Unwrap lets errors.Is and errors.As check every item
+func (e *MultiErrors) Unwrap() []error {
+	return e.List()
+}
+
 func (e *MultiErrors) AsErr() error {
 	if e.Len() == 0 {
 		return nil
@@ -0,0 +1,374 @@
+package cloudy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrProviderExists    = errors.New("provider already registered")
+	ErrUnknownDependency = errors.New("provider depends on an unknown provider")
+	ErrDependencyCycle   = errors.New("provider dependency cycle")
+)
+
+// Starter is implemented by providers that need to start before they are used
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Stopper is implemented by providers that release resources when stopped
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// HealthChecker is implemented by providers that can report their health
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// ProviderWrapper is implemented by decorators, such as the resilient
+// providers. Unwrap returns the decorated provider. A Lifecycle starts, checks
+// and stops the first provider of the chain that implements the hook, so a
+// decorator only implements Starter, Stopper or HealthChecker itself when it
+// also takes care of the provider it wraps.
+type ProviderWrapper interface {
+	Unwrap() any
+}
+
+// lifecycleHook returns the instance, or the first provider it wraps, that
+// implements T
+func lifecycleHook[T any](instance any) (T, bool) {
+	for instance != nil {
+		if hook, ok := instance.(T); ok {
+			return hook, true
+		}
+		wrapper, ok := instance.(ProviderWrapper)
+		if !ok {
+			break
+		}
+		instance = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// factory2Adapter lets a ProviderFactory2 be registered in a ProvidersRegistry.
+// The configuration is bound from the environment with Bind.
+type factory2Adapter[T any] struct {
+	factory ProviderFactory2[T]
+}
+
+func (a *factory2Adapter[T]) Create(cfg interface{}) (T, error) {
+	return a.factory.New(context.Background(), cfg)
+}
+
+func (a *factory2Adapter[T]) FromEnv(env *Environment) (interface{}, error) {
+	cfg := a.factory.NewConfig()
+	if _, err := env.Bind(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (a *factory2Adapter[T]) NewConfig() interface{} {
+	return a.factory.NewConfig()
+}
+
+func (a *factory2Adapter[T]) ConfigSchema() *ConfigSchema {
+	schema, _ := FactorySchema(a.factory)
+	return schema
+}
+
+// Register2 adds a ProviderFactory2 (New/NewConfig) so both factory styles can
+// be created through the same registry
+func (pr *ProvidersRegistry[T]) Register2(name string, factory ProviderFactory2[T]) {
+	pr.Register(name, &factory2Adapter[T]{factory: factory})
+}
+
+// NewWithContext creates a provider, passing the context to ProviderFactory2 factories
+func (pr *ProvidersRegistry[T]) NewWithContext(ctx context.Context, name string, cfg interface{}) (T, error) {
+	var zero T
+	factory, ok := MapKey(pr.Providers, name, true)
+	if !ok {
+		return zero, ErrDriverNotFound
+	}
+	if adapter, ok := factory.(*factory2Adapter[T]); ok {
+		instance, err := adapter.factory.New(ctx, cfg)
+		return pr.decorate(nil, name, instance, err)
+	}
+	instance, err := factory.Create(cfg)
+	return pr.decorate(nil, name, instance, err)
+}
+
+// NewManaged creates a provider and adds it to the lifecycle under the name
+func (pr *ProvidersRegistry[T]) NewManaged(ctx context.Context, lc *Lifecycle, name string, driver string, cfg interface{}, dependsOn ...string) (T, error) {
+	var zero T
+	instance, err := pr.NewWithContext(ctx, driver, cfg)
+	if err != nil {
+		return zero, err
+	}
+	return addManaged(ctx, lc, name, instance, dependsOn)
+}
+
+// NewManagedFromEnv creates a provider from the environment and adds it to the
+// lifecycle under the name
+func (pr *ProvidersRegistry[T]) NewManagedFromEnv(lc *Lifecycle, name string, env *Environment, driverKey string, dependsOn ...string) (T, error) {
+	var zero T
+	instance, err := pr.NewFromEnv(env, driverKey)
+	if err != nil {
+		return zero, err
+	}
+	return addManaged(context.Background(), lc, name, instance, dependsOn)
+}
+
+// addManaged adds a created provider to the lifecycle, stopping it again when
+// the lifecycle does not take it
+func addManaged[T any](ctx context.Context, lc *Lifecycle, name string, instance T, dependsOn []string) (T, error) {
+	var zero T
+	if err := lc.Add(name, instance, dependsOn...); err != nil {
+		if stopErr := stopProvider(ctx, instance); stopErr != nil {
+			err = errors.Join(err, stopErr)
+		}
+		return zero, err
+	}
+	return instance, nil
+}
+
+type managedProvider struct {
+	name      string
+	instance  any
+	dependsOn []string
+	started   bool
+}
+
+// Lifecycle tracks created providers and starts, checks and stops them in
+// dependency order. A provider is started after the providers it depends on and
+// stopped before them. Providers take part by implementing Starter, Stopper
+// (or Shutdown(ctx) error, or io.Closer) and HealthChecker. Decorators that
+// implement ProviderWrapper are looked through.
+//
+//	lc := cloudy.NewLifecycle()
+//	secrets, _ := secrets.SecretProviders.NewManagedFromEnv(lc, "secrets", env.Segment("secrets"), "DRIVER")
+//	store, _ := datastore.BinaryDataStoreProviders.NewManagedFromEnv(lc, "store", env.Segment("store"), "DRIVER", "secrets")
+//	err := lc.Start(ctx)
+//	defer lc.Shutdown(ctx)
+type Lifecycle struct {
+	mu        sync.Mutex
+	providers map[string]*managedProvider
+	added     []string // Registration order, keeps the start order stable
+	order     []string // Start order of the last Start
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{
+		providers: make(map[string]*managedProvider),
+	}
+}
+
+// Add tracks a provider. Dependencies are checked when the lifecycle starts, so
+// providers can be added in any order.
+func (lc *Lifecycle) Add(name string, instance any, dependsOn ...string) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if _, exists := lc.providers[name]; exists {
+		return fmt.Errorf("%w: %s", ErrProviderExists, name)
+	}
+	lc.providers[name] = &managedProvider{name: name, instance: instance, dependsOn: dependsOn}
+	lc.added = append(lc.added, name)
+	return nil
+}
+
+// Get returns a tracked provider
+func (lc *Lifecycle) Get(name string) (any, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	p, ok := lc.providers[name]
+	if !ok {
+		return nil, false
+	}
+	return p.instance, true
+}
+
+// Names returns the providers in start order
+func (lc *Lifecycle) Names() ([]string, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.sorted()
+}
+
+// sorted orders the providers so dependencies come first
+func (lc *Lifecycle) sorted() ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(lc.providers))
+	order := make([]string, 0, len(lc.providers))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("%w: %v -> %s", ErrDependencyCycle, path, name)
+		}
+		state[name] = visiting
+		for _, dep := range lc.providers[name].dependsOn {
+			if _, ok := lc.providers[dep]; !ok {
+				return fmt.Errorf("%w: %s needs %s", ErrUnknownDependency, name, dep)
+			}
+			if err := visit(dep, append(path[:len(path):len(path)], name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range lc.added {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Start starts the providers in dependency order. When one fails the providers
+// already started are stopped again and the error is returned.
+func (lc *Lifecycle) Start(ctx context.Context) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	order, err := lc.sorted()
+	if err != nil {
+		return err
+	}
+	lc.order = order
+
+	for _, name := range order {
+		p := lc.providers[name]
+		if p.started {
+			continue
+		}
+		if starter, ok := lifecycleHook[Starter](p.instance); ok {
+			Info(ctx, "Starting provider %s", name)
+			if err := starter.Start(ctx); err != nil {
+				Error(ctx, "Error starting provider %s: %v", name, err)
+				if stopErr := lc.stopLocked(ctx); stopErr != nil {
+					return errors.Join(fmt.Errorf("start %s: %w", name, err), stopErr)
+				}
+				return fmt.Errorf("start %s: %w", name, err)
+			}
+		}
+		p.started = true
+	}
+	return nil
+}
+
+// Stop stops the started providers in reverse dependency order. Every provider
+// is stopped even when some fail, and the errors are returned together.
+func (lc *Lifecycle) Stop(ctx context.Context) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.stopLocked(ctx)
+}
+
+func (lc *Lifecycle) stopLocked(ctx context.Context) error {
+	return lc.stopInOrder(ctx, lc.order, true)
+}
+
+// stopInOrder stops the providers from the end of the order, only the started
+// ones when onlyStarted is set
+func (lc *Lifecycle) stopInOrder(ctx context.Context, order []string, onlyStarted bool) error {
+	errs := MultiError()
+	for i := len(order) - 1; i >= 0; i-- {
+		p := lc.providers[order[i]]
+		if onlyStarted && !p.started {
+			continue
+		}
+		p.started = false
+		if err := stopProvider(ctx, p.instance); err != nil {
+			Error(ctx, "Error stopping provider %s: %v", p.name, err)
+			errs.Append(fmt.Errorf("stop %s: %w", p.name, err))
+		}
+	}
+	return errs.AsErr()
+}
+
+func stopProvider(ctx context.Context, instance any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for instance != nil {
+		switch p := instance.(type) {
+		case Stopper:
+			return p.Stop(ctx)
+		case interface{ Shutdown(context.Context) error }:
+			return p.Shutdown(ctx)
+		case io.Closer:
+			return p.Close()
+		}
+		wrapper, ok := instance.(ProviderWrapper)
+		if !ok {
+			break
+		}
+		instance = wrapper.Unwrap()
+	}
+	return nil
+}
+
+// Shutdown stops every tracked provider, started or not, in reverse dependency
+// order and forgets them. Providers that have not been stopped when the context
+// ends are reported with the context error. When the dependencies cannot be
+// ordered the providers are stopped in reverse of the order they were added.
+func (lc *Lifecycle) Shutdown(ctx context.Context) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	order, err := lc.sorted()
+	if err != nil {
+		order = lc.added
+	}
+	err = lc.stopInOrder(ctx, order, false)
+	lc.providers = make(map[string]*managedProvider)
+	lc.added = nil
+	lc.order = nil
+	return err
+}
+
+// Health checks every provider that implements HealthChecker, by name
+func (lc *Lifecycle) Health(ctx context.Context) map[string]error {
+	lc.mu.Lock()
+	checks := make(map[string]HealthChecker)
+	for name, p := range lc.providers {
+		if checker, ok := lifecycleHook[HealthChecker](p.instance); ok {
+			checks[name] = checker
+		}
+	}
+	lc.mu.Unlock()
+
+	rtn := make(map[string]error, len(checks))
+	for name, checker := range checks {
+		rtn[name] = checker.HealthCheck(ctx)
+	}
+	return rtn
+}
+
+// HealthCheck returns the failed health checks together, nil when all are healthy
+func (lc *Lifecycle) HealthCheck(ctx context.Context) error {
+	errs := MultiError()
+	for name, err := range lc.Health(ctx) {
+		if err != nil {
+			errs.Append(fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errs.AsErr()
+}
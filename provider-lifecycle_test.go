@@ -0,0 +1,176 @@
+package cloudy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testLifecycleProvider struct {
+	name     string
+	events   *[]string
+	startErr error
+	health   error
+}
+
+func (p *testLifecycleProvider) Start(ctx context.Context) error {
+	*p.events = append(*p.events, "start "+p.name)
+	return p.startErr
+}
+
+func (p *testLifecycleProvider) Stop(ctx context.Context) error {
+	*p.events = append(*p.events, "stop "+p.name)
+	return nil
+}
+
+func (p *testLifecycleProvider) HealthCheck(ctx context.Context) error {
+	return p.health
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	var events []string
+	lc := NewLifecycle()
+
+	// Added before its dependencies
+	require.Nil(t, lc.Add("api", &testLifecycleProvider{name: "api", events: &events}, "datastore"))
+	require.Nil(t, lc.Add("datastore", &testLifecycleProvider{name: "datastore", events: &events}, "secrets"))
+	require.Nil(t, lc.Add("secrets", &testLifecycleProvider{name: "secrets", events: &events, health: errors.New("sealed")}))
+	assert.ErrorIs(t, lc.Add("api", nil), ErrProviderExists)
+
+	require.Nil(t, lc.Start(ctx))
+	assert.Equal(t, []string{"start secrets", "start datastore", "start api"}, events)
+
+	health := lc.Health(ctx)
+	assert.Nil(t, health["api"])
+	assert.EqualError(t, health["secrets"], "sealed")
+	assert.ErrorContains(t, lc.HealthCheck(ctx), "secrets: sealed")
+
+	events = nil
+	require.Nil(t, lc.Shutdown(ctx))
+	assert.Equal(t, []string{"stop api", "stop datastore", "stop secrets"}, events)
+	_, found := lc.Get("api")
+	assert.False(t, found)
+}
+
+type testWrappedProvider struct {
+	inner any
+}
+
+func (w *testWrappedProvider) Unwrap() any { return w.inner }
+
+func TestLifecycle_Wrapped(t *testing.T) {
+	ctx := context.Background()
+	var events []string
+	lc := NewLifecycle()
+	inner := &testLifecycleProvider{name: "users", events: &events, health: errors.New("down")}
+	require.Nil(t, lc.Add("users", &testWrappedProvider{inner: &testWrappedProvider{inner: inner}}))
+
+	require.Nil(t, lc.Start(ctx))
+	assert.EqualError(t, lc.Health(ctx)["users"], "down")
+	require.Nil(t, lc.Stop(ctx))
+	assert.Equal(t, []string{"start users", "stop users"}, events)
+}
+
+func TestLifecycle_StartFailure(t *testing.T) {
+	var events []string
+	lc := NewLifecycle()
+	require.Nil(t, lc.Add("secrets", &testLifecycleProvider{name: "secrets", events: &events}))
+	require.Nil(t, lc.Add("datastore", &testLifecycleProvider{name: "datastore", events: &events, startErr: errors.New("boom")}, "secrets"))
+
+	err := lc.Start(context.Background())
+	assert.ErrorContains(t, err, "start datastore: boom")
+	assert.Equal(t, []string{"start secrets", "start datastore", "stop secrets"}, events)
+}
+
+func TestLifecycle_ShutdownWithoutStart(t *testing.T) {
+	var events []string
+	lc := NewLifecycle()
+	require.Nil(t, lc.Add("datastore", &testLifecycleProvider{name: "datastore", events: &events}, "secrets"))
+	require.Nil(t, lc.Add("secrets", &testLifecycleProvider{name: "secrets", events: &events}))
+
+	// Providers created but never started still hold resources
+	require.Nil(t, lc.Shutdown(context.Background()))
+	assert.Equal(t, []string{"stop datastore", "stop secrets"}, events)
+}
+
+func TestLifecycle_Dependencies(t *testing.T) {
+	lc := NewLifecycle()
+	require.Nil(t, lc.Add("a", nil, "b"))
+	require.Nil(t, lc.Add("b", nil, "a"))
+	_, err := lc.Names()
+	assert.ErrorIs(t, err, ErrDependencyCycle)
+
+	lc = NewLifecycle()
+	require.Nil(t, lc.Add("a", nil, "missing"))
+	assert.ErrorIs(t, lc.Start(context.Background()), ErrUnknownDependency)
+}
+
+type testFactory2Config struct {
+	Name string `config:"name,required"`
+}
+
+type testFactory2 struct {
+	events *[]string
+}
+
+func (f *testFactory2) New(ctx context.Context, cfg interface{}) (*testLifecycleProvider, error) {
+	events := f.events
+	if events == nil {
+		events = &[]string{}
+	}
+	return &testLifecycleProvider{name: cfg.(*testFactory2Config).Name, events: events}, nil
+}
+
+func (f *testFactory2) NewConfig() interface{} {
+	return &testFactory2Config{}
+}
+
+func TestRegistry_BothFactoryStyles(t *testing.T) {
+	registry := NewProviderRegistry[*testLifecycleProvider]()
+	registry.Register2("v2", &testFactory2{})
+
+	envSvc := NewMapEnvironment()
+	envSvc.Set("STORE_DRIVER", "v2")
+	envSvc.Set("STORE_NAME", "primary")
+	env := NewEnvironment(NewHierarchicalEnvironment(envSvc)).Segment("store")
+
+	lc := NewLifecycle()
+	p, err := registry.NewManagedFromEnv(lc, "store", env, "DRIVER")
+	require.Nil(t, err)
+	assert.Equal(t, "primary", p.name)
+
+	tracked, found := lc.Get("store")
+	require.True(t, found)
+	assert.Same(t, p, tracked)
+
+	p, err = registry.NewManaged(context.Background(), lc, "other", "v2", &testFactory2Config{Name: "other"}, "store")
+	require.Nil(t, err)
+	assert.Equal(t, "other", p.name)
+
+	names, err := lc.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"store", "other"}, names)
+
+	schema, err := registry.Schema("v2")
+	require.Nil(t, err)
+	assert.Equal(t, "NAME", schema.Keys[0].Name)
+}
+
+func TestRegistry_NewManagedDuplicate(t *testing.T) {
+	var events []string
+	registry := NewProviderRegistry[*testLifecycleProvider]()
+	registry.Register2("v2", &testFactory2{events: &events})
+
+	lc := NewLifecycle()
+	_, err := registry.NewManaged(context.Background(), lc, "store", "v2", &testFactory2Config{Name: "first"})
+	require.Nil(t, err)
+
+	// The second provider is not tracked, so it is stopped right away
+	_, err = registry.NewManaged(context.Background(), lc, "store", "v2", &testFactory2Config{Name: "second"})
+	assert.ErrorIs(t, err, ErrProviderExists)
+	assert.Equal(t, []string{"stop second"}, events)
+}
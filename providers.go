@@ -22,8 +22,13 @@ type ProviderFactory[T any] interface {
 	FromEnv(env *Environment) (interface{}, error)
 }
 
+// ProviderDecorator wraps the providers created by a registry, e.g. with
+// WithResilience. The environment is nil when the provider was not created from one.
+type ProviderDecorator[T any] func(env *Environment, driver string, instance T) (T, error)
+
 type ProvidersRegistry[T any] struct {
-	Providers map[string]ProviderFactory[T]
+	Providers  map[string]ProviderFactory[T]
+	Decorators []ProviderDecorator[T]
 }
 
 func NewProviderRegistry[T any]() *ProvidersRegistry[T] {
@@ -37,6 +42,25 @@ func (pr *ProvidersRegistry[T]) Register(name string, factory ProviderFactory[T]
 	pr.Providers[name] = factory
 }
 
+// Use adds a decorator that wraps every provider created by the registry
+func (pr *ProvidersRegistry[T]) Use(decorator ProviderDecorator[T]) {
+	pr.Decorators = append(pr.Decorators, decorator)
+}
+
+// decorate applies the decorators in the order they were added
+func (pr *ProvidersRegistry[T]) decorate(env *Environment, driver string, instance T, err error) (T, error) {
+	if err != nil {
+		return instance, err
+	}
+	for _, decorator := range pr.Decorators {
+		instance, err = decorator(env, driver, instance)
+		if err != nil {
+			return instance, err
+		}
+	}
+	return instance, nil
+}
+
 func (pr *ProvidersRegistry[T]) New(name string, cfg interface{}) (T, error) {
 	var zero T
 	factory, ok := MapKey(pr.Providers, name, true)
@@ -44,7 +68,8 @@ func (pr *ProvidersRegistry[T]) New(name string, cfg interface{}) (T, error) {
 		return zero, ErrDriverNotFound
 	}
 
-	return factory.Create(cfg)
+	instance, err := factory.Create(cfg)
+	return pr.decorate(nil, name, instance, err)
 }
 
 func (pr *ProvidersRegistry[T]) NewFromEnv(env *Environment, driverKey string) (T, error) {
@@ -60,11 +85,15 @@ func (pr *ProvidersRegistry[T]) NewFromEnv(env *Environment, driverKey string) (
 		Error(context.Background(), "Driver Not found. Available drivers are: %v", keys)
 		return zero, ErrDriverNotFound
 	}
+	if err := pr.validateBeforeCreate(env, driver); err != nil {
+		return zero, err
+	}
 	cfg, err := factory.FromEnv(env)
 	if err != nil {
 		return zero, err
 	}
-	return factory.Create(cfg)
+	instance, err := factory.Create(cfg)
+	return pr.decorate(env, driver, instance, err)
 }
 
 func (pr *ProvidersRegistry[T]) NewFromEnvWith(env *Environment, driver string) (T, error) {
@@ -78,11 +107,15 @@ func (pr *ProvidersRegistry[T]) NewFromEnvWith(env *Environment, driver string)
 		Error(context.Background(), "Driver Not found. Available drivers are: %v", keys)
 		return zero, ErrDriverNotFound
 	}
+	if err := pr.validateBeforeCreate(env, driver); err != nil {
+		return zero, err
+	}
 	cfg, err := factory.FromEnv(env)
 	if err != nil {
 		return zero, err
 	}
-	return factory.Create(cfg)
+	instance, err := factory.Create(cfg)
+	return pr.decorate(env, driver, instance, err)
 }
 
 func FromEnv(prefix string, driverKey string) (string, map[string]interface{}, error) {
@@ -3,29 +3,55 @@ package providers
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/appliedres/cloudy"
 	"github.com/appliedres/cloudy/keyvalue"
 )
 
+// KeyValueStoreProviders maps driver names to key value store factories.
+// Drivers added to the map are also available through KeyValueStoreRegistry.
 var KeyValueStoreProviders = make(map[string]keyvalue.KeyValueStoreFactory)
 
+var (
+	keyValueStoreMu       sync.Mutex
+	keyValueStoreRegistry = cloudy.NewProviderRegistry[keyvalue.KeyValueStore]()
+)
+
+// KeyValueStoreRegistry returns a registry with the drivers of
+// KeyValueStoreProviders, to create key value stores from an environment or
+// with a Lifecycle. The configuration of a driver is bound by field name, so
+// the file driver reads FILENAME from an environment segment.
+func KeyValueStoreRegistry() *cloudy.ProvidersRegistry[keyvalue.KeyValueStore] {
+	keyValueStoreMu.Lock()
+	defer keyValueStoreMu.Unlock()
+	for name, factory := range KeyValueStoreProviders {
+		keyValueStoreRegistry.Register2(name, factory)
+	}
+	return keyValueStoreRegistry
+}
+
+// NewKeyValueStore creates a key value store with the driver configuration
+// read from the stores below the prefix. The configuration is bound with
+// KeyValueAggregator.Bind, by config tag or field name. Json tags are not used.
 func NewKeyValueStore(ctx context.Context, prefix string, store *keyvalue.KeyValueAggregator, driver string) (keyvalue.KeyValueStore, error) {
 	// Look up the Value
-	factory := KeyValueStoreProviders[driver]
-	if factory == nil {
+	factory, found := cloudy.MapKey(KeyValueStoreProviders, driver, true)
+	if !found || factory == nil {
 		return nil, fmt.Errorf("%v keystore driver not found", driver)
 	}
 	// Create the configuration object
 	config := factory.NewConfig()
-	err := store.GetObj(prefix, config)
-	if err != nil {
+	if _, err := store.Bind(config, prefix); err != nil {
 		return nil, err
 	}
 	// Create the Object
-	return factory.New(ctx, config)
+	return KeyValueStoreRegistry().NewWithContext(ctx, driver, config)
 }
 
 func init() {
 	KeyValueStoreProviders["file"] = &keyvalue.FileKeyValueStoreFactory{}
 	KeyValueStoreProviders["directory"] = &keyvalue.DirectoryKeyValueStoreFactory{}
+	KeyValueStoreProviders["encrypted-file"] = &keyvalue.EncryptedFileKeyValueStoreFactory{}
+	KeyValueStoreProviders["config-file"] = &keyvalue.ConfigFileKeyValueStoreFactory{}
 }
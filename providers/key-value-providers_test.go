@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/keyvalue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreProviders(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "values.env")
+
+	// The same key configures the driver from a store and from an environment
+	settings := keyvalue.NewDefaultInMemoryKeyValueStore()
+	require.Nil(t, settings.Set("kv.filename", filename))
+	store, err := NewKeyValueStore(context.Background(), "kv", keyvalue.NewKeyValueAggregator(settings), "file")
+	require.Nil(t, err)
+	assert.Equal(t, filename, store.(*keyvalue.FileKeyValueStore).Filename)
+
+	envSvc := cloudy.NewMapEnvironment()
+	envSvc.Set("KV_DRIVER", "file")
+	envSvc.Set("KV_FILENAME", filename)
+	env := cloudy.NewEnvironment(cloudy.NewHierarchicalEnvironment(envSvc)).Segment("kv")
+	store, err = KeyValueStoreRegistry().NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+	assert.Equal(t, filename, store.(*keyvalue.FileKeyValueStore).Filename)
+
+	// Drivers added to the map are available through the registry
+	KeyValueStoreProviders["custom"] = &keyvalue.FileKeyValueStoreFactory{}
+	defer delete(KeyValueStoreProviders, "custom")
+	envSvc.Set("KV_DRIVER", "custom")
+	store, err = KeyValueStoreRegistry().NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+	assert.Equal(t, filename, store.(*keyvalue.FileKeyValueStore).Filename)
+
+	_, err = NewKeyValueStore(context.Background(), "kv", keyvalue.NewKeyValueAggregator(settings), "missing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,461 @@
+package cloudy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// ResiliencePolicy configures retries, timeouts, the circuit breaker and the
+// rate limiter of a provider. It binds from the driver's environment segment,
+// e.g. USERS_RETRY_MAX_ATTEMPTS or USERS_CIRCUIT_FAILURE_THRESHOLD.
+type ResiliencePolicy struct {
+	// Timeout limits each attempt, 0 for no limit
+	Timeout time.Duration `config:"timeout" description:"Timeout of each attempt"`
+
+	Retry          RetryPolicy          `config:"retry"`
+	CircuitBreaker CircuitBreakerPolicy `config:"circuit"`
+	RateLimit      RateLimitPolicy      `config:"rate-limit"`
+
+	// Retryable decides which errors are retried and count as failures for the
+	// circuit breaker. Defaults to IsRetryable.
+	Retryable func(err error) bool `config:"-"`
+}
+
+// RetryPolicy is an exponential backoff with jitter
+type RetryPolicy struct {
+	MaxAttempts    int           `config:"max-attempts,default=3,min=1" description:"Attempts including the first call"`
+	InitialBackoff time.Duration `config:"initial-backoff,default=100ms" description:"Wait before the first retry"`
+	MaxBackoff     time.Duration `config:"max-backoff,default=10s" description:"Longest wait between attempts"`
+	Multiplier     float64       `config:"multiplier,default=2,min=1" description:"Growth of the wait per attempt"`
+	Jitter         float64       `config:"jitter,default=0.2,min=0,max=1" description:"Fraction of the wait that is random"`
+
+	// Writes allows retrying calls that are not idempotent, such as creating a
+	// user. A retry after a lost response may repeat the change.
+	Writes bool `config:"writes" description:"Retry calls that are not idempotent"`
+}
+
+// CircuitBreakerPolicy opens the circuit after consecutive failures. While open
+// calls fail with ErrCircuitOpen. After the open duration a limited number of
+// probe calls are let through, a success closes the circuit and a failure opens it again.
+type CircuitBreakerPolicy struct {
+	FailureThreshold int           `config:"failure-threshold" description:"Consecutive failures that open the circuit, 0 disables the breaker"`
+	OpenDuration     time.Duration `config:"open-duration,default=30s" description:"How long the circuit stays open before probing"`
+	HalfOpenProbes   int           `config:"half-open-probes,default=1,min=1" description:"Concurrent probe calls while half open"`
+}
+
+// RateLimitPolicy is a token bucket. Calls wait for a token.
+type RateLimitPolicy struct {
+	Rate  float64 `config:"rate" description:"Calls per second, 0 disables the limiter"`
+	Burst int     `config:"burst,default=1,min=1" description:"Calls allowed at once"`
+}
+
+// DefaultResiliencePolicy retries three times and has no timeout, breaker or limiter
+func DefaultResiliencePolicy() *ResiliencePolicy {
+	return &ResiliencePolicy{
+		Retry: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 100 * time.Millisecond,
+			MaxBackoff:     10 * time.Second,
+			Multiplier:     2,
+			Jitter:         0.2,
+		},
+		CircuitBreaker: CircuitBreakerPolicy{
+			OpenDuration:   30 * time.Second,
+			HalfOpenProbes: 1,
+		},
+		RateLimit: RateLimitPolicy{
+			Burst: 1,
+		},
+	}
+}
+
+// LoadResiliencePolicy reads the policy from the environment segment of a driver
+func LoadResiliencePolicy(env *Environment) (*ResiliencePolicy, error) {
+	policy := &ResiliencePolicy{}
+	if _, err := env.Bind(policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// permanentError marks an error that must not be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error so it is never retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// transientError marks an error that may be retried
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// Transient marks an error as a temporary failure that may be retried
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+// IsRetryable is the default error classification. Only temporary failures are
+// retried: errors marked Transient, network timeouts, attempts that ran out of
+// time and errors reporting an HTTP 429 or 5xx status through a StatusCode
+// method. Errors marked Permanent, cancellations, the circuit breaker and rate
+// limiter errors and errors about a missing, existing or invalid item never are.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, ErrCircuitOpen),
+		errors.Is(err, ErrRateLimited),
+		errors.Is(err, ErrKeyNotFound),
+		errors.Is(err, ErrDriverNotFound),
+		errors.Is(err, ErrInvalidConfiguration),
+		errors.Is(err, ErrOperationNotImplemented),
+		errors.Is(err, ErrUserNotFound),
+		errors.Is(err, ErrUserExists),
+		errors.Is(err, ErrInvalidPassword),
+		errors.Is(err, ErrGroupNotFound),
+		errors.Is(err, ErrGroupExists):
+		return false
+	}
+
+	var transient *transientError
+	if errors.As(err, &transient) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var status interface{ StatusCode() int }
+	if errors.As(err, &status) {
+		code := status.StatusCode()
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// Resilience runs calls with a policy. One instance is shared by all the calls
+// to a provider, so the breaker and limiter see the provider's total traffic.
+type Resilience struct {
+	policy  ResiliencePolicy
+	breaker *circuitBreaker
+	limiter *tokenBucket
+}
+
+func NewResilience(policy *ResiliencePolicy) *Resilience {
+	if policy == nil {
+		policy = DefaultResiliencePolicy()
+	}
+	r := &Resilience{policy: *policy}
+	if r.policy.Retryable == nil {
+		r.policy.Retryable = IsRetryable
+	}
+	if r.policy.Retry.MaxAttempts < 1 {
+		r.policy.Retry.MaxAttempts = 1
+	}
+	if r.policy.CircuitBreaker.FailureThreshold > 0 {
+		r.breaker = &circuitBreaker{policy: r.policy.CircuitBreaker}
+	}
+	if r.policy.RateLimit.Rate > 0 {
+		r.limiter = newTokenBucket(r.policy.RateLimit)
+	}
+	return r
+}
+
+// Do runs the call, retrying retryable errors with backoff
+func (r *Resilience) Do(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < r.policy.Retry.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			wait := r.backoff(attempt)
+			Info(ctx, "Retrying %s in %v (attempt %d of %d): %v", op, wait, attempt+1, r.policy.Retry.MaxAttempts, err)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			}
+		}
+
+		err = r.attempt(ctx, fn)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !r.policy.Retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// DoWrite runs a call that is not idempotent. It is made once unless the
+// policy allows retrying writes.
+func (r *Resilience) DoWrite(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	if r.policy.Retry.Writes {
+		return r.Do(ctx, op, fn)
+	}
+	return r.attempt(ctx, fn)
+}
+
+// attempt makes a single call through the breaker, limiter and timeout
+func (r *Resilience) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if r.breaker != nil {
+		if err := r.breaker.allow(); err != nil {
+			return err
+		}
+	}
+	if r.limiter != nil {
+		if err := r.limiter.wait(ctx); err != nil {
+			if r.breaker != nil {
+				r.breaker.cancel()
+			}
+			return err
+		}
+	}
+
+	callCtx := ctx
+	if r.policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, r.policy.Timeout)
+		defer cancel()
+	}
+
+	err := fn(callCtx)
+	if r.breaker != nil {
+		r.breaker.done(err == nil || !r.policy.Retryable(err))
+	}
+	return err
+}
+
+// backoff is the wait before the retry, with the configured fraction randomized
+func (r *Resilience) backoff(attempt int) time.Duration {
+	retry := r.policy.Retry
+	multiplier := retry.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	wait := float64(retry.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if retry.MaxBackoff > 0 && wait > float64(retry.MaxBackoff) {
+		wait = float64(retry.MaxBackoff)
+	}
+	jitter := math.Min(math.Max(retry.Jitter, 0), 1)
+	wait = wait*(1-jitter) + wait*jitter*rand.Float64()
+	return time.Duration(wait)
+}
+
+// ResilientCall runs a call that returns a value, see Resilience.Do
+func ResilientCall[T any](ctx context.Context, r *Resilience, op string, fn func(ctx context.Context) (T, error)) (T, error) {
+	var rtn T
+	err := r.Do(ctx, op, func(ctx context.Context) error {
+		v, err := fn(ctx)
+		if err == nil {
+			rtn = v
+		}
+		return err
+	})
+	return rtn, err
+}
+
+// ResilientWrite runs a call that returns a value, see Resilience.DoWrite
+func ResilientWrite[T any](ctx context.Context, r *Resilience, op string, fn func(ctx context.Context) (T, error)) (T, error) {
+	var rtn T
+	err := r.DoWrite(ctx, op, func(ctx context.Context) error {
+		v, err := fn(ctx)
+		if err == nil {
+			rtn = v
+		}
+		return err
+	})
+	return rtn, err
+}
+
+// WithResilience returns a registry decorator that wraps each created provider,
+// with the policy read from the driver's environment segment
+//
+//	cloudy.UserProviders.Use(cloudy.WithResilience(cloudy.NewResilientUserManager))
+func WithResilience[T any](wrap func(instance T, r *Resilience) T) ProviderDecorator[T] {
+	return func(env *Environment, driver string, instance T) (T, error) {
+		policy := DefaultResiliencePolicy()
+		if env != nil {
+			loaded, err := LoadResiliencePolicy(env)
+			if err != nil {
+				return instance, fmt.Errorf("resilience policy for %v: %w", driver, err)
+			}
+			policy = loaded
+		}
+		return wrap(instance, NewResilience(policy)), nil
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	policy CircuitBreakerPolicy
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+// allow reports whether a call may be made, moving an expired open circuit to half open
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitOpen {
+		if time.Since(cb.openedAt) < cb.policy.OpenDuration {
+			return ErrCircuitOpen
+		}
+		cb.state = circuitHalfOpen
+		cb.probes = 0
+	}
+	if cb.state == circuitHalfOpen {
+		max := cb.policy.HalfOpenProbes
+		if max < 1 {
+			max = 1
+		}
+		if cb.probes >= max {
+			return ErrCircuitOpen
+		}
+		cb.probes++
+	}
+	return nil
+}
+
+// cancel releases a probe that was allowed but never made
+func (cb *circuitBreaker) cancel() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == circuitHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// done records the outcome of a call
+func (cb *circuitBreaker) done(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if success {
+		cb.state = circuitClosed
+		cb.failures = 0
+		cb.probes = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == circuitHalfOpen || cb.failures >= cb.policy.FailureThreshold {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+		cb.probes = 0
+	}
+}
+
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(policy RateLimitPolicy) *tokenBucket {
+	burst := float64(policy.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   policy.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it
+func (tb *tokenBucket) reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// unreserve returns a token that was not used
+func (tb *tokenBucket) unreserve() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.tokens = math.Min(tb.burst, tb.tokens+1)
+}
+
+// wait blocks until a token is available or the context is done
+func (tb *tokenBucket) wait(ctx context.Context) error {
+	delay := tb.reserve()
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		tb.unreserve()
+		return ErrRateLimited
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		tb.unreserve()
+		return ctx.Err()
+	}
+}
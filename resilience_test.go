@@ -0,0 +1,261 @@
+package cloudy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fastPolicy() *ResiliencePolicy {
+	policy := DefaultResiliencePolicy()
+	policy.Retry.InitialBackoff = time.Millisecond
+	policy.Retry.MaxBackoff = 5 * time.Millisecond
+	return policy
+}
+
+func TestResilience_Retry(t *testing.T) {
+	ctx := context.Background()
+	r := NewResilience(fastPolicy())
+
+	calls := 0
+	v, err := ResilientCall(ctx, r, "flaky", func(ctx context.Context) (string, error) {
+		calls++
+		if calls < 3 {
+			return "", Transient(errors.New("unavailable"))
+		}
+		return "ok", nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "ok", v)
+	assert.Equal(t, 3, calls)
+
+	// Permanent errors are not retried
+	calls = 0
+	err = r.Do(ctx, "bad", func(ctx context.Context) error {
+		calls++
+		return Permanent(errors.New("bad request"))
+	})
+	assert.EqualError(t, err, "bad request")
+	assert.Equal(t, 1, calls)
+
+	// Errors are only retried when they are known to be temporary
+	calls = 0
+	err = r.Do(ctx, "invalid", func(ctx context.Context) error {
+		calls++
+		return errors.New("invalid")
+	})
+	assert.EqualError(t, err, "invalid")
+	assert.Equal(t, 1, calls)
+
+	// Gives up after the attempts
+	calls = 0
+	err = r.Do(ctx, "down", func(ctx context.Context) error {
+		calls++
+		return Transient(errors.New("down"))
+	})
+	assert.EqualError(t, err, "down")
+	assert.Equal(t, 3, calls)
+
+	// Writes are made once unless the policy retries them
+	calls = 0
+	_, err = ResilientWrite(ctx, r, "create", func(ctx context.Context) (string, error) {
+		calls++
+		return "", Transient(errors.New("unavailable"))
+	})
+	assert.EqualError(t, err, "unavailable")
+	assert.Equal(t, 1, calls)
+
+	policy := fastPolicy()
+	policy.Retry.Writes = true
+	r = NewResilience(policy)
+	calls = 0
+	err = r.DoWrite(ctx, "create", func(ctx context.Context) error {
+		calls++
+		return Transient(errors.New("unavailable"))
+	})
+	assert.EqualError(t, err, "unavailable")
+	assert.Equal(t, 3, calls)
+}
+
+type testStatusError int
+
+func (e testStatusError) Error() string   { return fmt.Sprintf("status %d", int(e)) }
+func (e testStatusError) StatusCode() int { return int(e) }
+
+func TestIsRetryable(t *testing.T) {
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsRetryable(errors.New("invalid")))
+	assert.True(t, IsRetryable(Transient(errors.New("unavailable"))))
+	assert.True(t, IsRetryable(fmt.Errorf("call: %w", context.DeadlineExceeded)))
+	assert.True(t, IsRetryable(&net.DNSError{IsTimeout: true}))
+	assert.False(t, IsRetryable(&net.DNSError{IsNotFound: true}))
+
+	assert.True(t, IsRetryable(testStatusError(http.StatusTooManyRequests)))
+	assert.True(t, IsRetryable(testStatusError(http.StatusBadGateway)))
+	assert.False(t, IsRetryable(testStatusError(http.StatusBadRequest)))
+	assert.False(t, IsRetryable(testStatusError(http.StatusConflict)))
+
+	for _, err := range []error{ErrUserNotFound, ErrUserExists, ErrInvalidPassword, ErrGroupNotFound, ErrGroupExists} {
+		assert.False(t, IsRetryable(Transient(fmt.Errorf("%w: x", err))), err.Error())
+	}
+	assert.False(t, IsRetryable(Permanent(Transient(errors.New("unavailable")))))
+}
+
+func TestResilience_Backoff(t *testing.T) {
+	policy := DefaultResiliencePolicy()
+	policy.Retry.Jitter = 0
+	r := NewResilience(policy)
+	assert.Equal(t, 100*time.Millisecond, r.backoff(1))
+	assert.Equal(t, 400*time.Millisecond, r.backoff(3))
+	assert.Equal(t, 10*time.Second, r.backoff(20))
+
+	policy.Retry.Jitter = 0.5
+	r = NewResilience(policy)
+	for i := 0; i < 20; i++ {
+		wait := r.backoff(2)
+		assert.GreaterOrEqual(t, wait, 100*time.Millisecond)
+		assert.LessOrEqual(t, wait, 200*time.Millisecond)
+	}
+}
+
+func TestResilience_Timeout(t *testing.T) {
+	policy := fastPolicy()
+	policy.Timeout = 10 * time.Millisecond
+	policy.Retry.MaxAttempts = 2
+	r := NewResilience(policy)
+
+	calls := 0
+	err := r.Do(context.Background(), "slow", func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 2, calls)
+}
+
+func TestResilience_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	policy := fastPolicy()
+	policy.Retry.MaxAttempts = 1
+	policy.CircuitBreaker.FailureThreshold = 2
+	policy.CircuitBreaker.OpenDuration = 20 * time.Millisecond
+	r := NewResilience(policy)
+
+	failing := func(ctx context.Context) error { return Transient(errors.New("down")) }
+	healthy := func(ctx context.Context) error { return nil }
+
+	assert.EqualError(t, r.Do(ctx, "op", failing), "down")
+	assert.EqualError(t, r.Do(ctx, "op", failing), "down")
+	assert.ErrorIs(t, r.Do(ctx, "op", healthy), ErrCircuitOpen)
+
+	// Half open, a failed probe opens the circuit again
+	time.Sleep(25 * time.Millisecond)
+	assert.EqualError(t, r.Do(ctx, "op", failing), "down")
+	assert.ErrorIs(t, r.Do(ctx, "op", healthy), ErrCircuitOpen)
+
+	// A successful probe closes it
+	time.Sleep(25 * time.Millisecond)
+	assert.Nil(t, r.Do(ctx, "op", healthy))
+	assert.Nil(t, r.Do(ctx, "op", healthy))
+
+	// Errors that are not retryable do not trip the breaker
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, r.Do(ctx, "op", func(ctx context.Context) error { return ErrKeyNotFound }), ErrKeyNotFound)
+	}
+	assert.Nil(t, r.Do(ctx, "op", healthy))
+}
+
+func TestResilience_RateLimit(t *testing.T) {
+	policy := fastPolicy()
+	policy.RateLimit.Rate = 100
+	policy.RateLimit.Burst = 2
+	r := NewResilience(policy)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.Nil(t, r.Do(context.Background(), "op", func(ctx context.Context) error { return nil }))
+	}
+	// Two calls from the burst, two more at 10ms each
+	assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	policy.RateLimit.Rate = 0.1
+	policy.RateLimit.Burst = 1
+	r = NewResilience(policy)
+	require.Nil(t, r.Do(ctx, "op", func(ctx context.Context) error { return nil }))
+	assert.ErrorIs(t, r.Do(ctx, "op", func(ctx context.Context) error { return nil }), ErrRateLimited)
+}
+
+type testPinger interface {
+	Ping(ctx context.Context) error
+}
+
+type testFlakyPinger struct {
+	calls int
+}
+
+func (p *testFlakyPinger) Ping(ctx context.Context) error {
+	p.calls++
+	if p.calls == 1 {
+		return Transient(errors.New("unavailable"))
+	}
+	return nil
+}
+
+type testResilientPinger struct {
+	pinger testPinger
+	r      *Resilience
+}
+
+func (p *testResilientPinger) Ping(ctx context.Context) error {
+	return p.r.Do(ctx, "Ping", p.pinger.Ping)
+}
+
+type testPingerFactory struct{}
+
+func (f *testPingerFactory) Create(cfg interface{}) (testPinger, error) {
+	return &testFlakyPinger{}, nil
+}
+
+func (f *testPingerFactory) FromEnv(env *Environment) (interface{}, error) {
+	return nil, nil
+}
+
+func TestWithResilience(t *testing.T) {
+	registry := NewProviderRegistry[testPinger]()
+	registry.Register("flaky", &testPingerFactory{})
+	var policy *Resilience
+	registry.Use(WithResilience(func(p testPinger, r *Resilience) testPinger {
+		policy = r
+		return &testResilientPinger{pinger: p, r: r}
+	}))
+
+	envSvc := NewMapEnvironment()
+	envSvc.Set("PING_DRIVER", "flaky")
+	envSvc.Set("PING_RETRY_MAX_ATTEMPTS", "5")
+	envSvc.Set("PING_RETRY_INITIAL_BACKOFF", "1ms")
+	envSvc.Set("PING_CIRCUIT_FAILURE_THRESHOLD", "10")
+	env := NewEnvironment(NewHierarchicalEnvironment(envSvc)).Segment("ping")
+
+	pinger, err := registry.NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+	require.Nil(t, pinger.Ping(context.Background()))
+	assert.Equal(t, 5, policy.policy.Retry.MaxAttempts)
+	assert.Equal(t, time.Millisecond, policy.policy.Retry.InitialBackoff)
+	assert.Equal(t, 10*time.Second, policy.policy.Retry.MaxBackoff)
+	assert.NotNil(t, policy.breaker)
+	assert.Nil(t, policy.limiter)
+
+	envSvc.Set("PING_RETRY_JITTER", "2")
+	_, err = registry.NewFromEnv(env, "DRIVER")
+	assert.ErrorIs(t, err, ErrConfigOutOfRange)
+}
@@ -0,0 +1,172 @@
+package cloudy
+
+import (
+	"context"
+
+	"github.com/appliedres/cloudy/models"
+)
+
+var _ UserManager = (*ResilientUserManager)(nil)
+var _ GroupManager = (*ResilientGroupManager)(nil)
+var _ ProviderWrapper = (*ResilientUserManager)(nil)
+var _ ProviderWrapper = (*ResilientGroupManager)(nil)
+
+// ResilientUserManager retries, times out and rate limits the calls to a UserManager
+type ResilientUserManager struct {
+	Users      UserManager
+	Resilience *Resilience
+}
+
+func NewResilientUserManager(users UserManager, r *Resilience) UserManager {
+	return &ResilientUserManager{Users: users, Resilience: r}
+}
+
+// Unwrap returns the UserManager
+func (m *ResilientUserManager) Unwrap() any {
+	return m.Users
+}
+
+func (m *ResilientUserManager) ForceUserName(ctx context.Context, name string) (string, bool, error) {
+	var exists bool
+	rtn, err := ResilientCall(ctx, m.Resilience, "ForceUserName", func(ctx context.Context) (string, error) {
+		v, found, err := m.Users.ForceUserName(ctx, name)
+		exists = found
+		return v, err
+	})
+	return rtn, exists, err
+}
+
+func (m *ResilientUserManager) ListUsers(ctx context.Context, filter string, attrs []string) (*[]models.User, error) {
+	return ResilientCall(ctx, m.Resilience, "ListUsers", func(ctx context.Context) (*[]models.User, error) {
+		return m.Users.ListUsers(ctx, filter, attrs)
+	})
+}
+
+func (m *ResilientUserManager) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	return ResilientCall(ctx, m.Resilience, "GetUser", func(ctx context.Context) (*models.User, error) {
+		return m.Users.GetUser(ctx, uid)
+	})
+}
+
+func (m *ResilientUserManager) GetUserByEmail(ctx context.Context, email string, opts *UserOptions) (*models.User, error) {
+	return ResilientCall(ctx, m.Resilience, "GetUserByEmail", func(ctx context.Context) (*models.User, error) {
+		return m.Users.GetUserByEmail(ctx, email, opts)
+	})
+}
+
+func (m *ResilientUserManager) GetUserWithAttributes(ctx context.Context, uid string, attrs []string) (*models.User, error) {
+	return ResilientCall(ctx, m.Resilience, "GetUserWithAttributes", func(ctx context.Context) (*models.User, error) {
+		return m.Users.GetUserWithAttributes(ctx, uid, attrs)
+	})
+}
+
+func (m *ResilientUserManager) NewUser(ctx context.Context, newUser *models.User) (*models.User, error) {
+	return ResilientWrite(ctx, m.Resilience, "NewUser", func(ctx context.Context) (*models.User, error) {
+		return m.Users.NewUser(ctx, newUser)
+	})
+}
+
+func (m *ResilientUserManager) UpdateUser(ctx context.Context, usr *models.User) error {
+	return m.Resilience.Do(ctx, "UpdateUser", func(ctx context.Context) error {
+		return m.Users.UpdateUser(ctx, usr)
+	})
+}
+
+func (m *ResilientUserManager) Enable(ctx context.Context, uid string) error {
+	return m.Resilience.Do(ctx, "Enable", func(ctx context.Context) error {
+		return m.Users.Enable(ctx, uid)
+	})
+}
+
+func (m *ResilientUserManager) Disable(ctx context.Context, uid string) error {
+	return m.Resilience.Do(ctx, "Disable", func(ctx context.Context) error {
+		return m.Users.Disable(ctx, uid)
+	})
+}
+
+func (m *ResilientUserManager) DeleteUser(ctx context.Context, uid string) error {
+	return m.Resilience.Do(ctx, "DeleteUser", func(ctx context.Context) error {
+		return m.Users.DeleteUser(ctx, uid)
+	})
+}
+
+func (m *ResilientUserManager) SetUserPassword(ctx context.Context, uid string, pwd string, mustChange bool) error {
+	return m.Resilience.DoWrite(ctx, "SetUserPassword", func(ctx context.Context) error {
+		return m.Users.SetUserPassword(ctx, uid, pwd, mustChange)
+	})
+}
+
+// ResilientGroupManager is the GroupManager counterpart of ResilientUserManager
+type ResilientGroupManager struct {
+	Groups     GroupManager
+	Resilience *Resilience
+}
+
+func NewResilientGroupManager(groups GroupManager, r *Resilience) GroupManager {
+	return &ResilientGroupManager{Groups: groups, Resilience: r}
+}
+
+// Unwrap returns the GroupManager
+func (m *ResilientGroupManager) Unwrap() any {
+	return m.Groups
+}
+
+func (m *ResilientGroupManager) ListGroups(ctx context.Context, filter string, attrs []string) (*[]models.Group, error) {
+	return ResilientCall(ctx, m.Resilience, "ListGroups", func(ctx context.Context) (*[]models.Group, error) {
+		return m.Groups.ListGroups(ctx, filter, attrs)
+	})
+}
+
+func (m *ResilientGroupManager) GetGroup(ctx context.Context, id string) (*models.Group, error) {
+	return ResilientCall(ctx, m.Resilience, "GetGroup", func(ctx context.Context) (*models.Group, error) {
+		return m.Groups.GetGroup(ctx, id)
+	})
+}
+
+func (m *ResilientGroupManager) GetGroupId(ctx context.Context, name string) (string, error) {
+	return ResilientCall(ctx, m.Resilience, "GetGroupId", func(ctx context.Context) (string, error) {
+		return m.Groups.GetGroupId(ctx, name)
+	})
+}
+
+func (m *ResilientGroupManager) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	return ResilientCall(ctx, m.Resilience, "GetUserGroups", func(ctx context.Context) ([]*models.Group, error) {
+		return m.Groups.GetUserGroups(ctx, uid)
+	})
+}
+
+func (m *ResilientGroupManager) NewGroup(ctx context.Context, grp *models.Group) (*models.Group, error) {
+	return ResilientWrite(ctx, m.Resilience, "NewGroup", func(ctx context.Context) (*models.Group, error) {
+		return m.Groups.NewGroup(ctx, grp)
+	})
+}
+
+func (m *ResilientGroupManager) UpdateGroup(ctx context.Context, grp *models.Group) (bool, error) {
+	return ResilientCall(ctx, m.Resilience, "UpdateGroup", func(ctx context.Context) (bool, error) {
+		return m.Groups.UpdateGroup(ctx, grp)
+	})
+}
+
+func (m *ResilientGroupManager) GetGroupMembers(ctx context.Context, grpId string) ([]*models.User, error) {
+	return ResilientCall(ctx, m.Resilience, "GetGroupMembers", func(ctx context.Context) ([]*models.User, error) {
+		return m.Groups.GetGroupMembers(ctx, grpId)
+	})
+}
+
+func (m *ResilientGroupManager) RemoveMembers(ctx context.Context, groupId string, userIds []string) error {
+	return m.Resilience.Do(ctx, "RemoveMembers", func(ctx context.Context) error {
+		return m.Groups.RemoveMembers(ctx, groupId, userIds)
+	})
+}
+
+func (m *ResilientGroupManager) AddMembers(ctx context.Context, groupId string, userIds []string) error {
+	return m.Resilience.DoWrite(ctx, "AddMembers", func(ctx context.Context) error {
+		return m.Groups.AddMembers(ctx, groupId, userIds)
+	})
+}
+
+func (m *ResilientGroupManager) DeleteGroup(ctx context.Context, groupId string) error {
+	return m.Resilience.Do(ctx, "DeleteGroup", func(ctx context.Context) error {
+		return m.Groups.DeleteGroup(ctx, groupId)
+	})
+}
@@ -0,0 +1,209 @@
+package scim
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// AuthenticationScheme is advertised in the ServiceProviderConfig
+type AuthenticationScheme struct {
+	Type             string `json:"type"`
+	Name             string `json:"name"`
+	Description      string `json:"description"`
+	SpecURI          string `json:"specUri,omitempty"`
+	DocumentationURI string `json:"documentationUri,omitempty"`
+	Primary          bool   `json:"primary,omitempty"`
+}
+
+// DefaultAuthenticationScheme is the bearer token scheme identity providers
+// use for SCIM provisioning
+var DefaultAuthenticationScheme = &AuthenticationScheme{
+	Type:        "oauthbearertoken",
+	Name:        "OAuth Bearer Token",
+	Description: "Authentication scheme using the OAuth Bearer Token Standard",
+	SpecURI:     "https://www.rfc-editor.org/info/rfc6750",
+	Primary:     true,
+}
+
+// SchemaAttribute describes an attribute in /Schemas (RFC 7643 7)
+type SchemaAttribute struct {
+	Name          string             `json:"name"`
+	Type          string             `json:"type"`
+	MultiValued   bool               `json:"multiValued"`
+	Required      bool               `json:"required"`
+	CaseExact     bool               `json:"caseExact"`
+	Mutability    string             `json:"mutability"`
+	Returned      string             `json:"returned"`
+	Uniqueness    string             `json:"uniqueness"`
+	SubAttributes []*SchemaAttribute `json:"subAttributes,omitempty"`
+}
+
+func stringAttribute(name string) *SchemaAttribute {
+	return &SchemaAttribute{Name: name, Type: "string", Mutability: "readWrite", Returned: "default", Uniqueness: "none"}
+}
+
+func (h *Handler) serviceProviderConfig() Resource {
+	schemes := h.options.AuthenticationSchemes
+	if len(schemes) == 0 {
+		schemes = []*AuthenticationScheme{DefaultAuthenticationScheme}
+	}
+	return toResource(map[string]any{
+		"schemas":               []string{ServiceProviderConfigSchema},
+		"patch":                 map[string]any{"supported": true},
+		"bulk":                  map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":                map[string]any{"supported": true, "maxResults": h.options.MaxResults},
+		"changePassword":        map[string]any{"supported": true},
+		"sort":                  map[string]any{"supported": false},
+		"etag":                  map[string]any{"supported": true},
+		"authenticationSchemes": schemes,
+		"meta":                  h.meta("ServiceProviderConfig", "/ServiceProviderConfig", nil),
+	})
+}
+
+func (h *Handler) resourceTypes() []Resource {
+	user := map[string]any{
+		"schemas":  []string{ResourceTypeSchema},
+		"id":       "User",
+		"name":     "User",
+		"endpoint": "/Users",
+		"schema":   UserSchema,
+		"meta":     h.meta("ResourceType", "/ResourceTypes/User", nil),
+	}
+	var extensions []any
+	for _, urn := range h.extensionSchemas() {
+		extensions = append(extensions, map[string]any{"schema": urn, "required": false})
+	}
+	if len(extensions) > 0 {
+		user["schemaExtensions"] = extensions
+	}
+	types := []Resource{toResource(user)}
+
+	if h.groups != nil {
+		types = append(types, toResource(map[string]any{
+			"schemas":  []string{ResourceTypeSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   GroupSchema,
+			"meta":     h.meta("ResourceType", "/ResourceTypes/Group", nil),
+		}))
+	}
+	return types
+}
+
+// schemas describes the core attributes and the mapped ones
+func (h *Handler) schemas() []Resource {
+	userName := stringAttribute("userName")
+	userName.Required, userName.Uniqueness = true, "server"
+	password := stringAttribute("password")
+	password.Mutability, password.Returned = "writeOnly", "never"
+	emails := &SchemaAttribute{Name: "emails", Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+		SubAttributes: []*SchemaAttribute{stringAttribute("value"), stringAttribute("type"), {Name: "primary", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"}}}
+	name := &SchemaAttribute{Name: "name", Type: "complex", Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+		SubAttributes: []*SchemaAttribute{stringAttribute("givenName"), stringAttribute("familyName")}}
+
+	core := []*SchemaAttribute{
+		userName,
+		name,
+		stringAttribute("displayName"),
+		emails,
+		{Name: "active", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+		password,
+	}
+	extensions := map[string][]*SchemaAttribute{}
+	for _, path := range h.mappedPaths() {
+		p, err := ParsePath(path)
+		if err != nil {
+			continue
+		}
+		if p.URN != "" && !strings.EqualFold(p.URN, UserSchema) {
+			extensions[p.URN] = addSchemaAttribute(extensions[p.URN], p)
+		} else if !strings.EqualFold(p.Attr, "externalId") {
+			core = addSchemaAttribute(core, p)
+		}
+	}
+
+	schemas := []Resource{h.schema(UserSchema, "User", core)}
+	for _, urn := range h.extensionSchemas() {
+		schemas = append(schemas, h.schema(urn, urn[strings.LastIndex(urn, ":")+1:], extensions[urn]))
+	}
+
+	if h.groups != nil {
+		displayName := stringAttribute("displayName")
+		displayName.Required = true
+		value := stringAttribute("value")
+		value.Mutability = "immutable"
+		display := stringAttribute("display")
+		display.Mutability = "readOnly"
+		members := &SchemaAttribute{Name: "members", Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+			SubAttributes: []*SchemaAttribute{value, display, stringAttribute("type"), {Name: "$ref", Type: "reference", Mutability: "immutable", Returned: "default", Uniqueness: "none"}}}
+		schemas = append(schemas, h.schema(GroupSchema, "Group", []*SchemaAttribute{displayName, members}))
+	}
+	return schemas
+}
+
+func (h *Handler) schema(urn string, name string, attributes []*SchemaAttribute) Resource {
+	return toResource(map[string]any{
+		"schemas":    []string{SchemaSchema},
+		"id":         urn,
+		"name":       name,
+		"attributes": attributes,
+		"meta":       h.meta("Schema", "/Schemas/"+urn, nil),
+	})
+}
+
+// addSchemaAttribute describes a mapped attribute, values selected by a
+// filter are complex multi-valued attributes
+func addSchemaAttribute(attrs []*SchemaAttribute, p *Path) []*SchemaAttribute {
+	var attr *SchemaAttribute
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name, p.Attr) {
+			attr = a
+		}
+	}
+	if attr == nil {
+		attr = stringAttribute(p.Attr)
+		attrs = append(attrs, attr)
+	}
+	if p.Filter != nil {
+		attr.Type, attr.MultiValued = "complex", true
+		for _, sub := range append(p.Filter.Attributes(), p.Sub) {
+			attr.SubAttributes = addSchemaAttribute(attr.SubAttributes, &Path{Attr: sub})
+		}
+	} else if p.Sub != "" {
+		attr.Type = "complex"
+		attr.SubAttributes = addSchemaAttribute(attr.SubAttributes, &Path{Attr: p.Sub})
+	}
+	return attrs
+}
+
+func (h *Handler) mappedPaths() []string {
+	var paths []string
+	for path := range h.options.Mapping.attributes() {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// extensionSchemas lists the extension schemas used by the mapping
+func (h *Handler) extensionSchemas() []string {
+	var urns []string
+	for _, path := range h.mappedPaths() {
+		p, err := ParsePath(path)
+		if err == nil && p.URN != "" && !strings.EqualFold(p.URN, UserSchema) && !containsFold(urns, p.URN) {
+			urns = append(urns, p.URN)
+		}
+	}
+	return urns
+}
+
+// toResource converts a message to a Resource through JSON so that it holds
+// the same types as a decoded one
+func toResource(v map[string]any) Resource {
+	data, _ := json.Marshal(v)
+	res := Resource{}
+	_ = json.Unmarshal(data, &res)
+	return res
+}
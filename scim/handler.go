@@ -0,0 +1,833 @@
+package scim
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+)
+
+// DefaultMaxResults is the page size when a query does not set "count"
+const DefaultMaxResults = 100
+
+// maxRequestSize limits request bodies
+const maxRequestSize = 1 << 20
+
+// HandlerOptions configures a Handler
+type HandlerOptions struct {
+	// BaseURL is the URL the handler is mounted at, used for meta.location,
+	// e.g. "https://api.example.com/scim/v2"
+	BaseURL string
+
+	// Mapping maps SCIM attributes to user attributes, DefaultAttributeMapping
+	// when nil
+	Mapping *AttributeMapping
+
+	// MaxResults is the largest page returned by a query, DefaultMaxResults
+	// when zero
+	MaxResults int
+
+	// AuthenticationSchemes are advertised in the ServiceProviderConfig. The
+	// handler does not authenticate requests, wrap it with middleware that
+	// does.
+	AuthenticationSchemes []*AuthenticationScheme
+}
+
+// Handler is a SCIM 2.0 service provider (RFC 7643 / 7644) for a
+// cloudy.UserManager and cloudy.GroupManager. It serves /Users, /Groups,
+// /ServiceProviderConfig, /Schemas and /ResourceTypes relative to where it is
+// mounted, use http.StripPrefix to mount it below a path. Queries support
+// filters and pagination. Filters are passed to ListUsers and ListGroups of
+// managers that implement cloudy.StandardFilterLister when all their
+// attributes map to user or group attributes, other managers list everything
+// and the results are filtered locally. Updates support PATCH and ETag
+// preconditions. The group manager may be nil, /Groups is then not available.
+type Handler struct {
+	users   cloudy.UserManager
+	groups  cloudy.GroupManager
+	options HandlerOptions
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler creates a SCIM handler, options may be nil
+func NewHandler(users cloudy.UserManager, groups cloudy.GroupManager, options *HandlerOptions) *Handler {
+	h := &Handler{users: users, groups: groups}
+	if options != nil {
+		h.options = *options
+	}
+	h.options.BaseURL = strings.TrimSuffix(h.options.BaseURL, "/")
+	if h.options.Mapping == nil {
+		h.options.Mapping = DefaultAttributeMapping()
+	}
+	if h.options.MaxResults <= 0 {
+		h.options.MaxResults = DefaultMaxResults
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id := ""
+	if len(segments) == 2 {
+		id = segments[1]
+	} else if len(segments) > 2 {
+		writeError(w, NewError(http.StatusNotFound, "", "%s not found", r.URL.Path))
+		return
+	}
+
+	switch endpoint := segments[0]; {
+	case strings.EqualFold(endpoint, "Users"):
+		h.serveUsers(w, r, id)
+	case strings.EqualFold(endpoint, "Groups") && h.groups != nil:
+		h.serveGroups(w, r, id)
+	case strings.EqualFold(endpoint, "ServiceProviderConfig"):
+		h.serveDiscovery(w, r, id, h.serviceProviderConfig())
+	case strings.EqualFold(endpoint, "ResourceTypes"):
+		h.serveDiscovery(w, r, id, h.resourceTypes()...)
+	case strings.EqualFold(endpoint, "Schemas"):
+		h.serveDiscovery(w, r, id, h.schemas()...)
+	case strings.EqualFold(endpoint, "Bulk"), strings.EqualFold(endpoint, "Me"):
+		writeError(w, NewError(http.StatusNotImplemented, "", "/%s is not supported", endpoint))
+	default:
+		writeError(w, NewError(http.StatusNotFound, "", "%s not found", r.URL.Path))
+	}
+}
+
+func (h *Handler) serveUsers(w http.ResponseWriter, r *http.Request, id string) {
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.search(w, r, h.queryUsers)
+	case id == ".search" && r.Method == http.MethodPost:
+		h.search(w, r, h.queryUsers)
+	case id == "" && r.Method == http.MethodPost:
+		h.createUser(w, r)
+	case id == "":
+		writeError(w, NewError(http.StatusMethodNotAllowed, "", "method %s is not supported", r.Method))
+	case r.Method == http.MethodGet:
+		res, err := h.getUser(r.Context(), id)
+		h.serveGet(w, r, res, err)
+	case r.Method == http.MethodPut, r.Method == http.MethodPatch:
+		h.updateUser(w, r, id)
+	case r.Method == http.MethodDelete:
+		h.deleteUser(w, r, id)
+	default:
+		writeError(w, NewError(http.StatusMethodNotAllowed, "", "method %s is not supported", r.Method))
+	}
+}
+
+func (h *Handler) serveGroups(w http.ResponseWriter, r *http.Request, id string) {
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.search(w, r, h.queryGroups)
+	case id == ".search" && r.Method == http.MethodPost:
+		h.search(w, r, h.queryGroups)
+	case id == "" && r.Method == http.MethodPost:
+		h.createGroup(w, r)
+	case id == "":
+		writeError(w, NewError(http.StatusMethodNotAllowed, "", "method %s is not supported", r.Method))
+	case r.Method == http.MethodGet:
+		res, err := h.getGroup(r.Context(), id, true)
+		h.serveGet(w, r, res, err)
+	case r.Method == http.MethodPut, r.Method == http.MethodPatch:
+		h.updateGroup(w, r, id)
+	case r.Method == http.MethodDelete:
+		h.deleteGroup(w, r, id)
+	default:
+		writeError(w, NewError(http.StatusMethodNotAllowed, "", "method %s is not supported", r.Method))
+	}
+}
+
+// Query is a search request, from the query string or a POST to .search
+type Query struct {
+	Schemas            []string `json:"schemas,omitempty"`
+	Filter             string   `json:"filter,omitempty"`
+	StartIndex         int      `json:"startIndex,omitempty"`
+	Count              *int     `json:"count,omitempty"`
+	Attributes         []string `json:"attributes,omitempty"`
+	ExcludedAttributes []string `json:"excludedAttributes,omitempty"`
+}
+
+func (h *Handler) search(w http.ResponseWriter, r *http.Request, query func(ctx context.Context, q *Query, filter *cloudy.Filter) ([]Resource, error)) {
+	q, err := readQuery(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	filter, err := cloudy.ParseFilter(q.Filter)
+	if err != nil {
+		writeError(w, NewError(http.StatusBadRequest, ScimTypeInvalidFilter, "%v", err))
+		return
+	}
+
+	all, err := query(r.Context(), q, filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// The providers filter what they can, matching again covers the rest
+	var matched []Resource
+	for _, res := range all {
+		if res.Match(filter) {
+			matched = append(matched, res)
+		}
+	}
+
+	start := max(q.StartIndex, 1)
+	count := h.options.MaxResults
+	if q.Count != nil {
+		count = min(max(*q.Count, 0), h.options.MaxResults)
+	}
+	page := []Resource{}
+	if start <= len(matched) {
+		for _, res := range matched[start-1 : min(start-1+count, len(matched))] {
+			page = append(page, project(res, q.Attributes, q.ExcludedAttributes))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, &ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(matched),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	})
+}
+
+func readQuery(w http.ResponseWriter, r *http.Request) (*Query, error) {
+	q := &Query{}
+	if r.Method == http.MethodPost {
+		if err := readBody(w, r, q); err != nil {
+			return nil, err
+		}
+		return q, nil
+	}
+
+	values := r.URL.Query()
+	q.Filter = values.Get("filter")
+	if s := values.Get("startIndex"); s != "" {
+		start, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, NewError(http.StatusBadRequest, ScimTypeInvalidValue, "invalid startIndex %q", s)
+		}
+		q.StartIndex = start
+	}
+	if s := values.Get("count"); s != "" {
+		count, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, NewError(http.StatusBadRequest, ScimTypeInvalidValue, "invalid count %q", s)
+		}
+		q.Count = &count
+	}
+	q.Attributes = splitList(values.Get("attributes"))
+	q.ExcludedAttributes = splitList(values.Get("excludedAttributes"))
+	return q, nil
+}
+
+func (h *Handler) queryUsers(ctx context.Context, q *Query, filter *cloudy.Filter) ([]Resource, error) {
+	translated := ""
+	if cloudy.UsesStandardFilters(h.users) {
+		translated = h.userFilter(filter)
+	}
+	users, err := h.users.ListUsers(ctx, translated, nil)
+	if err != nil || users == nil {
+		return nil, err
+	}
+	resources := make([]Resource, 0, len(*users))
+	for i := range *users {
+		resources = append(resources, h.userResource(&(*users)[i]))
+	}
+	return resources, nil
+}
+
+func (h *Handler) queryGroups(ctx context.Context, q *Query, filter *cloudy.Filter) ([]Resource, error) {
+	translated := ""
+	if cloudy.UsesStandardFilters(h.groups) {
+		translated = groupFilter(filter)
+	}
+	groups, err := h.groups.ListGroups(ctx, translated, nil)
+	if err != nil || groups == nil {
+		return nil, err
+	}
+
+	// Members are expensive to list, skip them when they are not needed
+	withMembers := !containsFold(q.ExcludedAttributes, "members") ||
+		containsFold(filter.Attributes(), "members") || containsFold(filter.Attributes(), "members.value")
+
+	resources := make([]Resource, 0, len(*groups))
+	for i := range *groups {
+		var members []*models.User
+		if withMembers {
+			members, err = h.groups.GetGroupMembers(ctx, (*groups)[i].ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		resources = append(resources, h.groupResource(&(*groups)[i], members))
+	}
+	return resources, nil
+}
+
+// userFilter translates a SCIM filter to a ListUsers filter. It is empty when
+// an attribute has no user attribute, the results are then filtered locally.
+func (h *Handler) userFilter(filter *cloudy.Filter) string {
+	ok := true
+	translated := filter.RenameAttributes(func(attr string) string {
+		name := h.options.Mapping.UserAttribute(attr)
+		if name == "" {
+			ok = false
+		}
+		return name
+	})
+	if !ok {
+		return ""
+	}
+	return translated.String()
+}
+
+// groupFilter translates a SCIM filter to a ListGroups filter, only id and
+// displayName exist on groups
+func groupFilter(filter *cloudy.Filter) string {
+	ok := true
+	translated := filter.RenameAttributes(func(attr string) string {
+		if name, found := cutPrefixFold(attr, GroupSchema+":"); found {
+			attr = name
+		}
+		switch strings.ToLower(attr) {
+		case "id":
+			return "id"
+		case "displayname":
+			return "name"
+		}
+		ok = false
+		return attr
+	})
+	if !ok {
+		return ""
+	}
+	return translated.String()
+}
+
+// serveGet returns a resource, honouring If-None-Match and the attributes
+// parameters
+func (h *Handler) serveGet(w http.ResponseWriter, r *http.Request, res Resource, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version := resourceVersion(res)
+	if etagMatches(r.Header.Get("If-None-Match"), version) {
+		w.Header().Set("ETag", version)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	values := r.URL.Query()
+	writeResource(w, http.StatusOK, project(res, splitList(values.Get("attributes")), splitList(values.Get("excludedAttributes"))))
+}
+
+func (h *Handler) getUser(ctx context.Context, id string) (Resource, error) {
+	u, err := h.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return h.userResource(u), nil
+}
+
+func (h *Handler) findUser(ctx context.Context, id string) (*models.User, error) {
+	u, err := h.users.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, NewError(http.StatusNotFound, "", "user %s not found", id)
+	}
+	return u, nil
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	res := Resource{}
+	if err := readBody(w, r, &res); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	u := h.options.Mapping.ToUser(res)
+	u.UID = ""
+	if u.Username == "" {
+		writeError(w, NewError(http.StatusBadRequest, ScimTypeInvalidValue, "userName is required"))
+		return
+	}
+	enabled := u.Enabled
+	created, err := h.users.NewUser(ctx, u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.applyUserState(ctx, created, enabled, Password(res)); err != nil {
+		// Do not leave a user without the password or state it was created with
+		_ = h.users.DeleteUser(ctx, created.UID)
+		writeError(w, err)
+		return
+	}
+
+	created, err = h.findUser(ctx, created.UID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res = h.userResource(created)
+	w.Header().Set("Location", location(res))
+	writeResource(w, http.StatusCreated, res)
+}
+
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	existing, err := h.findUser(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	current := h.userResource(existing)
+	if err := checkIfMatch(r, current); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := readUpdate(w, r, current)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	u := h.options.Mapping.MergeUser(existing, res)
+	if u.Username == "" {
+		writeError(w, NewError(http.StatusBadRequest, ScimTypeInvalidValue, "userName is required"))
+		return
+	}
+	if err := h.users.UpdateUser(ctx, u); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.applyUserState(ctx, existing, u.Enabled, Password(res)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err = h.getUser(ctx, existing.UID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResource(w, http.StatusOK, res)
+}
+
+// applyUserState sets what UpdateUser does not: the enabled state and the
+// password
+func (h *Handler) applyUserState(ctx context.Context, u *models.User, enabled bool, password string) error {
+	if enabled != u.Enabled {
+		var err error
+		if enabled {
+			err = h.users.Enable(ctx, u.UID)
+		} else {
+			err = h.users.Disable(ctx, u.UID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if password != "" {
+		return h.users.SetUserPassword(ctx, u.UID, password, false)
+	}
+	return nil
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	existing, err := h.findUser(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, h.userResource(existing)); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.users.DeleteUser(ctx, existing.UID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getGroup(ctx context.Context, id string, withMembers bool) (Resource, error) {
+	g, err := h.groups.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, NewError(http.StatusNotFound, "", "group %s not found", id)
+	}
+	var members []*models.User
+	if withMembers {
+		members, err = h.groups.GetGroupMembers(ctx, g.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h.groupResource(g, members), nil
+}
+
+func (h *Handler) createGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	res := Resource{}
+	if err := readBody(w, r, &res); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	g := ToGroup(res)
+	g.ID = ""
+	if g.Name == "" {
+		writeError(w, NewError(http.StatusBadRequest, ScimTypeInvalidValue, "displayName is required"))
+		return
+	}
+	created, err := h.groups.NewGroup(ctx, g)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.syncMembers(ctx, created.ID, nil, MemberIDs(res)); err != nil {
+		// Do not leave a group without the members it was created with
+		_ = h.groups.DeleteGroup(ctx, created.ID)
+		writeError(w, err)
+		return
+	}
+
+	res, err = h.getGroup(ctx, created.ID, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", location(res))
+	writeResource(w, http.StatusCreated, res)
+}
+
+func (h *Handler) updateGroup(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	current, err := h.getGroup(ctx, id, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, current); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := readUpdate(w, r, current)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	existing := ToGroup(current)
+	g := ToGroup(res)
+	g.ID = existing.ID
+	if g.Name == "" {
+		writeError(w, NewError(http.StatusBadRequest, ScimTypeInvalidValue, "displayName is required"))
+		return
+	}
+	if g.Name != existing.Name {
+		if _, err := h.groups.UpdateGroup(ctx, g); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if err := h.syncMembers(ctx, g.ID, MemberIDs(current), MemberIDs(res)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err = h.getGroup(ctx, g.ID, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResource(w, http.StatusOK, res)
+}
+
+// syncMembers adds and removes members so the group has the wanted ones
+func (h *Handler) syncMembers(ctx context.Context, groupID string, current []string, wanted []string) error {
+	var add, remove []string
+	for _, id := range wanted {
+		if !cloudy.ArrayIncludes(current, id) && !cloudy.ArrayIncludes(add, id) {
+			add = append(add, id)
+		}
+	}
+	for _, id := range current {
+		if !cloudy.ArrayIncludes(wanted, id) {
+			remove = append(remove, id)
+		}
+	}
+
+	if len(remove) > 0 {
+		if err := h.groups.RemoveMembers(ctx, groupID, remove); err != nil {
+			return err
+		}
+	}
+	if len(add) > 0 {
+		err := h.groups.AddMembers(ctx, groupID, add)
+		if errors.Is(err, cloudy.ErrUserNotFound) {
+			return NewError(http.StatusBadRequest, ScimTypeInvalidValue, "%v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) deleteGroup(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	current, err := h.getGroup(ctx, id, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, current); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.groups.DeleteGroup(ctx, current.String("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) serveDiscovery(w http.ResponseWriter, r *http.Request, id string, resources ...Resource) {
+	if r.Method != http.MethodGet {
+		writeError(w, NewError(http.StatusMethodNotAllowed, "", "method %s is not supported", r.Method))
+		return
+	}
+	if len(resources) == 1 && resources[0].isCore(ServiceProviderConfigSchema) {
+		writeJSON(w, http.StatusOK, resources[0])
+		return
+	}
+	if id != "" {
+		for _, res := range resources {
+			if strings.EqualFold(res.String("id"), id) {
+				writeJSON(w, http.StatusOK, res)
+				return
+			}
+		}
+		writeError(w, NewError(http.StatusNotFound, "", "%s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, &ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *Handler) userResource(u *models.User) Resource {
+	res := h.options.Mapping.ToResource(u)
+	res["meta"] = h.meta("User", "/Users/"+u.UID, res)
+	return res
+}
+
+func (h *Handler) groupResource(g *models.Group, members []*models.User) Resource {
+	res := ToGroupResource(g, members)
+	for _, m := range res.Get("members") {
+		m.(map[string]any)["$ref"] = h.options.BaseURL + "/Users/" + stringValue(m.(map[string]any)["value"])
+	}
+	res["meta"] = h.meta("Group", "/Groups/"+g.ID, res)
+	return res
+}
+
+func (h *Handler) meta(resourceType string, path string, res Resource) map[string]any {
+	meta := map[string]any{
+		"resourceType": resourceType,
+		"location":     h.options.BaseURL + path,
+	}
+	if res != nil {
+		meta["version"] = resourceVersion(res)
+	}
+	return meta
+}
+
+// readUpdate returns the resource a PUT replaces the current one with, or the
+// current one patched by a PATCH
+func readUpdate(w http.ResponseWriter, r *http.Request, current Resource) (Resource, error) {
+	if r.Method == http.MethodPut {
+		res := Resource{}
+		if err := readBody(w, r, &res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	patch := &PatchRequest{}
+	if err := readBody(w, r, patch); err != nil {
+		return nil, err
+	}
+	res := current.Clone()
+	if err := res.Patch(patch.Operations); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, v any) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(v); err != nil {
+		return NewError(http.StatusBadRequest, ScimTypeInvalidSyntax, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+// resourceVersion is a weak ETag of the resource without its meta data
+func resourceVersion(res Resource) string {
+	content := make(map[string]any, len(res))
+	for k, v := range res {
+		if !strings.EqualFold(k, "meta") {
+			content[k] = v
+		}
+	}
+	data, _ := json.Marshal(content)
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// checkIfMatch fails with 412 when the If-Match header does not match the
+// current version
+func checkIfMatch(r *http.Request, current Resource) error {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagMatches(header, resourceVersion(current)) {
+		return nil
+	}
+	return NewError(http.StatusPreconditionFailed, ScimTypeInvalidVers, "the resource has changed")
+}
+
+// etagMatches compares a list of ETags with the version, ignoring the weak
+// prefix
+func etagMatches(header string, version string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag != "" && tag == strings.TrimPrefix(version, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func location(res Resource) string {
+	if meta, ok := res.lookup("meta").(map[string]any); ok {
+		return stringValue(meta["location"])
+	}
+	return ""
+}
+
+// project keeps the requested attributes, or drops the excluded ones. The
+// schemas, id and meta attributes are always returned.
+func project(res Resource, attributes []string, excluded []string) Resource {
+	if len(attributes) > 0 {
+		out := Resource{}
+		for _, k := range []string{"schemas", "id", "meta"} {
+			if v := res.lookup(k); v != nil {
+				out[k] = v
+			}
+		}
+		for _, attr := range attributes {
+			p, err := ParsePath(attr)
+			if err != nil {
+				continue
+			}
+			key := p.Attr
+			if p.URN != "" && !res.isCore(p.URN) {
+				key = p.URN
+			}
+			if v := res.lookup(key); v != nil {
+				out[keyFold(res, key)] = v
+			}
+		}
+		return out
+	}
+
+	if len(excluded) == 0 {
+		return res
+	}
+	out := res.Clone()
+	for _, attr := range excluded {
+		if p, err := ParsePath(attr); err == nil && !containsFold([]string{"schemas", "id", "meta"}, p.Attr) {
+			_ = out.apply(PatchRemove, p, nil)
+		}
+	}
+	return out
+}
+
+func writeResource(w http.ResponseWriter, status int, res Resource) {
+	if meta, ok := res.lookup("meta").(map[string]any); ok {
+		if version := stringValue(meta["version"]); version != "" {
+			w.Header().Set("ETag", version)
+		}
+	}
+	writeJSON(w, status, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps errors from the managers to SCIM errors
+func writeError(w http.ResponseWriter, err error) {
+	var scimErr *Error
+	switch {
+	case errors.As(err, &scimErr):
+	case errors.Is(err, cloudy.ErrUserNotFound), errors.Is(err, cloudy.ErrGroupNotFound):
+		scimErr = NewError(http.StatusNotFound, "", "%v", err)
+	case errors.Is(err, cloudy.ErrUserExists), errors.Is(err, cloudy.ErrGroupExists):
+		scimErr = NewError(http.StatusConflict, ScimTypeUniqueness, "%v", err)
+	case errors.Is(err, cloudy.ErrInvalidFilter):
+		scimErr = NewError(http.StatusBadRequest, ScimTypeInvalidFilter, "%v", err)
+	case errors.Is(err, cloudy.ErrInvalidPassword):
+		scimErr = NewError(http.StatusBadRequest, ScimTypeInvalidValue, "%v", err)
+	case errors.Is(err, cloudy.ErrOperationNotImplemented):
+		scimErr = NewError(http.StatusNotImplemented, "", "%v", err)
+	default:
+		scimErr = NewError(http.StatusInternalServerError, "", "%v", err)
+	}
+	writeJSON(w, scimErr.StatusCode(), scimErr)
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,367 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/identity"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type scimTestServer struct {
+	t        *testing.T
+	server   *httptest.Server
+	provider *identity.MemoryIdentityProvider
+}
+
+func newSCIMTestServer(t *testing.T) *scimTestServer {
+	p, err := identity.NewMemoryIdentityProvider(context.Background(), nil)
+	require.Nil(t, err)
+	h := NewHandler(p, p, &HandlerOptions{BaseURL: "https://example.com/scim/v2", MaxResults: 50})
+	server := httptest.NewServer(http.StripPrefix("/scim/v2", h))
+	t.Cleanup(server.Close)
+	return &scimTestServer{t: t, server: server, provider: p}
+}
+
+func (s *scimTestServer) do(method string, path string, body any, headers ...string) (*http.Response, Resource) {
+	var reader *bytes.Reader
+	if text, ok := body.(string); ok {
+		reader = bytes.NewReader([]byte(text))
+	} else {
+		data, err := json.Marshal(body)
+		require.Nil(s.t, err)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.server.URL+"/scim/v2"+path, reader)
+	require.Nil(s.t, err)
+	req.Header.Set("Content-Type", ContentType)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(s.t, err)
+	defer resp.Body.Close()
+
+	res := Resource{}
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	return resp, res
+}
+
+func TestHandlerUsers(t *testing.T) {
+	s := newSCIMTestServer(t)
+
+	resp, created := s.do(http.MethodPost, "/Users", `{
+		"schemas": ["`+UserSchema+`", "`+EnterpriseUserSchema+`"],
+		"userName": "jane@example.com",
+		"name": {"givenName": "Jane", "familyName": "Doe"},
+		"emails": [{"value": "jane@example.com", "primary": true}],
+		"password": "Secret!123",
+		"`+EnterpriseUserSchema+`": {"department": "eng"}
+	}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	id := created.String("id")
+	require.NotEmpty(t, id)
+	assert.Equal(t, "https://example.com/scim/v2/Users/"+id, resp.Header.Get("Location"))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+	assert.Nil(t, created.lookup("password"), "passwords are never returned")
+
+	_, err := s.provider.Authenticate(context.Background(), "jane@example.com", "Secret!123")
+	assert.Nil(t, err)
+	u, _ := s.provider.GetUser(context.Background(), id)
+	assert.Equal(t, "eng", u.Attributes["department"])
+	assert.Equal(t, "Jane", u.FirstName)
+
+	resp, dup := s.do(http.MethodPost, "/Users", `{"userName": "jane@example.com"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, ScimTypeUniqueness, dup.String("scimType"))
+	assert.Contains(t, dup.Schemas(), ErrorSchema)
+
+	// A rejected password does not leave the user behind
+	resp, _ = s.do(http.MethodPost, "/Users", `{"userName": "weak@example.com", "password": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	users, err := s.provider.ListUsers(context.Background(), `username eq "weak@example.com"`, nil)
+	require.Nil(t, err)
+	assert.Empty(t, *users)
+
+	// ETags
+	resp, _ = s.do(http.MethodGet, "/Users/"+id, nil)
+	etag := resp.Header.Get("ETag")
+	resp, _ = s.do(http.MethodGet, "/Users/"+id, nil, "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	patch := map[string]any{
+		"schemas": []string{PatchOpSchema},
+		"Operations": []map[string]any{
+			{"op": "Replace", "path": "active", "value": "False"},
+			{"op": "replace", "value": map[string]any{"displayName": "Jane D."}},
+		},
+	}
+	resp, _ = s.do(http.MethodPatch, "/Users/"+id, patch, "If-Match", `W/"stale"`)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, patched := s.do(http.MethodPatch, "/Users/"+id, patch, "If-Match", etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, false, patched.lookup("active"))
+	assert.Equal(t, "Jane D.", patched.String("displayName"))
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	u, _ = s.provider.GetUser(context.Background(), id)
+	assert.False(t, u.Enabled)
+	assert.Equal(t, "eng", u.Attributes["department"])
+
+	// PUT replaces the mapped attributes
+	resp, replaced := s.do(http.MethodPut, "/Users/"+id, `{"userName": "jane@example.com", "active": true, "title": "Engineer"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Engineer", replaced.String("title"))
+	assert.Nil(t, replaced.lookup("name"))
+	u, _ = s.provider.GetUser(context.Background(), id)
+	assert.True(t, u.Enabled)
+	assert.Empty(t, u.Attributes["department"])
+
+	resp, _ = s.do(http.MethodDelete, "/Users/"+id, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, missing := s.do(http.MethodGet, "/Users/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "404", missing.String("status"))
+}
+
+func TestHandlerListUsers(t *testing.T) {
+	s := newSCIMTestServer(t)
+	ctx := context.Background()
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		_, err := s.provider.NewUser(ctx, &models.User{UID: name, Username: name, Email: name + "@example.com"})
+		require.Nil(t, err)
+	}
+	require.Nil(t, s.provider.Disable(ctx, "bob"))
+
+	list := func(query url.Values) *ListResponse {
+		resp, err := http.Get(s.server.URL + "/scim/v2/Users?" + query.Encode())
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		out := &ListResponse{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(out))
+		return out
+	}
+
+	page := list(url.Values{"startIndex": {"2"}, "count": {"2"}})
+	assert.Equal(t, 4, page.TotalResults)
+	assert.Equal(t, 2, page.StartIndex)
+	require.Equal(t, 2, page.ItemsPerPage)
+	assert.Equal(t, "bob", page.Resources[0].String("userName"))
+	assert.Equal(t, "carol", page.Resources[1].String("userName"))
+
+	page = list(url.Values{"filter": {`userName eq "Carol" or active eq false`}})
+	require.Equal(t, 2, page.TotalResults)
+	assert.Equal(t, "bob", page.Resources[0].String("id"))
+
+	page = list(url.Values{"filter": {`emails.value sw "d"`}, "attributes": {"userName"}})
+	require.Equal(t, 1, page.TotalResults)
+	assert.Equal(t, Resource{"schemas": []any{UserSchema}, "id": "dave", "userName": "dave", "meta": page.Resources[0]["meta"]}, page.Resources[0])
+
+	page = list(url.Values{"count": {"0"}})
+	assert.Equal(t, 4, page.TotalResults)
+	assert.Empty(t, page.Resources)
+
+	resp, res := s.do(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq`), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, ScimTypeInvalidFilter, res.String("scimType"))
+
+	resp, res = s.do(http.MethodPost, "/Users/.search", map[string]any{"filter": `userName ew "e"`, "count": 10})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, float64(2), res.lookup("totalResults"))
+}
+
+type filterRecorder struct {
+	*identity.MemoryIdentityProvider
+	userFilters  []string
+	groupFilters []string
+}
+
+func (r *filterRecorder) ListUsers(ctx context.Context, filter string, attrs []string) (*[]models.User, error) {
+	r.userFilters = append(r.userFilters, filter)
+	return r.MemoryIdentityProvider.ListUsers(ctx, filter, attrs)
+}
+
+func (r *filterRecorder) ListGroups(ctx context.Context, filter string, attrs []string) (*[]models.Group, error) {
+	r.groupFilters = append(r.groupFilters, filter)
+	return r.MemoryIdentityProvider.ListGroups(ctx, filter, attrs)
+}
+
+func TestHandlerFilterPushDown(t *testing.T) {
+	ctx := context.Background()
+	p, err := identity.NewMemoryIdentityProvider(ctx, nil)
+	require.Nil(t, err)
+	rec := &filterRecorder{MemoryIdentityProvider: p}
+	h := NewHandler(rec, rec, &HandlerOptions{BaseURL: "https://example.com/scim/v2", MaxResults: 50})
+	for _, name := range []string{"alice", "bob"} {
+		_, err := p.NewUser(ctx, &models.User{UID: name, Username: name, Attributes: map[string]string{"department": name + "-dept"}})
+		require.Nil(t, err)
+		_, err = p.NewGroup(ctx, &models.Group{Name: name + "s"})
+		require.Nil(t, err)
+	}
+
+	search := func(path string, filter string) *ListResponse {
+		req := httptest.NewRequest(http.MethodGet, path+"?"+url.Values{"filter": {filter}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		out := &ListResponse{}
+		require.Nil(t, json.NewDecoder(w.Body).Decode(out))
+		return out
+	}
+
+	page := search("/Users", `userName eq "bob" and `+EnterpriseUserSchema+`:department pr`)
+	assert.Equal(t, 1, page.TotalResults)
+	assert.Equal(t, []string{`username eq "bob" and attributes.department pr`}, rec.userFilters)
+
+	// Attributes without a user attribute are filtered locally
+	page = search("/Users", `nickName pr or userName eq "alice"`)
+	assert.Equal(t, 1, page.TotalResults)
+	assert.Equal(t, "", rec.userFilters[1])
+
+	page = search("/Groups", `displayName eq "bobs"`)
+	assert.Equal(t, 1, page.TotalResults)
+	page = search("/Groups", `members.value eq "alice"`)
+	assert.Equal(t, 0, page.TotalResults)
+	assert.Equal(t, []string{`name eq "bobs"`, ""}, rec.groupFilters)
+}
+
+// foreignManager has a filter syntax of its own
+type foreignManager struct {
+	cloudy.UserManager
+	cloudy.GroupManager
+}
+
+func (m *foreignManager) ListUsers(ctx context.Context, filter string, attrs []string) (*[]models.User, error) {
+	if filter != "" {
+		return nil, cloudy.ErrInvalidFilter
+	}
+	return m.UserManager.ListUsers(ctx, filter, attrs)
+}
+
+func (m *foreignManager) ListGroups(ctx context.Context, filter string, attrs []string) (*[]models.Group, error) {
+	if filter != "" {
+		return nil, cloudy.ErrInvalidFilter
+	}
+	return m.GroupManager.ListGroups(ctx, filter, attrs)
+}
+
+func TestHandlerForeignFilters(t *testing.T) {
+	ctx := context.Background()
+	p, err := identity.NewMemoryIdentityProvider(ctx, nil)
+	require.Nil(t, err)
+	m := &foreignManager{UserManager: p, GroupManager: p}
+	h := NewHandler(m, m, nil)
+	_, err = p.NewUser(ctx, &models.User{UID: "alice", Username: "alice"})
+	require.Nil(t, err)
+	_, err = p.NewGroup(ctx, &models.Group{Name: "admins"})
+	require.Nil(t, err)
+
+	for path, filter := range map[string]string{"/Users": `userName eq "alice"`, "/Groups": `displayName eq "admins"`} {
+		req := httptest.NewRequest(http.MethodGet, path+"?"+url.Values{"filter": {filter}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		out := &ListResponse{}
+		require.Nil(t, json.NewDecoder(w.Body).Decode(out))
+		assert.Equal(t, 1, out.TotalResults, path)
+	}
+}
+
+func TestHandlerGroups(t *testing.T) {
+	s := newSCIMTestServer(t)
+	ctx := context.Background()
+	for _, name := range []string{"alice", "bob", "carol"} {
+		_, err := s.provider.NewUser(ctx, &models.User{UID: name, Username: name})
+		require.Nil(t, err)
+	}
+
+	resp, group := s.do(http.MethodPost, "/Groups", `{
+		"schemas": ["`+GroupSchema+`"],
+		"displayName": "Engineering",
+		"members": [{"value": "alice"}, {"value": "bob"}]
+	}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	id := group.String("id")
+	assert.Equal(t, []string{"alice", "bob"}, MemberIDs(group))
+	assert.Equal(t, "https://example.com/scim/v2/Users/alice", group.GetString(`members[value eq "alice"].$ref`))
+
+	resp, _ = s.do(http.MethodPatch, "/Groups/"+id, `{"schemas": ["`+PatchOpSchema+`"], "Operations": [
+		{"op": "remove", "path": "members[value eq \"alice\"]"},
+		{"op": "add", "path": "members", "value": [{"value": "carol"}]},
+		{"op": "replace", "path": "displayName", "value": "Eng"}
+	]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	members, err := s.provider.GetGroupMembers(ctx, id)
+	require.Nil(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "bob", members[0].UID)
+	assert.Equal(t, "carol", members[1].UID)
+	g, _ := s.provider.GetGroup(ctx, id)
+	assert.Equal(t, "Eng", g.Name)
+
+	resp, res := s.do(http.MethodPatch, "/Groups/"+id, `{"Operations": [{"op": "add", "path": "members", "value": [{"value": "nobody"}]}]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, ScimTypeInvalidValue, res.String("scimType"))
+
+	resp, res = s.do(http.MethodGet, "/Groups?excludedAttributes=members&filter="+url.QueryEscape(`displayName eq "eng"`), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, float64(1), res.lookup("totalResults"))
+	listed := Resource(res.lookup("Resources").([]any)[0].(map[string]any))
+	assert.Nil(t, listed.lookup("members"))
+
+	resp, res = s.do(http.MethodGet, "/Groups?filter="+url.QueryEscape(`members.value eq "carol"`), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, float64(1), res.lookup("totalResults"))
+
+	resp, _ = s.do(http.MethodPut, "/Groups/"+id, `{"displayName": "Eng", "members": []}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	members, _ = s.provider.GetGroupMembers(ctx, id)
+	assert.Empty(t, members)
+
+	resp, _ = s.do(http.MethodDelete, "/Groups/"+id, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = s.do(http.MethodGet, "/Groups/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandlerDiscovery(t *testing.T) {
+	s := newSCIMTestServer(t)
+
+	resp, config := s.do(http.MethodGet, "/ServiceProviderConfig", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, true, config.Get("patch.supported")[0])
+	assert.Equal(t, true, config.Get("etag.supported")[0])
+	assert.Equal(t, float64(50), config.Get("filter.maxResults")[0])
+	assert.Equal(t, "oauthbearertoken", config.GetString("authenticationSchemes.type"))
+
+	resp, types := s.do(http.MethodGet, "/ResourceTypes", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, float64(2), types.lookup("totalResults"))
+
+	resp, userType := s.do(http.MethodGet, "/ResourceTypes/User", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, EnterpriseUserSchema, userType.GetString("schemaExtensions.schema"))
+
+	resp, schema := s.do(http.MethodGet, "/Schemas/"+UserSchema, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "never", schema.GetString(`attributes[name eq "password"].returned`))
+	assert.Equal(t, "complex", schema.GetString(`attributes[name eq "phoneNumbers"].type`))
+
+	resp, schema = s.do(http.MethodGet, "/Schemas/"+EnterpriseUserSchema, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "string", schema.GetString(`attributes[name eq "department"].type`))
+
+	resp, _ = s.do(http.MethodGet, "/Bulk", nil)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	resp, _ = s.do(http.MethodGet, "/Unknown", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
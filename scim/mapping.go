@@ -0,0 +1,269 @@
+package scim
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/appliedres/cloudy/models"
+)
+
+// AttributeMapping maps SCIM attributes to models.User. The core attributes
+// are fixed:
+//
+//	id                  UID
+//	userName            Username
+//	displayName         DisplayName
+//	name.givenName      FirstName
+//	name.familyName     LastName
+//	emails[primary]     Email
+//	active              Enabled
+//
+// Attributes maps other SCIM attribute paths to keys of models.User.Attributes.
+// SCIM attributes that are not mapped are dropped.
+type AttributeMapping struct {
+	Attributes map[string]string
+}
+
+// DefaultAttributeMapping maps the commonly provisioned attributes, including
+// the enterprise extension
+func DefaultAttributeMapping() *AttributeMapping {
+	return &AttributeMapping{
+		Attributes: map[string]string{
+			"externalId":                             "externalId",
+			"title":                                  "title",
+			`phoneNumbers[type eq "work"].value`:     "phone",
+			`phoneNumbers[type eq "mobile"].value`:   "mobile",
+			EnterpriseUserSchema + ":employeeNumber": "employeeNumber",
+			EnterpriseUserSchema + ":department":     "department",
+			EnterpriseUserSchema + ":organization":   "organization",
+			EnterpriseUserSchema + ":manager.value":  "manager",
+		},
+	}
+}
+
+// ParseAttributeMapping parses a mapping from a comma separated list of
+// path=attribute pairs, e.g. `title=jobTitle,urn:...:User:department=dept`
+func ParseAttributeMapping(s string) (*AttributeMapping, error) {
+	m := &AttributeMapping{Attributes: map[string]string{}}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, fmt.Errorf("invalid attribute mapping %q", pair)
+		}
+		path, attr := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if _, err := ParsePath(path); err != nil {
+			return nil, fmt.Errorf("invalid attribute mapping %q: %w", pair, err)
+		}
+		m.Attributes[path] = attr
+	}
+	return m, nil
+}
+
+// ToResource converts a user to a SCIM User
+func (m *AttributeMapping) ToResource(u *models.User) Resource {
+	r := Resource{
+		"schemas": []any{UserSchema},
+		"id":      u.UID,
+		"active":  u.Enabled,
+	}
+	r["userName"] = u.Username
+	if u.Username == "" {
+		r["userName"] = u.Email
+	}
+	if u.DisplayName != "" {
+		r["displayName"] = u.DisplayName
+	}
+
+	name := map[string]any{}
+	if u.FirstName != "" {
+		name["givenName"] = u.FirstName
+	}
+	if u.LastName != "" {
+		name["familyName"] = u.LastName
+	}
+	if len(name) > 0 {
+		r["name"] = name
+	}
+	if u.Email != "" {
+		r["emails"] = []any{map[string]any{"value": u.Email, "type": "work", "primary": true}}
+	}
+
+	for path, attr := range m.attributes() {
+		if v := u.Attributes[attr]; v != "" {
+			// Mapped paths were validated, an error leaves the attribute out
+			_ = r.Set(path, v)
+		}
+	}
+	return r
+}
+
+// ToUser converts a SCIM User to a user. A missing "active" means enabled.
+func (m *AttributeMapping) ToUser(r Resource) *models.User {
+	u := &models.User{
+		UID:         r.String("id"),
+		Username:    r.String("userName"),
+		DisplayName: r.String("displayName"),
+		FirstName:   r.GetString("name.givenName"),
+		LastName:    r.GetString("name.familyName"),
+		Email:       r.GetString("emails[primary eq true].value"),
+		Enabled:     true,
+	}
+	if u.Email == "" {
+		u.Email = r.GetString("emails.value")
+	}
+	if active, ok := boolValue(r.lookup("active")); ok {
+		u.Enabled = active
+	}
+
+	for path, attr := range m.attributes() {
+		if v := r.GetString(path); v != "" {
+			if u.Attributes == nil {
+				u.Attributes = map[string]string{}
+			}
+			u.Attributes[attr] = v
+		}
+	}
+	return u
+}
+
+// MergeUser converts a SCIM User that replaces the existing user. Attributes
+// the mapping does not cover are kept.
+func (m *AttributeMapping) MergeUser(existing *models.User, r Resource) *models.User {
+	u := m.ToUser(r)
+	u.UID = existing.UID
+	mapped := map[string]bool{}
+	for _, attr := range m.attributes() {
+		mapped[attr] = true
+	}
+	for k, v := range existing.Attributes {
+		if !mapped[k] {
+			if u.Attributes == nil {
+				u.Attributes = map[string]string{}
+			}
+			u.Attributes[k] = v
+		}
+	}
+	return u
+}
+
+// Path returns the SCIM attribute path of a user attribute, including the
+// core ones ("username", "email", ...). It is empty when the attribute is not
+// mapped.
+func (m *AttributeMapping) Path(attr string) string {
+	switch strings.ToLower(attr) {
+	case "uid", "id":
+		return "id"
+	case "username":
+		return "userName"
+	case "displayname":
+		return "displayName"
+	case "firstname":
+		return "name.givenName"
+	case "lastname":
+		return "name.familyName"
+	case "email":
+		return "emails.value"
+	case "enabled":
+		return "active"
+	}
+	name, _ := cutPrefixFold(attr, "attributes.")
+	for path, a := range m.attributes() {
+		if strings.EqualFold(a, name) {
+			return path
+		}
+	}
+	return ""
+}
+
+// UserAttribute returns the user attribute of a SCIM attribute path, the
+// reverse of Path. It is empty when the path is not mapped.
+func (m *AttributeMapping) UserAttribute(path string) string {
+	if name, ok := cutPrefixFold(path, UserSchema+":"); ok {
+		path = name
+	}
+	switch strings.ToLower(path) {
+	case "id":
+		return "uid"
+	case "username":
+		return "username"
+	case "displayname":
+		return "displayName"
+	case "name.givenname":
+		return "firstName"
+	case "name.familyname":
+		return "lastName"
+	case "emails", "emails.value":
+		return "email"
+	case "active":
+		return "enabled"
+	}
+	for p, attr := range m.attributes() {
+		if strings.EqualFold(p, path) {
+			return "attributes." + attr
+		}
+	}
+	return ""
+}
+
+// Password returns the password of a SCIM User, it is never returned by the
+// service provider
+func Password(r Resource) string {
+	return r.String("password")
+}
+
+// ToGroupResource converts a group and its members to a SCIM Group
+func ToGroupResource(g *models.Group, members []*models.User) Resource {
+	r := Resource{
+		"schemas":     []any{GroupSchema},
+		"id":          g.ID,
+		"displayName": g.Name,
+	}
+	list := make([]any, 0, len(members))
+	for _, u := range members {
+		member := map[string]any{"value": u.UID, "type": "User"}
+		if display := userDisplay(u); display != "" {
+			member["display"] = display
+		}
+		list = append(list, member)
+	}
+	r["members"] = list
+	return r
+}
+
+// ToGroup converts a SCIM Group to a group
+func ToGroup(r Resource) *models.Group {
+	return &models.Group{
+		ID:   r.String("id"),
+		Name: r.String("displayName"),
+	}
+}
+
+// MemberIDs returns the ids of the members of a SCIM Group
+func MemberIDs(r Resource) []string {
+	var ids []string
+	for _, v := range r.Get("members.value") {
+		if id := stringValue(v); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (m *AttributeMapping) attributes() map[string]string {
+	if m == nil {
+		return nil
+	}
+	return maps.Clone(m.Attributes)
+}
+
+func userDisplay(u *models.User) string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
+}
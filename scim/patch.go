@@ -0,0 +1,74 @@
+package scim
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Patch applies the operations of a PATCH request to the resource. Operation
+// names are case insensitive and operations without a path merge their value
+// into the resource, as sent by most identity providers.
+func (r Resource) Patch(ops []*PatchOperation) error {
+	for _, op := range ops {
+		name := strings.ToLower(op.Op)
+		switch name {
+		case PatchAdd, PatchReplace, PatchRemove:
+		default:
+			return NewError(http.StatusBadRequest, ScimTypeInvalidSyntax, "unknown operation %q", op.Op)
+		}
+
+		if op.Path == "" || r.isSchema(op.Path) {
+			if name == PatchRemove {
+				return NewError(http.StatusBadRequest, ScimTypeNoTarget, "remove requires a path")
+			}
+			values, ok := op.Value.(map[string]any)
+			if !ok {
+				return NewError(http.StatusBadRequest, ScimTypeInvalidValue, "%s without a path requires an object", op.Op)
+			}
+			if err := r.merge(name, op.Path, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		p, err := ParsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		if err := r.apply(name, p, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge applies each attribute of the value, keys may be paths such as
+// "name.givenName" or extension schemas holding their attributes
+func (r Resource) merge(op string, urn string, values map[string]any) error {
+	for key, value := range values {
+		if urn == "" && r.isSchema(key) {
+			if ext, ok := value.(map[string]any); ok {
+				if err := r.merge(op, key, ext); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if urn == "" && strings.EqualFold(key, "schemas") {
+			continue
+		}
+
+		path := key
+		if urn != "" {
+			path = urn + ":" + key
+		}
+		p, err := ParsePath(path)
+		if err != nil {
+			return err
+		}
+		if err := r.apply(op, p, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
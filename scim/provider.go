@@ -0,0 +1,676 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+)
+
+const ProviderID = "scim"
+
+func init() {
+	cloudy.UserProviders.Register2(ProviderID, &ProviderFactory[cloudy.UserManager]{})
+	cloudy.GroupProviders.Register2(ProviderID, &ProviderFactory[cloudy.GroupManager]{})
+}
+
+var (
+	_ cloudy.UserManager          = (*Provider)(nil)
+	_ cloudy.GroupManager         = (*Provider)(nil)
+	_ cloudy.StandardFilterLister = (*Provider)(nil)
+)
+
+// ProviderConfig configures a Provider
+type ProviderConfig struct {
+	// URL is the base URL of the SCIM service, e.g. https://example.com/scim/v2
+	URL string `config:"url,required" description:"Base URL of the SCIM service"`
+
+	// Token is sent as a bearer token, Username and Password use basic
+	// authentication instead
+	Token    string `config:"token,secret" description:"Bearer token"`
+	Username string `config:"username" description:"Basic authentication user"`
+	Password string `config:"password,secret" description:"Basic authentication password"`
+
+	// AttributeMap is parsed with ParseAttributeMapping, the default mapping
+	// is used when it and Mapping are empty
+	AttributeMap string `config:"attribute_map" description:"SCIM path=attribute pairs mapped to user attributes"`
+
+	PageSize int           `config:"page_size,default=100,min=1" description:"Results requested per page"`
+	Timeout  time.Duration `config:"timeout,default=30s" description:"Request timeout"`
+
+	// Mapping overrides AttributeMap
+	Mapping *AttributeMapping `config:"-"`
+
+	// Client is used for the requests, defaults to a client with the Timeout
+	Client *http.Client `config:"-"`
+}
+
+// ProviderFactory creates a Provider as a cloudy.UserManager or
+// cloudy.GroupManager
+type ProviderFactory[T any] struct{}
+
+func (f *ProviderFactory[T]) NewConfig() interface{} {
+	return &ProviderConfig{PageSize: 100, Timeout: 30 * time.Second}
+}
+
+func (f *ProviderFactory[T]) New(ctx context.Context, cfg interface{}) (T, error) {
+	var zero T
+	config, ok := cfg.(*ProviderConfig)
+	if !ok || config == nil {
+		return zero, cloudy.ErrInvalidConfiguration
+	}
+	p, err := NewProvider(config)
+	if err != nil {
+		return zero, err
+	}
+	return any(p).(T), nil
+}
+
+// Provider implements cloudy.UserManager and cloudy.GroupManager against a
+// SCIM 2.0 service, e.g. to manage the users of a SaaS application. Users and
+// groups are identified by their SCIM id; users can also be given by user name
+// or email. Filters are sent to the service when their attributes are mapped
+// and evaluated locally otherwise, or when the service rejects them.
+type Provider struct {
+	cfg     ProviderConfig
+	base    string
+	mapping *AttributeMapping
+	client  *http.Client
+}
+
+// NewProvider creates a SCIM client provider
+func NewProvider(cfg *ProviderConfig) (*Provider, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%w: missing SCIM url", cloudy.ErrInvalidConfiguration)
+	}
+	p := &Provider{cfg: *cfg, base: strings.TrimSuffix(cfg.URL, "/"), mapping: cfg.Mapping}
+	if p.mapping == nil && cfg.AttributeMap != "" {
+		mapping, err := ParseAttributeMapping(cfg.AttributeMap)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", cloudy.ErrInvalidConfiguration, err)
+		}
+		p.mapping = mapping
+	}
+	if p.mapping == nil {
+		p.mapping = DefaultAttributeMapping()
+	}
+	if p.cfg.PageSize <= 0 {
+		p.cfg.PageSize = 100
+	}
+	p.client = cfg.Client
+	if p.client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		p.client = &http.Client{Timeout: timeout}
+	}
+	return p, nil
+}
+
+// ForceUserName trims the name and checks whether a user has it
+func (p *Provider) ForceUserName(ctx context.Context, name string) (string, bool, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", false, fmt.Errorf("%w: empty user name", cloudy.ErrInvalidConfiguration)
+	}
+	found, err := p.search(ctx, "/Users", &cloudy.Filter{Op: cloudy.FilterEq, Attr: "userName", Value: name}, 1, nil)
+	if err != nil {
+		return "", false, err
+	}
+	return name, len(found) > 0, nil
+}
+
+// UsesStandardFilters implements cloudy.StandardFilterLister
+func (p *Provider) UsesStandardFilters() {}
+
+func (p *Provider) ListUsers(ctx context.Context, filter string, attrs []string) (*[]models.User, error) {
+	f, err := cloudy.ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []Resource
+	remote, ok := p.translateUserFilter(f)
+	if ok {
+		resources, err = p.search(ctx, "/Users", remote, 0, nil)
+	}
+	if !ok || isInvalidFilter(err) {
+		// Fetch everything and filter here
+		resources, err = p.search(ctx, "/Users", nil, 0, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := []models.User{}
+	for _, res := range resources {
+		u := p.mapping.ToUser(res)
+		if f.MatchUser(u) {
+			rtn = append(rtn, *cloudy.FilterUserAttributes(u, attrs))
+		}
+	}
+	return &rtn, nil
+}
+
+// GetUser returns the user with the id, user name or email, nil when there is
+// none
+func (p *Provider) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	res, err := p.findUser(ctx, uid)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return p.mapping.ToUser(res), nil
+}
+
+func (p *Provider) GetUserByEmail(ctx context.Context, email string, opts *cloudy.UserOptions) (*models.User, error) {
+	found, err := p.search(ctx, "/Users", &cloudy.Filter{Op: cloudy.FilterEq, Attr: "emails.value", Value: email}, 1, nil)
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return p.mapping.ToUser(found[0]), nil
+}
+
+func (p *Provider) GetUserWithAttributes(ctx context.Context, uid string, attrs []string) (*models.User, error) {
+	u, err := p.GetUser(ctx, uid)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return cloudy.FilterUserAttributes(u, attrs), nil
+}
+
+// NewUser creates an enabled user, the service assigns the id
+func (p *Provider) NewUser(ctx context.Context, newUser *models.User) (*models.User, error) {
+	u := *newUser
+	u.Enabled = true
+	res := p.mapping.ToResource(&u)
+	delete(res, "id")
+
+	created := Resource{}
+	if err := p.do(ctx, http.MethodPost, "/Users", res, &created); err != nil {
+		return nil, userError(err)
+	}
+	return p.mapping.ToUser(created), nil
+}
+
+// UpdateUser patches the attributes that changed, the enabled state is kept
+func (p *Provider) UpdateUser(ctx context.Context, usr *models.User) error {
+	current, err := p.mustFindUser(ctx, usr.UID)
+	if err != nil {
+		return err
+	}
+
+	wanted := *usr
+	wanted.Enabled = p.mapping.ToUser(current).Enabled
+	ops := p.userChanges(current, p.mapping.ToResource(&wanted))
+	if len(ops) == 0 {
+		return nil
+	}
+	return p.patch(ctx, "/Users/"+url.PathEscape(current.String("id")), ops, version(current))
+}
+
+// userChanges lists the operations turning the current user into the wanted
+// one. Attributes of the service that are not mapped are left alone.
+func (p *Provider) userChanges(current Resource, wanted Resource) []*PatchOperation {
+	var ops []*PatchOperation
+	currentUser, wantedUser := p.mapping.ToUser(current), p.mapping.ToUser(wanted)
+	if currentUser.Email != wantedUser.Email {
+		if wantedUser.Email == "" {
+			ops = append(ops, &PatchOperation{Op: PatchRemove, Path: "emails"})
+		} else {
+			ops = append(ops, &PatchOperation{Op: PatchReplace, Path: "emails", Value: wanted.lookup("emails")})
+		}
+	}
+
+	paths := []string{"userName", "displayName", "name.givenName", "name.familyName"}
+	for path := range p.mapping.attributes() {
+		paths = append(paths, path)
+	}
+	// Keep the operations in a stable order
+	sort.Strings(paths[4:])
+	for _, path := range paths {
+		have, want := current.GetString(path), wanted.GetString(path)
+		switch {
+		case have == want:
+		case want == "":
+			ops = append(ops, &PatchOperation{Op: PatchRemove, Path: path})
+		default:
+			ops = append(ops, &PatchOperation{Op: PatchReplace, Path: path, Value: want})
+		}
+	}
+	return ops
+}
+
+func (p *Provider) Enable(ctx context.Context, uid string) error {
+	return p.patchUser(ctx, uid, &PatchOperation{Op: PatchReplace, Path: "active", Value: true})
+}
+
+func (p *Provider) Disable(ctx context.Context, uid string) error {
+	return p.patchUser(ctx, uid, &PatchOperation{Op: PatchReplace, Path: "active", Value: false})
+}
+
+func (p *Provider) DeleteUser(ctx context.Context, uid string) error {
+	res, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	return userError(p.do(ctx, http.MethodDelete, "/Users/"+url.PathEscape(res.String("id")), nil, nil))
+}
+
+// SetUserPassword replaces the password. SCIM has no way to force a change at
+// the next sign in, mustChange is ignored.
+func (p *Provider) SetUserPassword(ctx context.Context, uid string, pwd string, mustChange bool) error {
+	err := p.patchUser(ctx, uid, &PatchOperation{Op: PatchReplace, Path: "password", Value: pwd})
+	if isStatus(err, http.StatusBadRequest) {
+		return fmt.Errorf("%w: %v", cloudy.ErrInvalidPassword, err)
+	}
+	return err
+}
+
+func (p *Provider) ListGroups(ctx context.Context, filter string, attrs []string) (*[]models.Group, error) {
+	f, err := cloudy.ParseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []Resource
+	remote, ok := translateGroupFilter(f)
+	if ok {
+		resources, err = p.search(ctx, "/Groups", remote, 0, []string{"members"})
+	}
+	if !ok || isInvalidFilter(err) {
+		resources, err = p.search(ctx, "/Groups", nil, 0, []string{"members"})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := []models.Group{}
+	for _, res := range resources {
+		g := ToGroup(res)
+		if f.MatchGroup(g) {
+			rtn = append(rtn, *g)
+		}
+	}
+	return &rtn, nil
+}
+
+func (p *Provider) GetGroup(ctx context.Context, id string) (*models.Group, error) {
+	res, err := p.getGroup(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	return ToGroup(res), nil
+}
+
+// GetGroupId returns the id of the group with the name, empty when there is
+// none
+func (p *Provider) GetGroupId(ctx context.Context, name string) (string, error) {
+	found, err := p.search(ctx, "/Groups", &cloudy.Filter{Op: cloudy.FilterEq, Attr: "displayName", Value: name}, 1, []string{"members"})
+	if err != nil || len(found) == 0 {
+		return "", err
+	}
+	return found[0].String("id"), nil
+}
+
+func (p *Provider) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	user, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	id := user.String("id")
+
+	found, err := p.search(ctx, "/Groups", &cloudy.Filter{Op: cloudy.FilterEq, Attr: "members.value", Value: id}, 0, []string{"members"})
+	if isInvalidFilter(err) {
+		// Not every service can filter on members, check every group
+		var all []Resource
+		all, err = p.search(ctx, "/Groups", nil, 0, nil)
+		found = nil
+		for _, res := range all {
+			if cloudy.ArrayIncludes(MemberIDs(res), id) {
+				found = append(found, res)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := make([]*models.Group, 0, len(found))
+	for _, res := range found {
+		rtn = append(rtn, ToGroup(res))
+	}
+	return rtn, nil
+}
+
+func (p *Provider) NewGroup(ctx context.Context, grp *models.Group) (*models.Group, error) {
+	res := ToGroupResource(grp, nil)
+	delete(res, "id")
+	delete(res, "members")
+
+	created := Resource{}
+	if err := p.do(ctx, http.MethodPost, "/Groups", res, &created); err != nil {
+		return nil, groupError(err)
+	}
+	return ToGroup(created), nil
+}
+
+// UpdateGroup renames the group
+func (p *Provider) UpdateGroup(ctx context.Context, grp *models.Group) (bool, error) {
+	err := p.patch(ctx, "/Groups/"+url.PathEscape(grp.ID), []*PatchOperation{
+		{Op: PatchReplace, Path: "displayName", Value: grp.Name},
+	}, "")
+	if err != nil {
+		return false, groupError(err)
+	}
+	return true, nil
+}
+
+// GetGroupMembers returns partial users with the id and display name the
+// group lists
+func (p *Provider) GetGroupMembers(ctx context.Context, grpId string) ([]*models.User, error) {
+	res, err := p.getGroup(ctx, grpId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []*models.User{}
+	for _, m := range res.Get("members") {
+		member, ok := m.(map[string]any)
+		if !ok {
+			continue
+		}
+		if t := stringValue(lookupFold(member, "type")); t != "" && !strings.EqualFold(t, "User") {
+			continue
+		}
+		members = append(members, &models.User{
+			UID:         stringValue(lookupFold(member, "value")),
+			DisplayName: stringValue(lookupFold(member, "display")),
+		})
+	}
+	return members, nil
+}
+
+// RemoveMembers removes the users, one operation per user as most services
+// expect
+func (p *Provider) RemoveMembers(ctx context.Context, groupId string, userIds []string) error {
+	ids, err := p.userIDs(ctx, userIds)
+	if err != nil {
+		return err
+	}
+	var ops []*PatchOperation
+	for _, id := range ids {
+		ops = append(ops, &PatchOperation{Op: PatchRemove, Path: fmt.Sprintf("members[value eq %s]", strconv.Quote(id))})
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return groupError(p.patch(ctx, "/Groups/"+url.PathEscape(groupId), ops, ""))
+}
+
+func (p *Provider) AddMembers(ctx context.Context, groupId string, userIds []string) error {
+	ids, err := p.userIDs(ctx, userIds)
+	if err != nil {
+		return err
+	}
+	var members []any
+	for _, id := range ids {
+		members = append(members, map[string]any{"value": id})
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return groupError(p.patch(ctx, "/Groups/"+url.PathEscape(groupId), []*PatchOperation{
+		{Op: PatchAdd, Path: "members", Value: members},
+	}, ""))
+}
+
+func (p *Provider) DeleteGroup(ctx context.Context, groupId string) error {
+	return groupError(p.do(ctx, http.MethodDelete, "/Groups/"+url.PathEscape(groupId), nil, nil))
+}
+
+// findUser gets a user by id, falling back to a search by user name or email.
+// It returns nil when there is none.
+func (p *Provider) findUser(ctx context.Context, uid string) (Resource, error) {
+	if uid == "" {
+		return nil, nil
+	}
+	res := Resource{}
+	err := p.do(ctx, http.MethodGet, "/Users/"+url.PathEscape(uid), nil, &res)
+	if err == nil {
+		return res, nil
+	}
+	if !isStatus(err, http.StatusNotFound) && !isStatus(err, http.StatusBadRequest) {
+		return nil, err
+	}
+
+	found, err := p.search(ctx, "/Users", &cloudy.Filter{Op: cloudy.FilterOr, Children: []*cloudy.Filter{
+		{Op: cloudy.FilterEq, Attr: "userName", Value: uid},
+		{Op: cloudy.FilterEq, Attr: "emails.value", Value: uid},
+	}}, 1, nil)
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return found[0], nil
+}
+
+func (p *Provider) mustFindUser(ctx context.Context, uid string) (Resource, error) {
+	res, err := p.findUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	return res, nil
+}
+
+// userIDs resolves user names and emails to SCIM ids
+func (p *Provider) userIDs(ctx context.Context, userIds []string) ([]string, error) {
+	ids := make([]string, 0, len(userIds))
+	for _, uid := range userIds {
+		res, err := p.mustFindUser(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, res.String("id"))
+	}
+	return ids, nil
+}
+
+func (p *Provider) patchUser(ctx context.Context, uid string, ops ...*PatchOperation) error {
+	res, err := p.mustFindUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	return userError(p.patch(ctx, "/Users/"+url.PathEscape(res.String("id")), ops, ""))
+}
+
+func (p *Provider) getGroup(ctx context.Context, id string, withMembers bool) (Resource, error) {
+	path := "/Groups/" + url.PathEscape(id)
+	if !withMembers {
+		path += "?excludedAttributes=members"
+	}
+	res := Resource{}
+	if err := p.do(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return nil, groupError(err)
+	}
+	return res, nil
+}
+
+// search pages through a query. A limit of zero returns every result.
+func (p *Provider) search(ctx context.Context, endpoint string, filter *cloudy.Filter, limit int, excluded []string) ([]Resource, error) {
+	var rtn []Resource
+	for start := 1; ; {
+		count := p.cfg.PageSize
+		if limit > 0 {
+			count = min(count, limit-len(rtn))
+		}
+		query := url.Values{"startIndex": {strconv.Itoa(start)}, "count": {strconv.Itoa(count)}}
+		if filter != nil {
+			query.Set("filter", filter.String())
+		}
+		if len(excluded) > 0 {
+			query.Set("excludedAttributes", strings.Join(excluded, ","))
+		}
+
+		page := &ListResponse{}
+		if err := p.do(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil, page); err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, page.Resources...)
+		start += len(page.Resources)
+
+		if len(page.Resources) == 0 || len(rtn) >= page.TotalResults || (limit > 0 && len(rtn) >= limit) {
+			return rtn, nil
+		}
+	}
+}
+
+func (p *Provider) patch(ctx context.Context, path string, ops []*PatchOperation, ifMatch string) error {
+	body := &PatchRequest{Schemas: []string{PatchOpSchema}, Operations: ops}
+	return p.doWithHeaders(ctx, http.MethodPatch, path, body, nil, map[string]string{"If-Match": ifMatch})
+}
+
+func (p *Provider) do(ctx context.Context, method string, path string, body any, out any) error {
+	return p.doWithHeaders(ctx, method, path, body, out, nil)
+}
+
+// doWithHeaders sends a request, errors returned by the service are *Error
+func (p *Provider) doWithHeaders(ctx context.Context, method string, path string, body any, out any, headers map[string]string) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", ContentType+", application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", ContentType)
+	}
+	for k, v := range headers {
+		if v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	} else if p.cfg.Username != "" {
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		scimErr := &Error{}
+		if json.Unmarshal(data, scimErr) != nil || scimErr.Detail == "" {
+			scimErr.Detail = strings.TrimSpace(string(data))
+		}
+		scimErr.Schemas = []string{ErrorSchema}
+		scimErr.Status = strconv.Itoa(resp.StatusCode)
+		return scimErr
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// translateUserFilter renames the user attributes of a filter to SCIM paths,
+// ok is false when an attribute has no path the service can filter on
+func (p *Provider) translateUserFilter(f *cloudy.Filter) (*cloudy.Filter, bool) {
+	ok := true
+	translated := f.RenameAttributes(func(attr string) string {
+		path := p.mapping.Path(attr)
+		if parsed, err := ParsePath(path); path == "" || err != nil || parsed.Filter != nil {
+			ok = false
+		}
+		return path
+	})
+	return translated, ok
+}
+
+// translateGroupFilter maps the group filter attributes, only id and name
+// exist in SCIM
+func translateGroupFilter(f *cloudy.Filter) (*cloudy.Filter, bool) {
+	ok := true
+	translated := f.RenameAttributes(func(attr string) string {
+		switch strings.ToLower(attr) {
+		case "id":
+			return "id"
+		case "name":
+			return "displayName"
+		}
+		ok = false
+		return attr
+	})
+	return translated, ok
+}
+
+// version returns the ETag of a resource, empty when the service does not
+// return versions
+func version(res Resource) string {
+	if meta, ok := res.lookup("meta").(map[string]any); ok {
+		return stringValue(lookupFold(meta, "version"))
+	}
+	return ""
+}
+
+func isStatus(err error, status int) bool {
+	var scimErr *Error
+	return errors.As(err, &scimErr) && scimErr.StatusCode() == status
+}
+
+func isInvalidFilter(err error) bool {
+	var scimErr *Error
+	return errors.As(err, &scimErr) && (scimErr.ScimType == ScimTypeInvalidFilter ||
+		(scimErr.StatusCode() == http.StatusBadRequest && scimErr.ScimType == "") ||
+		scimErr.StatusCode() == http.StatusNotImplemented)
+}
+
+// userError wraps service errors with the matching cloudy errors
+func userError(err error) error {
+	switch {
+	case isStatus(err, http.StatusNotFound):
+		return fmt.Errorf("%w: %v", cloudy.ErrUserNotFound, err)
+	case isStatus(err, http.StatusConflict):
+		return fmt.Errorf("%w: %v", cloudy.ErrUserExists, err)
+	}
+	return err
+}
+
+func groupError(err error) error {
+	switch {
+	case isStatus(err, http.StatusNotFound):
+		return fmt.Errorf("%w: %v", cloudy.ErrGroupNotFound, err)
+	case isStatus(err, http.StatusConflict):
+		return fmt.Errorf("%w: %v", cloudy.ErrGroupExists, err)
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package scim
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/identity"
+	"github.com/appliedres/cloudy/models"
+	"github.com/appliedres/cloudy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSCIMBackedProvider runs a local SCIM service over an in-memory identity
+// provider and returns a client provider for it
+func newSCIMBackedProvider(t *testing.T, configure ...func(cfg *ProviderConfig)) (*Provider, *identity.MemoryIdentityProvider, *requestLog) {
+	backend, err := identity.NewMemoryIdentityProvider(context.Background(), &identity.MemoryIdentityConfig{
+		Domains:          []string{"example.com"},
+		PasswordSettings: identity.DefaultPasswordSettings(),
+	})
+	require.Nil(t, err)
+
+	log := &requestLog{}
+	handler := NewHandler(backend, backend, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			writeError(w, NewError(http.StatusUnauthorized, "", "missing token"))
+			return
+		}
+		log.add(r.Method + " " + r.URL.RequestURI())
+		http.StripPrefix("/scim/v2", handler).ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := &ProviderConfig{URL: server.URL + "/scim/v2/", Token: "secret"}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	p, err := NewProvider(cfg)
+	require.Nil(t, err)
+	return p, backend, log
+}
+
+type requestLog struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (l *requestLog) add(req string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = append(l.requests, req)
+}
+
+func (l *requestLog) reset() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rtn := l.requests
+	l.requests = nil
+	return rtn
+}
+
+func TestProviderSuites(t *testing.T) {
+	t.Setenv("USER_DOMAIN", "example.com")
+	p, _, _ := newSCIMBackedProvider(t)
+
+	testutil.TestUserManager(t, p)
+	testutil.TestGroupManager(t, p, p)
+}
+
+func TestProviderDriver(t *testing.T) {
+	envSvc := cloudy.NewMapEnvironment()
+	envSvc.Set("IDP_DRIVER", ProviderID)
+	env := cloudy.NewEnvironment(cloudy.NewHierarchicalEnvironment(envSvc)).Segment("idp")
+
+	_, err := cloudy.UserProviders.NewFromEnv(env, "DRIVER")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "IDP_URL", "the url is required")
+
+	envSvc.Set("IDP_URL", "https://example.com/scim/v2")
+	envSvc.Set("IDP_TOKEN", "secret")
+	envSvc.Set("IDP_ATTRIBUTE_MAP", "title=jobTitle")
+	users, err := cloudy.UserProviders.NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+	p := users.(*Provider)
+	assert.Equal(t, "https://example.com/scim/v2", p.base)
+	assert.Equal(t, map[string]string{"title": "jobTitle"}, p.mapping.Attributes)
+	assert.Equal(t, 100, p.cfg.PageSize)
+
+	groups, err := cloudy.GroupProviders.NewFromEnv(env, "DRIVER")
+	require.Nil(t, err)
+	assert.IsType(t, &Provider{}, groups)
+}
+
+func TestProviderUsers(t *testing.T) {
+	ctx := context.Background()
+	p, backend, log := newSCIMBackedProvider(t, func(cfg *ProviderConfig) { cfg.PageSize = 2 })
+
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		_, err := p.NewUser(ctx, &models.User{
+			Username:   name,
+			Email:      name + "@example.com",
+			FirstName:  name,
+			Attributes: map[string]string{"department": "eng"},
+		})
+		require.Nil(t, err)
+	}
+	_, err := p.NewUser(ctx, &models.User{Username: "alice"})
+	assert.ErrorIs(t, err, cloudy.ErrUserExists)
+	require.Nil(t, p.Disable(ctx, "bob@example.com"))
+
+	// Paged through two at a time
+	log.reset()
+	users, err := p.ListUsers(ctx, "", nil)
+	require.Nil(t, err)
+	assert.Len(t, *users, 5)
+	assert.Len(t, log.reset(), 3)
+
+	// Mapped attributes are filtered by the service
+	users, err = p.ListUsers(ctx, `enabled eq false or attributes.department ne "eng"`, nil)
+	require.Nil(t, err)
+	require.Len(t, *users, 1)
+	assert.Equal(t, "bob", (*users)[0].Username)
+	assert.Contains(t, log.reset()[0], "filter=active+eq+false")
+
+	// Unmapped ones here
+	backendUser, _ := backend.GetUser(ctx, "carol")
+	backendUser.Attributes["unmapped"] = "x"
+	require.Nil(t, backend.UpdateUser(ctx, backendUser))
+	users, err = p.ListUsers(ctx, `attributes.unmapped pr or username eq "dave"`, nil)
+	require.Nil(t, err)
+	require.Len(t, *users, 1, "the service does not return unmapped attributes")
+	assert.Equal(t, "dave", (*users)[0].Username)
+	assert.NotContains(t, log.reset()[0], "filter=")
+
+	// Updates only send what changed and keep attributes the mapping does not cover
+	u, err := p.GetUser(ctx, "carol")
+	require.Nil(t, err)
+	u.LastName = "Jones"
+	delete(u.Attributes, "department")
+	require.Nil(t, p.UpdateUser(ctx, u))
+	backendUser, _ = backend.GetUser(ctx, "carol")
+	assert.Equal(t, "Jones", backendUser.LastName)
+	assert.Equal(t, map[string]string{"unmapped": "x"}, backendUser.Attributes)
+	assert.True(t, backendUser.Enabled)
+
+	byEmail, err := p.GetUserByEmail(ctx, "dave@example.com", nil)
+	require.Nil(t, err)
+	assert.Equal(t, "dave", byEmail.Username)
+	missing, err := p.GetUser(ctx, "nobody")
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+
+	name, exists, err := p.ForceUserName(ctx, " erin ")
+	require.Nil(t, err)
+	assert.Equal(t, "erin", name)
+	assert.True(t, exists)
+
+	require.Nil(t, p.SetUserPassword(ctx, "erin", "Secret!123", false))
+	_, err = backend.Authenticate(ctx, "erin", "Secret!123")
+	assert.Nil(t, err)
+	assert.ErrorIs(t, p.SetUserPassword(ctx, "erin", "weak", false), cloudy.ErrInvalidPassword)
+
+	assert.ErrorIs(t, p.DeleteUser(ctx, "nobody"), cloudy.ErrUserNotFound)
+}
+
+func TestProviderGroups(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newSCIMBackedProvider(t)
+
+	for _, name := range []string{"alice", "bob"} {
+		_, err := p.NewUser(ctx, &models.User{Username: name, DisplayName: "User " + name})
+		require.Nil(t, err)
+	}
+	admins, err := p.NewGroup(ctx, &models.Group{Name: "admins"})
+	require.Nil(t, err)
+	staff, err := p.NewGroup(ctx, &models.Group{Name: "staff"})
+	require.Nil(t, err)
+
+	require.Nil(t, p.AddMembers(ctx, admins.ID, []string{"alice"}))
+	require.Nil(t, p.AddMembers(ctx, staff.ID, []string{"alice", "bob"}))
+	assert.ErrorIs(t, p.AddMembers(ctx, staff.ID, []string{"nobody"}), cloudy.ErrUserNotFound)
+
+	groups, err := p.GetUserGroups(ctx, "alice")
+	require.Nil(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "admins", groups[0].Name)
+
+	members, err := p.GetGroupMembers(ctx, staff.ID)
+	require.Nil(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "User alice", members[0].DisplayName)
+
+	require.Nil(t, p.RemoveMembers(ctx, staff.ID, []string{"alice"}))
+	members, err = p.GetGroupMembers(ctx, staff.ID)
+	require.Nil(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "User bob", members[0].DisplayName)
+
+	id, err := p.GetGroupId(ctx, "staff")
+	require.Nil(t, err)
+	assert.Equal(t, staff.ID, id)
+
+	ok, err := p.UpdateGroup(ctx, &models.Group{ID: staff.ID, Name: "everyone"})
+	require.Nil(t, err)
+	assert.True(t, ok)
+
+	list, err := p.ListGroups(ctx, `name sw "every"`, nil)
+	require.Nil(t, err)
+	require.Len(t, *list, 1)
+	assert.Equal(t, staff.ID, (*list)[0].ID)
+
+	require.Nil(t, p.DeleteGroup(ctx, admins.ID))
+	_, err = p.GetGroup(ctx, admins.ID)
+	assert.ErrorIs(t, err, cloudy.ErrGroupNotFound)
+}
+
+func TestProviderErrors(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newSCIMBackedProvider(t, func(cfg *ProviderConfig) { cfg.Token = "wrong" })
+
+	_, err := p.ListUsers(ctx, "", nil)
+	var scimErr *Error
+	require.ErrorAs(t, err, &scimErr)
+	assert.Equal(t, http.StatusUnauthorized, scimErr.StatusCode())
+	assert.Equal(t, "missing token", scimErr.Detail)
+
+	_, err = p.ListUsers(ctx, "username eq", nil)
+	assert.ErrorIs(t, err, cloudy.ErrInvalidFilter)
+}
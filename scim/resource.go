@@ -0,0 +1,568 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+)
+
+// Resource is a SCIM resource as decoded from JSON. Attribute names are case
+// insensitive, extension attributes live under their schema URN.
+type Resource map[string]any
+
+// Path is a parsed attribute path (RFC 7644 3.5.2):
+//
+//	userName
+//	name.givenName
+//	emails[type eq "work"].value
+//	urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department
+type Path struct {
+	// URN is the schema of an extension attribute, empty for core attributes
+	URN    string
+	Attr   string
+	Filter *cloudy.Filter
+	Sub    string
+}
+
+// ParsePath parses an attribute path
+func ParsePath(path string) (*Path, error) {
+	s := strings.TrimSpace(path)
+	p := &Path{}
+
+	head := s
+	if i := strings.Index(s, "["); i >= 0 {
+		head = s[:i]
+	}
+	if _, ok := cutPrefixFold(head, "urn:"); ok {
+		i := strings.LastIndex(head, ":")
+		p.URN = s[:i]
+		s = s[i+1:]
+	}
+
+	if open := strings.Index(s, "["); open >= 0 {
+		closing := strings.LastIndex(s, "]")
+		if closing < open {
+			return nil, NewError(http.StatusBadRequest, ScimTypeInvalidPath, "missing ] in %q", path)
+		}
+		filter, err := cloudy.ParseFilter(s[open+1 : closing])
+		if err != nil || filter == nil {
+			return nil, NewError(http.StatusBadRequest, ScimTypeInvalidPath, "invalid filter in %q", path)
+		}
+		p.Attr, p.Filter = s[:open], filter
+		rest := s[closing+1:]
+		if rest != "" {
+			sub, ok := strings.CutPrefix(rest, ".")
+			if !ok {
+				return nil, NewError(http.StatusBadRequest, ScimTypeInvalidPath, "unexpected %q in %q", rest, path)
+			}
+			p.Sub = sub
+		}
+	} else {
+		p.Attr, p.Sub, _ = strings.Cut(s, ".")
+	}
+
+	if p.Attr == "" || strings.ContainsAny(p.Attr+p.Sub, " \"[]") {
+		return nil, NewError(http.StatusBadRequest, ScimTypeInvalidPath, "invalid path %q", path)
+	}
+	return p, nil
+}
+
+func (p *Path) String() string {
+	var sb strings.Builder
+	if p.URN != "" {
+		sb.WriteString(p.URN + ":")
+	}
+	sb.WriteString(p.Attr)
+	if p.Filter != nil {
+		sb.WriteString("[" + p.Filter.String() + "]")
+	}
+	if p.Sub != "" {
+		sb.WriteString("." + p.Sub)
+	}
+	return sb.String()
+}
+
+// Schemas returns the schema URNs of the resource
+func (r Resource) Schemas() []string {
+	var schemas []string
+	if list, ok := r.lookup("schemas").([]any); ok {
+		for _, s := range list {
+			if str, ok := s.(string); ok {
+				schemas = append(schemas, str)
+			}
+		}
+	} else if list, ok := r.lookup("schemas").([]string); ok {
+		schemas = list
+	}
+	return schemas
+}
+
+// AddSchema adds a schema URN to the resource if it is missing
+func (r Resource) AddSchema(urn string) {
+	schemas := r.Schemas()
+	for _, s := range schemas {
+		if strings.EqualFold(s, urn) {
+			return
+		}
+	}
+	list := make([]any, 0, len(schemas)+1)
+	for _, s := range schemas {
+		list = append(list, s)
+	}
+	r[keyFold(r, "schemas")] = append(list, urn)
+}
+
+// String returns a top level string attribute
+func (r Resource) String(attr string) string {
+	return stringValue(r.lookup(attr))
+}
+
+// Get returns the values at the path. Multi-valued attributes return all of
+// their values.
+func (r Resource) Get(path string) []any {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil
+	}
+	return r.values(p)
+}
+
+// GetString returns the first value at the path as a string
+func (r Resource) GetString(path string) string {
+	for _, v := range r.Get(path) {
+		if s := stringValue(v); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Set replaces the value at the path. Filtered paths that match no value
+// create one when the filter is made of "eq" comparisons, which is how most
+// identity providers add typed values such as phoneNumbers[type eq "work"].
+func (r Resource) Set(path string, value any) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	return r.apply(PatchReplace, p, value)
+}
+
+// Match evaluates a filter against the resource
+func (r Resource) Match(filter *cloudy.Filter) bool {
+	return filter.Match(r.filterValues)
+}
+
+// Clone returns a deep copy of the resource
+func (r Resource) Clone() Resource {
+	return cloneValue(map[string]any(r)).(map[string]any)
+}
+
+func (r Resource) filterValues(attr string) ([]string, bool) {
+	p, err := ParsePath(attr)
+	if err != nil {
+		return nil, false
+	}
+	var values []string
+	for _, v := range r.values(p) {
+		if m, ok := v.(map[string]any); ok {
+			// Complex values compare on their "value" sub-attribute
+			v = lookupFold(m, "value")
+		}
+		if v != nil {
+			values = append(values, stringValue(v))
+		}
+	}
+	return values, len(values) > 0
+}
+
+func (r Resource) lookup(attr string) any {
+	return lookupFold(r, attr)
+}
+
+// container returns the map holding the attributes of the path's schema
+func (r Resource) container(p *Path, create bool) map[string]any {
+	if p.URN == "" || r.isCore(p.URN) {
+		return r
+	}
+	if m, ok := r.lookup(p.URN).(map[string]any); ok {
+		return m
+	}
+	if !create {
+		return nil
+	}
+	m := map[string]any{}
+	r[p.URN] = m
+	r.AddSchema(p.URN)
+	return m
+}
+
+// isCore reports whether the urn is the core schema of the resource, the
+// first one listed
+func (r Resource) isCore(urn string) bool {
+	schemas := r.Schemas()
+	return len(schemas) > 0 && strings.EqualFold(schemas[0], urn)
+}
+
+// isSchema reports whether the urn is one of the schemas of the resource
+func (r Resource) isSchema(urn string) bool {
+	for _, s := range append(r.Schemas(), UserSchema, GroupSchema, EnterpriseUserSchema) {
+		if strings.EqualFold(s, urn) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Resource) values(p *Path) []any {
+	c := r.container(p, false)
+	if c == nil {
+		return nil
+	}
+	v := lookupFold(c, p.Attr)
+	if v == nil {
+		return nil
+	}
+
+	var elements []any
+	if list, ok := v.([]any); ok {
+		elements = list
+	} else {
+		elements = []any{v}
+	}
+	if p.Filter != nil {
+		elements = matching(elements, p.Filter)
+	}
+	if p.Sub == "" {
+		return elements
+	}
+
+	var values []any
+	for _, el := range elements {
+		if m, ok := el.(map[string]any); ok {
+			if sub := lookupFold(m, p.Sub); sub != nil {
+				values = append(values, sub)
+			}
+		}
+	}
+	return values
+}
+
+// apply performs a patch operation on the path
+func (r Resource) apply(op string, p *Path, value any) error {
+	c := r.container(p, op != PatchRemove)
+	if c == nil {
+		return nil
+	}
+	key := keyFold(c, p.Attr)
+	current := c[key]
+
+	if p.Filter == nil {
+		if p.Sub != "" {
+			return applySub(op, c, key, p.Sub, value)
+		}
+		switch op {
+		case PatchRemove:
+			list, ok := current.([]any)
+			if !ok || value == nil {
+				delete(c, key)
+				return nil
+			}
+			// Removing listed values, e.g. {"path": "members", "value": [{"value": "id"}]}
+			remaining := removeValues(list, toList(value))
+			if len(remaining) == 0 {
+				delete(c, key)
+			} else {
+				c[key] = remaining
+			}
+		case PatchAdd:
+			list, isList := current.([]any)
+			if _, valueList := value.([]any); isList || valueList {
+				c[key] = appendValues(list, toList(value))
+			} else if m, ok := current.(map[string]any); ok && isMap(value) {
+				mergeMap(m, value.(map[string]any))
+			} else {
+				c[key] = value
+			}
+		default:
+			c[key] = value
+		}
+		return nil
+	}
+
+	list, _ := current.([]any)
+	var matched []int
+	for i, el := range list {
+		if m, ok := el.(map[string]any); ok && p.Filter.Match(mapValues(m)) {
+			matched = append(matched, i)
+		}
+	}
+
+	if op == PatchRemove {
+		if p.Sub != "" {
+			for _, i := range matched {
+				delete(list[i].(map[string]any), keyFold(list[i].(map[string]any), p.Sub))
+			}
+			return nil
+		}
+		remaining := make([]any, 0, len(list))
+		for i, el := range list {
+			if !cloudy.ArrayIncludes(matched, i) {
+				remaining = append(remaining, el)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(c, key)
+		} else {
+			c[key] = remaining
+		}
+		return nil
+	}
+
+	if len(matched) == 0 {
+		el, ok := elementFromFilter(p.Filter)
+		if !ok {
+			return NewError(http.StatusBadRequest, ScimTypeNoTarget, "no value matches %s", p)
+		}
+		if p.Sub != "" {
+			el[p.Sub] = value
+		} else if m, ok := value.(map[string]any); ok {
+			mergeMap(el, m)
+		}
+		c[key] = append(list, el)
+		return nil
+	}
+
+	for _, i := range matched {
+		el := list[i].(map[string]any)
+		switch {
+		case p.Sub != "":
+			el[keyFold(el, p.Sub)] = value
+		case isMap(value):
+			mergeMap(el, value.(map[string]any))
+		default:
+			list[i] = value
+		}
+	}
+	return nil
+}
+
+func applySub(op string, c map[string]any, key string, sub string, value any) error {
+	switch current := c[key].(type) {
+	case []any:
+		// Applies to every value of a multi-valued attribute
+		for _, el := range current {
+			if m, ok := el.(map[string]any); ok {
+				if op == PatchRemove {
+					delete(m, keyFold(m, sub))
+				} else {
+					m[keyFold(m, sub)] = value
+				}
+			}
+		}
+	case map[string]any:
+		if op == PatchRemove {
+			delete(current, keyFold(current, sub))
+		} else {
+			current[keyFold(current, sub)] = value
+		}
+	default:
+		if op != PatchRemove {
+			c[key] = map[string]any{sub: value}
+		}
+	}
+	return nil
+}
+
+// elementFromFilter builds the value a filter made of "eq" comparisons
+// selects, e.g. {"type": "work"} for [type eq "work"]
+func elementFromFilter(f *cloudy.Filter) (map[string]any, bool) {
+	switch f.Op {
+	case cloudy.FilterEq:
+		return map[string]any{f.Attr: f.Value}, true
+	case cloudy.FilterAnd:
+		left, ok := elementFromFilter(f.Children[0])
+		if !ok {
+			return nil, false
+		}
+		right, ok := elementFromFilter(f.Children[1])
+		if !ok {
+			return nil, false
+		}
+		mergeMap(left, right)
+		return left, true
+	}
+	return nil, false
+}
+
+func matching(elements []any, f *cloudy.Filter) []any {
+	var matched []any
+	for _, el := range elements {
+		if m, ok := el.(map[string]any); ok && f.Match(mapValues(m)) {
+			matched = append(matched, el)
+		}
+	}
+	return matched
+}
+
+func mapValues(m map[string]any) cloudy.FilterValues {
+	return func(attr string) ([]string, bool) {
+		v := lookupFold(m, attr)
+		if v == nil {
+			return nil, false
+		}
+		return []string{stringValue(v)}, true
+	}
+}
+
+func appendValues(list []any, values []any) []any {
+	for _, v := range values {
+		if indexOfValue(list, v) < 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func removeValues(list []any, values []any) []any {
+	remaining := make([]any, 0, len(list))
+	for _, el := range list {
+		if indexOfValue(values, el) < 0 {
+			remaining = append(remaining, el)
+		}
+	}
+	return remaining
+}
+
+// indexOfValue finds a value in a list, complex values are the same when their
+// "value" sub-attributes are
+func indexOfValue(list []any, v any) int {
+	key := valueKey(v)
+	for i, el := range list {
+		if key != "" && valueKey(el) == key {
+			return i
+		}
+		if reflect.DeepEqual(el, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func valueKey(v any) string {
+	if m, ok := v.(map[string]any); ok {
+		return stringValue(lookupFold(m, "value"))
+	}
+	return ""
+}
+
+func toList(v any) []any {
+	if list, ok := v.([]any); ok {
+		return list
+	}
+	return []any{v}
+}
+
+func isMap(v any) bool {
+	_, ok := v.(map[string]any)
+	return ok
+}
+
+func mergeMap(dst map[string]any, src map[string]any) {
+	for k, v := range src {
+		dst[keyFold(dst, k)] = v
+	}
+}
+
+func lookupFold(m map[string]any, key string) any {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
+
+// keyFold returns the existing key matching the name, or the name
+func keyFold(m map[string]any, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
+
+func stringValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(val)
+	case json.Number:
+		return val.String()
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func boolValue(v any) (bool, bool) {
+	switch val := v.(type) {
+	case bool:
+		return val, true
+	case string:
+		// Some identity providers send "True" / "False"
+		b, err := strconv.ParseBool(strings.ToLower(val))
+		return b, err == nil
+	}
+	return false, false
+}
+
+func cloneValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(val))
+		for k, el := range val {
+			m[k] = cloneValue(el)
+		}
+		return m
+	case Resource:
+		return cloneValue(map[string]any(val))
+	case []any:
+		list := make([]any, len(val))
+		for i, el := range val {
+			list[i] = cloneValue(el)
+		}
+		return list
+	case []string:
+		list := make([]any, len(val))
+		for i, el := range val {
+			list[i] = el
+		}
+		return list
+	}
+	return v
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
@@ -0,0 +1,137 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeResource(t *testing.T, doc string) Resource {
+	res := Resource{}
+	require.Nil(t, json.Unmarshal([]byte(doc), &res))
+	return res
+}
+
+func TestParsePath(t *testing.T) {
+	p, err := ParsePath(`emails[type eq "work"].value`)
+	require.Nil(t, err)
+	assert.Equal(t, "emails", p.Attr)
+	assert.Equal(t, "value", p.Sub)
+	assert.Equal(t, `type eq "work"`, p.Filter.String())
+
+	p, err = ParsePath(EnterpriseUserSchema + ":manager.value")
+	require.Nil(t, err)
+	assert.Equal(t, EnterpriseUserSchema, p.URN)
+	assert.Equal(t, "manager", p.Attr)
+	assert.Equal(t, "value", p.Sub)
+	assert.Equal(t, EnterpriseUserSchema+":manager.value", p.String())
+
+	p, err = ParsePath("name.givenName")
+	require.Nil(t, err)
+	assert.Equal(t, "name", p.Attr)
+	assert.Equal(t, "givenName", p.Sub)
+
+	for _, bad := range []string{"", `members[value eq "a"`, `members[value eq "a"]x`, "a b"} {
+		_, err := ParsePath(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestResourceGet(t *testing.T) {
+	res := decodeResource(t, `{
+		"schemas": ["`+UserSchema+`"],
+		"userName": "jane",
+		"Emails": [{"value": "jane@work.com", "type": "work"}, {"value": "jane@home.com", "type": "home", "primary": true}],
+		"`+EnterpriseUserSchema+`": {"department": "eng"}
+	}`)
+
+	assert.Equal(t, "jane", res.GetString("USERNAME"))
+	assert.Equal(t, "jane@home.com", res.GetString("emails[primary eq true].value"))
+	assert.Equal(t, []any{"jane@work.com", "jane@home.com"}, res.Get("emails.value"))
+	assert.Equal(t, "eng", res.GetString(EnterpriseUserSchema+":department"))
+	assert.Equal(t, "jane", res.GetString(UserSchema+":userName"))
+
+	f, err := cloudy.ParseFilter(`emails co "home" and ` + EnterpriseUserSchema + `:department eq "ENG"`)
+	require.Nil(t, err)
+	assert.True(t, res.Match(f))
+}
+
+func TestResourcePatch(t *testing.T) {
+	res := decodeResource(t, `{
+		"schemas": ["`+UserSchema+`"],
+		"userName": "jane",
+		"active": true,
+		"emails": [{"value": "jane@work.com", "type": "work", "primary": true}],
+		"members": [{"value": "a"}, {"value": "b"}, {"value": "c"}]
+	}`)
+
+	var patch PatchRequest
+	require.Nil(t, json.Unmarshal([]byte(`{"Operations": [
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "jane@new.com"},
+		{"op": "add", "path": "phoneNumbers[type eq \"work\"].value", "value": "555-0100"},
+		{"op": "add", "value": {"name.givenName": "Jane", "`+EnterpriseUserSchema+`:department": "eng"}},
+		{"op": "add", "value": {"`+EnterpriseUserSchema+`": {"employeeNumber": "42"}}},
+		{"op": "remove", "path": "members[value eq \"b\"]"},
+		{"op": "remove", "path": "members", "value": [{"value": "c"}]},
+		{"op": "add", "path": "members", "value": [{"value": "a"}, {"value": "d"}]}
+	]}`), &patch))
+	require.Nil(t, res.Patch(patch.Operations))
+
+	assert.Equal(t, "False", res.String("active"))
+	assert.Equal(t, "jane@new.com", res.GetString("emails.value"))
+	assert.Equal(t, "555-0100", res.GetString(`phoneNumbers[type eq "work"].value`))
+	assert.Equal(t, "Jane", res.GetString("name.givenName"))
+	assert.Equal(t, "eng", res.GetString(EnterpriseUserSchema+":department"))
+	assert.Equal(t, "42", res.GetString(EnterpriseUserSchema+":employeeNumber"))
+	assert.Contains(t, res.Schemas(), EnterpriseUserSchema)
+	assert.Equal(t, []string{"a", "d"}, MemberIDs(res))
+
+	assert.NotNil(t, res.Patch([]*PatchOperation{{Op: "remove"}}))
+	assert.NotNil(t, res.Patch([]*PatchOperation{{Op: "move", Path: "active"}}))
+	err := res.Patch([]*PatchOperation{{Op: "replace", Path: `emails[type co "x"].value`, Value: "x"}})
+	var scimErr *Error
+	require.ErrorAs(t, err, &scimErr)
+	assert.Equal(t, ScimTypeNoTarget, scimErr.ScimType)
+}
+
+func TestAttributeMapping(t *testing.T) {
+	m := DefaultAttributeMapping()
+	u := &models.User{
+		UID:         "u1",
+		Username:    "jane",
+		Email:       "jane@example.com",
+		FirstName:   "Jane",
+		LastName:    "Doe",
+		DisplayName: "Jane Doe",
+		Enabled:     true,
+		Attributes:  map[string]string{"department": "eng", "phone": "555-0100", "unmapped": "x"},
+	}
+
+	res := m.ToResource(u)
+	assert.Equal(t, "eng", res.GetString(EnterpriseUserSchema+":department"))
+	assert.Equal(t, "555-0100", res.GetString(`phoneNumbers[type eq "work"].value`))
+	assert.Contains(t, res.Schemas(), EnterpriseUserSchema)
+	assert.Empty(t, res.Get("unmapped"))
+
+	back := m.ToUser(res)
+	assert.Equal(t, "Jane", back.FirstName)
+	assert.Equal(t, "jane@example.com", back.Email)
+	assert.Equal(t, map[string]string{"department": "eng", "phone": "555-0100"}, back.Attributes)
+
+	merged := m.MergeUser(u, res)
+	assert.Equal(t, "x", merged.Attributes["unmapped"], "attributes outside the mapping are kept")
+
+	custom, err := ParseAttributeMapping(`title=jobTitle, ` + EnterpriseUserSchema + `:costCenter=cc`)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"title": "jobTitle", EnterpriseUserSchema + ":costCenter": "cc"}, custom.Attributes)
+	assert.Equal(t, "title", custom.Path("attributes.jobTitle"))
+	assert.Equal(t, "name.givenName", custom.Path("firstName"))
+
+	_, err = ParseAttributeMapping("title")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Archive entries carry their object metadata in places that standard tools ignore
+// but preserve, so archives written by the tar and zip stores still extract normally.
+//
+//   - Tar entries use PAX extended header records: CLOUDY.md5 holds the MD5 of the
+//     content and each tag is stored as CLOUDY.tag.<name>. PAX keys cannot hold
+//     "=" or NUL, so those and "%" are percent-encoded in the name.
+//   - Zip entries use a private extra field (id 0x4c43, "CL") holding a JSON document
+//     with the MD5 and the tags.
+const (
+	TarPAXRecordPrefix = "CLOUDY."
+	TarPAXTagPrefix    = TarPAXRecordPrefix + "tag."
+	TarPAXMD5          = TarPAXRecordPrefix + "md5"
+
+	ZipMetadataExtraID uint16 = 0x4c43
+
+	zip64ExtraID uint16 = 0x0001
+)
+
+// archiveMetadata is the metadata kept for each archive entry
+type archiveMetadata struct {
+	MD5  string            `json:"md5,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// md5Hex returns the hex encoded MD5 of the content
+func md5Hex(content []byte) string {
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// tarPAXRecords converts the metadata into PAX records
+func tarPAXRecords(meta archiveMetadata) map[string]string {
+	if meta.MD5 == "" && len(meta.Tags) == 0 {
+		return nil
+	}
+
+	records := make(map[string]string, len(meta.Tags)+1)
+	if meta.MD5 != "" {
+		records[TarPAXMD5] = meta.MD5
+	}
+	for k, v := range meta.Tags {
+		records[TarPAXTagPrefix+escapePAXTagName(k)] = v
+	}
+	return records
+}
+
+// escapePAXTagName percent-encodes the characters a PAX key cannot hold
+func escapePAXTagName(name string) string {
+	if !strings.ContainsAny(name, "%=\x00") {
+		return name
+	}
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '%', '=', 0:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// unescapePAXTagName reverses escapePAXTagName, names it did not write are kept
+func unescapePAXTagName(name string) string {
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// tarMetadata reads the metadata from the PAX records of an entry
+func tarMetadata(records map[string]string) archiveMetadata {
+	meta := archiveMetadata{Tags: make(map[string]string)}
+	for k, v := range records {
+		switch {
+		case k == TarPAXMD5:
+			meta.MD5 = v
+		case strings.HasPrefix(k, TarPAXTagPrefix):
+			meta.Tags[unescapePAXTagName(strings.TrimPrefix(k, TarPAXTagPrefix))] = v
+		}
+	}
+	return meta
+}
+
+// zipExtraBlocks walks the extra field blocks, calling fn with the id and data of each
+func zipExtraBlocks(extra []byte, fn func(id uint16, data []byte)) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			return
+		}
+		fn(id, extra[4:4+size])
+		extra = extra[4+size:]
+	}
+}
+
+// zipMetadata reads the metadata from the extra field of an entry
+func zipMetadata(extra []byte) (archiveMetadata, bool) {
+	var meta archiveMetadata
+	found := false
+	zipExtraBlocks(extra, func(id uint16, data []byte) {
+		if id == ZipMetadataExtraID && json.Unmarshal(data, &meta) == nil {
+			found = true
+		}
+	})
+	if meta.Tags == nil {
+		meta.Tags = make(map[string]string)
+	}
+	return meta, found
+}
+
+// zipExtraWithMetadata returns the extra field with the metadata block replaced.
+// Any zip64 block is dropped since the zip writer adds it again when needed.
+func zipExtraWithMetadata(extra []byte, meta archiveMetadata) ([]byte, error) {
+	var rtn []byte
+	zipExtraBlocks(extra, func(id uint16, data []byte) {
+		if id == ZipMetadataExtraID || id == zip64ExtraID {
+			return
+		}
+		rtn = binary.LittleEndian.AppendUint16(rtn, id)
+		rtn = binary.LittleEndian.AppendUint16(rtn, uint16(len(data)))
+		rtn = append(rtn, data...)
+	})
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(rtn)+4+len(data) > 0xffff {
+		return nil, ErrMetadataTooLarge
+	}
+
+	rtn = binary.LittleEndian.AppendUint16(rtn, ZipMetadataExtraID)
+	rtn = binary.LittleEndian.AppendUint16(rtn, uint16(len(data)))
+	return append(rtn, data...), nil
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func md5String(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func findObject(t *testing.T, store ObjectStorage, prefix, key string) *StoredObject {
+	objects, _, err := store.List(context.Background(), prefix)
+	require.Nil(t, err)
+	for _, obj := range objects {
+		if obj.Key == key {
+			return obj
+		}
+	}
+	t.Fatalf("object %s not listed", key)
+	return nil
+}
+
+func TestArchiveMetadata_Tar(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "meta.tar")
+	store := NewTarObjectStorage(path)
+
+	require.Nil(t, store.Upload(ctx, "docs/a.txt", strings.NewReader("alpha"), map[string]string{"owner": "ops"}))
+	require.Nil(t, store.Upload(ctx, "b.txt", strings.NewReader("beta"), nil))
+
+	obj := findObject(t, store, "docs/", "docs/a.txt")
+	assert.Equal(t, map[string]string{"owner": "ops"}, obj.Tags)
+	assert.Equal(t, md5String("alpha"), obj.MD5)
+
+	require.Nil(t, store.UpdateMetadata(ctx, "docs/a.txt", map[string]string{"owner": "dev", "tier": "gold"}))
+
+	// A fresh store reads the metadata back from the archive
+	obj = findObject(t, NewTarObjectStorage(path), "docs/", "docs/a.txt")
+	assert.Equal(t, map[string]string{"owner": "dev", "tier": "gold"}, obj.Tags)
+	assert.Equal(t, md5String("alpha"), obj.MD5)
+
+	// The archive is still readable by standard tools
+	f, err := os.Open(path)
+	require.Nil(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		if header.Name == "docs/a.txt" {
+			assert.Equal(t, "gold", header.PAXRecords[TarPAXTagPrefix+"tier"])
+			assert.Equal(t, md5String("alpha"), header.PAXRecords[TarPAXMD5])
+			data, err := io.ReadAll(tr)
+			require.Nil(t, err)
+			assert.Equal(t, "alpha", string(data))
+		}
+	}
+}
+
+func TestArchiveMetadata_TarTagNames(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "names.tar")
+	store := NewTarObjectStorage(path)
+
+	tags := map[string]string{"a=b": "1", "nul\x00": "2", "100%": "3", "%3D": "4", "plain": "5"}
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("alpha"), tags))
+	require.Nil(t, store.Upload(ctx, "b.txt", strings.NewReader("beta"), nil))
+	require.Nil(t, store.UpdateMetadata(ctx, "b.txt", map[string]string{"x=y": "z"}))
+
+	obj := findObject(t, NewTarObjectStorage(path), "", "a.txt")
+	assert.Equal(t, tags, obj.Tags)
+	obj = findObject(t, NewTarObjectStorage(path), "", "b.txt")
+	assert.Equal(t, map[string]string{"x=y": "z"}, obj.Tags)
+}
+
+func TestArchiveMetadata_Zip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "meta.zip")
+	store := NewZipObjectStorage(path)
+
+	require.Nil(t, store.Upload(ctx, "docs/a.txt", strings.NewReader("alpha"), map[string]string{"owner": "ops"}))
+	require.Nil(t, store.Upload(ctx, "b.txt", strings.NewReader("beta"), nil))
+
+	obj := findObject(t, store, "docs/", "docs/a.txt")
+	assert.Equal(t, map[string]string{"owner": "ops"}, obj.Tags)
+	assert.Equal(t, md5String("alpha"), obj.MD5)
+
+	require.Nil(t, store.UpdateMetadata(ctx, "docs/a.txt", map[string]string{"tier": "gold"}))
+	assert.ErrorIs(t, store.UpdateMetadata(ctx, "missing.txt", nil), ErrFileNotFound)
+
+	// Other entries keep their metadata when the archive is rewritten
+	require.Nil(t, store.Delete(ctx, "b.txt"))
+	obj = findObject(t, NewZipObjectStorage(path), "docs/", "docs/a.txt")
+	assert.Equal(t, map[string]string{"tier": "gold"}, obj.Tags)
+	assert.Equal(t, md5String("alpha"), obj.MD5)
+
+	reader, err := store.Download(ctx, "docs/a.txt")
+	require.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "alpha", string(data))
+}
+
+func TestArchiveMetadata_ZipWithoutMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.zip")
+
+	// Archives created by other tools have no metadata block
+	f, err := os.Create(path)
+	require.Nil(t, err)
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("plain.txt")
+	require.Nil(t, err)
+	_, err = w.Write([]byte("plain"))
+	require.Nil(t, err)
+	require.Nil(t, zw.Close())
+	require.Nil(t, f.Close())
+
+	obj := findObject(t, NewZipObjectStorage(path), "", "plain.txt")
+	assert.Empty(t, obj.Tags)
+	assert.Equal(t, md5String("plain"), obj.MD5)
+}
+
+func TestZipExtraWithMetadata(t *testing.T) {
+	other := []byte{0x55, 0x54, 0x01, 0x00, 0x07}
+
+	extra, err := zipExtraWithMetadata(other, archiveMetadata{MD5: "abc"})
+	require.Nil(t, err)
+	assert.Equal(t, other, extra[:len(other)])
+
+	// Replacing the metadata keeps a single block
+	extra, err = zipExtraWithMetadata(extra, archiveMetadata{MD5: "def", Tags: map[string]string{"k": "v"}})
+	require.Nil(t, err)
+	meta, found := zipMetadata(extra)
+	assert.True(t, found)
+	assert.Equal(t, "def", meta.MD5)
+	assert.Equal(t, map[string]string{"k": "v"}, meta.Tags)
+	assert.Equal(t, other, extra[:len(other)])
+
+	_, err = zipExtraWithMetadata(nil, archiveMetadata{Tags: map[string]string{"k": strings.Repeat("x", 0x10000)}})
+	assert.ErrorIs(t, err, ErrMetadataTooLarge)
+}
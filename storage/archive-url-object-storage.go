@@ -1,14 +1,26 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 // ArchiveFormat represents the format of an archive file
@@ -21,192 +33,628 @@ const (
 	ZipFormat
 	// TarFormat indicates a TAR archive (possibly gzipped)
 	TarFormat
+	// TarZstdFormat indicates a zstd compressed TAR archive
+	TarZstdFormat
+	// TarXzFormat indicates an xz compressed TAR archive
+	TarXzFormat
 )
 
+const (
+	DefaultArchiveURLRetries      = 3
+	DefaultArchiveURLRetryBackoff = 500 * time.Millisecond
+)
+
+var ErrChecksumMismatch = errors.New("archive checksum does not match")
+
+// ArchiveURLOptions configures how an ArchiveURLObjectStorage fetches and caches its archive
+type ArchiveURLOptions struct {
+	// CacheDir keeps the downloaded archive between instances. Archives are stored
+	// under a name derived from the URL. When empty a temporary directory is used
+	// and removed by Close.
+	CacheDir string
+
+	// RefreshInterval is how often the archive is checked for changes with a
+	// conditional request (ETag / Last-Modified). Zero never checks again once
+	// the archive is cached.
+	RefreshInterval time.Duration
+
+	// SHA256 pins the hex encoded SHA-256 of the archive. Downloads and cached
+	// copies that do not match are rejected with ErrChecksumMismatch.
+	SHA256 string
+
+	// Retries is the number of times a failed download is retried. Defaults to
+	// DefaultArchiveURLRetries; use a negative value to disable retries.
+	Retries int
+
+	// RetryBackoff is the wait before the first retry. It doubles on every
+	// retry. Defaults to DefaultArchiveURLRetryBackoff.
+	RetryBackoff time.Duration
+
+	// Client is used for the requests. Defaults to a client with a 5 minute timeout.
+	Client *http.Client
+}
+
+// archiveCacheEntry is stored next to a cached archive to allow conditional refreshes
+type archiveCacheEntry struct {
+	URL          string        `json:"url"`
+	ETag         string        `json:"etag,omitempty"`
+	LastModified string        `json:"lastModified,omitempty"`
+	SHA256       string        `json:"sha256"`
+	Format       ArchiveFormat `json:"format"`
+	CheckedAt    time.Time     `json:"checkedAt"`
+}
+
 // ArchiveURLObjectStorage implements ObjectStorage by downloading a ZIP or TAR archive
 // from a URL and then using the appropriate storage implementation to access its contents.
-// The archive is downloaded once and cached for the lifecycle of this storage instance.
+//
+// The archive is cached on disk. When a refresh interval is set the cached copy is
+// revalidated with a conditional request and replaced when the server has a new
+// version; if the server cannot be reached the cached copy keeps being served.
+// Compressed tar archives (gzip, zstd and xz) are decompressed once into the cache
+// and read through an IndexedTarObjectStorage so large archives are not loaded into memory.
 type ArchiveURLObjectStorage struct {
 	archiveURL     string            // URL of the archive to download
 	format         ArchiveFormat     // Format of the archive
-	archiveStorage ObjectStorage     // The actual storage implementation (Zip or Tar)
-	tempFilePath   string            // Path to the downloaded archive file
-	mu             sync.RWMutex      // Protects the archiveStorage initialization
-	initialized    bool              // Whether the archive has been downloaded and initialized
 	headers        map[string]string // Custom headers for HTTP requests
+	options        ArchiveURLOptions
+	client         *http.Client
+	cacheDir       string        // Directory holding the cached archive
+	tempCache      bool          // Whether cacheDir is removed on Close
+	archiveStorage ObjectStorage // The actual storage implementation (Zip or Tar)
+	entry          *archiveCacheEntry
+	mu             sync.RWMutex // Protects the archiveStorage and cache entry
+	now            func() time.Time
 }
 
 // Create a variable to satisfy the interface check
-var _ ObjectStorage = (*ArchiveURLObjectStorage)(nil)
+var _ ListableObjectStorage = (*ArchiveURLObjectStorage)(nil)
 
 // NewArchiveURLObjectStorage creates a new object storage implementation that
 // downloads an archive file from a URL and provides access to its contents.
 func NewArchiveURLObjectStorage(archiveURL string, format ArchiveFormat, headers map[string]string) *ArchiveURLObjectStorage {
-	return &ArchiveURLObjectStorage{
+	return NewArchiveURLObjectStorageWithOptions(archiveURL, format, headers, nil)
+}
+
+// NewArchiveURLObjectStorageWithOptions creates an archive URL object storage with
+// caching, refresh, integrity and retry options.
+func NewArchiveURLObjectStorageWithOptions(archiveURL string, format ArchiveFormat, headers map[string]string, options *ArchiveURLOptions) *ArchiveURLObjectStorage {
+	a := &ArchiveURLObjectStorage{
 		archiveURL: archiveURL,
 		format:     format,
 		headers:    headers,
-		mu:         sync.RWMutex{},
+		now:        time.Now,
+	}
+	if options != nil {
+		a.options = *options
+	}
+	if a.options.Retries == 0 {
+		a.options.Retries = DefaultArchiveURLRetries
+	}
+	if a.options.Retries < 0 {
+		a.options.Retries = 0
+	}
+	if a.options.RetryBackoff <= 0 {
+		a.options.RetryBackoff = DefaultArchiveURLRetryBackoff
 	}
+	a.options.SHA256 = strings.ToLower(a.options.SHA256)
+
+	a.client = a.options.Client
+	if a.client == nil {
+		a.client = &http.Client{
+			Timeout: 300 * time.Second, // Set a reasonable timeout for downloads
+		}
+	}
+	return a
+}
+
+// cacheBase returns the path of the cache files without an extension
+func (a *ArchiveURLObjectStorage) cacheBase() string {
+	sum := sha256.Sum256([]byte(a.archiveURL))
+	return filepath.Join(a.cacheDir, hex.EncodeToString(sum[:16]))
 }
 
-func (a *ArchiveURLObjectStorage) downloadArchive(ctx context.Context) (io.ReadCloser, error) {
-	// Create a new HTTP client with the configured headers
-	client := &http.Client{
-		Timeout: 300 * time.Second, // Set a reasonable timeout for downloads
+// ensureCacheDir creates the cache directory on first use
+func (a *ArchiveURLObjectStorage) ensureCacheDir() error {
+	if a.cacheDir != "" {
+		return nil
+	}
+
+	if a.options.CacheDir != "" {
+		if err := os.MkdirAll(a.options.CacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		a.cacheDir = a.options.CacheDir
+		return nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", a.archiveURL, nil)
+	dir, err := os.MkdirTemp("", "archive-url-*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create temp directory: %w", err)
 	}
+	a.cacheDir = dir
+	a.tempCache = true
+	return nil
+}
+
+// request performs a GET for the archive, retrying network errors and server
+// errors with exponential backoff
+func (a *ArchiveURLObjectStorage) request(ctx context.Context, conditional bool) (*http.Response, error) {
+	backoff := a.options.RetryBackoff
+	var lastErr error
+
+	for attempt := 0; attempt <= a.options.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.archiveURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
 
-	// Add custom headers if provided
-	for key, value := range a.headers {
-		req.Header.Add(key, value)
+		// Add custom headers if provided
+		for key, value := range a.headers {
+			req.Header.Add(key, value)
+		}
+		if conditional && a.entry != nil {
+			if a.entry.ETag != "" {
+				req.Header.Set("If-None-Match", a.entry.ETag)
+			}
+			if a.entry.LastModified != "" {
+				req.Header.Set("If-Modified-Since", a.entry.LastModified)
+			}
+		}
+
+		resp, err := a.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("status code %d", resp.StatusCode)
+			continue
+		}
+		return resp, nil
 	}
 
-	resp, err := client.Do(req)
+	return nil, fmt.Errorf("failed to download archive after %d attempts: %w", a.options.Retries+1, lastErr)
+}
+
+// fetch downloads the archive, or confirms the cached copy is current, and
+// switches to the new archive. Must be called with the write lock held.
+func (a *ArchiveURLObjectStorage) fetch(ctx context.Context) error {
+	resp, err := a.request(ctx, a.archiveStorage != nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download archive: %w", err)
+		return err
 	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && a.archiveStorage != nil {
+		a.entry.CheckedAt = a.now()
+		return a.saveEntry()
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download archive: status code %d", resp.StatusCode)
+		return fmt.Errorf("failed to download archive: status code %d", resp.StatusCode)
 	}
 
-	// Generate a unique filename based on the URL
-	fileName := fmt.Sprintf("archive_%x", a.archiveURL)
-	file, err := os.CreateTemp("", fileName)
+	// Download next to the cache so the final rename is atomic
+	file, err := os.CreateTemp(a.cacheDir, "download-*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
+		return fmt.Errorf("failed to create temp file: %w", err)
 	}
+	defer os.Remove(file.Name())
 
-	_, err = io.Copy(file, resp.Body)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), resp.Body)
 	if err != nil {
 		file.Close()
-		return nil, fmt.Errorf("failed to write archive to temp file: %w", err)
+		return fmt.Errorf("failed to write archive to temp file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if a.options.SHA256 != "" && checksum != a.options.SHA256 {
+		return fmt.Errorf("%w: expected %s, downloaded %s", ErrChecksumMismatch, a.options.SHA256, checksum)
+	}
+
+	format, err := a.detectFormat(file.Name())
+	if err != nil {
+		return err
+	}
+
+	entry := &archiveCacheEntry{
+		URL:          a.archiveURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		SHA256:       checksum,
+		Format:       format,
+		CheckedAt:    a.now(),
+	}
+
+	if err := SafeReplace(file.Name(), a.cacheBase()+".archive"); err != nil {
+		return fmt.Errorf("failed to cache archive: %w", err)
+	}
+	store, err := a.openArchive(entry.Format, true)
+	if err != nil {
+		return err
+	}
+
+	a.entry = entry
+	a.archiveStorage = store
+	return a.saveEntry()
+}
+
+// loadCache opens a previously cached archive. Must be called with the write lock held.
+func (a *ArchiveURLObjectStorage) loadCache() error {
+	data, err := os.ReadFile(a.cacheBase() + ".json")
+	if err != nil {
+		return err
+	}
+
+	var entry archiveCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("invalid cache entry: %w", err)
+	}
+	if entry.URL != a.archiveURL {
+		return fmt.Errorf("cache entry is for %s", entry.URL)
+	}
+
+	if a.options.SHA256 != "" {
+		if entry.SHA256 != a.options.SHA256 {
+			return fmt.Errorf("%w: cached %s", ErrChecksumMismatch, entry.SHA256)
+		}
+
+		// The cached file may have been changed on disk
+		checksum, err := fileSHA256(a.cacheBase() + ".archive")
+		if err != nil {
+			return err
+		}
+		if checksum != a.options.SHA256 {
+			return fmt.Errorf("%w: cached file is %s", ErrChecksumMismatch, checksum)
+		}
 	}
 
-	err = file.Close()
+	store, err := a.openArchive(entry.Format, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to close temp file: %w", err)
+		return err
 	}
-	a.tempFilePath = file.Name()
 
-	return os.Open(file.Name()) // Ensure the file is opened for reading
+	a.entry = &entry
+	a.archiveStorage = store
+	return nil
+}
+
+// saveEntry writes the cache entry next to the archive
+func (a *ArchiveURLObjectStorage) saveEntry() error {
+	data, err := json.MarshalIndent(a.entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := a.cacheBase() + ".json.tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return SafeReplace(tmp, a.cacheBase()+".json")
 }
 
-// initialize downloads the archive file and initializes the appropriate storage
-func (a *ArchiveURLObjectStorage) initialize(ctx context.Context) error {
+// fileSHA256 returns the hex encoded SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// detectFormat determines the archive format from the configuration, the URL
+// and finally the first bytes of the file
+func (a *ArchiveURLObjectStorage) detectFormat(path string) (ArchiveFormat, error) {
+	if a.format != AutoDetect {
+		return a.format, nil
+	}
+
+	// Try to detect format from the URL
+	name := strings.ToLower(a.archiveURL)
+	if u, err := url.Parse(a.archiveURL); err == nil {
+		name = strings.ToLower(u.Path)
+	}
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return ZipFormat, nil
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TarFormat, nil
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return TarZstdFormat, nil
+	case strings.HasSuffix(name, ".tar.xz"), strings.HasSuffix(name, ".txz"):
+		return TarXzFormat, nil
+	}
+
+	magic, err := readMagic(path)
+	if err != nil {
+		return AutoDetect, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return ZipFormat, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return TarZstdFormat, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		return TarXzFormat, nil
+	default:
+		return TarFormat, nil
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// readMagic returns the first bytes of a file
+func readMagic(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 6)
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return magic[:n], nil
+}
+
+// openArchive creates the storage for the cached archive. Compressed tar
+// archives are decompressed to a plain tar next to it when extract is set or
+// no decompressed copy exists yet.
+func (a *ArchiveURLObjectStorage) openArchive(format ArchiveFormat, extract bool) (ObjectStorage, error) {
+	archivePath := a.cacheBase() + ".archive"
+
+	switch format {
+	case ZipFormat:
+		return NewZipObjectStorage(archivePath), nil
+	case TarFormat, TarZstdFormat, TarXzFormat:
+	default:
+		// This should not happen, but handle it anyway
+		return nil, fmt.Errorf("unknown archive format")
+	}
+
+	magic, err := readMagic(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	compressed := bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zstdMagic) || bytes.HasPrefix(magic, xzMagic)
+	if !compressed {
+		return NewIndexedTarObjectStorage(archivePath), nil
+	}
+
+	tarPath := a.cacheBase() + ".tar"
+	if exists, _ := CheckFileExists(tarPath); extract || !exists {
+		if err := decompressArchive(archivePath, tarPath, magic); err != nil {
+			return nil, err
+		}
+	}
+	return NewIndexedTarObjectStorage(tarPath), nil
+}
+
+// decompressArchive writes the decompressed archive to dest
+func decompressArchive(src, dest string, magic []byte) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	case bytes.HasPrefix(magic, xzMagic):
+		xzReader, err := xz.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		reader = xzReader
+	default:
+		reader = file
+	}
+
+	tmp, tmpPath, err := CreateTempFile(dest, "decompress_", ".tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	return SafeReplace(tmpPath, dest)
+}
+
+// refreshDue reports whether the cached archive should be revalidated
+func (a *ArchiveURLObjectStorage) refreshDue() bool {
+	return a.options.RefreshInterval > 0 && a.entry != nil &&
+		a.now().Sub(a.entry.CheckedAt) >= a.options.RefreshInterval
+}
+
+// storage returns the storage for the current archive, downloading or
+// refreshing the archive first when needed
+func (a *ArchiveURLObjectStorage) storage(ctx context.Context) (ObjectStorage, error) {
+	a.mu.RLock()
+	store := a.archiveStorage
+	due := a.refreshDue()
+	a.mu.RUnlock()
+	if store != nil && !due {
+		return store, nil
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	// If already initialized, do nothing
-	if a.initialized {
-		return nil
+	// Another caller may have done the work while we waited
+	if a.archiveStorage != nil && !a.refreshDue() {
+		return a.archiveStorage, nil
 	}
 
-	// Download the archive file
-	reader, err := a.downloadArchive(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to download archive: %w", err)
+	if err := a.ensureCacheDir(); err != nil {
+		return nil, err
 	}
-	defer reader.Close()
 
-	// Add extension based on format or detection
-	format := a.format
-	if format == AutoDetect {
-		// Try to detect format from URL
-		if strings.HasSuffix(strings.ToLower(a.tempFilePath), ".zip") {
-			format = ZipFormat
-		} else if strings.HasSuffix(strings.ToLower(a.tempFilePath), ".tar") {
-			format = TarFormat
-		} else if strings.HasSuffix(strings.ToLower(a.tempFilePath), ".tar.gz") ||
-			strings.HasSuffix(strings.ToLower(a.tempFilePath), ".tgz") {
-			format = TarFormat
-		} else {
-			// Default to zip if can't detect
-			format = ZipFormat
+	if a.archiveStorage == nil {
+		if err := a.loadCache(); err != nil && !os.IsNotExist(err) {
+			cloudy.Warn(ctx, "ignoring cached archive for %s: %v", a.archiveURL, err)
+		}
+		if a.archiveStorage != nil && !a.refreshDue() {
+			return a.archiveStorage, nil
 		}
 	}
 
-	// Create the appropriate storage implementation
-	if format == ZipFormat {
-		a.archiveStorage = NewZipObjectStorage(a.tempFilePath)
-	} else if format == TarFormat {
-		a.archiveStorage = NewTarObjectStorage(a.tempFilePath)
-	} else {
-		// This should not happen, but handle it anyway
-		return fmt.Errorf("unknown archive format")
+	if err := a.fetch(ctx); err != nil {
+		if a.archiveStorage == nil {
+			return nil, fmt.Errorf("failed to download archive: %w", err)
+		}
+
+		// Keep serving the cached copy and try again after the next interval
+		cloudy.Warn(ctx, "failed to refresh archive %s, using cached copy: %v", a.archiveURL, err)
+		a.entry.CheckedAt = a.now()
 	}
+	return a.archiveStorage, nil
+}
 
-	a.initialized = true
-	return nil
+// Refresh revalidates the archive with the server now, regardless of the refresh interval
+func (a *ArchiveURLObjectStorage) Refresh(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.ensureCacheDir(); err != nil {
+		return err
+	}
+	if a.archiveStorage == nil {
+		if err := a.loadCache(); err != nil && !os.IsNotExist(err) {
+			cloudy.Warn(ctx, "ignoring cached archive for %s: %v", a.archiveURL, err)
+		}
+	}
+	return a.fetch(ctx)
 }
 
-// ensureInitialized makes sure the archive is downloaded and storage is ready
-func (a *ArchiveURLObjectStorage) ensureInitialized(ctx context.Context) error {
-	if !a.initialized {
-		return a.initialize(ctx)
+// SHA256 returns the hex encoded SHA-256 of the current archive, or an empty
+// string if it has not been downloaded yet
+func (a *ArchiveURLObjectStorage) SHA256() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.entry == nil {
+		return ""
 	}
-	return nil
+	return a.entry.SHA256
 }
 
-// cleanup removes the temporary file when done
+// cleanup removes the temporary cache when done
 func (a *ArchiveURLObjectStorage) cleanup() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.tempFilePath != "" {
-		if err := os.Remove(a.tempFilePath); err != nil {
-			return fmt.Errorf("failed to remove temp file: %w", err)
+	if a.tempCache && a.cacheDir != "" {
+		if err := os.RemoveAll(a.cacheDir); err != nil {
+			return fmt.Errorf("failed to remove temp directory: %w", err)
 		}
-		a.tempFilePath = ""
 	}
 
-	a.initialized = false
+	a.cacheDir = ""
+	a.tempCache = false
+	a.entry = nil
 	a.archiveStorage = nil
 
 	return nil
 }
 
-// Close cleans up resources when done
+// Close cleans up resources when done. A persistent cache directory is kept.
 func (a *ArchiveURLObjectStorage) Close() error {
 	return a.cleanup()
 }
 
 // Download implements ObjectStorage.Download
 func (a *ArchiveURLObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
-	// Ensure archive is downloaded and initialized
-	if err := a.ensureInitialized(ctx); err != nil {
+	// Ensure archive is downloaded and current
+	store, err := a.storage(ctx)
+	if err != nil {
 		return nil, err
 	}
 
 	// Delegate to the archive storage
-	return a.archiveStorage.Download(ctx, key)
+	return store.Download(ctx, key)
 }
 
 // Exists implements ObjectStorage.Exists
 func (a *ArchiveURLObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
-	// Ensure archive is downloaded and initialized
-	if err := a.ensureInitialized(ctx); err != nil {
+	// Ensure archive is downloaded and current
+	store, err := a.storage(ctx)
+	if err != nil {
 		return false, err
 	}
 
 	// Delegate to the archive storage
-	return a.archiveStorage.Exists(ctx, key)
+	return store.Exists(ctx, key)
 }
 
 // List implements ObjectStorage.List
 func (a *ArchiveURLObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
-	// Ensure archive is downloaded and initialized
-	if err := a.ensureInitialized(ctx); err != nil {
+	// Ensure archive is downloaded and current
+	store, err := a.storage(ctx)
+	if err != nil {
 		return nil, nil, err
 	}
 
 	// Delegate to the archive storage
-	return a.archiveStorage.List(ctx, prefix)
+	return store.List(ctx, prefix)
+}
+
+// ListItems implements ListableObjectStorage.ListItems
+func (a *ArchiveURLObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	// Ensure archive is downloaded and current
+	store, err := a.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delegate to the archive storage
+	return ListItems(ctx, store, options)
 }
 
 // Upload implements ObjectStorage.Upload
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+// archiveServer serves one archive with an ETag and counts the requests
+type archiveServer struct {
+	mu       sync.Mutex
+	data     []byte
+	version  int
+	requests int
+	full     int // Requests answered with the archive
+	failures int // Number of requests to fail before succeeding
+	server   *httptest.Server
+}
+
+func newArchiveServer(t *testing.T, data []byte) *archiveServer {
+	s := &archiveServer{data: data, version: 1}
+	s.server = httptest.NewServer(s)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *archiveServer) set(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = data
+	s.version++
+}
+
+func (s *archiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	etag := fmt.Sprintf(`"v%d"`, s.version)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.full++
+	w.Write(s.data)
+}
+
+func buildTarArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		require.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.Nil(t, err)
+	}
+	require.Nil(t, tw.Close())
+	return buf.Bytes()
+}
+
+func compressArchive(t *testing.T, format string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch format {
+	case "gz":
+		w = gzip.NewWriter(&buf)
+	case "zst":
+		w, err = zstd.NewWriter(&buf)
+	case "xz":
+		w, err = xz.NewWriter(&buf)
+	}
+	require.Nil(t, err)
+	_, err = w.Write(data)
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func buildZipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.Nil(t, err)
+		_, err = w.Write([]byte(content))
+		require.Nil(t, err)
+	}
+	require.Nil(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestArchiveURLObjectStorage_Formats(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{"readme.txt": "hello", "bin/tool.sh": "echo tool"}
+	tarData := buildTarArchive(t, files)
+
+	archives := map[string][]byte{
+		"/files.zip":     buildZipArchive(t, files),
+		"/files.tar":     tarData,
+		"/files.tar.gz":  compressArchive(t, "gz", tarData),
+		"/files.tar.zst": compressArchive(t, "zst", tarData),
+		"/files.tar.xz":  compressArchive(t, "xz", tarData),
+		"/download?id=1": compressArchive(t, "zst", tarData), // Detected from the content
+	}
+
+	for path, data := range archives {
+		t.Run(path, func(t *testing.T) {
+			server := newArchiveServer(t, data)
+			store := NewArchiveURLObjectStorage(server.server.URL+path, AutoDetect, nil)
+			defer store.Close()
+
+			assert.Equal(t, "hello", readAllObject(t, store, "readme.txt"))
+			assert.Equal(t, "echo tool", readAllObject(t, store, "bin/tool.sh"))
+
+			result, err := ListItems(ctx, store, &ListItemsOptions{Recursive: true})
+			require.Nil(t, err)
+			assert.Equal(t, []string{"bin/tool.sh", "readme.txt"}, listKeys(result))
+			assert.Equal(t, 1, server.requests)
+		})
+	}
+}
+
+func TestArchiveURLObjectStorage_Refresh(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	server := newArchiveServer(t, buildTarArchive(t, map[string]string{"a.txt": "one"}))
+	url := server.server.URL + "/files.tar.gz"
+
+	now := time.Now()
+	options := &ArchiveURLOptions{CacheDir: cacheDir, RefreshInterval: time.Minute, RetryBackoff: time.Millisecond}
+	store := NewArchiveURLObjectStorageWithOptions(url, AutoDetect, nil, options)
+	store.now = func() time.Time { return now }
+
+	assert.Equal(t, "one", readAllObject(t, store, "a.txt"))
+	assert.Equal(t, 1, server.requests)
+
+	// Within the interval nothing is requested
+	assert.Equal(t, "one", readAllObject(t, store, "a.txt"))
+	assert.Equal(t, 1, server.requests)
+
+	// After the interval a conditional request finds no change
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, "one", readAllObject(t, store, "a.txt"))
+	assert.Equal(t, 2, server.requests)
+	assert.Equal(t, 1, server.full)
+
+	// A new version is picked up on the next refresh
+	server.set(buildTarArchive(t, map[string]string{"a.txt": "two"}))
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, "two", readAllObject(t, store, "a.txt"))
+	assert.Equal(t, 2, server.full)
+
+	// The server being down does not break reads of the cached copy
+	server.mu.Lock()
+	server.failures = 100
+	server.mu.Unlock()
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, "two", readAllObject(t, store, "a.txt"))
+
+	// A new instance uses the persistent cache without downloading
+	server.mu.Lock()
+	server.failures = 0
+	requests := server.requests
+	server.mu.Unlock()
+	other := NewArchiveURLObjectStorageWithOptions(url, AutoDetect, nil, &ArchiveURLOptions{CacheDir: cacheDir})
+	assert.Equal(t, "two", readAllObject(t, other, "a.txt"))
+	assert.Equal(t, requests, server.requests)
+
+	// Forcing a refresh revalidates
+	require.Nil(t, other.Refresh(ctx))
+	assert.Equal(t, requests+1, server.requests)
+	assert.Equal(t, 2, server.full)
+
+	// Close keeps a persistent cache
+	require.Nil(t, other.Close())
+	exists, _ := CheckFileExists(cacheDir)
+	assert.True(t, exists)
+}
+
+func TestArchiveURLObjectStorage_Checksum(t *testing.T) {
+	ctx := context.Background()
+	data := buildZipArchive(t, map[string]string{"a.txt": "pinned"})
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	server := newArchiveServer(t, data)
+	url := server.server.URL + "/files.zip"
+
+	wrong := NewArchiveURLObjectStorageWithOptions(url, AutoDetect, nil, &ArchiveURLOptions{SHA256: "00" + checksum[2:]})
+	_, err := wrong.Exists(ctx, "a.txt")
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	cacheDir := t.TempDir()
+	pinned := NewArchiveURLObjectStorageWithOptions(url, ZipFormat, nil, &ArchiveURLOptions{SHA256: checksum, CacheDir: cacheDir})
+	assert.Equal(t, "pinned", readAllObject(t, pinned, "a.txt"))
+	assert.Equal(t, checksum, pinned.SHA256())
+
+	// A cached copy pinned to another checksum is downloaded again
+	requests := server.requests
+	server.set(buildZipArchive(t, map[string]string{"a.txt": "changed"}))
+	repinned := NewArchiveURLObjectStorageWithOptions(url, ZipFormat, nil, &ArchiveURLOptions{SHA256: "00" + checksum[2:], CacheDir: cacheDir})
+	_, err = repinned.Exists(ctx, "a.txt")
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Equal(t, requests+1, server.requests)
+}
+
+func TestArchiveURLObjectStorage_Retries(t *testing.T) {
+	server := newArchiveServer(t, buildZipArchive(t, map[string]string{"a.txt": "retried"}))
+	server.failures = 2
+
+	store := NewArchiveURLObjectStorageWithOptions(server.server.URL+"/files.zip", AutoDetect, nil, &ArchiveURLOptions{RetryBackoff: time.Millisecond})
+	defer store.Close()
+	assert.Equal(t, "retried", readAllObject(t, store, "a.txt"))
+	assert.Equal(t, 3, server.requests)
+
+	server.failures = 5
+	failing := NewArchiveURLObjectStorageWithOptions(server.server.URL+"/files.zip", AutoDetect, nil, &ArchiveURLOptions{Retries: 1, RetryBackoff: time.Millisecond})
+	defer failing.Close()
+	_, err := failing.Exists(context.Background(), "a.txt")
+	assert.ErrorContains(t, err, "after 2 attempts")
+}
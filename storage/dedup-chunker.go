@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Default chunk sizes used by the content-defined chunker
+const (
+	DefaultMinChunkSize = 16 * 1024  // 16KB
+	DefaultAvgChunkSize = 64 * 1024  // 64KB
+	DefaultMaxChunkSize = 256 * 1024 // 256KB
+)
+
+// gearTable holds the random values used by the gear rolling hash. It is generated
+// from a fixed seed so that chunk boundaries are stable across processes and versions.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x636c6f7564792d63) // "cloudy-c"
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks using a FastCDC style gear
+// hash with normalized chunking. Identical runs of bytes produce identical chunks
+// regardless of where they appear in the stream, which is what allows chunks to be
+// shared between objects.
+type Chunker struct {
+	reader io.Reader
+	min    int
+	avg    int
+	max    int
+	maskS  uint64 // stricter mask used before the average size is reached
+	maskL  uint64 // looser mask used after the average size is reached
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// NewChunker creates a chunker over the reader. Sizes of zero fall back to the
+// defaults. The average size must be a power of two between min and max.
+func NewChunker(reader io.Reader, min, avg, max int) (*Chunker, error) {
+	if min <= 0 {
+		min = DefaultMinChunkSize
+	}
+	if avg <= 0 {
+		avg = DefaultAvgChunkSize
+	}
+	if max <= 0 {
+		max = DefaultMaxChunkSize
+	}
+
+	if min > avg || avg > max {
+		return nil, fmt.Errorf("%w: chunk sizes must satisfy min <= avg <= max", ErrInvalidStorage)
+	}
+	if avg&(avg-1) != 0 {
+		return nil, fmt.Errorf("%w: average chunk size must be a power of two", ErrInvalidStorage)
+	}
+
+	avgBits := bits.TrailingZeros(uint(avg))
+	return &Chunker{
+		reader: reader,
+		min:    min,
+		avg:    avg,
+		max:    max,
+		maskS:  highMask(avgBits + 1),
+		maskL:  highMask(avgBits - 1),
+		buf:    make([]byte, 2*max),
+	}, nil
+}
+
+// highMask returns a mask with the n most significant bits set. The gear hash shifts
+// left, so the high bits depend on the widest window of preceding bytes.
+func highMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if n >= 64 {
+		return ^uint64(0)
+	}
+	return ((uint64(1) << n) - 1) << (64 - n)
+}
+
+// Next returns the next chunk in the stream. The returned slice is only valid until
+// the next call. io.EOF is returned once the stream has been fully consumed.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill makes sure at least max bytes are buffered, or everything that is left
+func (c *Chunker) fill() error {
+	if c.end-c.start >= c.max || c.eof {
+		return nil
+	}
+
+	// Shift the remaining data to the front of the buffer
+	if c.start > 0 {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+	}
+
+	for c.end < c.max && !c.eof {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read data: %w", err)
+		}
+	}
+	return nil
+}
+
+// cut finds the next chunk boundary in the data
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+
+	normal := c.avg
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,497 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/appliedres/cloudy"
+)
+
+var _ ObjectStorage = (*DedupObjectStorage)(nil)
+
+const (
+	dedupChunkPrefix    = "chunks/"
+	dedupManifestPrefix = "manifests/"
+)
+
+// DedupOptions controls how content is split into chunks
+type DedupOptions struct {
+	MinChunkSize int // Smallest chunk that will be produced (default 16KB)
+	AvgChunkSize int // Target average chunk size, must be a power of two (default 64KB)
+	MaxChunkSize int // Largest chunk that will be produced (default 256KB)
+}
+
+// DedupManifest describes a stored object as an ordered list of chunks
+type DedupManifest struct {
+	Key    string            `json:"key"`
+	Size   int64             `json:"size"`
+	MD5    string            `json:"md5"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Chunks []DedupChunkRef   `json:"chunks"`
+}
+
+// DedupChunkRef references a single chunk by its SHA-256 digest
+type DedupChunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// DedupStats reports how much space is saved by sharing chunks
+type DedupStats struct {
+	Objects      int     // Number of stored objects
+	Chunks       int     // Number of unique referenced chunks
+	LogicalBytes int64   // Sum of the sizes of all objects
+	StoredBytes  int64   // Sum of the sizes of all unique chunks
+	DedupRatio   float64 // LogicalBytes / StoredBytes (1 when nothing is stored)
+}
+
+// DedupObjectStorage is a content-addressable ObjectStorage wrapper. Uploaded content
+// is split with content-defined chunking and each chunk is stored once in the underlying
+// store under its SHA-256 digest. Each key maps to a manifest listing its chunks, so
+// identical content uploaded under different keys only consumes space once.
+//
+// Chunks are reference counted. When the last manifest referencing a chunk is removed
+// or overwritten the chunk is deleted. GC can be used to sweep chunks that were left
+// behind by interrupted uploads.
+//
+// The wrapper keeps an index of the manifests in memory which is loaded from the
+// underlying store on first use. It assumes it is the only writer to that store.
+type DedupObjectStorage struct {
+	store ObjectStorage
+	opts  DedupOptions
+
+	mu        sync.RWMutex
+	loaded    bool
+	manifests map[string]*DedupManifest // key -> manifest
+	refs      map[string]int            // chunk hash -> reference count
+	sizes     map[string]int64          // chunk hash -> chunk size
+}
+
+// NewDedupObjectStorage creates a deduplicating wrapper around the given store
+func NewDedupObjectStorage(store ObjectStorage, opts *DedupOptions) *DedupObjectStorage {
+	d := &DedupObjectStorage{
+		store:     store,
+		manifests: make(map[string]*DedupManifest),
+		refs:      make(map[string]int),
+		sizes:     make(map[string]int64),
+	}
+	if opts != nil {
+		d.opts = *opts
+	}
+	return d
+}
+
+func dedupChunkKey(hash string) string {
+	return dedupChunkPrefix + hash[:2] + "/" + hash
+}
+
+func dedupManifestKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return dedupManifestPrefix + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// load reads all of the manifests from the underlying store and rebuilds the
+// reference counts. Must be called with the write lock held.
+func (d *DedupObjectStorage) load(ctx context.Context) error {
+	if d.loaded {
+		return nil
+	}
+
+	keys, err := listAllKeys(ctx, d.store, dedupManifestPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list manifests: %w", err)
+	}
+
+	for _, key := range keys {
+		manifest, err := d.readManifest(ctx, key)
+		if err != nil {
+			return err
+		}
+		d.manifests[manifest.Key] = manifest
+		d.addRefs(manifest)
+	}
+
+	d.loaded = true
+	return nil
+}
+
+// ensureLoaded makes sure the manifest index has been loaded
+func (d *DedupObjectStorage) ensureLoaded(ctx context.Context) error {
+	d.mu.RLock()
+	loaded := d.loaded
+	d.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.load(ctx)
+}
+
+func (d *DedupObjectStorage) readManifest(ctx context.Context, storeKey string) (*DedupManifest, error) {
+	reader, err := d.store.Download(ctx, storeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", storeKey, err)
+	}
+	defer reader.Close()
+
+	var manifest DedupManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %w", storeKey, err)
+	}
+	return &manifest, nil
+}
+
+func (d *DedupObjectStorage) writeManifest(ctx context.Context, manifest *DedupManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := d.store.Upload(ctx, dedupManifestKey(manifest.Key), bytes.NewReader(data), nil); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+func (d *DedupObjectStorage) addRefs(manifest *DedupManifest) {
+	for _, chunk := range manifest.Chunks {
+		d.refs[chunk.Hash]++
+		d.sizes[chunk.Hash] = chunk.Size
+	}
+}
+
+// releaseRefs decrements the reference counts for the manifest and deletes any
+// chunk that is no longer referenced. Failed deletes are left for GC.
+func (d *DedupObjectStorage) releaseRefs(ctx context.Context, manifest *DedupManifest) {
+	for _, chunk := range manifest.Chunks {
+		d.refs[chunk.Hash]--
+		if d.refs[chunk.Hash] > 0 {
+			continue
+		}
+
+		delete(d.refs, chunk.Hash)
+		delete(d.sizes, chunk.Hash)
+		if err := d.store.Delete(ctx, dedupChunkKey(chunk.Hash)); err != nil {
+			cloudy.Warn(ctx, "DedupObjectStorage: failed to delete chunk %s: %v", chunk.Hash, err)
+		}
+	}
+}
+
+// Upload implements ObjectStorage.
+// The content is chunked and only chunks that are not already stored are written.
+func (d *DedupObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.load(ctx); err != nil {
+		return err
+	}
+
+	chunker, err := NewChunker(data, d.opts.MinChunkSize, d.opts.AvgChunkSize, d.opts.MaxChunkSize)
+	if err != nil {
+		return err
+	}
+
+	manifest := &DedupManifest{
+		Key:    key,
+		Tags:   copyTags(tags),
+		Chunks: []DedupChunkRef{},
+	}
+	md5Hash := md5.New()
+	written := make(map[string]bool)
+
+	for {
+		chunk, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		md5Hash.Write(chunk)
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+
+		if d.refs[hash] == 0 && !written[hash] {
+			if err := d.store.Upload(ctx, dedupChunkKey(hash), bytes.NewReader(chunk), nil); err != nil {
+				return fmt.Errorf("failed to write chunk %s: %w", hash, err)
+			}
+			written[hash] = true
+		}
+
+		manifest.Chunks = append(manifest.Chunks, DedupChunkRef{Hash: hash, Size: int64(len(chunk))})
+		manifest.Size += int64(len(chunk))
+	}
+	manifest.MD5 = hex.EncodeToString(md5Hash.Sum(nil))
+
+	if err := d.writeManifest(ctx, manifest); err != nil {
+		return err
+	}
+
+	// Reference the new chunks before releasing the old ones so that shared
+	// chunks are never deleted
+	d.addRefs(manifest)
+	if previous, ok := d.manifests[key]; ok {
+		d.releaseRefs(ctx, previous)
+	}
+	d.manifests[key] = manifest
+
+	closer, canClose := data.(io.ReadCloser)
+	if canClose {
+		_ = closer.Close()
+	}
+
+	return nil
+}
+
+// Exists implements ObjectStorage.
+func (d *DedupObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ValidateKey(key); err != nil {
+		return false, err
+	}
+	if err := d.ensureLoaded(ctx); err != nil {
+		return false, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	_, ok := d.manifests[key]
+	return ok, nil
+}
+
+// Download implements ObjectStorage.
+// Chunks are streamed from the underlying store one at a time and verified
+// against their digest as they are read.
+func (d *DedupObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := d.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	manifest, ok := d.manifests[key]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	return &dedupChunkReader{
+		ctx:    ctx,
+		store:  d.store,
+		chunks: manifest.Chunks,
+	}, nil
+}
+
+// Delete implements ObjectStorage.
+func (d *DedupObjectStorage) Delete(ctx context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.load(ctx); err != nil {
+		return err
+	}
+
+	manifest, ok := d.manifests[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	if err := d.store.Delete(ctx, dedupManifestKey(key)); err != nil {
+		return fmt.Errorf("failed to delete manifest: %w", err)
+	}
+
+	delete(d.manifests, key)
+	d.releaseRefs(ctx, manifest)
+	return nil
+}
+
+// List implements ObjectStorage.
+// Objects directly under the prefix are returned along with the "/" delimited
+// sub-prefixes. Sizes and MD5s are those of the original content.
+func (d *DedupObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
+	if err := d.ensureLoaded(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	d.mu.RLock()
+	var all []*StoredObject
+	for _, manifest := range d.manifests {
+		all = append(all, &StoredObject{
+			Key:  manifest.Key,
+			Size: manifest.Size,
+			MD5:  manifest.MD5,
+			Tags: copyTags(manifest.Tags),
+		})
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Key < all[j].Key })
+	objects, prefixes := ListDelimited(all, prefix)
+	return objects, prefixes, nil
+}
+
+// UpdateMetadata implements ObjectStorage.
+// Tags are stored in the manifest so no content is rewritten.
+func (d *DedupObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.load(ctx); err != nil {
+		return err
+	}
+
+	manifest, ok := d.manifests[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	updated := *manifest
+	updated.Tags = copyTags(tags)
+	if err := d.writeManifest(ctx, &updated); err != nil {
+		return err
+	}
+
+	d.manifests[key] = &updated
+	return nil
+}
+
+// GC removes chunks from the underlying store that are not referenced by any
+// manifest and returns the number of chunks removed.
+func (d *DedupObjectStorage) GC(ctx context.Context) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.load(ctx); err != nil {
+		return 0, err
+	}
+
+	keys, err := listAllKeys(ctx, d.store, dedupChunkPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chunks: %w", err)
+	}
+
+	removed := 0
+	for _, key := range keys {
+		hash := key[strings.LastIndex(key, "/")+1:]
+		if d.refs[hash] > 0 {
+			continue
+		}
+		if err := d.store.Delete(ctx, key); err != nil {
+			return removed, fmt.Errorf("failed to delete chunk %s: %w", hash, err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Stats reports the logical and stored sizes along with the dedup ratio
+func (d *DedupObjectStorage) Stats(ctx context.Context) (*DedupStats, error) {
+	if err := d.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	stats := &DedupStats{
+		Objects: len(d.manifests),
+		Chunks:  len(d.refs),
+	}
+	for _, manifest := range d.manifests {
+		stats.LogicalBytes += manifest.Size
+	}
+	for _, size := range d.sizes {
+		stats.StoredBytes += size
+	}
+
+	stats.DedupRatio = 1
+	if stats.StoredBytes > 0 {
+		stats.DedupRatio = float64(stats.LogicalBytes) / float64(stats.StoredBytes)
+	}
+
+	return stats, nil
+}
+
+// dedupChunkReader streams the chunks of a manifest in order
+type dedupChunkReader struct {
+	ctx     context.Context
+	store   ObjectStorage
+	chunks  []DedupChunkRef
+	index   int
+	current io.ReadCloser
+	digest  hash.Hash
+}
+
+func (r *dedupChunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.index >= len(r.chunks) {
+				return 0, io.EOF
+			}
+
+			chunk := r.chunks[r.index]
+			reader, err := r.store.Download(r.ctx, dedupChunkKey(chunk.Hash))
+			if err != nil {
+				return 0, fmt.Errorf("failed to read chunk %s: %w", chunk.Hash, err)
+			}
+			r.current = reader
+			r.digest = sha256.New()
+		}
+
+		n, err := r.current.Read(p)
+		r.digest.Write(p[:n])
+		if errors.Is(err, io.EOF) {
+			chunk := r.chunks[r.index]
+			r.current.Close()
+			r.current = nil
+			r.index++
+
+			if hex.EncodeToString(r.digest.Sum(nil)) != chunk.Hash {
+				return n, fmt.Errorf("chunk %s failed integrity check", chunk.Hash)
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *dedupChunkReader) Close() error {
+	if r.current != nil {
+		err := r.current.Close()
+		r.current = nil
+		return err
+	}
+	return nil
+}
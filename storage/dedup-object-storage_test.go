@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunker_Boundaries(t *testing.T) {
+	data := make([]byte, 1024*1024)
+	_, err := rand.Read(data)
+	require.Nil(t, err)
+
+	chunker, err := NewChunker(bytes.NewReader(data), 2048, 8192, 32768)
+	require.Nil(t, err)
+
+	var total int
+	var sizes []int
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		sizes = append(sizes, len(chunk))
+		total += len(chunk)
+	}
+
+	assert.Equal(t, len(data), total)
+	for _, size := range sizes[:len(sizes)-1] {
+		assert.GreaterOrEqual(t, size, 2048)
+		assert.LessOrEqual(t, size, 32768)
+	}
+
+	// Prepending data should only change the first few chunks
+	shifted, err := NewChunker(io.MultiReader(bytes.NewReader([]byte("some prefix")), bytes.NewReader(data)), 2048, 8192, 32768)
+	require.Nil(t, err)
+
+	var shiftedSizes []int
+	for {
+		chunk, err := shifted.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		shiftedSizes = append(shiftedSizes, len(chunk))
+	}
+	assert.Equal(t, sizes[len(sizes)-5:], shiftedSizes[len(shiftedSizes)-5:])
+
+	_, err = NewChunker(bytes.NewReader(data), 2048, 5000, 32768)
+	assert.NotNil(t, err)
+}
+
+func TestDedupObjectStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	opts := &DedupOptions{MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16384}
+	store := NewDedupObjectStorage(NewFilesystemObjectStorage(dir), opts)
+
+	data := make([]byte, 200*1024)
+	_, err := rand.Read(data)
+	require.Nil(t, err)
+	sum := md5.Sum(data)
+
+	// Upload the same content under two keys
+	err = store.Upload(ctx, "apps/installer.msi", bytes.NewReader(data), map[string]string{"app": "one"})
+	require.Nil(t, err)
+	err = store.Upload(ctx, "apps/copy.msi", bytes.NewReader(data), nil)
+	require.Nil(t, err)
+	err = store.Upload(ctx, "readme.txt", bytes.NewReader([]byte("hello")), nil)
+	require.Nil(t, err)
+
+	stats, err := store.Stats(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 3, stats.Objects)
+	assert.Equal(t, int64(2*len(data)+5), stats.LogicalBytes)
+	assert.Equal(t, int64(len(data)+5), stats.StoredBytes)
+	assert.Greater(t, stats.DedupRatio, 1.9)
+
+	// Download
+	reader, err := store.Download(ctx, "apps/copy.msi")
+	require.Nil(t, err)
+	downloaded, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	assert.Equal(t, data, downloaded)
+
+	// List
+	objects, prefixes, err := store.List(ctx, "")
+	require.Nil(t, err)
+	assert.Len(t, objects, 1)
+	assert.Len(t, prefixes, 1)
+	assert.Equal(t, "apps/", prefixes[0].Key)
+
+	objects, _, err = store.List(ctx, "apps/")
+	require.Nil(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, "apps/copy.msi", objects[0].Key)
+	assert.Equal(t, int64(len(data)), objects[0].Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), objects[0].MD5)
+	assert.Equal(t, "one", objects[1].Tags["app"])
+
+	// Metadata
+	err = store.UpdateMetadata(ctx, "apps/copy.msi", map[string]string{"app": "two"})
+	require.Nil(t, err)
+
+	// Reopening rebuilds the index from the manifests
+	reopened := NewDedupObjectStorage(NewFilesystemObjectStorage(dir), opts)
+	objects, _, err = reopened.List(ctx, "apps/")
+	require.Nil(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, "two", objects[0].Tags["app"])
+
+	// Deleting one copy keeps the shared chunks
+	err = reopened.Delete(ctx, "apps/installer.msi")
+	require.Nil(t, err)
+	reader, err = reopened.Download(ctx, "apps/copy.msi")
+	require.Nil(t, err)
+	downloaded, err = io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	assert.Equal(t, data, downloaded)
+
+	// Deleting the last copy removes them
+	err = reopened.Delete(ctx, "apps/copy.msi")
+	require.Nil(t, err)
+	stats, err = reopened.Stats(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, int64(5), stats.StoredBytes)
+
+	removed, err := reopened.GC(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 0, removed)
+
+	exists, err := reopened.Exists(ctx, "apps/copy.msi")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	_, err = reopened.Download(ctx, "apps/copy.msi")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestDedupObjectStorage_GC(t *testing.T) {
+	ctx := context.Background()
+	backing := NewFilesystemObjectStorage(t.TempDir())
+	store := NewDedupObjectStorage(backing, nil)
+
+	err := store.Upload(ctx, "a.txt", bytes.NewReader([]byte("content")), nil)
+	require.Nil(t, err)
+
+	// Simulate a chunk left behind by an interrupted upload
+	err = backing.Upload(ctx, dedupChunkKey("ffee0000000000000000000000000000"), bytes.NewReader([]byte("orphan")), nil)
+	require.Nil(t, err)
+
+	removed, err := store.GC(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	exists, err := store.Exists(ctx, "a.txt")
+	require.Nil(t, err)
+	assert.True(t, exists)
+}
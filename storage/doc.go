@@ -19,51 +19,84 @@ S3Account -> Bucket -> Object
 # Sample Usage
 
 	// Load the account
-	account, err := storage.ObjectStorageAccount.NewFromEnv(env.Segment("PERSONAL_FILE_SHARE"), "DRIVER")
+	account, err := storage.ObjectStorageProviders.NewFromEnv(env.Segment("PERSONAL_FILE_SHARE"), "DRIVER")
 	if err != nil {
 		log.Fatalf("Failed to create storage account: %v\n", err)
 	}
 
-	fmt.Printf("Account Name: ", account.Name())
-
 	// Get the containers
-	containers, err := account.List(ctx, &storage.ListContainerOptions{
-		pageToken: nil,
-	})
-
+	areas, err := account.List(ctx)
 	if err != nil {
 		log.Fatalf("Failed to list the containers in an account: %v\n", err)
 	}
 
-	for _, container := range containers {
-
-		items, prefixes, err := container.List(ctx, &storage.ListItemsOptions{
-			pageToken: nil,
-			prefix: "",
-		})
+	for _, area := range areas {
+		container, err := account.Get(ctx, area.Name)
+		if err != nil {
+			log.Fatalf("Failed to open container %v: %v\n", area.Name, err)
+		}
 
-		for _, p := range prefixes {
-			fmt.Printf("%v\n", p.Name())
+		// List a page at a time. Stores that do not support paging natively
+		// are listed in full and paged in memory.
+		options := &storage.ListItemsOptions{
+			Prefix:     "reports/",
+			MaxResults: 100,
 		}
+		for {
+			page, err := storage.ListItems(ctx, container, options)
+			if err != nil {
+				log.Fatalf("Failed to list the items in a container: %v\n", err)
+			}
+
+			for _, p := range page.Prefixes {
+				fmt.Printf("%v\n", p.Key)
+			}
+
+			for _, item := range page.Objects {
+				fmt.Printf("%v\n", item.Key)
+				for k, v := range item.Tags {
+					fmt.Printf("\t%v:%v\n", k, v)
+				}
+			}
 
-		for _, item := range items {
-			fmt.Printf("%v\n", item.Name())
-			for k, v := range item.Tags() {
-				fmt.Printf("\t%v:%v\n", k, v)
+			if page.NextPageToken == "" {
+				break
 			}
+			options.PageToken = page.NextPageToken
 		}
 	}
 
+Setting Recursive lists every object below the prefix, Delimiter changes how
+keys are grouped into prefixes and Tags keeps only the objects carrying those tags.
+
 # Current Implementations
 
 - Filesystem
   - Directory (rw) - Local filesystem storage
   - Zip (rw) - ZIP archive storage
   - Tar (rw) - TAR/GZIP archive storage
+  - Indexed Tar (rw) - Large uncompressed TAR archives streamed through an offset index
+
+- Archive URL (ro) - ZIP or TAR (gzip, zstd, xz) archives downloaded from a URL, cached on disk and refreshed with conditional requests
+
+- Docker Registry (rw) - Files stored as OCI artifact layers, read-only access to container images
+
+- Wrappers
+  - Dedup (rw) - Content-addressable chunk storage over any backend
+  - Encrypted (rw) - Client-side AES-GCM encryption over any backend
+  - Signed URLs - HMAC signed upload/download URLs and an http.Handler that serves them for any backend
+  - Hooked (rw) - Before/after hooks and ObjectCreated/ObjectRemoved events around the writes to any backend
+  - Union (rw) - Layers several backends, writes go to the top writable layer and deletes leave whiteouts
+
+# File Shares
+
+FileStorageManager creates and deletes shares. ManagedFileStorageManager adds quotas,
+usage, user and group access lists and SMB/NFS mount information.
+
+- Local - Shares are directories below a root directory, quotas are enforced through Storage
 
 # Planned Implementations
 
-- Docker Registry (ro) - Access files in container images directly
 - Artifactory (rw) - JFrog Artifactory storage
 - PGSql (rw) - PostgreSQL database storage
 - HTTP/URL Storage (ro) - Simple downloads from HTTP URLs
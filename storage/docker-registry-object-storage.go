@@ -1,42 +1,86 @@
 package storage
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 )
 
-// DockerRegistryObjectStorage implements a read-only version of the ObjectStorage interface
-// for Docker container images. It allows listing and downloading files from a container image
-// stored in a Docker registry without extracting the entire image.
+// Media types and annotations used by OCI artifacts
+const (
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCIEmpty       = "application/vnd.oci.empty.v1+json"
+	MediaTypeOCILayer       = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeFilesArtifact  = "application/vnd.cloudy.files.v1"
+
+	// AnnotationTitle holds the object key of a file layer
+	AnnotationTitle = "org.opencontainers.image.title"
+)
+
+// The empty JSON config ("{}") used by OCI artifacts
+var (
+	ociEmptyConfig       = []byte("{}")
+	ociEmptyConfigDigest = "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+)
+
+// DockerRegistryObjectStorage implements the ObjectStorage interface on top of an
+// OCI / Docker registry using the OCI distribution API.
+//
+// Files are stored as OCI artifact layers: each object is a single blob referenced
+// from the manifest of the configured tag with its key in the
+// "org.opencontainers.image.title" annotation and its tags as the remaining
+// annotations. Uploads push the blob and then a new manifest, and downloads fetch
+// only the blob for the requested file.
+//
+// Regular container images can be read as well. Their filesystem layers are
+// overlaid (honoring whiteouts) so the files inside the image can be listed and
+// downloaded. Writing is only supported for artifact manifests.
 type DockerRegistryObjectStorage struct {
-	registryURL string     // URL to the Docker registry (e.g., "https://registry.hub.docker.com")
-	repository  string     // Repository name (e.g., "library/ubuntu")
-	tag         string     // Image tag (e.g., "latest")
-	token       string     // Auth token for registry access
-	mu          sync.Mutex // Mutex to protect token
+	registryURL string       // URL to the Docker registry (e.g., "https://registry.hub.docker.com")
+	repository  string       // Repository name (e.g., "library/ubuntu")
+	tag         string       // Image tag (e.g., "latest")
+	username    string       // Optional credentials used when the registry requests them
+	password    string       // Optional credentials used when the registry requests them
+	client      *http.Client // HTTP client used for all requests
+	authHeader  string       // Authorization header for registry access
+	authChecked bool         // Whether the registry has been checked for authentication
+	mu          sync.Mutex   // Mutex to protect the authorization
+	writeMu     sync.Mutex   // Serializes manifest updates
 }
 
-// DockerManifest represents the Docker image manifest v2
+// DockerManifest represents an OCI image manifest (or Docker image manifest v2)
 type DockerManifest struct {
-	SchemaVersion int    `json:"schemaVersion"`
-	MediaType     string `json:"mediaType"`
-	Config        struct {
-		MediaType string `json:"mediaType"`
-		Digest    string `json:"digest"`
-		Size      int    `json:"size"`
-	} `json:"config"`
-	Layers []struct {
-		MediaType string `json:"mediaType"`
-		Digest    string `json:"digest"`
-		Size      int    `json:"size"`
-	} `json:"layers"`
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        OCIDescriptor     `json:"config"`
+	Layers        []OCIDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIDescriptor references a blob in the registry
+type OCIDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // FileSystemLayer represents a layer in the image that contains filesystem data
@@ -53,7 +97,7 @@ type FileInfo struct {
 }
 
 // Create a variable to satisfy the interface check
-var _ ObjectStorage = (*DockerRegistryObjectStorage)(nil)
+var _ ListableObjectStorage = (*DockerRegistryObjectStorage)(nil)
 
 // NewDockerRegistryObjectStorage creates a new instance for accessing files from a Docker container
 func NewDockerRegistryObjectStorage(registryURL, repository, tag string) *DockerRegistryObjectStorage {
@@ -69,73 +113,214 @@ func NewDockerRegistryObjectStorage(registryURL, repository, tag string) *Docker
 		registryURL: registryURL,
 		repository:  repository,
 		tag:         tag,
+		client:      http.DefaultClient,
 		mu:          sync.Mutex{},
 	}
 }
 
-// getAuthToken fetches an authentication token for the Docker registry
+// SetCredentials sets the username and password used when the registry asks for
+// authentication, either directly (Basic) or through a token service (Bearer).
+func (d *DockerRegistryObjectStorage) SetCredentials(username, password string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.username = username
+	d.password = password
+	d.authChecked = false
+	d.authHeader = ""
+}
+
+// getAuthToken checks whether the registry requires authentication and, if so,
+// resolves the Authorization header from the challenge it returns
 func (d *DockerRegistryObjectStorage) getAuthToken(ctx context.Context) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	// If we already have a token, just return
-	if d.token != "" {
+	// If we already checked, just return
+	if d.authChecked {
 		return nil
 	}
 
-	// For Docker Hub, get auth token from auth.docker.io
-	authURL := fmt.Sprintf("https://auth.docker.io/token?service=registry.docker.io&scope=repository:%s:pull", d.repository)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.registryURL+"/v2/", nil)
 	if err != nil {
 		return fmt.Errorf("failed to create auth request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := d.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to request auth token: %w", err)
+		return fmt.Errorf("failed to contact registry: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		d.authChecked = true
+		return nil
+	}
+
+	scheme, params := parseAuthChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if d.username == "" {
+			return fmt.Errorf("registry requires credentials")
+		}
+		d.authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte(d.username+":"+d.password))
+	case "bearer":
+		token, err := d.fetchToken(ctx, params)
+		if err != nil {
+			return err
+		}
+		d.authHeader = "Bearer " + token
+	default:
+		return fmt.Errorf("unsupported registry authentication scheme: %q", scheme)
+	}
+
+	d.authChecked = true
+	return nil
+}
+
+// fetchToken requests a bearer token from the token service named in the challenge
+func (d *DockerRegistryObjectStorage) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry auth challenge is missing the realm")
+	}
+
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull,push", d.repository))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create auth request: %w", err)
+	}
+	if d.username != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request auth token: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("authentication failed with status: %s", resp.Status)
+		return "", fmt.Errorf("authentication failed with status: %s", resp.Status)
 	}
 
 	var authResp struct {
-		Token string `json:"token"`
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		return fmt.Errorf("failed to decode auth response: %w", err)
+		return "", fmt.Errorf("failed to decode auth response: %w", err)
 	}
 
-	d.token = authResp.Token
-	return nil
+	if authResp.Token != "" {
+		return authResp.Token, nil
+	}
+	return authResp.AccessToken, nil
 }
 
-// getManifest gets the image manifest from the registry
-func (d *DockerRegistryObjectStorage) getManifest(ctx context.Context) (*DockerManifest, error) {
-	// Make sure we have an auth token
+// parseAuthChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseAuthChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+
+	for _, part := range strings.Split(rest, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	return scheme, params
+}
+
+// request performs an authenticated request against the registry API. Tokens
+// expire, so a 401 response resolves the authorization again and the request
+// is retried once when its body can be replayed. Bodies that are io.Seekers
+// are rewound for the retry.
+func (d *DockerRegistryObjectStorage) request(ctx context.Context, method, target string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	if strings.HasPrefix(target, "/") {
+		target = d.registryURL + target
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if seeker, ok := body.(io.ReadSeeker); ok && req.GetBody == nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(seeker), nil
+		}
+	}
+
+	resp, err := d.send(ctx, req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if body != nil && req.GetBody == nil {
+		// A streamed body has been consumed
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	d.mu.Lock()
+	d.authChecked = false
+	d.authHeader = ""
+	d.mu.Unlock()
+
+	retry := req.Clone(ctx)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("failed to replay request: %w", err)
+		}
+	}
+	return d.send(ctx, retry)
+}
+
+// send sets the Authorization header, resolving it first when needed, and
+// performs the request
+func (d *DockerRegistryObjectStorage) send(ctx context.Context, req *http.Request) (*http.Response, error) {
 	if err := d.getAuthToken(ctx); err != nil {
 		return nil, err
 	}
 
-	// Request the manifest for the specified image and tag
-	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", d.registryURL, d.repository, d.tag)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create manifest request: %w", err)
+	d.mu.Lock()
+	if d.authHeader != "" {
+		req.Header.Set("Authorization", d.authHeader)
+	} else {
+		req.Header.Del("Authorization")
 	}
+	d.mu.Unlock()
 
-	// Add authentication and accept headers
-	req.Header.Add("Authorization", "Bearer "+d.token)
-	req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	return d.client.Do(req)
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// getManifest gets the image manifest from the registry. A nil manifest is
+// returned when the tag does not exist yet.
+func (d *DockerRegistryObjectStorage) getManifest(ctx context.Context) (*DockerManifest, error) {
+	// Request the manifest for the specified image and tag
+	manifestURL := fmt.Sprintf("/v2/%s/manifests/%s", d.repository, d.tag)
+	resp, err := d.request(ctx, http.MethodGet, manifestURL, nil, map[string]string{
+		"Accept": MediaTypeOCIManifest + ", " + MediaTypeDockerManifest,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get manifest: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("failed to get manifest with status: %s", resp.Status)
 	}
@@ -148,207 +333,593 @@ func (d *DockerRegistryObjectStorage) getManifest(ctx context.Context) (*DockerM
 	return &manifest, nil
 }
 
-// Download implements ObjectStorage.Download.
-// Retrieves a file from the container image.
-func (d *DockerRegistryObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
-	// Validate the key
-	if err := ValidateKey(key); err != nil {
-		return nil, err
+// putManifest pushes the manifest and tags it
+func (d *DockerRegistryObjectStorage) putManifest(ctx context.Context, manifest *DockerManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
 	}
 
-	// Get the manifest for the image
+	manifestURL := fmt.Sprintf("/v2/%s/manifests/%s", d.repository, d.tag)
+	resp, err := d.request(ctx, http.MethodPut, manifestURL, bytes.NewReader(data), map[string]string{
+		"Content-Type": MediaTypeOCIManifest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to put manifest with status: %s", resp.Status)
+	}
+	return nil
+}
+
+// artifactManifest returns the current manifest for writing, or a new empty
+// artifact manifest when the tag does not exist yet
+func (d *DockerRegistryObjectStorage) artifactManifest(ctx context.Context) (*DockerManifest, error) {
 	manifest, err := d.getManifest(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get container manifest: %w", err)
+		return nil, err
 	}
 
-	// Now we need to:
-	// 1. Determine which layer might contain our file
-	// 2. Download that layer
-	// 3. Extract only the requested file
+	if manifest == nil {
+		if err := d.ensureBlob(ctx, ociEmptyConfigDigest, ociEmptyConfig); err != nil {
+			return nil, err
+		}
+		return &DockerManifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeOCIManifest,
+			ArtifactType:  MediaTypeFilesArtifact,
+			Config: OCIDescriptor{
+				MediaType: MediaTypeOCIEmpty,
+				Digest:    ociEmptyConfigDigest,
+				Size:      int64(len(ociEmptyConfig)),
+			},
+			Layers: []OCIDescriptor{},
+		}, nil
+	}
 
-	// For container images, we would need to sequentially check layers
-	// from bottom to top since later layers can override earlier ones.
-	// This is a simplified approach.
+	for _, layer := range manifest.Layers {
+		if layer.Annotations[AnnotationTitle] == "" {
+			return nil, fmt.Errorf("%s:%s is a container image and cannot be modified", d.repository, d.tag)
+		}
+	}
 
-	// Make sure we have a token
-	if err := d.getAuthToken(ctx); err != nil {
-		return nil, err
+	manifest.MediaType = MediaTypeOCIManifest
+	return manifest, nil
+}
+
+// blobExists checks if a blob is already in the repository
+func (d *DockerRegistryObjectStorage) blobExists(ctx context.Context, digest string) (bool, error) {
+	resp, err := d.request(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/blobs/%s", d.repository, digest), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// ensureBlob pushes a small in-memory blob if the repository does not have it
+func (d *DockerRegistryObjectStorage) ensureBlob(ctx context.Context, digest string, data []byte) error {
+	exists, err := d.blobExists(ctx, digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, _, err = d.pushBlob(ctx, bytes.NewReader(data))
+	return err
+}
+
+// pushBlob uploads the data to the registry with an upload session and returns
+// the digest and size of the blob. The data is spooled to a temporary file
+// first, so the upload can be sent again when the token expires.
+func (d *DockerRegistryObjectStorage) pushBlob(ctx context.Context, data io.Reader) (string, int64, error) {
+	spool, err := os.CreateTemp("", "cloudy-blob-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to spool blob: %w", err)
 	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
 
-	// In a real implementation, we would examine each layer from top to bottom
-	// Since this is a demonstration, we'll just return information about what we would do
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), data)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to spool blob: %w", err)
+	}
+	digest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
 
-	// Get the topmost layer (most recent changes)
-	if len(manifest.Layers) > 0 {
-		layer := manifest.Layers[len(manifest.Layers)-1]
+	// Start the upload session
+	resp, err := d.request(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", d.repository), nil, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to start blob upload: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", 0, fmt.Errorf("failed to start blob upload with status: %s", resp.Status)
+	}
+	location, err := d.resolveLocation(resp)
+	if err != nil {
+		return "", 0, err
+	}
 
-		// The URL to download the layer blob would be:
-		blobURL := fmt.Sprintf("%s/v2/%s/blobs/%s", d.registryURL, d.repository, layer.Digest)
+	// The section hides Close, the transport must not close the spool
+	resp, err = d.request(ctx, http.MethodPatch, location, io.NewSectionReader(spool, 0, size), map[string]string{
+		"Content-Type": "application/octet-stream",
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to upload blob: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		return "", 0, fmt.Errorf("failed to upload blob with status: %s", resp.Status)
+	}
+	if resp.Header.Get("Location") != "" {
+		if location, err = d.resolveLocation(resp); err != nil {
+			return "", 0, err
+		}
+	}
 
-		// Let the caller know this is a stub implementation
-		message := fmt.Sprintf("Would download layer %s from %s and extract file %s",
-			layer.Digest, blobURL, key)
-		return io.NopCloser(bytes.NewReader([]byte(message))), nil
+	// Complete the upload
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+	resp, err = d.request(ctx, http.MethodPut, location+separator+"digest="+url.QueryEscape(digest), nil, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to complete blob upload: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", 0, fmt.Errorf("failed to complete blob upload with status: %s", resp.Status)
 	}
 
-	// If we get here, we didn't find the file in any layer
-	return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	return digest, size, nil
 }
 
-// List implements ObjectStorage.List.
-// Lists files in the container image matching the given prefix.
-func (d *DockerRegistryObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
-	var objects []*StoredObject
-	var prefixes []*StoredPrefix
-	prefixesMap := make(map[string]bool) // Track unique prefixes
+// resolveLocation resolves the upload Location header against the registry URL
+func (d *DockerRegistryObjectStorage) resolveLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("registry did not return an upload location")
+	}
 
-	// Get the manifest for the image
-	manifest, err := d.getManifest(ctx)
+	base, err := url.Parse(d.registryURL + "/")
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get container manifest: %w", err)
+		return "", err
 	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %q: %w", location, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
 
-	// Make sure we have a token
-	if err := d.getAuthToken(ctx); err != nil {
+// getBlob opens a blob for reading
+func (d *DockerRegistryObjectStorage) getBlob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	resp, err := d.request(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", d.repository, digest), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob %s: %w", digest, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to get blob %s with status: %s", digest, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// findLayer returns the artifact layer holding the key
+func findLayer(manifest *DockerManifest, key string) (int, *OCIDescriptor) {
+	if manifest == nil {
+		return -1, nil
+	}
+	for i := range manifest.Layers {
+		if manifest.Layers[i].Annotations[AnnotationTitle] == key {
+			return i, &manifest.Layers[i]
+		}
+	}
+	return -1, nil
+}
+
+// isImage reports whether the manifest describes a container image rather than
+// a file artifact
+func isImage(manifest *DockerManifest) bool {
+	for _, layer := range manifest.Layers {
+		if layer.Annotations[AnnotationTitle] == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// layerAnnotations converts object tags into layer annotations
+func layerAnnotations(key string, tags map[string]string) map[string]string {
+	annotations := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		annotations[k] = v
+	}
+	annotations[AnnotationTitle] = key
+	return annotations
+}
+
+// layerTags converts layer annotations back into object tags
+func layerTags(annotations map[string]string) map[string]string {
+	tags := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		if k != AnnotationTitle {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// imageKey normalizes a key for lookups inside container image layers
+func imageKey(key string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(key, "./"), "/")
+}
+
+// openLayer opens a filesystem layer as a tar stream
+func (d *DockerRegistryObjectStorage) openLayer(ctx context.Context, layer OCIDescriptor) (*tar.Reader, io.Closer, error) {
+	blob, err := d.getBlob(ctx, layer.Digest)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	// In a real implementation, we would need to:
-	// 1. Download each layer (which is a tar.gz file)
-	// 2. Extract the file listing from each layer
-	// 3. Build a composite view by overlaying later layers on top of earlier ones
-
-	// For demonstration purposes, we'll return a sample structure based on common paths
-	// in container images, filtered by the prefix parameter
-
-	// We'll simulate finding these common paths in Linux containers
-	commonPaths := []struct {
-		path  string
-		size  int64
-		isDir bool
-	}{
-		{"/bin", 4096, true},
-		{"/bin/bash", 1156040, false},
-		{"/bin/ls", 142144, false},
-		{"/etc", 4096, true},
-		{"/etc/passwd", 1730, false},
-		{"/etc/hosts", 176, false},
-		{"/usr", 4096, true},
-		{"/usr/bin", 4096, true},
-		{"/usr/bin/curl", 248744, false},
-		{"/usr/lib", 4096, true},
-		{"/var", 4096, true},
-		{"/var/log", 4096, true},
-	}
-
-	// Filter by prefix and organize into objects and prefixes
-	for _, entry := range commonPaths {
-		if !strings.HasPrefix(entry.path, prefix) {
-			continue
+	switch {
+	case strings.HasSuffix(layer.MediaType, "gzip"):
+		gz, err := gzip.NewReader(blob)
+		if err != nil {
+			blob.Close()
+			return nil, nil, fmt.Errorf("failed to decompress layer %s: %w", layer.Digest, err)
 		}
+		return tar.NewReader(gz), blob, nil
+	case strings.HasSuffix(layer.MediaType, ".tar"):
+		return tar.NewReader(blob), blob, nil
+	default:
+		blob.Close()
+		return nil, nil, fmt.Errorf("unsupported layer media type: %s", layer.MediaType)
+	}
+}
 
-		// Skip the prefix itself
-		if entry.path == prefix {
-			continue
+// imageFiles overlays the filesystem layers of a container image, applying
+// whiteouts, and returns the files it contains
+func (d *DockerRegistryObjectStorage) imageFiles(ctx context.Context, manifest *DockerManifest) (map[string]*StoredObject, error) {
+	files := make(map[string]*StoredObject)
+
+	for _, layer := range manifest.Layers {
+		reader, closer, err := d.openLayer(ctx, layer)
+		if err != nil {
+			return nil, err
 		}
 
-		// Calculate relative path from prefix
-		relPath := strings.TrimPrefix(entry.path, prefix)
-		// Always trim any leading slash
-		relPath = strings.TrimPrefix(relPath, "/")
+		// Whiteouts only hide files of lower layers, the layer's own files are
+		// added once it has been read
+		added := make(map[string]*StoredObject)
+		for {
+			header, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				closer.Close()
+				return nil, fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+			}
 
-		parts := strings.Split(relPath, "/")
+			name := imageKey(header.Name)
+			dir, base := path.Split(name)
+			switch {
+			case base == WhiteoutOpaque:
+				// Opaque directory: hide everything from lower layers
+				for key := range files {
+					if strings.HasPrefix(key, dir) {
+						delete(files, key)
+					}
+				}
+			case strings.HasPrefix(base, WhiteoutPrefix):
+				removed := dir + strings.TrimPrefix(base, WhiteoutPrefix)
+				for key := range files {
+					if key == removed || strings.HasPrefix(key, removed+"/") {
+						delete(files, key)
+					}
+				}
+			case header.Typeflag == tar.TypeReg:
+				added[name] = &StoredObject{
+					Key:  name,
+					Size: header.Size,
+					Tags: map[string]string{},
+				}
+			}
+		}
+		closer.Close()
+		maps.Copy(files, added)
+	}
 
-		if entry.isDir || len(parts) > 1 {
-			// This is a directory or has subdirectories
-			prefixKey := prefix
-			if !strings.HasSuffix(prefix, "/") {
-				prefixKey += "/"
+	return files, nil
+}
+
+// openImageFile finds a file in a container image by searching the layers from
+// the top down. Only the layers above the one holding the file are read.
+func (d *DockerRegistryObjectStorage) openImageFile(ctx context.Context, manifest *DockerManifest, key string) (io.ReadCloser, error) {
+	key = imageKey(key)
+
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		reader, closer, err := d.openLayer(ctx, manifest.Layers[i])
+		if err != nil {
+			return nil, err
+		}
+
+		opaque := false
+		for {
+			header, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				closer.Close()
+				return nil, fmt.Errorf("failed to read layer %s: %w", manifest.Layers[i].Digest, err)
 			}
-			prefixKey += parts[0] + "/"
 
-			if !prefixesMap[prefixKey] {
-				prefixes = append(prefixes, &StoredPrefix{Key: prefixKey})
-				prefixesMap[prefixKey] = true
+			name := imageKey(header.Name)
+			dir, base := path.Split(name)
+			if name == key && header.Typeflag == tar.TypeReg {
+				return &layerFileReader{Reader: io.LimitReader(reader, header.Size), closer: closer}, nil
+			}
+			if base == WhiteoutOpaque && strings.HasPrefix(key, dir) {
+				opaque = true
+			}
+			if strings.HasPrefix(base, WhiteoutPrefix) {
+				removed := dir + strings.TrimPrefix(base, WhiteoutPrefix)
+				if key == removed || strings.HasPrefix(key, removed+"/") {
+					closer.Close()
+					return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+				}
 			}
-		} else if !entry.isDir && len(parts) == 1 {
-			// This is a file directly in the requested prefix
-			objects = append(objects, &StoredObject{
-				Key:  entry.path,
-				Size: entry.size,
-				Tags: make(map[string]string),
-			})
+		}
+		closer.Close()
+
+		if opaque {
+			break
 		}
 	}
 
-	// Include metadata about the image itself
-	objects = append(objects, &StoredObject{
-		Key:  "/.docker_image_info",
-		Size: 1024,
-		Tags: map[string]string{
-			"repository": d.repository,
-			"tag":        d.tag,
-			"layers":     fmt.Sprintf("%d", len(manifest.Layers)),
-		},
-	})
+	return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+}
+
+// layerFileReader streams a single file out of a layer and closes the layer when done
+type layerFileReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (l *layerFileReader) Close() error {
+	return l.closer.Close()
+}
 
+// Download implements ObjectStorage.Download.
+// For artifacts only the blob holding the file is fetched.
+func (d *DockerRegistryObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	// Get the manifest for the image
+	manifest, err := d.getManifest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container manifest: %w", err)
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	if _, layer := findLayer(manifest, key); layer != nil {
+		return d.getBlob(ctx, layer.Digest)
+	}
+
+	if isImage(manifest) {
+		return d.openImageFile(ctx, manifest, key)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+}
+
+// List implements ObjectStorage.List.
+// Lists the files directly under the prefix along with the "/" delimited sub-prefixes.
+// Listing a container image reads all of its layers.
+func (d *DockerRegistryObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
+	all, image, err := d.storedObjects(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if image {
+		prefix = imageKey(prefix)
+	}
+
+	objects, prefixes := ListDelimited(all, prefix)
 	return objects, prefixes, nil
 }
 
-// The following methods are required by the ObjectStorage interface
-// but are not implemented for read-only access
+// ListItems implements ListableObjectStorage.ListItems.
+// Listing a container image reads all of its layers.
+func (d *DockerRegistryObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	if options == nil {
+		options = &ListItemsOptions{}
+	}
+
+	all, image, err := d.storedObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := *options
+	if image {
+		opts.Prefix = imageKey(opts.Prefix)
+	}
+	return PageObjects(all, &opts)
+}
+
+// storedObjects returns every file in the artifact or image, sorted by key, and
+// whether the reference is a container image
+func (d *DockerRegistryObjectStorage) storedObjects(ctx context.Context) ([]*StoredObject, bool, error) {
+	// Get the manifest for the image
+	manifest, err := d.getManifest(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get container manifest: %w", err)
+	}
+	if manifest == nil {
+		return nil, false, nil
+	}
+
+	var all []*StoredObject
+	image := isImage(manifest)
+	if image {
+		files, err := d.imageFiles(ctx, manifest)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, file := range files {
+			all = append(all, file)
+		}
+	} else {
+		for _, layer := range manifest.Layers {
+			all = append(all, &StoredObject{
+				Key:  layer.Annotations[AnnotationTitle],
+				Size: layer.Size,
+				Tags: layerTags(layer.Annotations),
+			})
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Key < all[j].Key })
+	return all, image, nil
+}
 
 // Upload implements ObjectStorage.Upload.
-// Not implemented for Docker registry.
+// The content is pushed as a new layer and the tag is moved to a new manifest
+// that references it. Tags are stored as layer annotations.
 func (d *DockerRegistryObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
-	return fmt.Errorf("upload operation not supported for Docker registry storage")
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	digest, size, err := d.pushBlob(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	manifest, err := d.artifactManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	layer := OCIDescriptor{
+		MediaType:   MediaTypeOCILayer,
+		Digest:      digest,
+		Size:        size,
+		Annotations: layerAnnotations(key, tags),
+	}
+
+	if i, _ := findLayer(manifest, key); i >= 0 {
+		manifest.Layers[i] = layer
+	} else {
+		manifest.Layers = append(manifest.Layers, layer)
+	}
+
+	return d.putManifest(ctx, manifest)
 }
 
 // Delete implements ObjectStorage.Delete.
-// Not implemented for Docker registry.
+// The layer is removed from the manifest. The blob itself is left for the
+// registry's garbage collection.
 func (d *DockerRegistryObjectStorage) Delete(ctx context.Context, key string) error {
-	return fmt.Errorf("delete operation not supported for Docker registry storage")
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	manifest, err := d.getManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	i, _ := findLayer(manifest, key)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	if isImage(manifest) {
+		return fmt.Errorf("%s:%s is a container image and cannot be modified", d.repository, d.tag)
+	}
+
+	manifest.MediaType = MediaTypeOCIManifest
+	manifest.Layers = append(manifest.Layers[:i], manifest.Layers[i+1:]...)
+	return d.putManifest(ctx, manifest)
 }
 
 // UpdateMetadata implements ObjectStorage.UpdateMetadata.
-// Not implemented for Docker registry.
+// The annotations of the layer are replaced and a new manifest is pushed.
 func (d *DockerRegistryObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
-	return fmt.Errorf("update metadata operation not supported for Docker registry storage")
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	manifest, err := d.getManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	i, _ := findLayer(manifest, key)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	if isImage(manifest) {
+		return fmt.Errorf("%s:%s is a container image and cannot be modified", d.repository, d.tag)
+	}
+
+	manifest.MediaType = MediaTypeOCIManifest
+	manifest.Layers[i].Annotations = layerAnnotations(key, tags)
+	return d.putManifest(ctx, manifest)
 }
 
 // Exists implements ObjectStorage.Exists.
-// Checks if a file exists in the container image.
+// Checks if a file exists in the artifact or container image.
 func (d *DockerRegistryObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
 	// Validate the key
 	if err := ValidateKey(key); err != nil {
 		return false, err
 	}
 
-	// For a simple implementation, we can use List to check if our file exists
-	// This avoids implementing the full layer extraction logic again
-	objects, _, err := d.List(ctx, path.Dir(key))
+	manifest, err := d.getManifest(ctx)
 	if err != nil {
 		return false, fmt.Errorf("failed to check existence: %w", err)
 	}
-
-	// Look for matching file
-	for _, obj := range objects {
-		if obj.Key == key {
-			return true, nil
-		}
+	if manifest == nil {
+		return false, nil
 	}
 
-	// Special handling for the metadata file
-	if key == "/.docker_image_info" {
+	if _, layer := findLayer(manifest, key); layer != nil {
 		return true, nil
 	}
+	if !isImage(manifest) {
+		return false, nil
+	}
 
-	// For a complete implementation, we would need to:
-	// 1. Get the manifest
-	// 2. Download each layer
-	// 3. Extract file listings
-	// 4. Check for the presence of the specific file
-
-	return false, nil
+	reader, err := d.openImageFile(ctx, manifest, key)
+	if errors.Is(err, ErrFileNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check existence: %w", err)
+	}
+	reader.Close()
+	return true, nil
 }
@@ -1,9 +1,19 @@
 package storage
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestDockerRegistryObjectStorage_Usage demonstrates how to use the Docker registry storage
@@ -57,8 +67,8 @@ func TestDockerRegistryObjectStorage_Usage(t *testing.T) {
 		}
 	}
 
-	// Example 4: Try to use unsupported operations
-	err = storage.Upload(ctx, "/test", nil, nil)
+	// Example 4: Container images cannot be modified, only artifacts can
+	err = storage.Upload(ctx, "/test", strings.NewReader("test"), nil)
 	if err != nil {
 		t.Logf("Expected error for Upload: %v", err)
 	}
@@ -95,3 +105,218 @@ func TestDockerRegistryObjectStorage_Errors(t *testing.T) {
 		t.Errorf("Expected ErrEmptyKey error, got: %v", err)
 	}
 }
+
+func TestDockerRegistryObjectStorage_Artifact(t *testing.T) {
+	stub := newRegistryStub()
+	defer stub.Close()
+
+	ctx := context.Background()
+	storage := NewDockerRegistryObjectStorage(stub.URL(), "team/files", "v1")
+
+	// Nothing has been pushed yet
+	objects, prefixes, err := storage.List(ctx, "")
+	require.Nil(t, err)
+	assert.Empty(t, objects)
+	assert.Empty(t, prefixes)
+
+	exists, err := storage.Exists(ctx, "scripts/setup.sh")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	// Upload
+	err = storage.Upload(ctx, "scripts/setup.sh", strings.NewReader("#!/bin/sh"), map[string]string{"os": "linux"})
+	require.Nil(t, err)
+	err = storage.Upload(ctx, "installer.msi", strings.NewReader("binary"), nil)
+	require.Nil(t, err)
+
+	objects, prefixes, err = storage.List(ctx, "")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "installer.msi", objects[0].Key)
+	assert.Equal(t, int64(6), objects[0].Size)
+	require.Len(t, prefixes, 1)
+	assert.Equal(t, "scripts/", prefixes[0].Key)
+
+	objects, _, err = storage.List(ctx, "scripts/")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, map[string]string{"os": "linux"}, objects[0].Tags)
+
+	// Downloading a single file only fetches its blob
+	stub.blobGets = 0
+	reader, err := storage.Download(ctx, "scripts/setup.sh")
+	require.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	assert.Equal(t, "#!/bin/sh", string(data))
+	assert.Equal(t, 1, stub.blobGets)
+
+	// Overwrite
+	err = storage.Upload(ctx, "scripts/setup.sh", strings.NewReader("#!/bin/bash"), nil)
+	require.Nil(t, err)
+	reader, err = storage.Download(ctx, "scripts/setup.sh")
+	require.Nil(t, err)
+	data, err = io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	assert.Equal(t, "#!/bin/bash", string(data))
+
+	// Metadata
+	err = storage.UpdateMetadata(ctx, "installer.msi", map[string]string{"version": "2.0"})
+	require.Nil(t, err)
+	objects, _, err = storage.List(ctx, "")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "2.0", objects[0].Tags["version"])
+
+	result, err := storage.ListItems(ctx, &ListItemsOptions{Recursive: true, Tags: map[string]string{"version": "2.0"}})
+	require.Nil(t, err)
+	require.Len(t, result.Objects, 1)
+	assert.Equal(t, "installer.msi", result.Objects[0].Key)
+	assert.Empty(t, result.Prefixes)
+
+	// The manifest is a valid OCI artifact
+	manifest, err := storage.getManifest(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, MediaTypeOCIManifest, manifest.MediaType)
+	assert.Equal(t, MediaTypeOCIEmpty, manifest.Config.MediaType)
+	assert.Len(t, manifest.Layers, 2)
+
+	// Delete
+	err = storage.Delete(ctx, "installer.msi")
+	require.Nil(t, err)
+	exists, err = storage.Exists(ctx, "installer.msi")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	err = storage.Delete(ctx, "installer.msi")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+	_, err = storage.Download(ctx, "installer.msi")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestDockerRegistryObjectStorage_Auth(t *testing.T) {
+	stub := newRegistryStub()
+	stub.token = "secret-token"
+	defer stub.Close()
+
+	ctx := context.Background()
+	storage := NewDockerRegistryObjectStorage(stub.URL(), "team/files", "latest")
+
+	err := storage.Upload(ctx, "a.txt", strings.NewReader("a"), nil)
+	require.Nil(t, err)
+
+	exists, err := storage.Exists(ctx, "a.txt")
+	require.Nil(t, err)
+	assert.True(t, exists)
+
+	// An expired token is replaced
+	stub.setToken("rotated-token")
+	err = storage.Upload(ctx, "b.txt", strings.NewReader("b"), nil)
+	require.Nil(t, err)
+	exists, err = storage.Exists(ctx, "b.txt")
+	require.Nil(t, err)
+	assert.True(t, exists)
+
+	// The token can expire while a streamed blob is uploaded
+	stub.mu.Lock()
+	stub.rotateOn = http.MethodPatch
+	stub.mu.Unlock()
+	err = storage.Upload(ctx, "c.txt", io.MultiReader(strings.NewReader("streamed")), nil)
+	require.Nil(t, err)
+	reader, err := storage.Download(ctx, "c.txt")
+	require.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "streamed", string(data))
+}
+
+func buildLayer(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		content := files[name]
+		require.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.Nil(t, err)
+	}
+	require.Nil(t, tw.Close())
+	require.Nil(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestDockerRegistryObjectStorage_Image(t *testing.T) {
+	stub := newRegistryStub()
+	defer stub.Close()
+
+	base := buildLayer(t, map[string]string{
+		"etc/passwd":       "root:x:0:0",
+		"etc/hosts":        "127.0.0.1 localhost",
+		"usr/bin/tool":     "v1",
+		"var/log/old.log":  "old",
+		"opt/app/old.conf": "old",
+	})
+	// The layer's own file is written before the opaque marker
+	top := buildLayer(t, map[string]string{
+		"usr/bin/tool":         "v2",
+		"etc/.wh.hosts":        "",
+		"var/log/.wh.old.log":  "",
+		"opt/app/.env":         "new",
+		"opt/app/.wh..wh..opq": "",
+	})
+
+	config := stub.putBlob([]byte("{}"))
+	manifest := &DockerManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerManifest,
+		Config:        OCIDescriptor{MediaType: "application/vnd.docker.container.image.v1+json", Digest: config, Size: 2},
+		Layers: []OCIDescriptor{
+			{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Digest: stub.putBlob(base), Size: int64(len(base))},
+			{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: stub.putBlob(top), Size: int64(len(top))},
+		},
+	}
+	stub.putManifest("library/test", "latest", manifest)
+
+	ctx := context.Background()
+	storage := NewDockerRegistryObjectStorage(stub.URL(), "library/test", "latest")
+
+	objects, _, err := storage.List(ctx, "/etc/")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "etc/passwd", objects[0].Key)
+
+	// An opaque directory hides only the files of lower layers
+	objects, _, err = storage.List(ctx, "/opt/app/")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "opt/app/.env", objects[0].Key)
+
+	_, prefixes, err := storage.List(ctx, "")
+	require.Nil(t, err)
+	assert.Len(t, prefixes, 3) // etc/, opt/ and usr/
+
+	// The file in the top layer wins and only that layer is read
+	stub.blobGets = 0
+	reader, err := storage.Download(ctx, "/usr/bin/tool")
+	require.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	assert.Equal(t, "v2", string(data))
+	assert.Equal(t, 1, stub.blobGets)
+
+	exists, err := storage.Exists(ctx, "etc/hosts")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	exists, err = storage.Exists(ctx, "etc/passwd")
+	require.Nil(t, err)
+	assert.True(t, exists)
+
+	// Images are read-only
+	err = storage.Upload(ctx, "new.txt", strings.NewReader("x"), nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// registryStub is a minimal in-memory implementation of the OCI distribution API
+// used to test the registry object storage without network access
+type registryStub struct {
+	mu        sync.Mutex
+	token     string // When set, requests must carry "Bearer <token>"
+	rotateOn  string // The next request with this method rotates the token first
+	blobs     map[string][]byte
+	uploads   map[string]*bytes.Buffer
+	manifests map[string][]byte // repository:reference -> manifest
+	blobGets  int
+	nextID    int
+	server    *httptest.Server
+}
+
+func newRegistryStub() *registryStub {
+	stub := &registryStub{
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+		manifests: make(map[string][]byte),
+	}
+	stub.server = httptest.NewServer(stub)
+	return stub
+}
+
+func (s *registryStub) Close() {
+	s.server.Close()
+}
+
+func (s *registryStub) URL() string {
+	return s.server.URL
+}
+
+func stubDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// putBlob stores a blob directly and returns its digest
+func (s *registryStub) putBlob(data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest := stubDigest(data)
+	s.blobs[digest] = data
+	return digest
+}
+
+// putManifest stores a manifest directly under a tag
+func (s *registryStub) putManifest(repository, tag string, manifest *DockerManifest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, _ := json.Marshal(manifest)
+	s.manifests[repository+":"+tag] = data
+}
+
+// setToken changes the token, e.g. to expire the one handed out before
+func (s *registryStub) setToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+func (s *registryStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.rotateOn != "" && r.Method == s.rotateOn {
+		s.rotateOn = ""
+		s.token += "-rotated"
+	}
+	token := s.token
+	s.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		return
+	}
+
+	if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="stub"`, s.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(p, "/blobs/uploads/"):
+		i := strings.LastIndex(p, "/blobs/uploads/")
+		s.serveUpload(w, r, p[:i], p[i+len("/blobs/uploads/"):])
+	case strings.Contains(p, "/blobs/"):
+		i := strings.LastIndex(p, "/blobs/")
+		s.serveBlob(w, r, p[i+len("/blobs/"):])
+	case strings.Contains(p, "/manifests/"):
+		i := strings.LastIndex(p, "/manifests/")
+		s.serveManifest(w, r, p[:i], p[i+len("/manifests/"):])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *registryStub) serveUpload(w http.ResponseWriter, r *http.Request, repository, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPost:
+		s.nextID++
+		id := fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		buf, ok := s.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.Copy(buf, r.Body)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		buf, ok := s.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.Copy(buf, r.Body)
+		digest := r.URL.Query().Get("digest")
+		if stubDigest(buf.Bytes()) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.blobs[digest] = buf.Bytes()
+		delete(s.uploads, id)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *registryStub) serveBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.blobs[digest]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		s.blobGets++
+		w.Write(data)
+	}
+}
+
+func (s *registryStub) serveManifest(w http.ResponseWriter, r *http.Request, repository, reference string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.manifests[repository+":"+reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", stubDigest(data))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		var manifest DockerManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Like a real registry, every referenced blob must exist
+		for _, desc := range append([]OCIDescriptor{manifest.Config}, manifest.Layers...) {
+			if _, ok := s.blobs[desc.Digest]; !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		digest := stubDigest(data)
+		s.manifests[repository+":"+reference] = data
+		s.manifests[repository+":"+digest] = data
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,649 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/appliedres/cloudy/secrets"
+)
+
+var _ ObjectStorage = (*EncryptedObjectStorage)(nil)
+
+// EncryptionKeyIDTag is the object tag that records which master key wraps the
+// data key of an encrypted object
+const EncryptionKeyIDTag = "encryption-key-id"
+
+// EncryptionSegmentSizeTag is the object tag that records the segment size used to
+// encrypt an object, which allows List to calculate plaintext sizes from tags alone
+const EncryptionSegmentSizeTag = "encryption-segment-size"
+
+// DefaultEncryptionSegmentSize is the amount of plaintext sealed in each segment
+const DefaultEncryptionSegmentSize = 64 * 1024
+
+const (
+	encMagic       = "CENC"
+	encVersion     = 1
+	encKeySize     = 32 // AES-256
+	encNonceSize   = 12
+	encPrefixSize  = 7
+	encTagSize     = 16
+	encWrappedSize = encNonceSize + encKeySize + encTagSize
+	encMaxSegment  = 16 * 1024 * 1024
+
+	// magic + version + segment size + nonce prefix + key id length + wrapped key length + wrapped key
+	encFixedHeaderSize = len(encMagic) + 1 + 4 + encPrefixSize + 2 + 2 + encWrappedSize
+)
+
+var (
+	ErrMasterKeyNotFound = errors.New("master key not found")
+	ErrDecryptionFailed  = errors.New("decryption failed")
+)
+
+// EncryptionOptions controls the encrypted storage format
+type EncryptionOptions struct {
+	SegmentSize int // Plaintext bytes per sealed segment (default 64KB)
+}
+
+// EncryptedObjectStorage is an ObjectStorage wrapper that encrypts content on the
+// client side before it reaches the underlying store.
+//
+// Each object is encrypted with its own random AES-256 data key using AES-GCM in
+// fixed size segments, so objects are streamed rather than held in memory. Every
+// segment nonce carries a counter and a final-segment flag which detects reordered,
+// dropped or truncated segments. The data key is wrapped by a master key loaded from
+// the SecretProvider and stored in a small header in front of the ciphertext. The id
+// of the master key is also recorded in the EncryptionKeyIDTag tag so that backends
+// which keep tags can be listed without reading any content.
+//
+// Master keys are never deleted by this wrapper. After Rotate the previous master
+// keys remain in the SecretProvider and can be removed once rotation has finished.
+type EncryptedObjectStorage struct {
+	store       ObjectStorage
+	keys        secrets.SecretProvider
+	keyID       string
+	segmentSize int
+
+	mu         sync.RWMutex
+	masterKeys map[string][]byte
+}
+
+// NewEncryptedObjectStorage creates an encrypting wrapper around the store. New
+// objects are encrypted under the master key stored in the SecretProvider as keyID.
+func NewEncryptedObjectStorage(store ObjectStorage, keys secrets.SecretProvider, keyID string, opts *EncryptionOptions) *EncryptedObjectStorage {
+	segmentSize := DefaultEncryptionSegmentSize
+	if opts != nil && opts.SegmentSize > 0 && opts.SegmentSize <= encMaxSegment {
+		segmentSize = opts.SegmentSize
+	}
+
+	return &EncryptedObjectStorage{
+		store:       store,
+		keys:        keys,
+		keyID:       keyID,
+		segmentSize: segmentSize,
+		masterKeys:  make(map[string][]byte),
+	}
+}
+
+// GenerateMasterKey creates a random AES-256 master key and saves it in the provider
+func GenerateMasterKey(ctx context.Context, keys secrets.SecretProvider, keyID string) error {
+	key := make([]byte, encKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return fmt.Errorf("failed to generate master key: %w", err)
+	}
+	return keys.SaveSecretBinary(ctx, keyID, key)
+}
+
+// KeyID returns the id of the master key used for new objects
+func (e *EncryptedObjectStorage) KeyID() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.keyID
+}
+
+func (e *EncryptedObjectStorage) masterKey(ctx context.Context, keyID string) ([]byte, error) {
+	e.mu.RLock()
+	key, ok := e.masterKeys[keyID]
+	e.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	key, err := e.keys.GetSecretBinary(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load master key %s: %w", keyID, err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMasterKeyNotFound, keyID)
+	}
+	if len(key) != encKeySize {
+		return nil, fmt.Errorf("master key %s must be %d bytes, got %d", keyID, encKeySize, len(key))
+	}
+
+	e.mu.Lock()
+	e.masterKeys[keyID] = key
+	e.mu.Unlock()
+	return key, nil
+}
+
+// wrapKey seals the data key with the master key, binding it to the key id
+func (e *EncryptedObjectStorage) wrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	master, err := e.masterKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, encNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+func (e *EncryptedObjectStorage) unwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	master, err := e.masterKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := aead.Open(nil, wrapped[:encNonceSize], wrapped[encNonceSize:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to unwrap data key with %s", ErrDecryptionFailed, keyID)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encHeader is the plaintext header written in front of every encrypted object
+type encHeader struct {
+	SegmentSize int
+	NoncePrefix []byte
+	KeyID       string
+	WrappedKey  []byte
+}
+
+func (h *encHeader) size() int {
+	return encFixedHeaderSize + len(h.KeyID)
+}
+
+func (h *encHeader) marshal() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, h.size()))
+	buf.WriteString(encMagic)
+	buf.WriteByte(encVersion)
+	_ = binary.Write(buf, binary.BigEndian, uint32(h.SegmentSize))
+	buf.Write(h.NoncePrefix)
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(h.KeyID)))
+	buf.WriteString(h.KeyID)
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(h.WrappedKey)))
+	buf.Write(h.WrappedKey)
+	return buf.Bytes()
+}
+
+func readEncHeader(reader io.Reader) (*encHeader, error) {
+	fixed := make([]byte, len(encMagic)+1+4+encPrefixSize+2)
+	if _, err := io.ReadFull(reader, fixed); err != nil {
+		return nil, fmt.Errorf("%w: unable to read header: %v", ErrDecryptionFailed, err)
+	}
+	if string(fixed[:len(encMagic)]) != encMagic {
+		return nil, fmt.Errorf("%w: object is not encrypted", ErrDecryptionFailed)
+	}
+	if fixed[len(encMagic)] != encVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrDecryptionFailed, fixed[len(encMagic)])
+	}
+
+	pos := len(encMagic) + 1
+	h := &encHeader{
+		SegmentSize: int(binary.BigEndian.Uint32(fixed[pos:])),
+		NoncePrefix: append([]byte{}, fixed[pos+4:pos+4+encPrefixSize]...),
+	}
+	if h.SegmentSize <= 0 || h.SegmentSize > encMaxSegment {
+		return nil, fmt.Errorf("%w: invalid segment size", ErrDecryptionFailed)
+	}
+
+	keyID := make([]byte, binary.BigEndian.Uint16(fixed[pos+4+encPrefixSize:]))
+	if _, err := io.ReadFull(reader, keyID); err != nil {
+		return nil, fmt.Errorf("%w: unable to read key id: %v", ErrDecryptionFailed, err)
+	}
+	h.KeyID = string(keyID)
+
+	var wrappedLen uint16
+	if err := binary.Read(reader, binary.BigEndian, &wrappedLen); err != nil {
+		return nil, fmt.Errorf("%w: unable to read wrapped key: %v", ErrDecryptionFailed, err)
+	}
+	if wrappedLen != encWrappedSize {
+		return nil, fmt.Errorf("%w: invalid wrapped key", ErrDecryptionFailed)
+	}
+	h.WrappedKey = make([]byte, wrappedLen)
+	if _, err := io.ReadFull(reader, h.WrappedKey); err != nil {
+		return nil, fmt.Errorf("%w: unable to read wrapped key: %v", ErrDecryptionFailed, err)
+	}
+
+	return h, nil
+}
+
+// segmentNonce builds the nonce for a segment: prefix | counter | final flag
+func segmentNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, encNonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encPrefixSize:], counter)
+	if final {
+		nonce[encNonceSize-1] = 1
+	}
+	return nonce
+}
+
+// plaintextSize calculates the size of the plaintext from the size of the stored object
+func plaintextSize(storedSize int64, headerSize int, segmentSize int) int64 {
+	body := storedSize - int64(headerSize)
+	if body <= 0 {
+		return 0
+	}
+	sealed := int64(segmentSize + encTagSize)
+	segments := (body + sealed - 1) / sealed
+	return body - segments*encTagSize
+}
+
+// Upload implements ObjectStorage.
+// The content is encrypted as it is streamed to the underlying store.
+func (e *EncryptedObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	keyID := e.KeyID()
+	dataKey := make([]byte, encKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := e.wrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := &encHeader{
+		SegmentSize: e.segmentSize,
+		NoncePrefix: make([]byte, encPrefixSize),
+		KeyID:       keyID,
+		WrappedKey:  wrapped,
+	}
+	if _, err := io.ReadFull(rand.Reader, header.NoncePrefix); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	reader := &encryptReader{
+		source: bufio.NewReaderSize(data, e.segmentSize),
+		aead:   aead,
+		header: header,
+		plain:  make([]byte, e.segmentSize),
+	}
+
+	storedTags := copyTags(tags)
+	if storedTags == nil {
+		storedTags = make(map[string]string)
+	}
+	storedTags[EncryptionKeyIDTag] = keyID
+	storedTags[EncryptionSegmentSizeTag] = strconv.Itoa(e.segmentSize)
+
+	err = e.store.Upload(ctx, key, reader, storedTags)
+
+	closer, canClose := data.(io.ReadCloser)
+	if canClose {
+		_ = closer.Close()
+	}
+	return err
+}
+
+// Exists implements ObjectStorage.
+func (e *EncryptedObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
+	return e.store.Exists(ctx, key)
+}
+
+// Download implements ObjectStorage.
+// The returned reader decrypts and authenticates each segment as it is read.
+func (e *EncryptedObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	raw, err := e.store.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := readEncHeader(raw)
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	dataKey, err := e.unwrapKey(ctx, header.KeyID, header.WrappedKey)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	return &decryptReader{
+		raw:    raw,
+		source: bufio.NewReaderSize(raw, header.SegmentSize+encTagSize),
+		aead:   aead,
+		header: header,
+		sealed: make([]byte, header.SegmentSize+encTagSize),
+	}, nil
+}
+
+// Delete implements ObjectStorage.
+func (e *EncryptedObjectStorage) Delete(ctx context.Context, key string) error {
+	return e.store.Delete(ctx, key)
+}
+
+// List implements ObjectStorage.
+// Sizes are reported as plaintext sizes. Because the MD5 of the stored object is
+// computed over the ciphertext it is not reported.
+func (e *EncryptedObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
+	objects, prefixes, err := e.store.List(ctx, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, obj := range objects {
+		item := *obj
+		item.MD5 = ""
+
+		keyID, hasKeyID := obj.Tags[EncryptionKeyIDTag]
+		segmentSize, err := strconv.Atoi(obj.Tags[EncryptionSegmentSizeTag])
+		headerSize := encFixedHeaderSize + len(keyID)
+		if !hasKeyID || err != nil || segmentSize <= 0 {
+			// The backend does not keep tags, so read the header instead
+			header, err := e.readHeader(ctx, obj.Key)
+			if err != nil {
+				return nil, nil, err
+			}
+			headerSize = header.size()
+			segmentSize = header.SegmentSize
+		}
+
+		item.Size = plaintextSize(obj.Size, headerSize, segmentSize)
+		objects[i] = &item
+	}
+
+	return objects, prefixes, nil
+}
+
+func (e *EncryptedObjectStorage) readHeader(ctx context.Context, key string) (*encHeader, error) {
+	raw, err := e.store.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+
+	header, err := readEncHeader(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return header, nil
+}
+
+// UpdateMetadata implements ObjectStorage.
+// The key id tag is always preserved.
+func (e *EncryptedObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	header, err := e.readHeader(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	storedTags := copyTags(tags)
+	if storedTags == nil {
+		storedTags = make(map[string]string)
+	}
+	storedTags[EncryptionKeyIDTag] = header.KeyID
+	storedTags[EncryptionSegmentSizeTag] = strconv.Itoa(header.SegmentSize)
+	return e.store.UpdateMetadata(ctx, key, storedTags)
+}
+
+// Rotate makes newKeyID the master key for new objects and re-wraps the data key of
+// every existing object that uses a different master key. Only the header of each
+// object changes; the encrypted content is copied as is. Returns the number of
+// objects that were re-wrapped.
+func (e *EncryptedObjectStorage) Rotate(ctx context.Context, newKeyID string) (int, error) {
+	if _, err := e.masterKey(ctx, newKeyID); err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	e.keyID = newKeyID
+	e.mu.Unlock()
+
+	objects, err := listAllObjects(ctx, e.store, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	rotated := 0
+	for _, obj := range objects {
+		if obj.Tags[EncryptionKeyIDTag] == newKeyID {
+			continue
+		}
+
+		changed, err := e.rewrap(ctx, obj, newKeyID)
+		if err != nil {
+			return rotated, err
+		}
+		if changed {
+			rotated++
+		}
+	}
+
+	return rotated, nil
+}
+
+// rewrap replaces the header of a single object with one wrapped by the new key
+func (e *EncryptedObjectStorage) rewrap(ctx context.Context, obj *StoredObject, newKeyID string) (bool, error) {
+	raw, err := e.store.Download(ctx, obj.Key)
+	if err != nil {
+		return false, err
+	}
+	defer raw.Close()
+
+	header, err := readEncHeader(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", obj.Key, err)
+	}
+	if header.KeyID == newKeyID {
+		return false, nil
+	}
+
+	dataKey, err := e.unwrapKey(ctx, header.KeyID, header.WrappedKey)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", obj.Key, err)
+	}
+	wrapped, err := e.wrapKey(ctx, newKeyID, dataKey)
+	if err != nil {
+		return false, err
+	}
+	header.KeyID = newKeyID
+	header.WrappedKey = wrapped
+
+	// Spool the body so the object is not overwritten while it is being read
+	temp, err := os.CreateTemp("", "cloudy-rewrap-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	if _, err := io.Copy(temp, raw); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", obj.Key, err)
+	}
+	raw.Close()
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	tags := copyTags(obj.Tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[EncryptionKeyIDTag] = newKeyID
+	tags[EncryptionSegmentSizeTag] = strconv.Itoa(header.SegmentSize)
+
+	body := io.MultiReader(bytes.NewReader(header.marshal()), temp)
+	if err := e.store.Upload(ctx, obj.Key, body, tags); err != nil {
+		return false, fmt.Errorf("failed to rewrite %s: %w", obj.Key, err)
+	}
+	return true, nil
+}
+
+// encryptReader produces the encrypted representation of a plaintext stream
+type encryptReader struct {
+	source  *bufio.Reader
+	aead    cipher.AEAD
+	header  *encHeader
+	plain   []byte
+	pending []byte
+	counter uint32
+	started bool
+	done    bool
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// next fills pending with the header or the next sealed segment
+func (r *encryptReader) next() error {
+	if !r.started {
+		r.started = true
+		r.pending = r.header.marshal()
+		return nil
+	}
+
+	n, err := io.ReadFull(r.source, r.plain)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+
+	final := n < len(r.plain)
+	if !final {
+		// A full segment is only the last one when nothing follows it
+		if _, err := r.source.Peek(1); errors.Is(err, io.EOF) {
+			final = true
+		}
+	}
+
+	nonce := segmentNonce(r.header.NoncePrefix, r.counter, final)
+	r.pending = r.aead.Seal(nil, nonce, r.plain[:n], nil)
+	r.counter++
+	r.done = final
+	return nil
+}
+
+// decryptReader authenticates and decrypts an encrypted stream
+type decryptReader struct {
+	raw     io.ReadCloser
+	source  *bufio.Reader
+	aead    cipher.AEAD
+	header  *encHeader
+	sealed  []byte
+	pending []byte
+	counter uint32
+	done    bool
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.source, r.sealed)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+	if n < encTagSize {
+		return fmt.Errorf("%w: content is truncated", ErrDecryptionFailed)
+	}
+
+	final := n < len(r.sealed)
+	if !final {
+		if _, err := r.source.Peek(1); errors.Is(err, io.EOF) {
+			final = true
+		}
+	}
+
+	nonce := segmentNonce(r.header.NoncePrefix, r.counter, final)
+	plain, err := r.aead.Open(r.sealed[:0], nonce, r.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: segment %d failed authentication", ErrDecryptionFailed, r.counter)
+	}
+
+	r.pending = plain
+	r.counter++
+	r.done = final
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.raw.Close()
+}
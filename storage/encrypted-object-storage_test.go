@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appliedres/cloudy/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedObjectStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys := secrets.NewInMemorySecretProvider()
+	require.Nil(t, GenerateMasterKey(ctx, keys, "master-1"))
+
+	store := NewEncryptedObjectStorage(NewFilesystemObjectStorage(dir), keys, "master-1", &EncryptionOptions{SegmentSize: 1024})
+
+	sizes := []int{0, 10, 1024, 1025, 4096, 10000}
+	payloads := make(map[string][]byte)
+	for _, size := range sizes {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.Nil(t, err)
+
+		key := fmt.Sprintf("files/size-%d", size)
+		payloads[key] = data
+		require.Nil(t, store.Upload(ctx, key, bytes.NewReader(data), map[string]string{"owner": "me"}))
+	}
+
+	for key, data := range payloads {
+		reader, err := store.Download(ctx, key)
+		require.Nil(t, err)
+		plain, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		reader.Close()
+		assert.Equal(t, data, plain, key)
+
+		// The stored bytes must not contain the plaintext
+		raw, err := os.ReadFile(filepath.Join(dir, key))
+		require.Nil(t, err)
+		if len(data) > 0 {
+			assert.False(t, bytes.Contains(raw, data))
+		}
+	}
+
+	// The filesystem backend does not keep tags so sizes come from the headers
+	objects, _, err := store.List(ctx, "files/")
+	require.Nil(t, err)
+	require.Len(t, objects, len(sizes))
+	for _, obj := range objects {
+		assert.Equal(t, int64(len(payloads[strings.TrimPrefix(obj.Key, "/")])), obj.Size, obj.Key)
+	}
+}
+
+func TestEncryptedObjectStorage_Tamper(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys := secrets.NewInMemorySecretProvider()
+	require.Nil(t, GenerateMasterKey(ctx, keys, "master"))
+	store := NewEncryptedObjectStorage(NewFilesystemObjectStorage(dir), keys, "master", &EncryptionOptions{SegmentSize: 512})
+
+	data := make([]byte, 2000)
+	_, err := rand.Read(data)
+	require.Nil(t, err)
+	require.Nil(t, store.Upload(ctx, "secret.bin", bytes.NewReader(data), nil))
+
+	path := filepath.Join(dir, "secret.bin")
+	raw, err := os.ReadFile(path)
+	require.Nil(t, err)
+
+	// Flip a bit in the content
+	modified := append([]byte{}, raw...)
+	modified[len(modified)-20] ^= 0x01
+	require.Nil(t, os.WriteFile(path, modified, 0600))
+	reader, err := store.Download(ctx, "secret.bin")
+	require.Nil(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+	// Drop the final segment
+	require.Nil(t, os.WriteFile(path, raw[:len(raw)-(2000-3*512)-encTagSize], 0600))
+	reader, err = store.Download(ctx, "secret.bin")
+	require.Nil(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+	// Missing master key
+	other := NewEncryptedObjectStorage(NewFilesystemObjectStorage(dir), secrets.NewInMemorySecretProvider(), "master", nil)
+	require.Nil(t, os.WriteFile(path, raw, 0600))
+	_, err = other.Download(ctx, "secret.bin")
+	assert.ErrorIs(t, err, ErrMasterKeyNotFound)
+}
+
+func TestEncryptedObjectStorage_Rotate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys := secrets.NewInMemorySecretProvider()
+	require.Nil(t, GenerateMasterKey(ctx, keys, "old"))
+	require.Nil(t, GenerateMasterKey(ctx, keys, "new"))
+
+	store := NewEncryptedObjectStorage(NewFilesystemObjectStorage(dir), keys, "old", nil)
+	require.Nil(t, store.Upload(ctx, "a.txt", bytes.NewReader([]byte("alpha")), nil))
+	require.Nil(t, store.Upload(ctx, "nested/b.txt", bytes.NewReader([]byte("bravo")), nil))
+
+	rotated, err := store.Rotate(ctx, "new")
+	require.Nil(t, err)
+	assert.Equal(t, 2, rotated)
+	assert.Equal(t, "new", store.KeyID())
+
+	// The old key is no longer required
+	require.Nil(t, keys.DeleteSecret(ctx, "old"))
+	fresh := NewEncryptedObjectStorage(NewFilesystemObjectStorage(dir), keys, "new", nil)
+
+	for key, expected := range map[string]string{"a.txt": "alpha", "nested/b.txt": "bravo"} {
+		reader, err := fresh.Download(ctx, key)
+		require.Nil(t, err)
+		plain, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		reader.Close()
+		assert.Equal(t, expected, string(plain))
+	}
+
+	rotated, err = fresh.Rotate(ctx, "new")
+	require.Nil(t, err)
+	assert.Equal(t, 0, rotated)
+}
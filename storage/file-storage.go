@@ -23,3 +23,80 @@ type FileStorageManager interface {
 	Create(ctx context.Context, key string, tags map[string]string) (*FileShare, error)
 	Delete(ctx context.Context, key string) error
 }
+
+// FileShareAccess is the level of access a principal has to a share
+type FileShareAccess string
+
+const (
+	FileShareNoAccess  FileShareAccess = ""
+	FileShareRead      FileShareAccess = "read"
+	FileShareReadWrite FileShareAccess = "readwrite"
+)
+
+// Allows reports whether the access level includes the requested one
+func (a FileShareAccess) Allows(requested FileShareAccess) bool {
+	switch requested {
+	case FileShareRead:
+		return a == FileShareRead || a == FileShareReadWrite
+	case FileShareReadWrite:
+		return a == FileShareReadWrite
+	}
+	return false
+}
+
+// FileShareACE grants a user or a group access to a share
+type FileShareACE struct {
+	Principal string // User or group ID
+	IsGroup   bool
+	Access    FileShareAccess
+}
+
+// FileShareUsage reports the space used by a share
+type FileShareUsage struct {
+	UsedBytes  int64
+	FileCount  int64
+	QuotaBytes int64 // Zero means no quota
+}
+
+// MountProtocol is a network file system protocol a share can be mounted with
+type MountProtocol string
+
+const (
+	MountSMB MountProtocol = "smb"
+	MountNFS MountProtocol = "nfs"
+)
+
+// FileShareMount describes how to mount a share from a client
+type FileShareMount struct {
+	Protocol MountProtocol
+	Host     string
+	Source   string   // What to pass to mount, e.g. "//host/share" or "host:/export/share"
+	URL      string   // e.g. "smb://host/share" or "nfs://host/export/share"
+	Options  []string // Mount options, e.g. "vers=3.0"
+	Command  string   // Example Linux mount command
+}
+
+// ManagedFileStorageManager extends FileStorageManager with quotas, access lists
+// and mount information for the shares.
+type ManagedFileStorageManager interface {
+	FileStorageManager
+
+	// SetQuota limits the size of a share. Zero removes the limit.
+	SetQuota(ctx context.Context, key string, limitBytes int64) error
+
+	// GetUsage reports the size of a share against its quota
+	GetUsage(ctx context.Context, key string) (*FileShareUsage, error)
+
+	// GetACL returns the access list of a share
+	GetACL(ctx context.Context, key string) ([]*FileShareACE, error)
+
+	// SetACL replaces the access list of a share
+	SetACL(ctx context.Context, key string, acl []*FileShareACE) error
+
+	// CheckAccess reports whether a user has the access to a share, either
+	// directly or through one of their groups
+	CheckAccess(ctx context.Context, key string, uid string, access FileShareAccess) (bool, error)
+
+	// MountInfo describes how to mount a share with the protocol
+	MountInfo(ctx context.Context, key string, protocol MountProtocol) (*FileShareMount, error)
+}
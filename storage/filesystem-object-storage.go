@@ -2,7 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,48 +19,90 @@ func NewFilesystemObjectStorage(rootDir string) *FilesystemObjectStorage {
 	}
 }
 
+var _ ListableObjectStorage = (*FilesystemObjectStorage)(nil)
+var _ StatObjectStorage = (*FilesystemObjectStorage)(nil)
+
 type FilesystemObjectStorage struct {
 	rootDir string
 }
 
-func (fso *FilesystemObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
-	fullpath := filepath.Join(fso.rootDir, key)
-	dir := filepath.Dir(fullpath)
+// fullPath returns the file of a key, ErrInvalidKey when the key leaves the root directory
+func (fso *FilesystemObjectStorage) fullPath(key string) (string, error) {
+	fullpath := filepath.Join(fso.rootDir, filepath.FromSlash(key))
+	rel, err := filepath.Rel(fso.rootDir, fullpath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidKey, key)
+	}
+	return fullpath, nil
+}
 
-	err := os.MkdirAll(dir, 0700)
+// Upload writes the data to a temporary file next to the object and replaces
+// the object once everything was written, a failed upload leaves it unchanged
+func (fso *FilesystemObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	fullpath, err := fso.fullPath(key)
 	if err != nil {
 		return err
 	}
+	if closer, ok := data.(io.ReadCloser); ok {
+		defer closer.Close()
+	}
 
-	file, err := os.Create(fullpath)
+	file, tmpPath, err := CreateTempFile(fullpath, "."+filepath.Base(fullpath)+"-", ".tmp")
 	if err != nil {
 		return err
 	}
-
 	_, err = io.Copy(file, data)
-	if err != nil {
-		return err
+	if err == nil {
+		err = file.Chmod(0644)
 	}
-
-	closer, canClose := data.(io.ReadCloser)
-	if canClose {
-		_ = closer.Close()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
 	}
-
-	return file.Close()
+	if err == nil {
+		err = SafeReplace(tmpPath, fullpath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
 }
 
 func (fso *FilesystemObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
-	fullpath := filepath.Join(fso.rootDir, key)
+	fullpath, err := fso.fullPath(key)
+	if err != nil {
+		return false, err
+	}
 	return cloudy.Exists(fullpath)
 }
+
+// Stat describes a single file
+func (fso *FilesystemObjectStorage) Stat(ctx context.Context, key string) (*StoredObject, error) {
+	fullpath, err := fso.fullPath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullpath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &StoredObject{Key: strings.TrimPrefix(key, "/"), Size: info.Size()}, nil
+}
+
 func (fso *FilesystemObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
-	fullpath := filepath.Join(fso.rootDir, key)
-	file, err := os.Open(fullpath)
-	return file, err
+	fullpath, err := fso.fullPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fullpath)
 }
 func (fso *FilesystemObjectStorage) Delete(ctx context.Context, key string) error {
-	fullpath := filepath.Join(fso.rootDir, key)
+	fullpath, err := fso.fullPath(key)
+	if err != nil {
+		return err
+	}
 	return os.Remove(fullpath)
 }
 
@@ -139,6 +184,77 @@ func (fso *FilesystemObjectStorage) listinternal(entry os.DirEntry, path string,
 	return nil
 }
 
+// ListItems walks the directory holding the prefix, only its first level unless
+// the listing is recursive or uses another delimiter. Keys are returned without
+// a leading "/" and directories are returned as prefixes. A prefix outside of
+// the root directory fails with ErrInvalidKey.
+func (fso *FilesystemObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	if options == nil {
+		options = &ListItemsOptions{}
+	}
+	opts := *options
+	opts.Prefix = strings.TrimPrefix(opts.Prefix, "/")
+
+	start := ""
+	if i := strings.LastIndex(opts.Prefix, "/"); i >= 0 {
+		start = opts.Prefix[:i+1]
+	}
+
+	// With the "/" delimiter everything below the first level folds into the
+	// prefix of its directory, so deeper directories are not walked
+	shallow := !opts.Recursive && (opts.Delimiter == "" || opts.Delimiter == DefaultListDelimiter)
+
+	root, err := fso.fullPath(start)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*StoredObject
+	err = filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(fso.rootDir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			all = append(all, &StoredObject{Key: key + "/"})
+			if shallow {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		all = append(all, &StoredObject{
+			Key:  key,
+			Size: info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return PageObjects(all, &opts)
+}
+
 func (fso *FilesystemObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
 	return nil
 }
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/appliedres/cloudy"
+)
+
+// ObjectEventType is the kind of change an ObjectEvent reports
+type ObjectEventType string
+
+const (
+	ObjectCreated         ObjectEventType = "ObjectCreated"
+	ObjectRemoved         ObjectEventType = "ObjectRemoved"
+	ObjectMetadataUpdated ObjectEventType = "ObjectMetadataUpdated"
+)
+
+// ObjectEvent describes a change made through a HookedObjectStorage. Size and MD5
+// are only known for ObjectCreated.
+type ObjectEvent struct {
+	Type ObjectEventType
+	Key  string
+	Size int64
+	MD5  string
+	Tags map[string]string
+	Time time.Time
+}
+
+// ObjectEventSink receives the events of a HookedObjectStorage, e.g. to forward
+// them to a message queue.
+type ObjectEventSink interface {
+	Publish(ctx context.Context, event *ObjectEvent) error
+}
+
+// ObjectEventSinkFunc adapts a function to an ObjectEventSink
+type ObjectEventSinkFunc func(ctx context.Context, event *ObjectEvent) error
+
+func (f ObjectEventSinkFunc) Publish(ctx context.Context, event *ObjectEvent) error {
+	return f(ctx, event)
+}
+
+// BeforeUploadHook runs before an object is stored. It may replace the content
+// and the tags, e.g. a virus scanner can read the content, fail on a match and
+// return a reader over the scanned copy. Returning an error cancels the upload.
+type BeforeUploadHook interface {
+	BeforeUpload(ctx context.Context, store ObjectStorage, key string, data io.Reader, tags map[string]string) (io.Reader, map[string]string, error)
+}
+
+// AfterUploadHook runs after an object has been stored, e.g. to create thumbnails
+type AfterUploadHook interface {
+	AfterUpload(ctx context.Context, store ObjectStorage, event *ObjectEvent) error
+}
+
+// BeforeDeleteHook runs before an object is deleted. Returning an error cancels
+// the delete.
+type BeforeDeleteHook interface {
+	BeforeDelete(ctx context.Context, store ObjectStorage, key string) error
+}
+
+// AfterDeleteHook runs after an object has been deleted
+type AfterDeleteHook interface {
+	AfterDelete(ctx context.Context, store ObjectStorage, event *ObjectEvent) error
+}
+
+// BeforeUpdateMetadataHook runs before the tags of an object are replaced. It
+// may change the tags. Returning an error cancels the update.
+type BeforeUpdateMetadataHook interface {
+	BeforeUpdateMetadata(ctx context.Context, store ObjectStorage, key string, tags map[string]string) (map[string]string, error)
+}
+
+// AfterUpdateMetadataHook runs after the tags of an object have been replaced
+type AfterUpdateMetadataHook interface {
+	AfterUpdateMetadata(ctx context.Context, store ObjectStorage, event *ObjectEvent) error
+}
+
+var _ ListableObjectStorage = (*HookedObjectStorage)(nil)
+
+// HookedObjectStorage runs hooks around the writes to any ObjectStorage and
+// publishes an ObjectEvent for every successful write. It is the object storage
+// counterpart of the datastore interceptors.
+//
+// Before hooks run in order and can change the input or cancel the operation.
+// After hooks run in order once the store has been changed; an error from an
+// after hook is returned to the caller but the change is not undone. Events are
+// published after the after hooks, a sink failure is logged and does not fail
+// the operation. Reads are passed through to the wrapped store.
+type HookedObjectStorage struct {
+	ObjectStorage
+
+	Sink ObjectEventSink
+
+	BeforeUpload         []BeforeUploadHook
+	AfterUpload          []AfterUploadHook
+	BeforeDelete         []BeforeDeleteHook
+	AfterDelete          []AfterDeleteHook
+	BeforeUpdateMetadata []BeforeUpdateMetadataHook
+	AfterUpdateMetadata  []AfterUpdateMetadataHook
+
+	now func() time.Time
+}
+
+// NewHookedObjectStorage wraps the store. The sink may be nil when only hooks are needed.
+func NewHookedObjectStorage(store ObjectStorage, sink ObjectEventSink) *HookedObjectStorage {
+	return &HookedObjectStorage{
+		ObjectStorage: store,
+		Sink:          sink,
+		now:           time.Now,
+	}
+}
+
+// Upload implements ObjectStorage
+func (h *HookedObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	var err error
+
+	// Run the hooks, fail on error
+	for _, hook := range h.BeforeUpload {
+		data, tags, err = hook.BeforeUpload(ctx, h.ObjectStorage, key, data, tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	sum := md5.New()
+	counter := &countingReader{reader: io.TeeReader(data, sum)}
+	if err := h.ObjectStorage.Upload(ctx, key, counter, tags); err != nil {
+		return err
+	}
+
+	event := &ObjectEvent{
+		Type: ObjectCreated,
+		Key:  key,
+		Size: counter.count,
+		MD5:  hex.EncodeToString(sum.Sum(nil)),
+		Tags: copyTags(tags),
+		Time: h.now(),
+	}
+
+	// Run the post upload hooks
+	for _, hook := range h.AfterUpload {
+		if err := hook.AfterUpload(ctx, h.ObjectStorage, event); err != nil {
+			return err
+		}
+	}
+
+	h.publish(ctx, event)
+	return nil
+}
+
+// Delete implements ObjectStorage
+func (h *HookedObjectStorage) Delete(ctx context.Context, key string) error {
+	for _, hook := range h.BeforeDelete {
+		if err := hook.BeforeDelete(ctx, h.ObjectStorage, key); err != nil {
+			return err
+		}
+	}
+
+	if err := h.ObjectStorage.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	event := &ObjectEvent{
+		Type: ObjectRemoved,
+		Key:  key,
+		Time: h.now(),
+	}
+
+	for _, hook := range h.AfterDelete {
+		if err := hook.AfterDelete(ctx, h.ObjectStorage, event); err != nil {
+			return err
+		}
+	}
+
+	h.publish(ctx, event)
+	return nil
+}
+
+// UpdateMetadata implements ObjectStorage
+func (h *HookedObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
+	var err error
+
+	for _, hook := range h.BeforeUpdateMetadata {
+		tags, err = hook.BeforeUpdateMetadata(ctx, h.ObjectStorage, key, tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := h.ObjectStorage.UpdateMetadata(ctx, key, tags); err != nil {
+		return err
+	}
+
+	event := &ObjectEvent{
+		Type: ObjectMetadataUpdated,
+		Key:  key,
+		Tags: copyTags(tags),
+		Time: h.now(),
+	}
+
+	for _, hook := range h.AfterUpdateMetadata {
+		if err := hook.AfterUpdateMetadata(ctx, h.ObjectStorage, event); err != nil {
+			return err
+		}
+	}
+
+	h.publish(ctx, event)
+	return nil
+}
+
+// ListItems implements ListableObjectStorage
+func (h *HookedObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	return ListItems(ctx, h.ObjectStorage, options)
+}
+
+// publish sends the event to the sink, the change has already been made so a
+// failure is only logged
+func (h *HookedObjectStorage) publish(ctx context.Context, event *ObjectEvent) {
+	if h.Sink == nil {
+		return
+	}
+	if err := h.Sink.Publish(ctx, event); err != nil {
+		cloudy.Warn(ctx, "failed to publish %s event for %s: %v", event.Type, event.Key, err)
+	}
+}
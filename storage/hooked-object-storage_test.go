@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errInfected = errors.New("infected")
+
+// scanHook rejects uploads containing a marker and tags the clean ones
+type scanHook struct{}
+
+func (scanHook) BeforeUpload(ctx context.Context, store ObjectStorage, key string, data io.Reader, tags map[string]string) (io.Reader, map[string]string, error) {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Contains(content, []byte("EICAR")) {
+		return nil, nil, errInfected
+	}
+	tags = copyTags(tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags["scanned"] = "clean"
+	return bytes.NewReader(content), tags, nil
+}
+
+// auditHook records the events it sees
+type auditHook struct {
+	events []*ObjectEvent
+	vetoed map[string]bool
+}
+
+func (a *auditHook) AfterUpload(ctx context.Context, store ObjectStorage, event *ObjectEvent) error {
+	a.events = append(a.events, event)
+	return nil
+}
+
+func (a *auditHook) BeforeDelete(ctx context.Context, store ObjectStorage, key string) error {
+	if a.vetoed[key] {
+		return errors.New("protected")
+	}
+	return nil
+}
+
+func (a *auditHook) AfterDelete(ctx context.Context, store ObjectStorage, event *ObjectEvent) error {
+	a.events = append(a.events, event)
+	return nil
+}
+
+func TestHookedObjectStorage(t *testing.T) {
+	ctx := context.Background()
+
+	var published []*ObjectEvent
+	sink := ObjectEventSinkFunc(func(ctx context.Context, event *ObjectEvent) error {
+		published = append(published, event)
+		return nil
+	})
+
+	audit := &auditHook{vetoed: map[string]bool{"keep.txt": true}}
+	store := NewHookedObjectStorage(NewFilesystemObjectStorage(t.TempDir()), sink)
+	store.BeforeUpload = append(store.BeforeUpload, scanHook{})
+	store.AfterUpload = append(store.AfterUpload, audit)
+	store.BeforeDelete = append(store.BeforeDelete, audit)
+	store.AfterDelete = append(store.AfterDelete, audit)
+
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("hello"), map[string]string{"owner": "ops"}))
+	require.Len(t, published, 1)
+	assert.Equal(t, ObjectCreated, published[0].Type)
+	assert.Equal(t, "a.txt", published[0].Key)
+	assert.Equal(t, int64(5), published[0].Size)
+	assert.Equal(t, md5String("hello"), published[0].MD5)
+	assert.Equal(t, map[string]string{"owner": "ops", "scanned": "clean"}, published[0].Tags)
+	assert.Equal(t, "hello", readAllObject(t, store, "a.txt"))
+
+	// A failing before hook cancels the upload and publishes nothing
+	err := store.Upload(ctx, "virus.txt", strings.NewReader("EICAR"), nil)
+	assert.ErrorIs(t, err, errInfected)
+	exists, err := store.Exists(ctx, "virus.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+	assert.Len(t, published, 1)
+
+	require.Nil(t, store.Upload(ctx, "keep.txt", strings.NewReader("keep"), nil))
+	assert.NotNil(t, store.Delete(ctx, "keep.txt"))
+
+	require.Nil(t, store.Delete(ctx, "a.txt"))
+	require.Len(t, published, 3)
+	assert.Equal(t, ObjectRemoved, published[2].Type)
+	assert.Equal(t, "a.txt", published[2].Key)
+
+	assert.Len(t, audit.events, 3)
+}
+
+func TestHookedObjectStorage_SinkFailure(t *testing.T) {
+	ctx := context.Background()
+	sink := ObjectEventSinkFunc(func(ctx context.Context, event *ObjectEvent) error {
+		return errors.New("queue unavailable")
+	})
+	store := NewHookedObjectStorage(NewFilesystemObjectStorage(t.TempDir()), sink)
+
+	// The object is stored even when the event cannot be published
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("hello"), nil))
+	assert.Equal(t, "hello", readAllObject(t, store, "a.txt"))
+}
@@ -0,0 +1,700 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ ListableObjectStorage = (*IndexedTarObjectStorage)(nil)
+
+// TarPAXDeleted marks an entry as a tombstone for a deleted key
+const TarPAXDeleted = TarPAXRecordPrefix + "deleted"
+
+// ErrCompressedArchive is returned when an indexed tar store is pointed at a
+// compressed archive, which cannot be read at random offsets or appended to
+var ErrCompressedArchive = errors.New("indexed tar storage requires an uncompressed archive")
+
+// IndexedTarObjectStorage implements the ObjectStorage interface over an
+// uncompressed tar file without loading the archive into memory.
+//
+// The archive is scanned once to build an index of key to data offset. Downloads
+// read directly from that offset, and uploads, metadata updates and deletes are
+// appended to the end of the archive. Appended entries replace earlier entries for
+// the same key; deletes are recorded as empty tombstone entries (see TarPAXDeleted).
+// The replaced and deleted entries stay in the file until Compact is called.
+//
+// Memory use does not depend on the size of the archive or of its files, and no
+// per-file size limit is applied. Entries written by other tools have no stored
+// MD5 and are listed without one. The index is rebuilt when the archive is
+// changed by another process.
+type IndexedTarObjectStorage struct {
+	tarFilePath string
+	mu          sync.RWMutex
+
+	index   map[string]*indexedTarEntry
+	end     int64 // Offset where the next entry is written (start of the trailer)
+	dead    int64 // Bytes held by replaced entries and tombstones
+	size    int64 // Size of the file when it was indexed
+	modTime time.Time
+	loaded  bool
+}
+
+// indexedTarEntry is the location and metadata of the latest entry for a key
+type indexedTarEntry struct {
+	Key     string
+	Offset  int64 // Offset of the content
+	Size    int64
+	Length  int64 // Bytes held in the archive, including headers and padding
+	IsDir   bool
+	ModTime time.Time
+	Tags    map[string]string
+	MD5     string
+}
+
+// IndexedTarStats reports how much of the archive is reclaimable by Compact
+type IndexedTarStats struct {
+	Entries   int
+	LiveBytes int64
+	DeadBytes int64
+}
+
+// NewIndexedTarObjectStorage creates a new object storage backend using an
+// uncompressed tar file. The archive is indexed on first use.
+func NewIndexedTarObjectStorage(tarFilePath string) *IndexedTarObjectStorage {
+	// Make sure we have an absolute path
+	absPath, err := filepath.Abs(tarFilePath)
+	if err == nil {
+		tarFilePath = absPath
+	}
+
+	return &IndexedTarObjectStorage{
+		tarFilePath: tarFilePath,
+	}
+}
+
+// offsetReader tracks the position in the underlying reader. The tar reader only
+// reads whole blocks, so after Next returns the offset is the start of the content.
+type offsetReader struct {
+	reader io.Reader
+	offset int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// tarBlocks rounds a size up to whole tar blocks
+func tarBlocks(size int64) int64 {
+	return (size + 511) &^ 511
+}
+
+// ensureIndex builds the index if it has not been built or the archive was
+// changed by someone else. Must be called with the write lock held.
+func (t *IndexedTarObjectStorage) ensureIndex(ctx context.Context) error {
+	info, err := os.Stat(t.tarFilePath)
+	if os.IsNotExist(err) {
+		t.index = make(map[string]*indexedTarEntry)
+		t.end, t.dead, t.size, t.modTime = 0, 0, 0, time.Time{}
+		t.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat tar file: %w", err)
+	}
+	if t.loaded && info.Size() == t.size && info.ModTime().Equal(t.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(t.tarFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open tar file: %w", err)
+	}
+	defer file.Close()
+
+	// Refuse gzip archives rather than failing with a confusing header error
+	magic := make([]byte, 2)
+	if n, _ := io.ReadFull(file, magic); n == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return ErrCompressedArchive
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek tar file: %w", err)
+	}
+
+	index := make(map[string]*indexedTarEntry)
+	var end, dead int64
+
+	counter := &offsetReader{reader: file}
+	tarReader := tar.NewReader(counter)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		start := end
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar entry: %w", err)
+		}
+
+		offset := counter.offset
+		end = offset + tarBlocks(header.Size)
+
+		key := tarEntryKey(header.Name)
+		if previous, ok := index[key]; ok {
+			dead += previous.Length
+			delete(index, key)
+		}
+
+		if header.PAXRecords[TarPAXDeleted] != "" {
+			dead += end - start
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir {
+			// Links and special files are not objects
+			dead += end - start
+			continue
+		}
+
+		meta := tarMetadata(header.PAXRecords)
+		index[key] = &indexedTarEntry{
+			Key:     key,
+			Offset:  offset,
+			Size:    header.Size,
+			Length:  end - start,
+			IsDir:   header.Typeflag == tar.TypeDir,
+			ModTime: header.ModTime,
+			Tags:    meta.Tags,
+			MD5:     meta.MD5,
+		}
+	}
+
+	t.index = index
+	t.end = end
+	t.dead = dead
+	t.size = info.Size()
+	t.modTime = info.ModTime()
+	t.loaded = true
+	return nil
+}
+
+// ensureIndexLocked makes sure the index is current while holding a read lock.
+// The read lock is released and reacquired when the index has to be rebuilt.
+func (t *IndexedTarObjectStorage) ensureIndexLocked(ctx context.Context) error {
+	if t.loaded {
+		info, err := os.Stat(t.tarFilePath)
+		if err == nil && info.Size() == t.size && info.ModTime().Equal(t.modTime) {
+			return nil
+		}
+		if os.IsNotExist(err) && t.size == 0 {
+			return nil
+		}
+	}
+
+	t.mu.RUnlock()
+	t.mu.Lock()
+	err := t.ensureIndex(ctx)
+	t.mu.Unlock()
+	t.mu.RLock()
+	return err
+}
+
+// append writes entries at the end of the archive followed by a new trailer.
+// The write function is called with the tar writer and writes the entries.
+func (t *IndexedTarObjectStorage) append(write func(tarWriter *tar.Writer, counter *offsetWriter) error) error {
+	if err := os.MkdirAll(filepath.Dir(t.tarFilePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	file, err := os.OpenFile(t.tarFilePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open tar file: %w", err)
+	}
+	defer file.Close()
+
+	// Overwrite the trailer of the archive
+	if _, err := file.Seek(t.end, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek tar file: %w", err)
+	}
+
+	counter := &offsetWriter{writer: file, offset: t.end}
+	tarWriter := tar.NewWriter(counter)
+	err = write(tarWriter, counter)
+	if err == nil {
+		// Pads the last entry and fails if less content was written than declared
+		if err = tarWriter.Flush(); err != nil {
+			err = fmt.Errorf("failed to write tar entry: %w", err)
+		}
+	}
+	if err != nil {
+		// Leave the archive readable by restoring the trailer
+		file.Truncate(t.end)
+		file.WriteAt(make([]byte, 1024), t.end)
+		t.loaded = false
+		return err
+	}
+	t.end = counter.offset
+
+	if err := tarWriter.Close(); err != nil {
+		t.loaded = false
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := file.Truncate(counter.offset); err != nil {
+		t.loaded = false
+		return fmt.Errorf("failed to truncate tar file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		t.loaded = false
+		return fmt.Errorf("failed to close tar file: %w", err)
+	}
+
+	info, err := os.Stat(t.tarFilePath)
+	if err != nil {
+		t.loaded = false
+		return fmt.Errorf("failed to stat tar file: %w", err)
+	}
+	t.size = info.Size()
+	t.modTime = info.ModTime()
+	return nil
+}
+
+// offsetWriter tracks the position in the underlying writer
+type offsetWriter struct {
+	writer io.Writer
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
+
+// tarHeader builds the header for an entry
+func (t *IndexedTarObjectStorage) tarHeader(key string, size int64, isDir bool, meta archiveMetadata) *tar.Header {
+	header := &tar.Header{
+		Name:     key,
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+	}
+	if isDir {
+		header.Typeflag = tar.TypeDir
+		header.Mode = 0755
+	}
+	if records := tarPAXRecords(meta); records != nil {
+		header.PAXRecords = records
+		header.Format = tar.FormatPAX
+	}
+	return header
+}
+
+// writeEntry appends one entry and records it in the index
+func (t *IndexedTarObjectStorage) writeEntry(header *tar.Header, content io.Reader) error {
+	key := tarEntryKey(header.Name)
+	var offset, length int64
+
+	err := t.append(func(tarWriter *tar.Writer, counter *offsetWriter) error {
+		start := counter.offset
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		offset = counter.offset
+		length = offset + tarBlocks(header.Size) - start
+
+		if content != nil {
+			if _, err := io.Copy(tarWriter, content); err != nil {
+				return fmt.Errorf("failed to write file content: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if previous, ok := t.index[key]; ok {
+		t.dead += previous.Length
+		delete(t.index, key)
+	}
+
+	// Tombstones are dead as soon as they are written
+	if header.PAXRecords[TarPAXDeleted] != "" {
+		t.dead += length
+		return nil
+	}
+
+	meta := tarMetadata(header.PAXRecords)
+	t.index[key] = &indexedTarEntry{
+		Key:     key,
+		Offset:  offset,
+		Size:    header.Size,
+		Length:  length,
+		IsDir:   header.Typeflag == tar.TypeDir,
+		ModTime: header.ModTime,
+		Tags:    meta.Tags,
+		MD5:     meta.MD5,
+	}
+	return nil
+}
+
+// sectionReadCloser reads one entry's content from its own file handle
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r *sectionReadCloser) Close() error {
+	return r.file.Close()
+}
+
+// Download implements ObjectStorage.
+// The content is streamed from the archive. The returned reader keeps its own
+// handle to the file so it stays valid while the archive is appended to or compacted.
+func (t *IndexedTarObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	if err := t.ensureIndexLocked(ctx); err != nil {
+		return nil, fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	entry, ok := t.index[key]
+	if !ok || entry.IsDir {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	file, err := os.Open(t.tarFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tar file: %w", err)
+	}
+
+	return &sectionReadCloser{
+		SectionReader: io.NewSectionReader(file, entry.Offset, entry.Size),
+		file:          file,
+	}, nil
+}
+
+// Exists implements ObjectStorage.
+func (t *IndexedTarObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return false, err
+	}
+
+	if err := t.ensureIndexLocked(ctx); err != nil {
+		return false, fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	_, ok := t.index[key]
+	return ok, nil
+}
+
+// List implements ObjectStorage.
+// Returns the objects directly under the given prefix and the "/" delimited
+// sub-prefixes below it.
+func (t *IndexedTarObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if err := t.ensureIndexLocked(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	objects, prefixes := ListDelimited(t.storedObjects(), prefix)
+	return objects, prefixes, nil
+}
+
+// ListItems implements ListableObjectStorage.
+func (t *IndexedTarObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if err := t.ensureIndexLocked(ctx); err != nil {
+		return nil, fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	return PageObjects(t.storedObjects(), options)
+}
+
+// storedObjects returns every indexed entry. Directories are returned as
+// markers ending in "/".
+func (t *IndexedTarObjectStorage) storedObjects() []*StoredObject {
+	all := make([]*StoredObject, 0, len(t.index))
+	for key, entry := range t.index {
+		if entry.IsDir {
+			// Directory markers only contribute prefixes
+			if !strings.HasSuffix(key, "/") {
+				key += "/"
+			}
+			all = append(all, &StoredObject{Key: key})
+			continue
+		}
+
+		all = append(all, &StoredObject{
+			Key:  key,
+			Size: entry.Size,
+			Tags: copyTags(entry.Tags),
+			MD5:  entry.MD5,
+		})
+	}
+	return all
+}
+
+// Delete implements ObjectStorage.
+// A tombstone is appended; the space is reclaimed by Compact.
+func (t *IndexedTarObjectStorage) Delete(ctx context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	if err := t.ensureIndex(ctx); err != nil {
+		return fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	entry, ok := t.index[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	header := t.tarHeader(entry.Key, 0, false, archiveMetadata{})
+	header.PAXRecords = map[string]string{TarPAXDeleted: "true"}
+	header.Format = tar.FormatPAX
+	return t.writeEntry(header, nil)
+}
+
+// UpdateMetadata implements ObjectStorage.
+// The entry is appended again with the new tags, copying the content within
+// the archive.
+func (t *IndexedTarObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	if err := t.ensureIndex(ctx); err != nil {
+		return fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	entry, ok := t.index[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	file, err := os.Open(t.tarFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open tar file: %w", err)
+	}
+	defer file.Close()
+
+	checksum := entry.MD5
+	if checksum == "" && !entry.IsDir {
+		hash := md5.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, entry.Offset, entry.Size)); err != nil {
+			return fmt.Errorf("failed to read file content: %w", err)
+		}
+		checksum = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	header := t.tarHeader(entry.Key, entry.Size, entry.IsDir, archiveMetadata{MD5: checksum, Tags: copyTags(tags)})
+	if entry.IsDir {
+		return t.writeEntry(header, nil)
+	}
+	return t.writeEntry(header, io.NewSectionReader(file, entry.Offset, entry.Size))
+}
+
+// Upload implements ObjectStorage.
+// The content is spooled to a temporary file to learn its size and MD5 before
+// the entry is appended, so memory use does not depend on the size of the data.
+func (t *IndexedTarObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	// If the key ends with '/', treat it as a directory
+	if IsDirectory(key) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if err := t.ensureIndex(ctx); err != nil {
+			return fmt.Errorf("failed to index tar file: %w", err)
+		}
+		return t.writeEntry(t.tarHeader(key, 0, true, archiveMetadata{Tags: copyTags(tags)}), nil)
+	}
+
+	// Spool outside of the lock so slow readers do not block other operations
+	if err := os.MkdirAll(filepath.Dir(t.tarFilePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	spool, spoolPath, err := CreateTempFile(t.tarFilePath, "spool_", ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer os.Remove(spoolPath)
+	defer spool.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), data)
+	if err != nil {
+		return fmt.Errorf("failed to read upload content: %w", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spool file: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.ensureIndex(ctx); err != nil {
+		return fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	meta := archiveMetadata{MD5: hex.EncodeToString(hash.Sum(nil)), Tags: copyTags(tags)}
+	return t.writeEntry(t.tarHeader(key, size, false, meta), spool)
+}
+
+// Stats reports the number of live entries and how many bytes Compact would reclaim
+func (t *IndexedTarObjectStorage) Stats(ctx context.Context) (*IndexedTarStats, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if err := t.ensureIndexLocked(ctx); err != nil {
+		return nil, fmt.Errorf("failed to index tar file: %w", err)
+	}
+
+	stats := &IndexedTarStats{
+		Entries:   len(t.index),
+		DeadBytes: t.dead,
+	}
+	for _, entry := range t.index {
+		stats.LiveBytes += entry.Length
+	}
+	return stats, nil
+}
+
+// Compact rewrites the archive with only the live entries, dropping replaced
+// entries and tombstones. It returns the number of bytes reclaimed. Readers
+// returned by Download before the compaction keep reading the old file.
+func (t *IndexedTarObjectStorage) Compact(ctx context.Context) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.ensureIndex(ctx); err != nil {
+		return 0, fmt.Errorf("failed to index tar file: %w", err)
+	}
+	if t.dead == 0 {
+		return 0, nil
+	}
+
+	source, err := os.Open(t.tarFilePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open tar file: %w", err)
+	}
+	defer source.Close()
+
+	tempFile, tempFilePath, err := CreateTempFile(t.tarFilePath, "temp_", ".tar")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tempFilePath) // Clean up in case of failure
+
+	// Keep the original order of the entries
+	entries := make([]*indexedTarEntry, 0, len(t.index))
+	for _, entry := range t.index {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+
+	tarWriter := tar.NewWriter(tempFile)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			tempFile.Close()
+			return 0, err
+		}
+
+		// Re-read the original header so everything in it is preserved
+		header, err := readIndexedTarHeader(source, entry)
+		if err != nil {
+			tempFile.Close()
+			return 0, err
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			tempFile.Close()
+			return 0, fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if _, err := io.Copy(tarWriter, io.NewSectionReader(source, entry.Offset, entry.Size)); err != nil {
+			tempFile.Close()
+			return 0, fmt.Errorf("failed to write file content: %w", err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		tempFile.Close()
+		return 0, fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	reclaimed := t.dead
+	if err := SafeReplace(tempFilePath, t.tarFilePath); err != nil {
+		return 0, fmt.Errorf("failed to replace original tar file: %w", err)
+	}
+
+	// Offsets have changed
+	t.loaded = false
+	if err := t.ensureIndex(ctx); err != nil {
+		return reclaimed, fmt.Errorf("failed to index tar file: %w", err)
+	}
+	return reclaimed, nil
+}
+
+// readIndexedTarHeader reads the header of an entry. The header blocks are the
+// Length-Size bytes in front of the content.
+func readIndexedTarHeader(source io.ReaderAt, entry *indexedTarEntry) (*tar.Header, error) {
+	headerLength := entry.Length - tarBlocks(entry.Size)
+	start := entry.Offset - headerLength
+
+	// Append an empty block so the reader stops after the header
+	section := io.NewSectionReader(source, start, headerLength)
+	tarReader := tar.NewReader(io.MultiReader(section, bytes.NewReader(make([]byte, 512*2))))
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, fmt.Errorf("error reading tar header for %s: %w", entry.Key, err)
+	}
+	return header, nil
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllObject(t *testing.T, store ObjectStorage, key string) string {
+	reader, err := store.Download(context.Background(), key)
+	require.Nil(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func TestIndexedTarObjectStorage_BasicOperations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.tar")
+	store := NewIndexedTarObjectStorage(path)
+
+	exists, err := store.Exists(ctx, "a.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("alpha"), map[string]string{"k": "v"}))
+	require.Nil(t, store.Upload(ctx, "dir/b.txt", strings.NewReader("beta"), nil))
+	require.Nil(t, store.Upload(ctx, "empty/", nil, nil))
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("alpha 2"), nil))
+
+	assert.Equal(t, "alpha 2", readAllObject(t, store, "a.txt"))
+	assert.Equal(t, "beta", readAllObject(t, store, "dir/b.txt"))
+
+	objects, prefixes, err := store.List(ctx, "")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "a.txt", objects[0].Key)
+	assert.Equal(t, int64(7), objects[0].Size)
+	assert.Equal(t, md5String("alpha 2"), objects[0].MD5)
+	assert.Empty(t, objects[0].Tags)
+	assert.Equal(t, []*StoredPrefix{{Key: "dir/"}, {Key: "empty/"}}, prefixes)
+
+	require.Nil(t, store.UpdateMetadata(ctx, "dir/b.txt", map[string]string{"owner": "ops"}))
+	require.Nil(t, store.Delete(ctx, "a.txt"))
+	assert.ErrorIs(t, store.Delete(ctx, "a.txt"), ErrFileNotFound)
+	assert.ErrorIs(t, store.UpdateMetadata(ctx, "a.txt", nil), ErrFileNotFound)
+	_, err = store.Download(ctx, "a.txt")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+
+	// A new store rebuilds the same view from the archive
+	reopened := NewIndexedTarObjectStorage(path)
+	exists, err = reopened.Exists(ctx, "a.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+	obj := findObject(t, reopened, "dir/", "dir/b.txt")
+	assert.Equal(t, map[string]string{"owner": "ops"}, obj.Tags)
+	assert.Equal(t, md5String("beta"), obj.MD5)
+	assert.Equal(t, "beta", readAllObject(t, reopened, "dir/b.txt"))
+
+	// The archive remains a valid tar file
+	f, err := os.Open(path)
+	require.Nil(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	count := 0
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 6, count)
+}
+
+func TestIndexedTarObjectStorage_Compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.tar")
+	store := NewIndexedTarObjectStorage(path)
+
+	big := bytes.Repeat([]byte("x"), 64*1024)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, store.Upload(ctx, "big.bin", bytes.NewReader(big), nil))
+	}
+	require.Nil(t, store.Upload(ctx, "gone.txt", strings.NewReader("gone"), nil))
+	require.Nil(t, store.Upload(ctx, "keep.txt", strings.NewReader("keep"), map[string]string{"k": "v"}))
+	require.Nil(t, store.Delete(ctx, "gone.txt"))
+
+	// A reader opened before compaction keeps working
+	early, err := store.Download(ctx, "keep.txt")
+	require.Nil(t, err)
+	defer early.Close()
+
+	stats, err := store.Stats(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 2, stats.Entries)
+	assert.Greater(t, stats.DeadBytes, int64(2*len(big)))
+
+	before, err := os.Stat(path)
+	require.Nil(t, err)
+
+	reclaimed, err := store.Compact(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, stats.DeadBytes, reclaimed)
+
+	after, err := os.Stat(path)
+	require.Nil(t, err)
+	assert.Equal(t, before.Size()-reclaimed, after.Size())
+
+	stats, err = store.Stats(ctx)
+	require.Nil(t, err)
+	assert.Zero(t, stats.DeadBytes)
+
+	assert.Equal(t, string(big), readAllObject(t, store, "big.bin"))
+	assert.Equal(t, "keep", readAllObject(t, store, "keep.txt"))
+	obj := findObject(t, store, "", "keep.txt")
+	assert.Equal(t, map[string]string{"k": "v"}, obj.Tags)
+
+	data, err := io.ReadAll(early)
+	require.Nil(t, err)
+	assert.Equal(t, "keep", string(data))
+
+	// Nothing left to reclaim
+	reclaimed, err = store.Compact(ctx)
+	require.Nil(t, err)
+	assert.Zero(t, reclaimed)
+}
+
+func TestIndexedTarObjectStorage_ForeignArchive(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "foreign.tar")
+
+	// Written like "tar -C dir ." with the trailer padded to a full record
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: "./hello.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: "./link", Typeflag: tar.TypeSymlink, Linkname: "hello.txt"}))
+	require.Nil(t, tw.Close())
+	buf.Write(make([]byte, 10240-buf.Len()%10240))
+	require.Nil(t, os.WriteFile(path, buf.Bytes(), 0644))
+
+	store := NewIndexedTarObjectStorage(path)
+	assert.Equal(t, "hello", readAllObject(t, store, "hello.txt"))
+	obj := findObject(t, store, "", "hello.txt")
+	assert.Empty(t, obj.MD5)
+
+	require.Nil(t, store.Upload(ctx, "new.txt", strings.NewReader("new"), nil))
+	assert.Equal(t, "hello", readAllObject(t, store, "hello.txt"))
+	assert.Equal(t, "new", readAllObject(t, NewIndexedTarObjectStorage(path), "new.txt"))
+
+	// Changes made by another process are picked up
+	other := NewIndexedTarObjectStorage(path)
+	require.Nil(t, other.Upload(ctx, "other.txt", strings.NewReader("other"), nil))
+	assert.Equal(t, "other", readAllObject(t, store, "other.txt"))
+}
+
+func TestIndexedTarObjectStorage_Compressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.tar.gz")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	require.Nil(t, tar.NewWriter(gz).Close())
+	require.Nil(t, gz.Close())
+	require.Nil(t, os.WriteFile(path, buf.Bytes(), 0644))
+
+	_, err := NewIndexedTarObjectStorage(path).Exists(context.Background(), "a.txt")
+	assert.ErrorIs(t, err, ErrCompressedArchive)
+}
@@ -0,0 +1,575 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/appliedres/cloudy"
+)
+
+const LocalFileShareID = "local"
+
+// localShareMetaDir holds the share descriptions below the root directory. It
+// starts with a dot so it is never listed as a share.
+const localShareMetaDir = ".shares"
+
+var (
+	ErrInvalidShareName  = errors.New("invalid file share name")
+	ErrFileShareNotFound = errors.New("file share not found")
+	ErrFileShareExists   = errors.New("file share already exists")
+	ErrQuotaExceeded     = errors.New("file share quota exceeded")
+	ErrUnsupportedMount  = errors.New("unsupported mount protocol")
+)
+
+var shareNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,79}$`)
+
+func init() {
+	FileShareProviders.Register(LocalFileShareID, &LocalFileStorageFactory{})
+}
+
+var _ ManagedFileStorageManager = (*LocalFileStorageManager)(nil)
+
+// LocalFileStorageConfig configures a LocalFileStorageManager
+type LocalFileStorageConfig struct {
+	// Dir is the directory the shares are created in
+	Dir string
+
+	// Groups resolves group membership for CheckAccess. Group entries in the
+	// access lists are ignored when it is not set.
+	Groups cloudy.GroupManager
+
+	// MountHost is the host name clients use to mount the shares. Defaults to
+	// the host name of the machine.
+	MountHost string
+
+	// NFSExportRoot is the exported path of Dir on the NFS server. Defaults to Dir.
+	NFSExportRoot string
+
+	// SMBOptions and NFSOptions are added to the generated mount information
+	SMBOptions []string
+	NFSOptions []string
+}
+
+type LocalFileStorageFactory struct{}
+
+func (f *LocalFileStorageFactory) Create(cfg interface{}) (FileStorageManager, error) {
+	config, ok := cfg.(*LocalFileStorageConfig)
+	if !ok || config == nil {
+		return nil, cloudy.ErrInvalidConfiguration
+	}
+	return NewLocalFileStorageManager(config)
+}
+
+func (f *LocalFileStorageFactory) ConfigSchema() *cloudy.ConfigSchema {
+	return &cloudy.ConfigSchema{
+		Description: "File shares as directories on the local machine",
+		Keys: []cloudy.ConfigKey{
+			{Name: "FILESHARE_DIR", Type: cloudy.ConfigTypeString, Required: true, Description: "Directory the shares are created in"},
+			{Name: "FILESHARE_MOUNT_HOST", Type: cloudy.ConfigTypeString, Description: "Host name clients mount the shares from"},
+			{Name: "FILESHARE_NFS_EXPORT_ROOT", Type: cloudy.ConfigTypeString, Description: "Exported path of the directory on the NFS server"},
+		},
+	}
+}
+
+func (f *LocalFileStorageFactory) FromEnv(env *cloudy.Environment) (interface{}, error) {
+	cfg := &LocalFileStorageConfig{}
+	cfg.Dir = env.Force("FILESHARE_DIR")
+	cfg.MountHost = env.Default("FILESHARE_MOUNT_HOST", "")
+	cfg.NFSExportRoot = env.Default("FILESHARE_NFS_EXPORT_ROOT", "")
+	return cfg, nil
+}
+
+// localShare is the stored description of a share
+type localShare struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	QuotaBytes int64             `json:"quotaBytes,omitempty"`
+	ACL        []*FileShareACE   `json:"acl,omitempty"`
+}
+
+// LocalFileStorageManager manages file shares as directories below a root
+// directory. The directories can be exported over SMB or NFS by the host, the
+// manager only generates the matching mount information. Quotas are enforced
+// for writes made through Storage.
+type LocalFileStorageManager struct {
+	config  LocalFileStorageConfig
+	mu      sync.RWMutex
+	uploads sync.Map // Share key to the *sync.Mutex serializing quota checked uploads
+}
+
+// NewLocalFileStorageManager creates a manager for the shares in the directory
+func NewLocalFileStorageManager(config *LocalFileStorageConfig) (*LocalFileStorageManager, error) {
+	if config == nil || config.Dir == "" {
+		return nil, fmt.Errorf("%w: directory is required", ErrInvalidStorage)
+	}
+
+	m := &LocalFileStorageManager{config: *config}
+	m.config.Dir = AbsPath(config.Dir)
+	if m.config.MountHost == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "localhost"
+		}
+		m.config.MountHost = host
+	}
+	if m.config.NFSExportRoot == "" {
+		m.config.NFSExportRoot = m.config.Dir
+	}
+
+	if err := os.MkdirAll(filepath.Join(m.config.Dir, localShareMetaDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create share directory: %w", err)
+	}
+	return m, nil
+}
+
+// Root returns the directory the shares are created in
+func (m *LocalFileStorageManager) Root() string {
+	return m.config.Dir
+}
+
+func (m *LocalFileStorageManager) shareDir(key string) string {
+	return filepath.Join(m.config.Dir, key)
+}
+
+func (m *LocalFileStorageManager) metaPath(key string) string {
+	return filepath.Join(m.config.Dir, localShareMetaDir, key+".json")
+}
+
+func checkShareName(key string) error {
+	if !shareNamePattern.MatchString(key) {
+		return fmt.Errorf("%w: %q", ErrInvalidShareName, key)
+	}
+	return nil
+}
+
+// load reads the description of a share. Directories created outside of the
+// manager are treated as shares without tags, quota or access list.
+func (m *LocalFileStorageManager) load(key string) (*localShare, error) {
+	if err := checkShareName(key); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(m.shareDir(key))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return nil, fmt.Errorf("%w: %s", ErrFileShareNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	share := &localShare{ID: key, Name: key}
+	data, err := os.ReadFile(m.metaPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return share, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, share); err != nil {
+		return nil, fmt.Errorf("invalid description of share %s: %w", key, err)
+	}
+	return share, nil
+}
+
+// save writes the description of a share
+func (m *LocalFileStorageManager) save(share *localShare) error {
+	data, err := json.MarshalIndent(share, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := m.metaPath(share.ID)
+	tmp, tmpPath, err := CreateTempFile(path, "share-", ".json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return SafeReplace(tmpPath, path)
+}
+
+func (s *localShare) fileShare() *FileShare {
+	return &FileShare{
+		ID:   s.ID,
+		Name: s.Name,
+		Tags: copyTags(s.Tags),
+	}
+}
+
+// List implements FileStorageManager
+func (m *LocalFileStorageManager) List(ctx context.Context) ([]*FileShare, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries, err := os.ReadDir(m.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var shares []*FileShare
+	for _, entry := range entries {
+		if !entry.IsDir() || checkShareName(entry.Name()) != nil {
+			continue
+		}
+		share, err := m.load(entry.Name())
+		if err != nil {
+			cloudy.Warn(ctx, "skipping file share %s: %v", entry.Name(), err)
+			continue
+		}
+		shares = append(shares, share.fileShare())
+	}
+	return shares, nil
+}
+
+// Get implements FileStorageManager
+func (m *LocalFileStorageManager) Get(ctx context.Context, key string) (*FileShare, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	share, err := m.load(key)
+	if err != nil {
+		return nil, err
+	}
+	return share.fileShare(), nil
+}
+
+// Exists implements FileStorageManager
+func (m *LocalFileStorageManager) Exists(ctx context.Context, key string) (bool, error) {
+	if err := checkShareName(key); err != nil {
+		return false, err
+	}
+	info, err := os.Stat(m.shareDir(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// Create implements FileStorageManager
+func (m *LocalFileStorageManager) Create(ctx context.Context, key string, tags map[string]string) (*FileShare, error) {
+	if err := checkShareName(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.Mkdir(m.shareDir(key), 0755); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("%w: %s", ErrFileShareExists, key)
+		}
+		return nil, err
+	}
+
+	share := &localShare{ID: key, Name: key, Tags: copyTags(tags)}
+	if err := m.save(share); err != nil {
+		_ = os.Remove(m.shareDir(key))
+		return nil, err
+	}
+
+	cloudy.Info(ctx, "created file share %s", key)
+	return share.fileShare(), nil
+}
+
+// Delete implements FileStorageManager.
+// The share and all of its files are removed.
+func (m *LocalFileStorageManager) Delete(ctx context.Context, key string) error {
+	if err := checkShareName(key); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.RemoveAll(m.shareDir(key)); err != nil {
+		return err
+	}
+	if err := os.Remove(m.metaPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	cloudy.Info(ctx, "deleted file share %s", key)
+	return nil
+}
+
+// update changes the description of an existing share
+func (m *LocalFileStorageManager) update(key string, fn func(share *localShare)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	share, err := m.load(key)
+	if err != nil {
+		return err
+	}
+	fn(share)
+	return m.save(share)
+}
+
+// SetQuota implements ManagedFileStorageManager
+func (m *LocalFileStorageManager) SetQuota(ctx context.Context, key string, limitBytes int64) error {
+	if limitBytes < 0 {
+		limitBytes = 0
+	}
+	return m.update(key, func(share *localShare) {
+		share.QuotaBytes = limitBytes
+	})
+}
+
+// GetUsage implements ManagedFileStorageManager
+func (m *LocalFileStorageManager) GetUsage(ctx context.Context, key string) (*FileShareUsage, error) {
+	m.mu.RLock()
+	share, err := m.load(key)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &FileShareUsage{QuotaBytes: share.QuotaBytes}
+	err = filepath.WalkDir(m.shareDir(key), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.UsedBytes += info.Size()
+		usage.FileCount++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// GetACL implements ManagedFileStorageManager
+func (m *LocalFileStorageManager) GetACL(ctx context.Context, key string) ([]*FileShareACE, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	share, err := m.load(key)
+	if err != nil {
+		return nil, err
+	}
+	return copyACL(share.ACL), nil
+}
+
+// SetACL implements ManagedFileStorageManager.
+// Entries without access are dropped.
+func (m *LocalFileStorageManager) SetACL(ctx context.Context, key string, acl []*FileShareACE) error {
+	var entries []*FileShareACE
+	for _, ace := range acl {
+		if ace == nil || ace.Access == FileShareNoAccess {
+			continue
+		}
+		if ace.Access != FileShareRead && ace.Access != FileShareReadWrite {
+			return fmt.Errorf("invalid access %q for %s", ace.Access, ace.Principal)
+		}
+		entries = append(entries, ace)
+	}
+
+	return m.update(key, func(share *localShare) {
+		share.ACL = copyACL(entries)
+	})
+}
+
+func copyACL(acl []*FileShareACE) []*FileShareACE {
+	var result []*FileShareACE
+	for _, ace := range acl {
+		entry := *ace
+		result = append(result, &entry)
+	}
+	return result
+}
+
+// CheckAccess implements ManagedFileStorageManager.
+// Group entries are matched against the groups returned by the configured
+// GroupManager for the user.
+func (m *LocalFileStorageManager) CheckAccess(ctx context.Context, key string, uid string, access FileShareAccess) (bool, error) {
+	acl, err := m.GetACL(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	var groupEntries []*FileShareACE
+	for _, ace := range acl {
+		if ace.IsGroup {
+			groupEntries = append(groupEntries, ace)
+			continue
+		}
+		if ace.Principal == uid && ace.Access.Allows(access) {
+			return true, nil
+		}
+	}
+
+	if len(groupEntries) == 0 || m.config.Groups == nil {
+		return false, nil
+	}
+
+	groups, err := m.config.Groups.GetUserGroups(ctx, uid)
+	if err != nil {
+		return false, fmt.Errorf("failed to get groups of %s: %w", uid, err)
+	}
+	for _, ace := range groupEntries {
+		if !ace.Access.Allows(access) {
+			continue
+		}
+		for _, group := range groups {
+			if group != nil && group.ID == ace.Principal {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// MountInfo implements ManagedFileStorageManager.
+// The SMB share name is the share key and the NFS export is the share directory
+// below NFSExportRoot.
+func (m *LocalFileStorageManager) MountInfo(ctx context.Context, key string, protocol MountProtocol) (*FileShareMount, error) {
+	exists, err := m.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrFileShareNotFound, key)
+	}
+
+	host := m.config.MountHost
+	mount := &FileShareMount{
+		Protocol: protocol,
+		Host:     host,
+	}
+
+	switch protocol {
+	case MountSMB:
+		mount.Source = "//" + host + "/" + key
+		mount.URL = "smb://" + host + "/" + key
+		mount.Options = append([]string{"vers=3.0"}, m.config.SMBOptions...)
+		mount.Command = fmt.Sprintf("mount -t cifs %s /mnt/%s -o %s", mount.Source, key, strings.Join(mount.Options, ","))
+	case MountNFS:
+		export := strings.TrimSuffix(filepath.ToSlash(m.config.NFSExportRoot), "/") + "/" + key
+		mount.Source = host + ":" + export
+		mount.URL = "nfs://" + host + export
+		mount.Options = append([]string{"vers=4"}, m.config.NFSOptions...)
+		mount.Command = fmt.Sprintf("mount -t nfs -o %s %s /mnt/%s", strings.Join(mount.Options, ","), mount.Source, key)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMount, protocol)
+	}
+	return mount, nil
+}
+
+// Storage returns the files of a share as an ObjectStorage. Uploads that would
+// take the share over its quota fail with ErrQuotaExceeded and leave the
+// existing object in place.
+func (m *LocalFileStorageManager) Storage(ctx context.Context, key string) (ObjectStorage, error) {
+	exists, err := m.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrFileShareNotFound, key)
+	}
+
+	return &localShareStorage{
+		FilesystemObjectStorage: NewFilesystemObjectStorage(m.shareDir(key)),
+		manager:                 m,
+		share:                   key,
+	}, nil
+}
+
+// localShareStorage enforces the share quota on uploads
+type localShareStorage struct {
+	*FilesystemObjectStorage
+	manager *LocalFileStorageManager
+	share   string
+}
+
+func (s *localShareStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	dest, err := s.fullPath(key)
+	if err != nil {
+		return err
+	}
+
+	// The usage must not change between the quota check and the replace
+	lock := s.manager.uploadLock(s.share)
+	lock.Lock()
+	usage, err := s.manager.GetUsage(ctx, s.share)
+	if err != nil {
+		lock.Unlock()
+		return err
+	}
+	if usage.QuotaBytes == 0 {
+		lock.Unlock()
+		return s.FilesystemObjectStorage.Upload(ctx, key, data, tags)
+	}
+	defer lock.Unlock()
+
+	if closer, ok := data.(io.ReadCloser); ok {
+		defer closer.Close()
+	}
+
+	// Replacing an object frees its current size
+	available := usage.QuotaBytes - usage.UsedBytes
+	if info, err := os.Stat(dest); err == nil && info.Mode().IsRegular() {
+		available += info.Size()
+	}
+	if available < 0 {
+		available = 0
+	}
+
+	// Spool outside of the share so a rejected upload never counts against it
+	tmp, tmpPath, err := CreateTempFile(s.manager.metaPath(s.share), "upload-", ".tmp")
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(tmp, io.LimitReader(data, available+1))
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > available {
+		err = fmt.Errorf("%w: %s allows %d more bytes", ErrQuotaExceeded, s.share, available)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err := SafeReplace(tmpPath, dest); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return s.UpdateMetadata(ctx, key, tags)
+}
+
+// uploadLock returns the lock held by quota checked uploads to a share
+func (m *LocalFileStorageManager) uploadLock(key string) *sync.Mutex {
+	lock, _ := m.uploads.LoadOrStore(key, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
@@ -0,0 +1,219 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+	"github.com/appliedres/cloudy/storage"
+	"github.com/appliedres/cloudy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userGroups answers GetUserGroups from a fixed map
+type userGroups struct {
+	cloudy.GroupManager
+	groups map[string][]string
+}
+
+func (g *userGroups) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	var result []*models.Group
+	for _, id := range g.groups[uid] {
+		result = append(result, &models.Group{ID: id, Name: id})
+	}
+	return result, nil
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestLocalFileStorageManager(t *testing.T) {
+	mgr, err := storage.NewLocalFileStorageManager(&storage.LocalFileStorageConfig{Dir: t.TempDir()})
+	require.Nil(t, err)
+
+	testutil.TestFileShareStorageManager(t, mgr, "test-share")
+}
+
+func TestLocalFileStorageManager_Quota(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := storage.NewLocalFileStorageManager(&storage.LocalFileStorageConfig{Dir: t.TempDir()})
+	require.Nil(t, err)
+
+	_, err = mgr.Create(ctx, "data", nil)
+	require.Nil(t, err)
+	require.Nil(t, mgr.SetQuota(ctx, "data", 10))
+
+	store, err := mgr.Storage(ctx, "data")
+	require.Nil(t, err)
+
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("123456"), nil))
+	err = store.Upload(ctx, "b.txt", strings.NewReader("123456"), nil)
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+
+	exists, err := store.Exists(ctx, "b.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	// Replacing an object only counts the difference
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("1234567890"), nil))
+
+	usage, err := mgr.GetUsage(ctx, "data")
+	require.Nil(t, err)
+	assert.Equal(t, int64(10), usage.UsedBytes)
+	assert.Equal(t, int64(1), usage.FileCount)
+	assert.Equal(t, int64(10), usage.QuotaBytes)
+
+	// Concurrent uploads cannot both pass the quota check
+	require.Nil(t, store.Delete(ctx, "a.txt"))
+	var wg sync.WaitGroup
+	var uploaded atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := mgr.Storage(ctx, "data")
+			require.Nil(t, err)
+			if s.Upload(ctx, fmt.Sprintf("c%d.txt", i), strings.NewReader("123456"), nil) == nil {
+				uploaded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), uploaded.Load())
+
+	body := &closeRecorder{Reader: strings.NewReader("1")}
+	require.Nil(t, store.Upload(ctx, "d.txt", body, nil))
+	assert.True(t, body.closed)
+
+	// The quota survives a new manager
+	mgr2, err := storage.NewLocalFileStorageManager(&storage.LocalFileStorageConfig{Dir: mgr.Root()})
+	require.Nil(t, err)
+	usage, err = mgr2.GetUsage(ctx, "data")
+	require.Nil(t, err)
+	assert.Equal(t, int64(10), usage.QuotaBytes)
+}
+
+func TestLocalFileStorageManager_KeyTraversal(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := storage.NewLocalFileStorageManager(&storage.LocalFileStorageConfig{Dir: t.TempDir()})
+	require.Nil(t, err)
+
+	for _, name := range []string{"data", "other"} {
+		_, err = mgr.Create(ctx, name, nil)
+		require.Nil(t, err)
+	}
+	require.Nil(t, mgr.SetQuota(ctx, "data", 10))
+	other, err := mgr.Storage(ctx, "other")
+	require.Nil(t, err)
+	require.Nil(t, other.Upload(ctx, "x.txt", strings.NewReader("other"), nil))
+
+	store, err := mgr.Storage(ctx, "data")
+	require.Nil(t, err)
+	for _, key := range []string{"../other/x.txt", "../.shares/data.json", "a/../../other/x.txt", ".."} {
+		assert.ErrorIs(t, store.Upload(ctx, key, strings.NewReader("1"), nil), storage.ErrInvalidKey, key)
+		_, err = store.Download(ctx, key)
+		assert.ErrorIs(t, err, storage.ErrInvalidKey, key)
+		_, err = store.Exists(ctx, key)
+		assert.ErrorIs(t, err, storage.ErrInvalidKey, key)
+		assert.ErrorIs(t, store.Delete(ctx, key), storage.ErrInvalidKey, key)
+		_, err = storage.StatObject(ctx, store, key)
+		assert.ErrorIs(t, err, storage.ErrInvalidKey, key)
+	}
+
+	// Keys that stay inside the share still work
+	require.Nil(t, store.Upload(ctx, "a/../b.txt", strings.NewReader("1"), nil))
+	exists, err := store.Exists(ctx, "b.txt")
+	require.Nil(t, err)
+	assert.True(t, exists)
+
+	rc, err := other.Download(ctx, "x.txt")
+	require.Nil(t, err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "other", string(data))
+	usage, err := mgr.GetUsage(ctx, "data")
+	require.Nil(t, err)
+	assert.Equal(t, int64(10), usage.QuotaBytes)
+}
+
+func TestLocalFileStorageManager_Access(t *testing.T) {
+	ctx := context.Background()
+	groups := &userGroups{groups: map[string][]string{"bob": {"engineers"}}}
+	mgr, err := storage.NewLocalFileStorageManager(&storage.LocalFileStorageConfig{Dir: t.TempDir(), Groups: groups})
+	require.Nil(t, err)
+
+	_, err = mgr.Create(ctx, "projects", map[string]string{"owner": "alice"})
+	require.Nil(t, err)
+
+	require.Nil(t, mgr.SetACL(ctx, "projects", []*storage.FileShareACE{
+		{Principal: "alice", Access: storage.FileShareReadWrite},
+		{Principal: "engineers", IsGroup: true, Access: storage.FileShareRead},
+	}))
+
+	acl, err := mgr.GetACL(ctx, "projects")
+	require.Nil(t, err)
+	assert.Len(t, acl, 2)
+
+	tests := []struct {
+		uid    string
+		access storage.FileShareAccess
+		want   bool
+	}{
+		{"alice", storage.FileShareReadWrite, true},
+		{"bob", storage.FileShareRead, true},
+		{"bob", storage.FileShareReadWrite, false},
+		{"carol", storage.FileShareRead, false},
+	}
+	for _, tt := range tests {
+		allowed, err := mgr.CheckAccess(ctx, "projects", tt.uid, tt.access)
+		require.Nil(t, err)
+		assert.Equal(t, tt.want, allowed, "%s %s", tt.uid, tt.access)
+	}
+
+	_, err = mgr.GetACL(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrFileShareNotFound)
+}
+
+func TestLocalFileStorageManager_MountInfo(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := storage.NewLocalFileStorageManager(&storage.LocalFileStorageConfig{
+		Dir:           t.TempDir(),
+		MountHost:     "files.example.com",
+		NFSExportRoot: "/export/shares",
+	})
+	require.Nil(t, err)
+
+	_, err = mgr.Create(ctx, "home", nil)
+	require.Nil(t, err)
+
+	smb, err := mgr.MountInfo(ctx, "home", storage.MountSMB)
+	require.Nil(t, err)
+	assert.Equal(t, "//files.example.com/home", smb.Source)
+	assert.Equal(t, "smb://files.example.com/home", smb.URL)
+
+	nfs, err := mgr.MountInfo(ctx, "home", storage.MountNFS)
+	require.Nil(t, err)
+	assert.Equal(t, "files.example.com:/export/shares/home", nfs.Source)
+	assert.Equal(t, "nfs://files.example.com/export/shares/home", nfs.URL)
+
+	_, err = mgr.MountInfo(ctx, "home", "ftp")
+	assert.ErrorIs(t, err, storage.ErrUnsupportedMount)
+
+	_, err = mgr.Create(ctx, "../escape", nil)
+	assert.ErrorIs(t, err, storage.ErrInvalidShareName)
+}
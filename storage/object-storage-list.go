@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultListDelimiter groups keys into prefixes when no delimiter is given
+const DefaultListDelimiter = "/"
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// ListItemsOptions controls a call to ListItems.
+type ListItemsOptions struct {
+	// Prefix limits the results to keys starting with it
+	Prefix string
+
+	// Delimiter groups the keys below the prefix into StoredPrefixes. It defaults
+	// to DefaultListDelimiter.
+	Delimiter string
+
+	// Recursive returns every object below the prefix and no prefixes. The
+	// delimiter is ignored.
+	Recursive bool
+
+	// MaxResults limits the number of objects and prefixes in one page. Zero or
+	// less returns everything.
+	MaxResults int
+
+	// PageToken continues a listing from the NextPageToken of a previous result
+	PageToken string
+
+	// Tags limits the objects to those that carry all of these tags with the
+	// same values. Prefixes are only returned when an object below them matches.
+	Tags map[string]string
+}
+
+// ListItemsResult is one page of a listing. Objects and prefixes are sorted by key.
+type ListItemsResult struct {
+	Objects  []*StoredObject
+	Prefixes []*StoredPrefix
+
+	// NextPageToken is set when there are more results
+	NextPageToken string
+}
+
+// ListableObjectStorage is implemented by object stores that support paged and
+// filtered listings natively. Use ListItems to list any ObjectStorage.
+type ListableObjectStorage interface {
+	ObjectStorage
+	ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error)
+}
+
+// ListItems lists the store with the given options. Stores that do not
+// implement ListableObjectStorage are listed in full with List and then
+// filtered and paged.
+func ListItems(ctx context.Context, store ObjectStorage, options *ListItemsOptions) (*ListItemsResult, error) {
+	if options == nil {
+		options = &ListItemsOptions{}
+	}
+
+	if listable, ok := store.(ListableObjectStorage); ok {
+		return listable.ListItems(ctx, options)
+	}
+
+	// List from the last delimiter in the prefix so partial names still match
+	start := options.Prefix
+	if i := strings.LastIndex(start, "/"); i >= 0 {
+		start = start[:i+1]
+	} else {
+		start = ""
+	}
+
+	all, err := listAllObjects(ctx, store, start)
+	if err != nil {
+		return nil, err
+	}
+	return PageObjects(all, options)
+}
+
+// StatObjectStorage is implemented by object stores that can describe a single
+// object without listing
+type StatObjectStorage interface {
+	ObjectStorage
+	Stat(ctx context.Context, key string) (*StoredObject, error)
+}
+
+// StatObject describes one object, it fails with ErrFileNotFound when the
+// object does not exist. Stores that do not implement StatObjectStorage are
+// asked for the first object starting with the key.
+func StatObject(ctx context.Context, store ObjectStorage, key string) (*StoredObject, error) {
+	key = strings.TrimPrefix(key, "/")
+	if stat, ok := store.(StatObjectStorage); ok {
+		return stat.Stat(ctx, key)
+	}
+
+	// The key itself sorts before every longer key it prefixes
+	page, err := ListItems(ctx, store, &ListItemsOptions{Prefix: key, Recursive: true, MaxResults: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Objects) == 0 || page.Objects[0].Key != key {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	return page.Objects[0], nil
+}
+
+// LegacyList lists a ListableObjectStorage with the signature of
+// ObjectStorage.List, reading every page of a "/" delimited listing.
+func LegacyList(ctx context.Context, store ListableObjectStorage, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
+	var objects []*StoredObject
+	var prefixes []*StoredPrefix
+
+	options := &ListItemsOptions{Prefix: prefix}
+	for {
+		page, err := store.ListItems(ctx, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, page.Objects...)
+		prefixes = append(prefixes, page.Prefixes...)
+
+		if page.NextPageToken == "" {
+			return objects, prefixes, nil
+		}
+		options.PageToken = page.NextPageToken
+	}
+}
+
+// PageObjects applies the options to a flat list of every object in a store.
+// Keys ending in "/" are treated as directory markers: they contribute prefixes
+// but are never returned as objects. A leading "/" on keys is ignored. Stores
+// that can produce such a list use this to implement ListItems.
+func PageObjects(all []*StoredObject, options *ListItemsOptions) (*ListItemsResult, error) {
+	if options == nil {
+		options = &ListItemsOptions{}
+	}
+
+	after, err := decodePageToken(options.PageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	delimiter := options.Delimiter
+	if delimiter == "" {
+		delimiter = DefaultListDelimiter
+	}
+	if options.Recursive {
+		delimiter = ""
+	}
+
+	// Objects and prefixes share one ordering so pages can split anywhere
+	type listEntry struct {
+		key    string
+		object *StoredObject
+	}
+	var entries []listEntry
+	seen := make(map[string]bool)
+
+	for _, obj := range all {
+		key := strings.TrimPrefix(obj.Key, "/")
+		if !strings.HasPrefix(key, options.Prefix) {
+			continue
+		}
+		remaining := key[len(options.Prefix):]
+		marker := strings.HasSuffix(key, "/")
+		if remaining == "" && marker {
+			// The directory being listed
+			continue
+		}
+
+		if len(options.Tags) > 0 && (marker || !matchTags(obj.Tags, options.Tags)) {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(remaining, delimiter); i >= 0 {
+				dir := options.Prefix + remaining[:i+len(delimiter)]
+				if !seen[dir] {
+					seen[dir] = true
+					entries = append(entries, listEntry{key: dir})
+				}
+				continue
+			}
+		}
+		if marker || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		item := *obj
+		item.Key = key
+		entries = append(entries, listEntry{key: key, object: &item})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	result := &ListItemsResult{}
+	count := 0
+	last := ""
+	for _, entry := range entries {
+		if after != "" && entry.key <= after {
+			continue
+		}
+		if options.MaxResults > 0 && count == options.MaxResults {
+			result.NextPageToken = encodePageToken(last)
+			break
+		}
+		count++
+		last = entry.key
+
+		if entry.object != nil {
+			result.Objects = append(result.Objects, entry.object)
+		} else {
+			result.Prefixes = append(result.Prefixes, &StoredPrefix{Key: entry.key})
+		}
+	}
+
+	return result, nil
+}
+
+// matchTags reports whether the tags contain every filter tag
+func matchTags(tags map[string]string, filter map[string]string) bool {
+	for k, v := range filter {
+		if actual, ok := tags[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// encodePageToken returns the token for the page following the key. The token
+// holds the last key returned so later pages are stable when keys are added or
+// removed between calls.
+func encodePageToken(last string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(last))
+}
+
+// decodePageToken returns the last key of the previous page
+func decodePageToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(key) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPageToken, token)
+	}
+	return string(key), nil
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyOnlyStorage hides the ListItems method of the wrapped store
+type legacyOnlyStorage struct {
+	ObjectStorage
+}
+
+func listKeys(result *ListItemsResult) []string {
+	var keys []string
+	for _, p := range result.Prefixes {
+		keys = append(keys, p.Key)
+	}
+	for _, o := range result.Objects {
+		keys = append(keys, o.Key)
+	}
+	return keys
+}
+
+func TestListItems_Stores(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	stores := map[string]ObjectStorage{
+		"filesystem":  NewFilesystemObjectStorage(filepath.Join(dir, "fs")),
+		"tar":         NewTarObjectStorage(filepath.Join(dir, "store.tar.gz")),
+		"indexed-tar": NewIndexedTarObjectStorage(filepath.Join(dir, "store.tar")),
+		"zip":         NewZipObjectStorage(filepath.Join(dir, "store.zip")),
+	}
+
+	files := map[string]map[string]string{
+		"a.txt":         {"team": "red"},
+		"b.txt":         nil,
+		"docs/c.txt":    {"team": "red"},
+		"docs/d.txt":    nil,
+		"docs/sub/e.md": {"team": "blue"},
+		"logs/f.log":    nil,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			for key, tags := range files {
+				require.Nil(t, store.Upload(ctx, key, strings.NewReader(key), tags))
+			}
+
+			for _, s := range []ObjectStorage{store, legacyOnlyStorage{store}} {
+				result, err := ListItems(ctx, s, nil)
+				require.Nil(t, err)
+				assert.Equal(t, []string{"docs/", "logs/", "a.txt", "b.txt"}, listKeys(result))
+
+				result, err = ListItems(ctx, s, &ListItemsOptions{Prefix: "docs/", Recursive: true})
+				require.Nil(t, err)
+				assert.Equal(t, []string{"docs/c.txt", "docs/d.txt", "docs/sub/e.md"}, listKeys(result))
+
+				result, err = ListItems(ctx, s, &ListItemsOptions{Prefix: "docs/s"})
+				require.Nil(t, err)
+				assert.Equal(t, []string{"docs/sub/"}, listKeys(result))
+
+				obj, err := StatObject(ctx, s, "/docs/d.txt")
+				require.Nil(t, err)
+				assert.Equal(t, "docs/d.txt", obj.Key)
+				assert.Equal(t, int64(len("docs/d.txt")), obj.Size)
+				_, err = StatObject(ctx, s, "docs/d")
+				assert.ErrorIs(t, err, ErrFileNotFound)
+				_, err = StatObject(ctx, s, "docs")
+				assert.ErrorIs(t, err, ErrFileNotFound)
+			}
+
+			// Paging visits every entry once
+			var keys []string
+			options := &ListItemsOptions{Recursive: true, MaxResults: 4}
+			pages := 0
+			for {
+				result, err := ListItems(ctx, store, options)
+				require.Nil(t, err)
+				keys = append(keys, listKeys(result)...)
+				pages++
+				if result.NextPageToken == "" {
+					break
+				}
+				options.PageToken = result.NextPageToken
+			}
+			assert.Equal(t, 2, pages)
+			assert.Equal(t, []string{"a.txt", "b.txt", "docs/c.txt", "docs/d.txt", "docs/sub/e.md", "logs/f.log"}, keys)
+
+			// Tags are not kept by the filesystem store
+			if name != "filesystem" {
+				result, err := ListItems(ctx, store, &ListItemsOptions{Tags: map[string]string{"team": "red"}})
+				require.Nil(t, err)
+				assert.Equal(t, []string{"docs/", "a.txt"}, listKeys(result))
+			}
+		})
+	}
+}
+
+func TestListItems_FilesystemOutsideRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	outside := NewFilesystemObjectStorage(dir)
+	require.Nil(t, outside.Upload(ctx, "secret.txt", strings.NewReader("secret"), nil))
+	store := NewFilesystemObjectStorage(filepath.Join(dir, "fs", "root"))
+	require.Nil(t, store.Upload(ctx, "a.txt", strings.NewReader("a"), nil))
+
+	for _, prefix := range []string{"../../", "../../secret", "docs/../../../", "/../../"} {
+		_, err := store.ListItems(ctx, &ListItemsOptions{Prefix: prefix, Recursive: true})
+		assert.ErrorIs(t, err, ErrInvalidKey, prefix)
+	}
+
+	result, err := store.ListItems(ctx, &ListItemsOptions{Prefix: "docs/../"})
+	require.Nil(t, err)
+	assert.Empty(t, listKeys(result), "keys never start with the prefix")
+}
+
+func TestPageObjects(t *testing.T) {
+	all := []*StoredObject{
+		{Key: "/2024-01-a"},
+		{Key: "2024-01-b"},
+		{Key: "2024-02-a"},
+		{Key: "dir/"},
+		{Key: "other"},
+	}
+
+	// Custom delimiter, leading slashes removed
+	result, err := PageObjects(all, &ListItemsOptions{Prefix: "2024-", Delimiter: "-"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"2024-01-", "2024-02-"}, listKeys(result))
+
+	// Directory markers only contribute prefixes
+	result, err = PageObjects(all, nil)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"dir/", "2024-01-a", "2024-01-b", "2024-02-a", "other"}, listKeys(result))
+
+	result, err = PageObjects(all, &ListItemsOptions{Recursive: true, MaxResults: 2})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"2024-01-a", "2024-01-b"}, listKeys(result))
+	require.NotEmpty(t, result.NextPageToken)
+
+	// Keys added before the token do not shift the next page
+	all = append(all, &StoredObject{Key: "2023"})
+	result, err = PageObjects(all, &ListItemsOptions{Recursive: true, PageToken: result.NextPageToken})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"2024-02-a", "other"}, listKeys(result))
+	assert.Empty(t, result.NextPageToken)
+
+	_, err = PageObjects(all, &ListItemsOptions{PageToken: "!!"})
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
+
+func TestArchiveList_Delimited(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, store := range map[string]ObjectStorage{
+		"tar": NewTarObjectStorage(filepath.Join(dir, "store.tar")),
+		"zip": NewZipObjectStorage(filepath.Join(dir, "store.zip")),
+	} {
+		require.Nil(t, store.Upload(ctx, "a/b.txt", strings.NewReader("b"), nil), name)
+		require.Nil(t, store.Upload(ctx, "a/c/d.txt", strings.NewReader("d"), nil), name)
+
+		objects, prefixes, err := store.List(ctx, "a/")
+		require.Nil(t, err, name)
+		require.Len(t, objects, 1, name)
+		assert.Equal(t, "a/b.txt", objects[0].Key, name)
+		assert.Equal(t, []*StoredPrefix{{Key: "a/c/"}}, prefixes, name)
+	}
+}
+
+func TestLegacyList(t *testing.T) {
+	ctx := context.Background()
+	store := NewZipObjectStorage(filepath.Join(t.TempDir(), "store.zip"))
+	require.Nil(t, store.Upload(ctx, "a/b.txt", strings.NewReader("b"), nil))
+	require.Nil(t, store.Upload(ctx, "a/c/d.txt", strings.NewReader("d"), nil))
+
+	objects, prefixes, err := LegacyList(ctx, store, "a/")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "a/b.txt", objects[0].Key)
+	assert.Equal(t, []*StoredPrefix{{Key: "a/c/"}}, prefixes)
+}
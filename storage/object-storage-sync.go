@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/appliedres/cloudy"
+)
+
+// SyncActionType describes what Sync does to a single key in the destination
+type SyncActionType string
+
+const (
+	SyncActionCopy           SyncActionType = "copy"            // Content is copied from the source
+	SyncActionUpdateMetadata SyncActionType = "update-metadata" // Only the tags are updated
+	SyncActionDelete         SyncActionType = "delete"          // The key is removed from the destination
+)
+
+// DefaultSyncConcurrency is the number of actions run in parallel when none is specified
+const DefaultSyncConcurrency = 4
+
+// SyncOptions controls the behavior of Sync
+type SyncOptions struct {
+	Prefix           string             // Only keys under this prefix are compared
+	DeleteExtraneous bool               // Remove keys from the destination that are not in the source
+	DryRun           bool               // Only report the differences, do not change the destination
+	IgnoreTags       bool               // Do not compare tags (for backends that do not keep them)
+	Concurrency      int                // Maximum number of actions run at once
+	Progress         func(SyncProgress) // Called after each action completes
+}
+
+// SyncAction is a single difference between the source and destination
+type SyncAction struct {
+	Type   SyncActionType
+	Key    string
+	Size   int64  // Size of the source object (0 for deletes)
+	Reason string // Why the action is needed (missing, size, md5, tags, extraneous)
+}
+
+// SyncProgress is reported to SyncOptions.Progress after each action
+type SyncProgress struct {
+	Action      SyncAction
+	Completed   int   // Number of actions finished so far
+	Total       int   // Total number of actions
+	BytesCopied int64 // Bytes copied so far
+	Err         error // Error for this action, if any
+}
+
+// SyncReport summarizes the differences found and the work performed by Sync
+type SyncReport struct {
+	DryRun      bool
+	Actions     []SyncAction
+	Unchanged   int
+	Copied      int
+	Updated     int
+	Deleted     int
+	Failed      int
+	BytesCopied int64
+}
+
+// Print writes a human readable diff of the report
+func (r *SyncReport) Print(w io.Writer) {
+	for _, action := range r.Actions {
+		switch action.Type {
+		case SyncActionCopy:
+			fmt.Fprintf(w, "+ %s (%s, %d bytes)\n", action.Key, action.Reason, action.Size)
+		case SyncActionUpdateMetadata:
+			fmt.Fprintf(w, "~ %s (%s)\n", action.Key, action.Reason)
+		case SyncActionDelete:
+			fmt.Fprintf(w, "- %s (%s)\n", action.Key, action.Reason)
+		}
+	}
+
+	if r.DryRun {
+		fmt.Fprintf(w, "%d to copy, %d to update, %d to delete, %d unchanged (dry run)\n",
+			r.count(SyncActionCopy), r.count(SyncActionUpdateMetadata), r.count(SyncActionDelete), r.Unchanged)
+		return
+	}
+	fmt.Fprintf(w, "%d copied (%d bytes), %d updated, %d deleted, %d unchanged, %d failed\n",
+		r.Copied, r.BytesCopied, r.Updated, r.Deleted, r.Unchanged, r.Failed)
+}
+
+func (r *SyncReport) count(t SyncActionType) int {
+	n := 0
+	for _, action := range r.Actions {
+		if action.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+// Sync mirrors the objects under a prefix from src into dst. Objects are compared
+// by key, size, MD5 (when both sides report one) and tags. Objects that are missing
+// or differ in content are copied, objects that only differ in tags have their
+// metadata updated, and with DeleteExtraneous objects only in dst are removed.
+//
+// Sync works with any pair of ObjectStorage implementations. Failures of individual
+// actions do not stop the sync; they are counted in the report and returned together
+// as a MultiErrors.
+func Sync(ctx context.Context, src ObjectStorage, dst ObjectStorage, opts *SyncOptions) (*SyncReport, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+
+	srcObjects, err := listAllObjects(ctx, src, opts.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+	dstObjects, err := listAllObjects(ctx, dst, opts.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination: %w", err)
+	}
+
+	report := planSync(srcObjects, dstObjects, opts)
+	if opts.DryRun {
+		return report, nil
+	}
+
+	errs := runSync(ctx, src, dst, srcObjects, report, opts)
+	return report, errs.AsErr()
+}
+
+// planSync compares the listings and records the actions that are needed
+func planSync(srcObjects, dstObjects []*StoredObject, opts *SyncOptions) *SyncReport {
+	report := &SyncReport{DryRun: opts.DryRun}
+
+	dstByKey := make(map[string]*StoredObject)
+	for _, obj := range dstObjects {
+		if !IsDirectory(obj.Key) {
+			dstByKey[obj.Key] = obj
+		}
+	}
+
+	srcKeys := make(map[string]bool)
+	for _, obj := range srcObjects {
+		if IsDirectory(obj.Key) {
+			continue
+		}
+		srcKeys[obj.Key] = true
+
+		existing, ok := dstByKey[obj.Key]
+		reason := ""
+		actionType := SyncActionCopy
+		switch {
+		case !ok:
+			reason = "missing"
+		case existing.Size != obj.Size:
+			reason = "size"
+		case existing.MD5 != "" && obj.MD5 != "" && !strings.EqualFold(existing.MD5, obj.MD5):
+			reason = "md5"
+		case !opts.IgnoreTags && !tagsEqual(existing.Tags, obj.Tags):
+			reason = "tags"
+			actionType = SyncActionUpdateMetadata
+		}
+
+		if reason == "" {
+			report.Unchanged++
+			continue
+		}
+		report.Actions = append(report.Actions, SyncAction{
+			Type:   actionType,
+			Key:    obj.Key,
+			Size:   obj.Size,
+			Reason: reason,
+		})
+	}
+
+	if opts.DeleteExtraneous {
+		var extra []string
+		for key := range dstByKey {
+			if !srcKeys[key] {
+				extra = append(extra, key)
+			}
+		}
+		sort.Strings(extra)
+		for _, key := range extra {
+			report.Actions = append(report.Actions, SyncAction{
+				Type:   SyncActionDelete,
+				Key:    key,
+				Reason: "extraneous",
+			})
+		}
+	}
+
+	return report
+}
+
+// runSync performs the planned actions with a bounded number of workers
+func runSync(ctx context.Context, src, dst ObjectStorage, srcObjects []*StoredObject, report *SyncReport, opts *SyncOptions) *cloudy.MultiErrors {
+	errs := cloudy.MultiError()
+
+	srcByKey := make(map[string]*StoredObject)
+	for _, obj := range srcObjects {
+		srcByKey[obj.Key] = obj
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultSyncConcurrency
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	completed := 0
+	work := make(chan SyncAction)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for action := range work {
+				copied, err := applySyncAction(ctx, src, dst, srcByKey[action.Key], action)
+
+				mu.Lock()
+				completed++
+				if err != nil {
+					report.Failed++
+					errs.Append(fmt.Errorf("%s %s: %w", action.Type, action.Key, err))
+				} else {
+					switch action.Type {
+					case SyncActionCopy:
+						report.Copied++
+					case SyncActionUpdateMetadata:
+						report.Updated++
+					case SyncActionDelete:
+						report.Deleted++
+					}
+					report.BytesCopied += copied
+				}
+				if opts.Progress != nil {
+					opts.Progress(SyncProgress{
+						Action:      action,
+						Completed:   completed,
+						Total:       len(report.Actions),
+						BytesCopied: report.BytesCopied,
+						Err:         err,
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, action := range report.Actions {
+		if ctx.Err() != nil {
+			errs.Append(ctx.Err())
+			break
+		}
+		work <- action
+	}
+	close(work)
+	wg.Wait()
+
+	return errs
+}
+
+// applySyncAction performs a single action and returns the number of bytes copied
+func applySyncAction(ctx context.Context, src, dst ObjectStorage, obj *StoredObject, action SyncAction) (int64, error) {
+	switch action.Type {
+	case SyncActionDelete:
+		return 0, dst.Delete(ctx, action.Key)
+	case SyncActionUpdateMetadata:
+		return 0, dst.UpdateMetadata(ctx, action.Key, obj.Tags)
+	}
+
+	reader, err := src.Download(ctx, action.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	counter := &countingReader{reader: reader}
+	if err := dst.Upload(ctx, action.Key, counter, obj.Tags); err != nil {
+		return 0, err
+	}
+	return counter.count, nil
+}
+
+// tagsEqual compares two tag sets, treating nil and empty as equal
+func tagsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
+			return false
+		}
+	}
+	return true
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync_FilesystemToArchives(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src := NewFilesystemObjectStorage(filepath.Join(dir, "src"))
+	files := map[string]string{
+		"readme.txt":          "hello",
+		"scripts/install.sh":  "#!/bin/sh\necho install",
+		"scripts/nested/x.sh": "echo x",
+	}
+	for key, content := range files {
+		require.Nil(t, src.Upload(ctx, key, strings.NewReader(content), nil))
+	}
+
+	tarStore := NewTarObjectStorage(filepath.Join(dir, "mirror.tar.gz"))
+	zipStore := NewZipObjectStorage(filepath.Join(dir, "mirror.zip"))
+
+	// Dry run reports the differences without changing anything
+	report, err := Sync(ctx, src, tarStore, &SyncOptions{DryRun: true})
+	require.Nil(t, err)
+	assert.Len(t, report.Actions, 3)
+	objects, prefixes, err := tarStore.List(ctx, "")
+	require.Nil(t, err)
+	assert.Empty(t, objects)
+	assert.Empty(t, prefixes)
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	assert.Contains(t, buf.String(), "+ scripts/install.sh (missing")
+	assert.Contains(t, buf.String(), "dry run")
+
+	// Mirror to tar, then tar to zip
+	var mu sync.Mutex
+	var progress []SyncProgress
+	report, err = Sync(ctx, src, tarStore, &SyncOptions{
+		Concurrency: 2,
+		Progress: func(p SyncProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			progress = append(progress, p)
+		},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 3, report.Copied)
+	assert.Len(t, progress, 3)
+	assert.Equal(t, int64(len("hello")+len("#!/bin/sh\necho install")+len("echo x")), report.BytesCopied)
+
+	report, err = Sync(ctx, tarStore, zipStore, nil)
+	require.Nil(t, err)
+	assert.Equal(t, 3, report.Copied)
+
+	for key, content := range files {
+		reader, err := zipStore.Download(ctx, key)
+		require.Nil(t, err)
+		data, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		reader.Close()
+		assert.Equal(t, content, string(data))
+	}
+
+	// A second run has nothing to do
+	report, err = Sync(ctx, src, tarStore, nil)
+	require.Nil(t, err)
+	assert.Empty(t, report.Actions)
+	assert.Equal(t, 3, report.Unchanged)
+}
+
+func TestSync_DeleteExtraneous(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src := NewZipObjectStorage(filepath.Join(dir, "src.zip"))
+	dst := NewFilesystemObjectStorage(filepath.Join(dir, "dst"))
+
+	require.Nil(t, src.Upload(ctx, "keep.txt", strings.NewReader("new content"), nil))
+	require.Nil(t, dst.Upload(ctx, "keep.txt", strings.NewReader("old"), nil))
+	require.Nil(t, dst.Upload(ctx, "extra/stale.txt", strings.NewReader("stale"), nil))
+
+	report, err := Sync(ctx, src, dst, &SyncOptions{DeleteExtraneous: true, DryRun: true})
+	require.Nil(t, err)
+	require.Len(t, report.Actions, 2)
+	assert.Equal(t, SyncAction{Type: SyncActionCopy, Key: "keep.txt", Size: 11, Reason: "size"}, report.Actions[0])
+	assert.Equal(t, SyncAction{Type: SyncActionDelete, Key: "extra/stale.txt", Reason: "extraneous"}, report.Actions[1])
+
+	report, err = Sync(ctx, src, dst, &SyncOptions{DeleteExtraneous: true})
+	require.Nil(t, err)
+	assert.Equal(t, 1, report.Copied)
+	assert.Equal(t, 1, report.Deleted)
+
+	exists, err := dst.Exists(ctx, "extra/stale.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestSync_Tags(t *testing.T) {
+	src := []*StoredObject{{Key: "a", Size: 1, MD5: "abc", Tags: map[string]string{"k": "v"}}}
+	dst := []*StoredObject{{Key: "a", Size: 1, MD5: "ABC"}}
+
+	report := planSync(src, dst, &SyncOptions{})
+	require.Len(t, report.Actions, 1)
+	assert.Equal(t, SyncActionUpdateMetadata, report.Actions[0].Type)
+
+	report = planSync(src, dst, &SyncOptions{IgnoreTags: true})
+	assert.Empty(t, report.Actions)
+
+	dst[0].MD5 = "def"
+	report = planSync(src, dst, &SyncOptions{})
+	require.Len(t, report.Actions, 1)
+	assert.Equal(t, "md5", report.Actions[0].Reason)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+)
+
+// SignedURLHandlerOptions configures a SignedURLHandler
+type SignedURLHandlerOptions struct {
+	// AllowedOrigins lists the origins browsers may call the handler from. Use
+	// "*" to allow any origin. CORS headers are only sent when this is set.
+	AllowedOrigins []string
+}
+
+// SignedURLHandler serves downloads and accepts uploads for an ObjectStorage
+// through URLs created by a URLSigner. Every request must carry a valid signature:
+// GET and HEAD return the object, PUT stores the request body under the key with
+// the tags signed into the URL. Store errors are logged, the response only
+// carries the status.
+type SignedURLHandler struct {
+	store   ObjectStorage
+	signer  *URLSigner
+	options SignedURLHandlerOptions
+}
+
+var _ http.Handler = (*SignedURLHandler)(nil)
+
+// NewSignedURLHandler creates a handler for the store. It must be served at
+// the base URL of the signer.
+func NewSignedURLHandler(store ObjectStorage, signer *URLSigner, options *SignedURLHandlerOptions) *SignedURLHandler {
+	handler := &SignedURLHandler{
+		store:  store,
+		signer: signer,
+	}
+	if options != nil {
+		handler.options = *options
+	}
+	return handler
+}
+
+// ServeHTTP implements http.Handler
+func (h *SignedURLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.setCORSHeaders(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	signed, err := h.signer.Verify(r)
+	if err != nil {
+		status := http.StatusForbidden
+		if errors.Is(err, ErrMethodNotAllowed) {
+			status = http.StatusMethodNotAllowed
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveDownload(w, r, signed)
+	case http.MethodPut:
+		h.serveUpload(w, r, signed)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// setCORSHeaders allows browsers on the configured origins to use the URLs
+func (h *SignedURLHandler) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(h.options.AllowedOrigins) == 0 {
+		return
+	}
+
+	for _, allowed := range h.options.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "*")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Content-Length")
+			w.Header().Add("Vary", "Origin")
+			return
+		}
+	}
+}
+
+func (h *SignedURLHandler) serveDownload(w http.ResponseWriter, r *http.Request, signed *SignedURLRequest) {
+	ctx := r.Context()
+
+	// The size and checksum are optional, the download works without them
+	info, err := StatObject(ctx, h.store, signed.Key)
+	if errors.Is(err, ErrFileNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		cloudy.Warn(ctx, "signed url lookup of %s failed: %v", signed.Key, err)
+		info = nil
+	}
+
+	if r.Method == http.MethodHead {
+		if info == nil {
+			exists, err := h.store.Exists(ctx, signed.Key)
+			if err != nil {
+				h.serverError(w, r, "lookup", signed.Key, err)
+				return
+			}
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+		setObjectHeaders(w, info)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	reader, err := h.store.Download(ctx, signed.Key)
+	if errors.Is(err, ErrFileNotFound) || errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "download", signed.Key, err)
+		return
+	}
+	defer reader.Close()
+
+	setObjectHeaders(w, info)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		cloudy.Warn(ctx, "signed url download of %s failed: %v", signed.Key, err)
+	}
+}
+
+// setObjectHeaders describes the object in the response when its details are known
+func setObjectHeaders(w http.ResponseWriter, info *StoredObject) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if info == nil {
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if info.MD5 != "" {
+		w.Header().Set("ETag", `"`+info.MD5+`"`)
+	}
+}
+
+func (h *SignedURLHandler) serveUpload(w http.ResponseWriter, r *http.Request, signed *SignedURLRequest) {
+	ctx := r.Context()
+
+	var body io.Reader = r.Body
+	if signed.MaxLength > 0 {
+		// Reject declared lengths up front and guard against bodies that lie about it
+		if r.ContentLength > signed.MaxLength {
+			http.Error(w, fmt.Sprintf("upload exceeds the limit of %d bytes", signed.MaxLength), http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, signed.MaxLength)
+	}
+
+	if err := h.store.Upload(ctx, signed.Key, body, signed.Tags); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("upload exceeds the limit of %d bytes", signed.MaxLength), http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.serverError(w, r, "upload", signed.Key, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serverError logs the error and answers without it, store errors can name
+// local paths
+func (h *SignedURLHandler) serverError(w http.ResponseWriter, r *http.Request, action string, key string, err error) {
+	cloudy.Warn(r.Context(), "signed url %s of %s failed: %v", action, key, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of a signed URL
+const (
+	SignedURLMethodParam    = "method"
+	SignedURLExpiresParam   = "expires"
+	SignedURLMaxLengthParam = "max-length"
+	SignedURLSignatureParam = "signature"
+
+	// SignedURLTagParamPrefix marks the tags an upload URL stores, e.g.
+	// "tag.owner=ops" stores the tag "owner"
+	SignedURLTagParamPrefix = "tag."
+)
+
+// DefaultSignedURLExpiry is how long generated URLs stay valid by default
+const DefaultSignedURLExpiry = 15 * time.Minute
+
+var (
+	ErrSignatureInvalid = errors.New("signed url signature is invalid")
+	ErrSignatureExpired = errors.New("signed url has expired")
+	ErrMethodNotAllowed = errors.New("signed url does not allow this method")
+)
+
+var _ ObjectStorageCloud = (*SignedURLObjectStorage)(nil)
+
+// URLSignerOptions configures a URLSigner
+type URLSignerOptions struct {
+	// Expiry is how long generated URLs stay valid. Defaults to DefaultSignedURLExpiry.
+	Expiry time.Duration
+
+	// MaxUploadSize limits the size of uploads through generated URLs. Zero
+	// means no limit.
+	MaxUploadSize int64
+}
+
+// URLSigner creates and verifies HMAC-SHA256 signed URLs for objects. A signed URL
+// names the object in its path and carries the allowed method, the expiry time,
+// an optional content length limit and the signature over all of them in its query:
+//
+//	https://files.example.com/objects/reports/q1.pdf?method=GET&expires=1700000000&signature=...
+//
+// The same key must be used to sign and to verify.
+type URLSigner struct {
+	baseURL *url.URL
+	key     []byte
+	options URLSignerOptions
+	now     func() time.Time
+}
+
+// SignedURLRequest describes a verified request
+type SignedURLRequest struct {
+	Key       string
+	Method    string
+	Expires   time.Time
+	MaxLength int64 // Zero means no limit
+	Tags      map[string]string
+}
+
+// NewURLSigner creates a signer for URLs below the base URL. The base URL is where
+// a SignedURLHandler is served, e.g. "https://files.example.com/objects".
+func NewURLSigner(baseURL string, key []byte, options *URLSignerOptions) (*URLSigner, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("%w: signing key must be at least 32 bytes", ErrInvalidStorage)
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url %s: %w", baseURL, err)
+	}
+
+	signer := &URLSigner{
+		baseURL: base,
+		key:     append([]byte(nil), key...),
+		now:     time.Now,
+	}
+	if options != nil {
+		signer.options = *options
+	}
+	if signer.options.Expiry <= 0 {
+		signer.options.Expiry = DefaultSignedURLExpiry
+	}
+	return signer, nil
+}
+
+// Sign returns a URL that allows the method on the key until the expiry time.
+// A positive maxLength limits the size of the request body.
+func (s *URLSigner) Sign(method, key string, expires time.Time, maxLength int64) (string, error) {
+	return s.SignWithTags(method, key, expires, maxLength, nil)
+}
+
+// SignWithTags returns a URL like Sign that also signs the tags an upload stores
+func (s *URLSigner) SignWithTags(method, key string, expires time.Time, maxLength int64, tags map[string]string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	key = strings.TrimPrefix(key, "/")
+	method = strings.ToUpper(method)
+	if maxLength < 0 {
+		maxLength = 0
+	}
+
+	signed := *s.baseURL
+	signed.Path = s.baseURL.Path + "/" + key
+	signed.RawPath = ""
+
+	query := url.Values{}
+	query.Set(SignedURLMethodParam, method)
+	query.Set(SignedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	if maxLength > 0 {
+		query.Set(SignedURLMaxLengthParam, strconv.FormatInt(maxLength, 10))
+	}
+	for name, value := range tags {
+		query.Set(SignedURLTagParamPrefix+name, value)
+	}
+	query.Set(SignedURLSignatureParam, s.signature(method, key, expires.Unix(), maxLength, tags))
+	signed.RawQuery = query.Encode()
+
+	return signed.String(), nil
+}
+
+// Verify checks the signature of a request made to a signed URL and returns
+// what it allows. HEAD requests are accepted for URLs signed for GET.
+func (s *URLSigner) Verify(r *http.Request) (*SignedURLRequest, error) {
+	key, ok := strings.CutPrefix(r.URL.Path, s.baseURL.Path+"/")
+	if !ok || key == "" {
+		return nil, fmt.Errorf("%w: path is outside of %s", ErrSignatureInvalid, s.baseURL.Path)
+	}
+
+	query := r.URL.Query()
+	method := query.Get(SignedURLMethodParam)
+	expires, err := strconv.ParseInt(query.Get(SignedURLExpiresParam), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad expiry", ErrSignatureInvalid)
+	}
+	var maxLength int64
+	if v := query.Get(SignedURLMaxLengthParam); v != "" {
+		maxLength, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || maxLength <= 0 {
+			return nil, fmt.Errorf("%w: bad max length", ErrSignatureInvalid)
+		}
+	}
+
+	var tags map[string]string
+	for name, values := range query {
+		if tag, ok := strings.CutPrefix(name, SignedURLTagParamPrefix); ok && len(values) > 0 {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[tag] = values[0]
+		}
+	}
+
+	expected := s.signature(method, key, expires, maxLength, tags)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(SignedURLSignatureParam))) {
+		return nil, ErrSignatureInvalid
+	}
+
+	// Only trust the values once the signature has been checked
+	if s.now().Unix() > expires {
+		return nil, ErrSignatureExpired
+	}
+	if r.Method != method && !(r.Method == http.MethodHead && method == http.MethodGet) {
+		return nil, fmt.Errorf("%w: %s", ErrMethodNotAllowed, r.Method)
+	}
+
+	return &SignedURLRequest{
+		Key:       key,
+		Method:    method,
+		Expires:   time.Unix(expires, 0),
+		MaxLength: maxLength,
+		Tags:      tags,
+	}, nil
+}
+
+// signature is the hex encoded HMAC of the signed values. Tags are added in
+// name order, so URLs without tags keep their signature.
+func (s *URLSigner) signature(method, key string, expires, maxLength int64, tags map[string]string) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%d", method, key, expires, maxLength)
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(mac, "\n%q=%q", name, tags[name])
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedURLObjectStorage adds signed URL generation to any ObjectStorage. The URLs
+// are served by a SignedURLHandler for the same store and signer.
+type SignedURLObjectStorage struct {
+	ObjectStorage
+	signer *URLSigner
+}
+
+// NewSignedURLObjectStorage wraps the store so it implements ObjectStorageCloud
+func NewSignedURLObjectStorage(store ObjectStorage, signer *URLSigner) *SignedURLObjectStorage {
+	return &SignedURLObjectStorage{
+		ObjectStorage: store,
+		signer:        signer,
+	}
+}
+
+// Signer returns the signer used for the URLs
+func (s *SignedURLObjectStorage) Signer() *URLSigner {
+	return s.signer
+}
+
+// GenUploadURL implements ObjectStorageCloud.
+// The URL accepts a PUT of up to MaxUploadSize bytes until it expires.
+func (s *SignedURLObjectStorage) GenUploadURL(ctx context.Context, key string) (string, error) {
+	expires := s.signer.now().Add(s.signer.options.Expiry)
+	return s.signer.Sign(http.MethodPut, key, expires, s.signer.options.MaxUploadSize)
+}
+
+// GenUploadURLWithTags returns an upload URL like GenUploadURL that stores the
+// tags with the object. The tags are signed, so the uploader cannot change them.
+func (s *SignedURLObjectStorage) GenUploadURLWithTags(ctx context.Context, key string, tags map[string]string) (string, error) {
+	expires := s.signer.now().Add(s.signer.options.Expiry)
+	return s.signer.SignWithTags(http.MethodPut, key, expires, s.signer.options.MaxUploadSize, tags)
+}
+
+// GenDownloadURL implements ObjectStorageCloud.
+// The URL accepts GET and HEAD requests until it expires.
+func (s *SignedURLObjectStorage) GenDownloadURL(ctx context.Context, key string) (string, error) {
+	expires := s.signer.now().Add(s.signer.options.Expiry)
+	return s.signer.Sign(http.MethodGet, key, expires, 0)
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSigningKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newSignedURLServer(t *testing.T, options *URLSignerOptions) (*SignedURLObjectStorage, *httptest.Server) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	signer, err := NewURLSigner(server.URL+"/objects", testSigningKey, options)
+	require.Nil(t, err)
+
+	store := NewSignedURLObjectStorage(NewZipObjectStorage(filepath.Join(t.TempDir(), "store.zip")), signer)
+	mux.Handle("/objects/", NewSignedURLHandler(store, signer, &SignedURLHandlerOptions{AllowedOrigins: []string{"https://app.example.com"}}))
+	return store, server
+}
+
+func doRequest(t *testing.T, method, target string, body io.Reader, headers map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, target, body)
+	require.Nil(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestSignedURL_UploadAndDownload(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newSignedURLServer(t, &URLSignerOptions{MaxUploadSize: 1024})
+
+	uploadURL, err := store.GenUploadURLWithTags(ctx, "reports/q1 final.txt", map[string]string{"owner": "finance"})
+	require.Nil(t, err)
+
+	// Tags only come from the signature
+	u, err := url.Parse(uploadURL)
+	require.Nil(t, err)
+	q := u.Query()
+	q.Set(SignedURLTagParamPrefix+"owner", "ops")
+	u.RawQuery = q.Encode()
+	resp := doRequest(t, http.MethodPut, u.String(), strings.NewReader("quarterly"), nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodPut, uploadURL, strings.NewReader("quarterly"), map[string]string{
+		"X-Object-Tag-Tier": "gold",
+	})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	obj := findObject(t, store, "reports/", "reports/q1 final.txt")
+	assert.Equal(t, map[string]string{"owner": "finance"}, obj.Tags)
+
+	// An upload URL cannot be used to download
+	resp = doRequest(t, http.MethodGet, uploadURL, nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	downloadURL, err := store.GenDownloadURL(ctx, "reports/q1 final.txt")
+	require.Nil(t, err)
+
+	resp = doRequest(t, http.MethodGet, downloadURL, nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	data, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Equal(t, "quarterly", string(data))
+	assert.Equal(t, `"`+md5String("quarterly")+`"`, resp.Header.Get("ETag"))
+
+	resp = doRequest(t, http.MethodHead, downloadURL, nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(9), resp.ContentLength)
+
+	// Browsers on allowed origins can preflight
+	resp = doRequest(t, http.MethodOptions, uploadURL, nil, map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	resp = doRequest(t, http.MethodOptions, uploadURL, nil, map[string]string{"Origin": "https://evil.example.com"})
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// Missing objects
+	downloadURL, err = store.GenDownloadURL(ctx, "missing.txt")
+	require.Nil(t, err)
+	resp = doRequest(t, http.MethodGet, downloadURL, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSignedURL_Limits(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newSignedURLServer(t, &URLSignerOptions{MaxUploadSize: 8, Expiry: time.Minute})
+
+	uploadURL, err := store.GenUploadURL(ctx, "small.txt")
+	require.Nil(t, err)
+
+	resp := doRequest(t, http.MethodPut, uploadURL, strings.NewReader("far too large"), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Bodies without a declared length are cut off as well
+	resp = doRequest(t, http.MethodPut, uploadURL, io.MultiReader(bytes.NewReader([]byte("far too large"))), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	exists, err := store.Exists(ctx, "small.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	// Tampering with any signed value invalidates the URL
+	u, err := url.Parse(uploadURL)
+	require.Nil(t, err)
+	q := u.Query()
+	q.Set(SignedURLMaxLengthParam, "1000000")
+	u.RawQuery = q.Encode()
+	resp = doRequest(t, http.MethodPut, u.String(), strings.NewReader("far too large"), nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	u, _ = url.Parse(uploadURL)
+	u.Path = strings.Replace(u.Path, "small.txt", "other.txt", 1)
+	resp = doRequest(t, http.MethodPut, u.String(), strings.NewReader("x"), nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Expired URLs are rejected
+	store.Signer().now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	resp = doRequest(t, http.MethodPut, uploadURL, strings.NewReader("ok"), nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestSignedURL_FilesystemUpload(t *testing.T) {
+	ctx := context.Background()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	signer, err := NewURLSigner(server.URL+"/objects", testSigningKey, &URLSignerOptions{MaxUploadSize: 8})
+	require.Nil(t, err)
+	dir := t.TempDir()
+	store := NewSignedURLObjectStorage(NewFilesystemObjectStorage(dir), signer)
+	mux.Handle("/objects/", NewSignedURLHandler(store, signer, nil))
+
+	require.Nil(t, store.Upload(ctx, "kept.txt", strings.NewReader("original"), nil))
+
+	// An oversized body leaves the previous object in place
+	uploadURL, err := store.GenUploadURL(ctx, "kept.txt")
+	require.Nil(t, err)
+	resp := doRequest(t, http.MethodPut, uploadURL, io.MultiReader(strings.NewReader("far too large")), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	reader, err := store.Download(ctx, "kept.txt")
+	require.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "original", string(data))
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Len(t, entries, 1, "no temporary file is left behind")
+
+	// Store errors do not reach the client
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "dir.txt"), 0700))
+	uploadURL, err = store.GenUploadURL(ctx, "dir.txt")
+	require.Nil(t, err)
+	resp = doRequest(t, http.MethodPut, uploadURL, strings.NewReader("x"), nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.NotContains(t, string(body), dir)
+
+	downloadURL, err := store.GenDownloadURL(ctx, "missing.txt")
+	require.Nil(t, err)
+	resp = doRequest(t, http.MethodGet, downloadURL, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	body, err = io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.NotContains(t, string(body), dir)
+}
+
+func TestURLSigner_Verify(t *testing.T) {
+	_, err := NewURLSigner("https://files.example.com", []byte("short"), nil)
+	assert.ErrorIs(t, err, ErrInvalidStorage)
+
+	signer, err := NewURLSigner("https://files.example.com/objects/", testSigningKey, nil)
+	require.Nil(t, err)
+
+	signed, err := signer.Sign("get", "/a/b.txt", time.Now().Add(time.Hour), 0)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(signed, "https://files.example.com/objects/a/b.txt?"))
+
+	req := httptest.NewRequest(http.MethodHead, signed, nil)
+	verified, err := signer.Verify(req)
+	require.Nil(t, err)
+	assert.Equal(t, "a/b.txt", verified.Key)
+	assert.Equal(t, http.MethodGet, verified.Method)
+
+	other, err := NewURLSigner("https://files.example.com/objects", bytes.Repeat([]byte("x"), 32), nil)
+	require.Nil(t, err)
+	_, err = other.Verify(req)
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+}
@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -18,9 +20,12 @@ const (
 // Common errors
 var (
 	ErrEmptyKey       = errors.New("key cannot be empty")
+	ErrInvalidKey     = errors.New("key is outside of the storage")
 	ErrFileNotFound   = errors.New("file not found")
 	ErrFileTooLarge   = errors.New("file size exceeds maximum allowed size")
 	ErrInvalidStorage = errors.New("invalid storage configuration")
+
+	ErrMetadataTooLarge = errors.New("metadata exceeds the space available in the archive entry")
 )
 
 // FileEntry represents a generic file entry that can be used across storage implementations
@@ -126,3 +131,108 @@ func AbsPath(path string) string {
 	}
 	return absPath
 }
+
+// ListDelimited groups a flat list of objects into the entries that sit directly
+// under the prefix and the "/" delimited sub-prefixes below it. Objects whose keys
+// do not start with the prefix are dropped. Keys ending in "/" are treated as
+// directory markers and only contribute prefixes. Prefixes are returned in sorted order.
+func ListDelimited(objects []*StoredObject, prefix string) ([]*StoredObject, []*StoredPrefix) {
+	var files []*StoredObject
+	var dirs []*StoredPrefix
+	seen := make(map[string]bool)
+
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Key, prefix) {
+			continue
+		}
+
+		remaining := strings.TrimPrefix(obj.Key, prefix)
+		if remaining == "" {
+			continue
+		}
+
+		i := strings.Index(remaining, "/")
+		if i < 0 {
+			files = append(files, obj)
+			continue
+		}
+
+		dir := prefix + remaining[:i+1]
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, &StoredPrefix{Key: dir})
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Key < dirs[j].Key })
+	return files, dirs
+}
+
+// listAllObjects collects every object under the prefix in the store, descending
+// into any sub-prefixes the store reports. Leading slashes are removed from the keys
+// so they are comparable across implementations.
+func listAllObjects(ctx context.Context, store ObjectStorage, prefix string) ([]*StoredObject, error) {
+	var all []*StoredObject
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+
+	var walk func(p string) error
+	walk = func(p string) error {
+		visited[p] = true
+		objects, prefixes, err := store.List(ctx, p)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			key := strings.TrimPrefix(obj.Key, "/")
+			if !strings.HasPrefix(key, prefix) || seen[key] {
+				continue
+			}
+			seen[key] = true
+			item := *obj
+			item.Key = key
+			all = append(all, &item)
+		}
+		for _, sub := range prefixes {
+			key := strings.TrimPrefix(sub.Key, "/")
+			if len(key) <= len(p) || visited[key] || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if err := walk(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(prefix); err != nil {
+		return nil, err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Key < all[j].Key })
+	return all, nil
+}
+
+// listAllKeys returns the keys of every object under the prefix in the store
+func listAllKeys(ctx context.Context, store ObjectStorage, prefix string) ([]string, error) {
+	objects, err := listAllObjects(ctx, store, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.Key
+	}
+	return keys, nil
+}
+
+// copyTags returns a copy of the tags so callers cannot mutate stored state
+func copyTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	rtn := make(map[string]string, len(tags))
+	for k, v := range tags {
+		rtn[k] = v
+	}
+	return rtn
+}
@@ -14,7 +14,7 @@ import (
 	"time"
 )
 
-var _ ObjectStorage = (*TarObjectStorage)(nil)
+var _ ListableObjectStorage = (*TarObjectStorage)(nil)
 
 // TarObjectStorage implements the ObjectStorage interface using a tar file
 // as the backing store. It provides methods for managing files within the tar archive.
@@ -23,6 +23,9 @@ var _ ObjectStorage = (*TarObjectStorage)(nil)
 // Thread safety is achieved through a read-write mutex that protects all operations.
 // All operations have protection against decompression bombs by enforcing a 100MB
 // file size limit for each file in the archive.
+//
+// Tags and the MD5 of each file are kept in PAX extended header records (see
+// TarPAXTagPrefix) so they survive standard tar tools.
 type TarObjectStorage struct {
 	tarFilePath string       // The path to the tar file
 	isGzipped   bool         // Whether the tar file is gzipped
@@ -32,11 +35,13 @@ type TarObjectStorage struct {
 // TarEntry represents an entry in the tar file
 // This struct holds both metadata and content for each file in the tar archive
 type TarEntry struct {
-	Name    string    // Path of the file within the tar
-	Size    int64     // Size of the file in bytes
-	IsDir   bool      // Whether this entry is a directory
-	ModTime time.Time // Modification time
-	Content []byte    // File content (nil for directories)
+	Name    string            // Path of the file within the tar
+	Size    int64             // Size of the file in bytes
+	IsDir   bool              // Whether this entry is a directory
+	ModTime time.Time         // Modification time
+	Content []byte            // File content (nil for directories)
+	Tags    map[string]string // Object tags stored in PAX records
+	MD5     string            // Hex encoded MD5 of the content
 }
 
 // NewTarObjectStorage creates a new object storage backend using a tar file
@@ -116,11 +121,14 @@ func (t *TarObjectStorage) readTarEntries(ctx context.Context) ([]TarEntry, erro
 		}
 
 		// Create entry with basic information from the header
+		meta := tarMetadata(header.PAXRecords)
 		entry := TarEntry{
 			Name:    header.Name,
 			Size:    header.Size,
 			IsDir:   header.Typeflag == tar.TypeDir,
 			ModTime: header.ModTime,
+			Tags:    meta.Tags,
+			MD5:     meta.MD5,
 		}
 
 		// Only read content for regular files (not dirs or special files)
@@ -143,6 +151,11 @@ func (t *TarObjectStorage) readTarEntries(ctx context.Context) ([]TarEntry, erro
 
 			// Update the size in case the actual content is smaller than the header size
 			entry.Size = int64(len(content))
+
+			// Archives written by other tools do not carry an MD5
+			if entry.MD5 == "" {
+				entry.MD5 = md5Hex(content)
+			}
 		}
 
 		entries = append(entries, entry)
@@ -151,6 +164,12 @@ func (t *TarObjectStorage) readTarEntries(ctx context.Context) ([]TarEntry, erro
 	return entries, nil
 }
 
+// tarEntryKey returns the object key for a tar entry name. Archives created by
+// tools such as "tar -C dir ." prefix every entry with "./"
+func tarEntryKey(name string) string {
+	return strings.TrimPrefix(name, "./")
+}
+
 // writeTarEntries writes all entries to a new tar file
 // This method takes the entire list of entries and writes them to a new tar file
 // It handles both regular tar files and gzip-compressed tar files based on the isGzipped flag
@@ -188,6 +207,11 @@ func (t *TarObjectStorage) writeTarEntries(entries []TarEntry) error {
 			ModTime: entry.ModTime,
 		}
 
+		if records := tarPAXRecords(archiveMetadata{MD5: entry.MD5, Tags: entry.Tags}); records != nil {
+			header.PAXRecords = records
+			header.Format = tar.FormatPAX
+		}
+
 		if entry.IsDir {
 			header.Typeflag = tar.TypeDir
 			header.Mode = 0755
@@ -269,7 +293,7 @@ func (t *TarObjectStorage) Download(ctx context.Context, key string) (io.ReadClo
 
 	// Find the entry with the specified key
 	for _, entry := range entries {
-		if entry.Name == key && !entry.IsDir {
+		if tarEntryKey(entry.Name) == key && !entry.IsDir {
 			// Return empty content if needed
 			if len(entry.Content) == 0 {
 				return io.NopCloser(bytes.NewReader([]byte{})), nil
@@ -305,7 +329,7 @@ func (t *TarObjectStorage) Exists(ctx context.Context, key string) (bool, error)
 
 	// Check if any entry matches the key
 	for _, entry := range entries {
-		if entry.Name == key {
+		if tarEntryKey(entry.Name) == key {
 			return true, nil
 		}
 	}
@@ -314,48 +338,62 @@ func (t *TarObjectStorage) Exists(ctx context.Context, key string) (bool, error)
 }
 
 // List implements ObjectStorage.
-// Returns the objects and prefixes in the tar archive that match the given prefix
+// Returns the objects directly under the given prefix and the "/" delimited
+// sub-prefixes below it. A leading "./" on entry names is ignored.
 func (t *TarObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	var objects []*StoredObject
-	var prefixes []*StoredPrefix
+	all, err := t.storedObjects(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects, prefixes := ListDelimited(all, prefix)
+	return objects, prefixes, nil
+}
+
+// ListItems implements ListableObjectStorage.
+func (t *TarObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	all, err := t.storedObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return PageObjects(all, options)
+}
 
+// storedObjects returns every entry in the archive. Directories are returned as
+// markers ending in "/".
+func (t *TarObjectStorage) storedObjects(ctx context.Context) ([]*StoredObject, error) {
 	// Read all entries from the tar file
 	entries, err := t.readTarEntries(ctx)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read tar entries: %w", err)
+		return nil, fmt.Errorf("failed to read tar entries: %w", err)
 	}
 
-	// All entries in a tar file start with "./"
-	prefix = "./" + prefix
-
-	// Filter entries based on the prefix and organize them into objects and prefixes
+	var all []*StoredObject
 	for _, entry := range entries {
-		if !strings.HasPrefix(entry.Name, prefix) {
-			// Skip entries that do not match the prefix
-			continue
-		}
-
+		key := tarEntryKey(entry.Name)
 		if entry.IsDir {
-			// This is a directory
-			prefixKey := entry.Name
-			if !strings.HasSuffix(entry.Name, "/") {
-				prefixKey += "/"
+			// Directory markers only contribute prefixes
+			if !strings.HasSuffix(key, "/") {
+				key += "/"
 			}
-			prefixes = append(prefixes, &StoredPrefix{Key: prefixKey})
+			all = append(all, &StoredObject{Key: key})
 			continue
 		}
 
-		objects = append(objects, &StoredObject{
-			Key:  entry.Name,
-			Size: entry.Size, // This is uint32, so safer to convert
-			Tags: make(map[string]string),
+		all = append(all, &StoredObject{
+			Key:  key,
+			Size: entry.Size,
+			Tags: entry.Tags,
+			MD5:  entry.MD5,
 		})
 	}
-
-	return objects, prefixes, nil
+	return all, nil
 }
 
 // Delete implements ObjectStorage.
@@ -379,7 +417,7 @@ func (t *TarObjectStorage) Delete(ctx context.Context, key string) error {
 	found := false
 	var newEntries []TarEntry
 	for _, entry := range entries {
-		if entry.Name != key {
+		if tarEntryKey(entry.Name) != key {
 			newEntries = append(newEntries, entry)
 		} else {
 			found = true
@@ -396,30 +434,38 @@ func (t *TarObjectStorage) Delete(ctx context.Context, key string) error {
 }
 
 // UpdateMetadata implements ObjectStorage.
-// TAR format doesn't have built-in support for metadata, so this implementation
-// just checks if the file exists and returns success.
-// A more sophisticated implementation could store metadata in a special file
-// within the tar archive.
+// The tags are stored in the PAX records of the entry, which requires the
+// archive to be rewritten.
 func (t *TarObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	// Validate the key
 	if err := ValidateKey(key); err != nil {
 		return err
 	}
 
-	// Check if the file exists
-	exists, err := t.Exists(ctx, key)
+	// Read all entries from the tar file
+	entries, err := t.readTarEntries(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check if file exists: %w", err)
+		return fmt.Errorf("failed to read tar entries: %w", err)
 	}
 
-	if !exists {
+	found := false
+	for i, entry := range entries {
+		if tarEntryKey(entry.Name) == key {
+			entries[i].Tags = copyTags(tags)
+			found = true
+			break
+		}
+	}
+
+	if !found {
 		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
 	}
 
-	// File exists, but we can't update metadata in a standard TAR file
-	// A better implementation could store metadata in a special file in the archive
-	// For now, just return success
-	return nil
+	// Write the entries back to the file
+	return t.writeTarEntries(entries)
 }
 
 // Upload implements ObjectStorage.
@@ -439,12 +485,15 @@ func (t *TarObjectStorage) Upload(ctx context.Context, key string, data io.Reade
 	var content []byte
 	var err error
 
+	var checksum string
+
 	// Only read content for regular files, not directories
 	if !isDir {
 		content, err = ReadLimitedContent(data)
 		if err != nil {
 			return err
 		}
+		checksum = md5Hex(content)
 	}
 
 	// Read all existing entries
@@ -459,11 +508,13 @@ func (t *TarObjectStorage) Upload(ctx context.Context, key string, data io.Reade
 	// Find and update or add the entry
 	found := false
 	for i, entry := range entries {
-		if entry.Name == key {
+		if tarEntryKey(entry.Name) == key {
 			entries[i].Content = content
 			entries[i].Size = int64(len(content))
 			entries[i].ModTime = time.Now()
 			entries[i].IsDir = isDir
+			entries[i].Tags = copyTags(tags)
+			entries[i].MD5 = checksum
 			found = true
 			break
 		}
@@ -477,6 +528,8 @@ func (t *TarObjectStorage) Upload(ctx context.Context, key string, data io.Reade
 			IsDir:   isDir,
 			ModTime: time.Now(),
 			Content: content,
+			Tags:    copyTags(tags),
+			MD5:     checksum,
 		})
 	}
 
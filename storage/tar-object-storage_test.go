@@ -154,7 +154,7 @@ func TestTarObjectStorage_BasicOperations(t *testing.T) {
 				t.Error("File should have been deleted")
 			}
 
-			// Test UpdateMetadata (which mostly just checks for existence)
+			// Test UpdateMetadata
 			err = storage.UpdateMetadata(ctx, "folder/nested.txt", map[string]string{"key": "value"})
 			if err != nil {
 				t.Fatalf("Error updating metadata: %v", err)
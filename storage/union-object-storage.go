@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Whiteout markers follow the OCI image layer convention. A file named
+// ".wh.<name>" hides <name> (and everything below it) in lower layers, and a file
+// named ".wh..wh..opq" hides everything in its directory from lower layers.
+const (
+	WhiteoutPrefix = ".wh."
+	WhiteoutOpaque = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+var (
+	ErrReservedKey      = errors.New("key is reserved for whiteout markers")
+	ErrNoWritableLayer  = errors.New("union storage has no writable layer")
+	ErrReadOnlyLayer    = errors.New("object is provided by a read-only layer")
+	ErrNoUnionLayers    = errors.New("union storage needs at least one layer")
+	errWhiteoutNotFound = errors.New("no whiteout")
+)
+
+var _ ListableObjectStorage = (*UnionObjectStorage)(nil)
+
+// UnionLayer is one layer of a UnionObjectStorage
+type UnionLayer struct {
+	Store    ObjectStorage
+	ReadOnly bool
+}
+
+// UnionObjectStorage layers several object stores into one. The first layer is
+// the top. Reads return the object from the highest layer that has it, writes go
+// to the highest writable layer, and deletes of objects that live in lower layers
+// leave a whiteout marker in the writable layer so they appear removed. Updating
+// the tags of an object from a lower layer copies it up to the writable layer.
+//
+// A typical setup puts an admin editable directory over default content shipped
+// in a zip:
+//
+//	union, err := storage.NewUnionObjectStorage(
+//		storage.UnionLayer{Store: storage.NewFilesystemObjectStorage("/etc/app/overrides")},
+//		storage.UnionLayer{Store: storage.NewZipObjectStorage("/usr/share/app/defaults.zip"), ReadOnly: true},
+//	)
+type UnionObjectStorage struct {
+	layers   []UnionLayer
+	writable int // Index of the top writable layer, -1 if none
+}
+
+// NewUnionObjectStorage creates a union of the layers, top layer first
+func NewUnionObjectStorage(layers ...UnionLayer) (*UnionObjectStorage, error) {
+	if len(layers) == 0 {
+		return nil, ErrNoUnionLayers
+	}
+
+	u := &UnionObjectStorage{
+		layers:   layers,
+		writable: -1,
+	}
+	for i, layer := range layers {
+		if !layer.ReadOnly {
+			u.writable = i
+			break
+		}
+	}
+	return u, nil
+}
+
+// whiteoutKey returns the marker that hides the key
+func whiteoutKey(key string) string {
+	dir, base := path.Split(strings.TrimSuffix(key, "/"))
+	return dir + WhiteoutPrefix + base
+}
+
+// isWhiteout reports whether the key is a whiteout marker
+func isWhiteout(key string) bool {
+	return strings.HasPrefix(path.Base(key), WhiteoutPrefix)
+}
+
+// checkKey validates a key written through the union
+func checkKey(key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, WhiteoutPrefix) {
+			return fmt.Errorf("%w: %s", ErrReservedKey, key)
+		}
+	}
+	return nil
+}
+
+// ancestors returns the directories above the key, closest first, e.g.
+// "a/b/c" returns "a/b/" and "a/"
+func ancestors(key string) []string {
+	var dirs []string
+	dir := path.Dir(strings.TrimSuffix(key, "/"))
+	for dir != "." && dir != "/" && dir != "" {
+		dirs = append(dirs, dir+"/")
+		dir = path.Dir(dir)
+	}
+	return dirs
+}
+
+// findWhiteout returns the marker in the layer that hides the key from lower
+// layers, or errWhiteoutNotFound
+func findWhiteout(ctx context.Context, layer ObjectStorage, key string) (string, error) {
+	candidates := []string{whiteoutKey(key)}
+	for _, dir := range ancestors(key) {
+		candidates = append(candidates, whiteoutKey(dir), dir+WhiteoutOpaque)
+	}
+
+	for _, candidate := range candidates {
+		exists, err := layer.Exists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return candidate, nil
+		}
+	}
+	return "", errWhiteoutNotFound
+}
+
+// resolve returns the index of the layer the key is read from, or -1 when no
+// layer from the start index down provides it
+func (u *UnionObjectStorage) resolve(ctx context.Context, key string, start int) (int, error) {
+	for i := start; i < len(u.layers); i++ {
+		layer := u.layers[i].Store
+
+		exists, err := layer.Exists(ctx, key)
+		if err != nil {
+			return -1, err
+		}
+		if exists {
+			return i, nil
+		}
+
+		_, err = findWhiteout(ctx, layer, key)
+		if err == nil {
+			return -1, nil
+		}
+		if !errors.Is(err, errWhiteoutNotFound) {
+			return -1, err
+		}
+	}
+	return -1, nil
+}
+
+// writableLayer returns the top writable layer
+func (u *UnionObjectStorage) writableLayer() (ObjectStorage, error) {
+	if u.writable < 0 {
+		return nil, ErrNoWritableLayer
+	}
+	return u.layers[u.writable].Store, nil
+}
+
+// Exists implements ObjectStorage.
+func (u *UnionObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ValidateKey(key); err != nil {
+		return false, err
+	}
+	if isWhiteout(key) {
+		return false, nil
+	}
+
+	i, err := u.resolve(ctx, key, 0)
+	if err != nil {
+		return false, err
+	}
+	return i >= 0, nil
+}
+
+// Download implements ObjectStorage.
+// The object is read from the highest layer that provides it.
+func (u *UnionObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if isWhiteout(key) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	i, err := u.resolve(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	return u.layers[i].Store.Download(ctx, key)
+}
+
+// Upload implements ObjectStorage.
+// The object is written to the top writable layer. Whiteouts in that layer that
+// hide the key are removed; a whiteout of a parent directory becomes an opaque
+// marker so the rest of the directory stays hidden.
+func (u *UnionObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	layer, err := u.writableLayer()
+	if err != nil {
+		return err
+	}
+
+	if err := layer.Upload(ctx, key, data, tags); err != nil {
+		return err
+	}
+	return u.clearWhiteouts(ctx, layer, key)
+}
+
+// clearWhiteouts removes the markers in the layer that hide the key
+func (u *UnionObjectStorage) clearWhiteouts(ctx context.Context, layer ObjectStorage, key string) error {
+	for {
+		marker, err := findWhiteout(ctx, layer, key)
+		if errors.Is(err, errWhiteoutNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Opaque markers only hide lower layers, the key in this layer is visible
+		if path.Base(marker) == WhiteoutOpaque {
+			return nil
+		}
+
+		if err := layer.Delete(ctx, marker); err != nil {
+			return fmt.Errorf("failed to remove whiteout %s: %w", marker, err)
+		}
+
+		// The key was hidden through a removed directory
+		if marker != whiteoutKey(key) {
+			dir := path.Dir(marker) + "/"
+			if dir == "./" {
+				dir = ""
+			}
+			dir += strings.TrimPrefix(path.Base(marker), WhiteoutPrefix) + "/"
+			if err := layer.Upload(ctx, dir+WhiteoutOpaque, bytes.NewReader(nil), nil); err != nil {
+				return fmt.Errorf("failed to write opaque marker for %s: %w", dir, err)
+			}
+		}
+	}
+}
+
+// Delete implements ObjectStorage.
+// The object is removed from the writable layer, and a whiteout is written
+// when a lower layer still provides it.
+func (u *UnionObjectStorage) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	layer, err := u.writableLayer()
+	if err != nil {
+		return err
+	}
+
+	i, err := u.resolve(ctx, key, 0)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+	if i < u.writable {
+		return fmt.Errorf("%w: %s", ErrReadOnlyLayer, key)
+	}
+
+	if i == u.writable {
+		if err := layer.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	lower, err := u.resolve(ctx, key, u.writable+1)
+	if err != nil {
+		return err
+	}
+	if lower < 0 {
+		return nil
+	}
+	return layer.Upload(ctx, whiteoutKey(key), bytes.NewReader(nil), nil)
+}
+
+// UpdateMetadata implements ObjectStorage.
+// Objects from lower layers are copied up to the writable layer with the new tags.
+func (u *UnionObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	layer, err := u.writableLayer()
+	if err != nil {
+		return err
+	}
+
+	i, err := u.resolve(ctx, key, 0)
+	if err != nil {
+		return err
+	}
+	switch {
+	case i < 0:
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	case i < u.writable:
+		return fmt.Errorf("%w: %s", ErrReadOnlyLayer, key)
+	case i == u.writable:
+		return layer.UpdateMetadata(ctx, key, tags)
+	}
+
+	reader, err := u.layers[i].Store.Download(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return layer.Upload(ctx, key, reader, tags)
+}
+
+// List implements ObjectStorage.
+// Returns the merged objects directly under the prefix and the "/" delimited
+// sub-prefixes below it.
+func (u *UnionObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
+	all, err := u.mergedObjects(ctx, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects, prefixes := ListDelimited(all, prefix)
+	return objects, prefixes, nil
+}
+
+// ListItems implements ListableObjectStorage.
+func (u *UnionObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	if options == nil {
+		options = &ListItemsOptions{}
+	}
+	all, err := u.mergedObjects(ctx, options.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return PageObjects(all, options)
+}
+
+// mergedObjects returns every visible object below the directory holding the
+// prefix, taking each key from the highest layer and applying whiteouts
+func (u *UnionObjectStorage) mergedObjects(ctx context.Context, prefix string) ([]*StoredObject, error) {
+	start := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = prefix[:i+1]
+	}
+
+	var merged []*StoredObject
+	seen := make(map[string]bool)
+	var removed []string // Keys hidden from lower layers, directories end in "/"
+
+	hidden := func(key string) bool {
+		for _, r := range removed {
+			if key == r || (strings.HasSuffix(r, "/") && strings.HasPrefix(key, r)) ||
+				strings.HasPrefix(key, r+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, layer := range u.layers {
+		objects, err := listRecursive(ctx, layer.Store, start)
+		if err != nil {
+			return nil, err
+		}
+
+		var layerRemoved []string
+		for _, obj := range objects {
+			dir, base := path.Split(obj.Key)
+			switch {
+			case base == WhiteoutOpaque:
+				layerRemoved = append(layerRemoved, dir)
+			case strings.HasPrefix(base, WhiteoutPrefix):
+				layerRemoved = append(layerRemoved, dir+strings.TrimPrefix(base, WhiteoutPrefix))
+			case !seen[obj.Key] && !hidden(obj.Key):
+				seen[obj.Key] = true
+				merged = append(merged, obj)
+			}
+		}
+
+		// Whiteouts in this layer only affect the layers below it
+		removed = append(removed, layerRemoved...)
+
+		// A whiteout above the listed directory hides the rest of the layers
+		if start != "" {
+			_, err := findWhiteout(ctx, layer.Store, start)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, errWhiteoutNotFound) {
+				return nil, err
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// listRecursive returns every object below the prefix. Recursive listings
+// leave out directory markers, so empty directories are not returned.
+func listRecursive(ctx context.Context, store ObjectStorage, prefix string) ([]*StoredObject, error) {
+	var all []*StoredObject
+	options := &ListItemsOptions{Prefix: prefix, Recursive: true}
+	for {
+		page, err := ListItems(ctx, store, options)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Objects...)
+		if page.NextPageToken == "" {
+			return all, nil
+		}
+		options.PageToken = page.NextPageToken
+	}
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUnion(t *testing.T) (*UnionObjectStorage, ObjectStorage, ObjectStorage) {
+	ctx := context.Background()
+
+	base := NewZipObjectStorage(filepath.Join(t.TempDir(), "defaults.zip"))
+	require.Nil(t, base.Upload(ctx, "config/app.yaml", strings.NewReader("default"), map[string]string{"source": "zip"}))
+	require.Nil(t, base.Upload(ctx, "config/db.yaml", strings.NewReader("db"), nil))
+	require.Nil(t, base.Upload(ctx, "templates/mail/welcome.txt", strings.NewReader("hello"), nil))
+	require.Nil(t, base.Upload(ctx, "templates/mail/reset.txt", strings.NewReader("reset"), nil))
+
+	top := NewFilesystemObjectStorage(t.TempDir())
+
+	union, err := NewUnionObjectStorage(
+		UnionLayer{Store: top},
+		UnionLayer{Store: base, ReadOnly: true},
+	)
+	require.Nil(t, err)
+	return union, top, base
+}
+
+func TestUnionObjectStorage_ReadsAndOverrides(t *testing.T) {
+	ctx := context.Background()
+	union, top, base := newTestUnion(t)
+
+	assert.Equal(t, "default", readAllObject(t, union, "config/app.yaml"))
+
+	require.Nil(t, union.Upload(ctx, "config/app.yaml", strings.NewReader("override"), nil))
+	assert.Equal(t, "override", readAllObject(t, union, "config/app.yaml"))
+	assert.Equal(t, "override", readAllObject(t, top, "config/app.yaml"))
+	assert.Equal(t, "default", readAllObject(t, base, "config/app.yaml"))
+
+	exists, err := union.Exists(ctx, "config/missing.yaml")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	_, err = union.Download(ctx, "config/missing.yaml")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+
+	err = union.Upload(ctx, "config/.wh.app.yaml", strings.NewReader(""), nil)
+	assert.ErrorIs(t, err, ErrReservedKey)
+}
+
+func TestUnionObjectStorage_DeleteWritesWhiteout(t *testing.T) {
+	ctx := context.Background()
+	union, top, _ := newTestUnion(t)
+
+	require.Nil(t, union.Upload(ctx, "config/app.yaml", strings.NewReader("override"), nil))
+	require.Nil(t, union.Delete(ctx, "config/app.yaml"))
+
+	exists, err := union.Exists(ctx, "config/app.yaml")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	exists, err = top.Exists(ctx, "config/.wh.app.yaml")
+	require.Nil(t, err)
+	assert.True(t, exists)
+
+	assert.ErrorIs(t, union.Delete(ctx, "config/app.yaml"), ErrFileNotFound)
+
+	objects, _, err := union.List(ctx, "config/")
+	require.Nil(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "config/db.yaml", objects[0].Key)
+
+	// Uploading again removes the whiteout
+	require.Nil(t, union.Upload(ctx, "config/app.yaml", strings.NewReader("again"), nil))
+	assert.Equal(t, "again", readAllObject(t, union, "config/app.yaml"))
+	exists, err = top.Exists(ctx, "config/.wh.app.yaml")
+	require.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestUnionObjectStorage_DirectoryWhiteout(t *testing.T) {
+	ctx := context.Background()
+	union, top, _ := newTestUnion(t)
+
+	// A whiteout of the directory hides everything below it
+	require.Nil(t, top.Upload(ctx, "templates/.wh.mail", strings.NewReader(""), nil))
+
+	exists, err := union.Exists(ctx, "templates/mail/welcome.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	result, err := union.ListItems(ctx, &ListItemsOptions{Prefix: "templates/", Recursive: true})
+	require.Nil(t, err)
+	assert.Empty(t, result.Objects)
+
+	// Writing into the directory keeps the rest of the lower directory hidden
+	require.Nil(t, union.Upload(ctx, "templates/mail/welcome.txt", strings.NewReader("custom"), nil))
+	assert.Equal(t, "custom", readAllObject(t, union, "templates/mail/welcome.txt"))
+
+	exists, err = union.Exists(ctx, "templates/mail/reset.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	result, err = union.ListItems(ctx, &ListItemsOptions{Prefix: "templates/mail/", Recursive: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"templates/mail/welcome.txt"}, listKeys(result))
+}
+
+func TestUnionObjectStorage_ListMergesLayers(t *testing.T) {
+	ctx := context.Background()
+	union, _, _ := newTestUnion(t)
+
+	require.Nil(t, union.Upload(ctx, "config/extra.yaml", strings.NewReader("extra"), nil))
+	require.Nil(t, union.Upload(ctx, "local/notes.txt", strings.NewReader("notes"), nil))
+
+	objects, prefixes, err := union.List(ctx, "config/")
+	require.Nil(t, err)
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	assert.ElementsMatch(t, []string{"config/app.yaml", "config/db.yaml", "config/extra.yaml"}, keys)
+	assert.Empty(t, prefixes)
+
+	result, err := union.ListItems(ctx, &ListItemsOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"config/", "local/", "templates/"}, listKeys(result))
+}
+
+func TestUnionObjectStorage_CopyUpOnMetadataUpdate(t *testing.T) {
+	ctx := context.Background()
+	union, top, base := newTestUnion(t)
+
+	require.Nil(t, union.UpdateMetadata(ctx, "config/app.yaml", map[string]string{"source": "admin"}))
+
+	assert.Equal(t, "default", readAllObject(t, top, "config/app.yaml"))
+	assert.Equal(t, map[string]string{"source": "zip"}, findObject(t, base, "config/", "config/app.yaml").Tags)
+
+	assert.ErrorIs(t, union.UpdateMetadata(ctx, "config/missing.yaml", nil), ErrFileNotFound)
+}
+
+func TestUnionObjectStorage_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	base := NewFilesystemObjectStorage(t.TempDir())
+	require.Nil(t, base.Upload(ctx, "a.txt", strings.NewReader("a"), nil))
+
+	union, err := NewUnionObjectStorage(UnionLayer{Store: base, ReadOnly: true})
+	require.Nil(t, err)
+
+	assert.Equal(t, "a", readAllObject(t, union, "a.txt"))
+	assert.ErrorIs(t, union.Upload(ctx, "b.txt", strings.NewReader("b"), nil), ErrNoWritableLayer)
+	assert.ErrorIs(t, union.Delete(ctx, "a.txt"), ErrNoWritableLayer)
+
+	_, err = NewUnionObjectStorage()
+	assert.ErrorIs(t, err, ErrNoUnionLayers)
+}
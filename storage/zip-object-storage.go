@@ -4,47 +4,47 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
-var _ ObjectStorage = (*ZipObjectStorage)(nil)
+var _ ListableObjectStorage = (*ZipObjectStorage)(nil)
 
 // ZipObjectStorage implements the ObjectStorage interface using a zip file
 // as the backing store. It provides methods for managing files within the zip.
+//
+// Tags and the MD5 of each file are kept in a private extra field of the entry
+// (see ZipMetadataExtraID) which standard zip tools ignore.
 type ZipObjectStorage struct {
 	zipFilePath string
 	mu          sync.RWMutex // Protect concurrent access to the zip file
 }
 
-// Delete implements ObjectStorage.
-func (z *ZipObjectStorage) Delete(ctx context.Context, key string) error {
-	z.mu.Lock()
-	defer z.mu.Unlock()
-
-	// Validate the key
-	if err := ValidateKey(key); err != nil {
-		return err
-	}
-
-	// Check if the zip file exists
-	exists, err := CheckFileExists(z.zipFilePath)
-	if !exists {
-		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
-	}
+// rewrite copies the archive into a new zip file and replaces the original. The
+// function is given the writer and the entries of the existing archive (if any)
+// and decides what is written. Entries are copied with Writer.Copy, which keeps
+// their compressed data, extra fields and comments intact.
+func (z *ZipObjectStorage) rewrite(fn func(zipWriter *zip.Writer, existing []*zip.File) error) error {
+	var existing []*zip.File
 
-	// Open the existing zip file
-	existingZipReader, err := zip.OpenReader(z.zipFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to open zip file: %w", err)
+	// Check if the zip file already exists
+	if _, err := os.Stat(z.zipFilePath); err == nil {
+		existingZipReader, err := zip.OpenReader(z.zipFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to open existing zip file: %w", err)
+		}
+		defer existingZipReader.Close()
+		existing = existingZipReader.File
 	}
-	defer existingZipReader.Close()
 
-	// Create a temp file to write the new zip content
+	// Create a temp file for the new zip content
 	tempZipFile, tempZipPath, err := CreateTempFile(z.zipFilePath, "temp_", ".zip")
 	if err != nil {
 		return fmt.Errorf("failed to create temp zip file: %w", err)
@@ -54,49 +54,10 @@ func (z *ZipObjectStorage) Delete(ctx context.Context, key string) error {
 	// Create a new zip writer
 	zipWriter := zip.NewWriter(tempZipFile)
 
-	// Copy all files from the original zip except the one to delete
-	for _, file := range existingZipReader.File {
-		if file.Name == key {
-			// Skip the file to delete
-			continue
-		}
-
-		// Open the file from the original zip
-		fileReader, err := file.Open()
-		if err != nil {
-			zipWriter.Close()
-			tempZipFile.Close()
-			return fmt.Errorf("failed to open file in original zip: %w", err)
-		}
-
-		// Create a new entry in the new zip
-		writer, err := zipWriter.Create(file.Name)
-		if err != nil {
-			fileReader.Close()
-			zipWriter.Close()
-			tempZipFile.Close()
-			return fmt.Errorf("failed to create file in new zip: %w", err)
-		}
-
-		// Copy the content with size limit to prevent decompression bombs
-		// Limit size to 100MB per file (adjust as needed)
-		const maxSize = 100 * 1024 * 1024
-		limitedReader := io.LimitReader(fileReader, maxSize)
-		written, err := io.Copy(writer, limitedReader)
-		if err != nil {
-			fileReader.Close()
-			zipWriter.Close()
-			tempZipFile.Close()
-			return fmt.Errorf("failed to copy file content: %w", err)
-		}
-		if written >= maxSize {
-			fileReader.Close()
-			zipWriter.Close()
-			tempZipFile.Close()
-			return fmt.Errorf("file size exceeds maximum allowed size of %d bytes", maxSize)
-		}
-
-		fileReader.Close()
+	if err := fn(zipWriter, existing); err != nil {
+		zipWriter.Close()
+		tempZipFile.Close()
+		return err
 	}
 
 	// Finalize the zip file
@@ -114,6 +75,43 @@ func (z *ZipObjectStorage) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// Delete implements ObjectStorage.
+func (z *ZipObjectStorage) Delete(ctx context.Context, key string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	// Check if the zip file exists
+	exists, _ := CheckFileExists(z.zipFilePath)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	}
+
+	return z.rewrite(func(zipWriter *zip.Writer, existing []*zip.File) error {
+		found := false
+
+		// Copy all files from the original zip except the one to delete
+		for _, file := range existing {
+			if file.Name == key {
+				found = true
+				continue
+			}
+			if err := zipWriter.Copy(file); err != nil {
+				return fmt.Errorf("failed to copy file %s: %w", file.Name, err)
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+		}
+		return nil
+	})
+}
+
 // Download implements ObjectStorage.
 func (z *ZipObjectStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
 	z.mu.RLock()
@@ -199,83 +197,110 @@ func (z *ZipObjectStorage) Exists(ctx context.Context, key string) (bool, error)
 }
 
 // List implements ObjectStorage.
+// Returns the objects directly under the given prefix and the "/" delimited
+// sub-prefixes below it, like the tar stores.
 func (z *ZipObjectStorage) List(ctx context.Context, prefix string) ([]*StoredObject, []*StoredPrefix, error) {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	var objects []*StoredObject
-	var prefixes []*StoredPrefix
+	all, err := z.storedObjects()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects, prefixes := ListDelimited(all, prefix)
+	return objects, prefixes, nil
+}
+
+// ListItems implements ListableObjectStorage.
+func (z *ZipObjectStorage) ListItems(ctx context.Context, options *ListItemsOptions) (*ListItemsResult, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	all, err := z.storedObjects()
+	if err != nil {
+		return nil, err
+	}
+	return PageObjects(all, options)
+}
+
+// storedObjects returns every entry in the zip. Directories are returned as
+// markers ending in "/".
+func (z *ZipObjectStorage) storedObjects() ([]*StoredObject, error) {
+	var all []*StoredObject
 
 	// Check if the zip file exists
 	exists, _ := CheckFileExists(z.zipFilePath)
 	if !exists {
 		// If zip doesn't exist, return empty results (not an error)
-		return objects, prefixes, nil
+		return all, nil
 	}
 
 	// Open the zip file
 	zipReader, err := zip.OpenReader(z.zipFilePath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open zip file: %w", err)
+		return nil, fmt.Errorf("failed to open zip file: %w", err)
 	}
 	defer zipReader.Close()
 
 	// Iterate through all files in the zip
 	for _, file := range zipReader.File {
-		if !strings.HasPrefix(file.Name, prefix) {
-			continue
-		}
-
-		// This file matches our prefix
 		info := file.FileInfo()
 		if info.IsDir() {
 			// This is a directory
-			prefixKey := file.Name
-			if !strings.HasSuffix(file.Name, "/") {
-				prefixKey += "/"
+			key := file.Name
+			if !strings.HasSuffix(key, "/") {
+				key += "/"
+			}
+			all = append(all, &StoredObject{Key: key})
+			continue
+		}
+
+		// This is a file
+		meta, found := zipMetadata(file.Extra)
+		if !found {
+			// Archives written by other tools do not carry an MD5
+			checksum, err := zipEntryMD5(file)
+			if err != nil {
+				return nil, err
 			}
-			prefixes = append(prefixes, &StoredPrefix{Key: prefixKey})
-		} else {
-			// This is a file
-			objects = append(objects, &StoredObject{
-				Key:  file.Name,
-				Size: info.Size(), // This is uint32, so safer to convert
-				Tags: make(map[string]string),
-			})
+			meta.MD5 = checksum
 		}
 
+		all = append(all, &StoredObject{
+			Key:  file.Name,
+			Size: info.Size(),
+			Tags: meta.Tags,
+			MD5:  meta.MD5,
+		})
 	}
 
-	return objects, prefixes, nil
+	return all, nil
 }
 
-// UpdateMetadata implements ObjectStorage.
-func (z *ZipObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
-	// Validate the key
-	if err := ValidateKey(key); err != nil {
-		return err
+// zipEntryMD5 calculates the MD5 of an entry's content
+func zipEntryMD5(file *zip.File) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open file in zip: %w", err)
 	}
+	defer reader.Close()
 
-	// ZIP format doesn't natively support metadata/tags
-	// We could implement this by storing a side JSON file inside the zip
-	// with the metadata, but for simplicity we'll just check if the file exists
-
-	exists, err := z.Exists(ctx, key)
+	hash := md5.New()
+	written, err := io.Copy(hash, io.LimitReader(reader, MaxFileSize))
 	if err != nil {
-		return fmt.Errorf("failed to check if file exists: %w", err)
+		return "", fmt.Errorf("failed to read file in zip: %w", err)
 	}
-
-	if !exists {
-		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
+	if written >= MaxFileSize {
+		return "", fmt.Errorf("%w: %d bytes", ErrFileTooLarge, MaxFileSize)
 	}
-
-	// File exists, but we can't update metadata in a standard ZIP file
-	// so we'll just return success
-	return nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// Upload implements ObjectStorage.
-func (z *ZipObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+// UpdateMetadata implements ObjectStorage.
+// The tags are stored in a private extra field of the entry. The compressed
+// content is copied as is.
+func (z *ZipObjectStorage) UpdateMetadata(ctx context.Context, key string, tags map[string]string) error {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
@@ -284,109 +309,114 @@ func (z *ZipObjectStorage) Upload(ctx context.Context, key string, data io.Reade
 		return err
 	}
 
-	// Read data with size limits to prevent decompression bombs
-	content, err := ReadLimitedContent(data)
-	if err != nil {
-		return err
-	}
-
-	var existingZipReader *zip.ReadCloser
-	zipExists := false
-
-	// Check if the zip file already exists
-	if _, err := os.Stat(z.zipFilePath); err == nil {
-		zipExists = true
-		// Open the existing zip file
-		existingZipReader, err = zip.OpenReader(z.zipFilePath)
-		if err != nil {
-			return fmt.Errorf("failed to open existing zip file: %w", err)
-		}
-		defer existingZipReader.Close()
-	}
-
-	// Create a temp file for the new zip content
-	tempZipFile, tempZipPath, err := CreateTempFile(z.zipFilePath, "temp_", ".zip")
-	if err != nil {
-		return fmt.Errorf("failed to create temp zip file: %w", err)
+	// Check if the zip file exists
+	exists, _ := CheckFileExists(z.zipFilePath)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, key)
 	}
-	defer os.Remove(tempZipPath) // Clean up in case of failure
 
-	// Create a new zip writer
-	zipWriter := zip.NewWriter(tempZipFile)
+	return z.rewrite(func(zipWriter *zip.Writer, existing []*zip.File) error {
+		found := false
 
-	// If the zip existed, copy all files from the original except the one we're updating
-	if zipExists {
-		for _, file := range existingZipReader.File {
-			if file.Name == key {
-				// Skip, we'll add an updated version later
+		for _, file := range existing {
+			if file.Name != key {
+				if err := zipWriter.Copy(file); err != nil {
+					return fmt.Errorf("failed to copy file %s: %w", file.Name, err)
+				}
 				continue
 			}
+			found = true
+
+			meta, hasMeta := zipMetadata(file.Extra)
+			if !hasMeta {
+				checksum, err := zipEntryMD5(file)
+				if err != nil {
+					return err
+				}
+				meta.MD5 = checksum
+			}
+			meta.Tags = copyTags(tags)
 
-			// Open the file from the original zip
-			fileReader, err := file.Open()
+			header := file.FileHeader
+			extra, err := zipExtraWithMetadata(header.Extra, meta)
 			if err != nil {
-				zipWriter.Close()
-				tempZipFile.Close()
-				return fmt.Errorf("failed to open file in original zip: %w", err)
+				return err
 			}
+			header.Extra = extra
 
-			// Create a new entry in the new zip
-			writer, err := zipWriter.Create(file.Name)
+			raw, err := file.OpenRaw()
 			if err != nil {
-				fileReader.Close()
-				zipWriter.Close()
-				tempZipFile.Close()
-				return fmt.Errorf("failed to create file in new zip: %w", err)
+				return fmt.Errorf("failed to open file in original zip: %w", err)
 			}
-
-			// Copy the content with size limit
-			const maxSize = 100 * 1024 * 1024 // 100MB per file
-			limitedReader := io.LimitReader(fileReader, maxSize)
-			written, err := io.Copy(writer, limitedReader)
+			writer, err := zipWriter.CreateRaw(&header)
 			if err != nil {
-				fileReader.Close()
-				zipWriter.Close()
-				tempZipFile.Close()
-				return fmt.Errorf("failed to copy file content: %w", err)
+				return fmt.Errorf("failed to create file in new zip: %w", err)
 			}
-			if written >= maxSize {
-				fileReader.Close()
-				zipWriter.Close()
-				tempZipFile.Close()
-				return fmt.Errorf("file size exceeds maximum allowed size of %d bytes", maxSize)
+			if _, err := io.Copy(writer, raw); err != nil {
+				return fmt.Errorf("failed to copy file content: %w", err)
 			}
+		}
 
-			fileReader.Close()
+		if !found {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, key)
 		}
+		return nil
+	})
+}
+
+// Upload implements ObjectStorage.
+// The tags and the MD5 of the content are stored in a private extra field.
+func (z *ZipObjectStorage) Upload(ctx context.Context, key string, data io.Reader, tags map[string]string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	// Validate the key
+	if err := ValidateKey(key); err != nil {
+		return err
 	}
 
-	// Add the new/updated file
-	writer, err := zipWriter.Create(key)
+	// Read data with size limits to prevent decompression bombs
+	content, err := ReadLimitedContent(data)
 	if err != nil {
-		zipWriter.Close()
-		tempZipFile.Close()
-		return fmt.Errorf("failed to create new file in zip: %w", err)
+		return err
 	}
 
-	if _, err := writer.Write(content); err != nil {
-		zipWriter.Close()
-		tempZipFile.Close()
-		return fmt.Errorf("failed to write content to zip: %w", err)
+	extra, err := zipExtraWithMetadata(nil, archiveMetadata{
+		MD5:  md5Hex(content),
+		Tags: copyTags(tags),
+	})
+	if err != nil {
+		return err
 	}
 
-	// Finalize the zip file
-	if err := zipWriter.Close(); err != nil {
-		tempZipFile.Close()
-		return fmt.Errorf("failed to close zip writer: %w", err)
-	}
-	tempZipFile.Close()
+	return z.rewrite(func(zipWriter *zip.Writer, existing []*zip.File) error {
+		// Copy all files from the original except the one we're updating
+		for _, file := range existing {
+			if file.Name == key {
+				// Skip, we'll add an updated version later
+				continue
+			}
+			if err := zipWriter.Copy(file); err != nil {
+				return fmt.Errorf("failed to copy file %s: %w", file.Name, err)
+			}
+		}
 
-	// Replace the original zip with the new one using the utility function
-	if err := SafeReplace(tempZipPath, z.zipFilePath); err != nil {
-		return fmt.Errorf("failed to replace original zip file: %w", err)
-	}
+		// Add the new/updated file
+		writer, err := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:     key,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+			Extra:    extra,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create new file in zip: %w", err)
+		}
 
-	return nil
+		if _, err := writer.Write(content); err != nil {
+			return fmt.Errorf("failed to write content to zip: %w", err)
+		}
+		return nil
+	})
 }
 
 // NewZipObjectStorage creates a new object storage backend using a zip file
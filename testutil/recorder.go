@@ -0,0 +1,342 @@
+package testutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// RecordEnv selects the recorder mode, set it to "record" to call the live
+// provider and write the recordings. Otherwise recordings are replayed.
+const RecordEnv = "CLOUDY_RECORD"
+
+// RedactedValue replaces secrets in recordings
+const RedactedValue = "REDACTED"
+
+type RecordMode string
+
+const (
+	ModeReplay RecordMode = "replay"
+	ModeRecord RecordMode = "record"
+)
+
+var ErrNoRecording = errors.New("no recorded call")
+
+// DefaultRedactedFields are the argument and result fields that never appear in recordings
+var DefaultRedactedFields = []string{"password", "pwd", "secret", "token", "apikey", "privatekey"}
+
+// Interaction is one recorded call
+type Interaction struct {
+	Op      string          `json:"op"`
+	Args    json.RawMessage `json:"args,omitempty"`
+	Results json.RawMessage `json:"results,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Recorder captures the calls made to a provider into a golden file during a
+// live run, and replays them in order without the provider. Replayed calls must
+// match the recording, a mismatch fails the test with a diff of the arguments.
+//
+//	rec := testutil.NewRecorder(t, "testdata/recordings/msgraph-users.json")
+//	var live cloudy.UserManager
+//	if rec.Recording() {
+//		live = createLiveUserManager()
+//	}
+//	testutil.TestUserManager(t, testutil.NewRecordingUserManager(rec, live))
+//
+// Record with CLOUDY_RECORD=record go test ./...
+type Recorder struct {
+	// RedactFields are field names (case and separator insensitive) whose values
+	// are replaced with RedactedValue
+	RedactFields []string
+
+	// KnownErrors are returned for replayed errors with the same message, or
+	// wrap the replayed error when it starts with "<message>: ", so errors.Is
+	// works for sentinel errors
+	KnownErrors []error
+
+	t        testing.TB
+	filename string
+	mode     RecordMode
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	next         int
+	secrets      []string
+}
+
+// NewRecorder records or replays the file, depending on CLOUDY_RECORD
+func NewRecorder(t testing.TB, filename string) *Recorder {
+	mode := ModeReplay
+	if strings.EqualFold(os.Getenv(RecordEnv), string(ModeRecord)) {
+		mode = ModeRecord
+	}
+	return NewRecorderMode(t, filename, mode)
+}
+
+// NewRecorderMode records or replays the file. Recordings are written and
+// unused replays are reported when the test ends.
+func NewRecorderMode(t testing.TB, filename string, mode RecordMode) *Recorder {
+	t.Helper()
+	r := &Recorder{
+		RedactFields: DefaultRedactedFields,
+		KnownErrors: []error{
+			cloudy.ErrKeyNotFound,
+			cloudy.ErrOperationNotImplemented,
+			cloudy.ErrInvalidConfiguration,
+			cloudy.ErrUserNotFound,
+			cloudy.ErrUserExists,
+			cloudy.ErrGroupNotFound,
+			cloudy.ErrGroupExists,
+		},
+		t:        t,
+		filename: filename,
+		mode:     mode,
+	}
+
+	if mode == ModeReplay {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("no recording %v, record it with %v=record: %v", filename, RecordEnv, err)
+		}
+		if err := json.Unmarshal(data, &r.interactions); err != nil {
+			t.Fatalf("invalid recording %v: %v", filename, err)
+		}
+	}
+
+	t.Cleanup(r.finish)
+	return r
+}
+
+// Recording is true when calls go to the live provider
+func (r *Recorder) Recording() bool {
+	return r.mode == ModeRecord
+}
+
+// RedactValue removes a secret, e.g. a generated password, wherever it appears
+// in the recording
+func (r *Recorder) RedactValue(secret string) {
+	if secret == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = append(r.secrets, secret)
+}
+
+// finish saves the recording or checks every recorded call was replayed
+func (r *Recorder) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == ModeReplay {
+		if r.next < len(r.interactions) {
+			var ops []string
+			for _, i := range r.interactions[r.next:] {
+				ops = append(ops, i.Op)
+			}
+			r.t.Errorf("%d recorded calls were not made: %v", len(ops), strings.Join(ops, ", "))
+		}
+		return
+	}
+
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		r.t.Errorf("unable to save recording: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.filename), 0755); err != nil {
+		r.t.Errorf("unable to save recording: %v", err)
+		return
+	}
+	if err := os.WriteFile(r.filename, append(data, '\n'), 0644); err != nil {
+		r.t.Errorf("unable to save recording: %v", err)
+	}
+}
+
+// redact encodes the value with secrets removed
+func (r *Recorder) redact(v any) (json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc = r.redactValue(doc)
+	return json.Marshal(doc)
+}
+
+func (r *Recorder) redactValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			if r.isSecretField(k) && child != nil {
+				val[k] = RedactedValue
+				continue
+			}
+			val[k] = r.redactValue(child)
+		}
+		return val
+	case []any:
+		for i, child := range val {
+			val[i] = r.redactValue(child)
+		}
+		return val
+	case string:
+		for _, secret := range r.secrets {
+			val = strings.ReplaceAll(val, secret, RedactedValue)
+		}
+		return val
+	}
+	return v
+}
+
+var fieldSeparators = regexp.MustCompile(`[-_. ]`)
+
+func (r *Recorder) isSecretField(name string) bool {
+	normalized := strings.ToLower(fieldSeparators.ReplaceAllString(name, ""))
+	for _, field := range r.RedactFields {
+		if normalized == field || strings.HasSuffix(normalized, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Call records or replays a call. Args are named so they can be redacted, the
+// result is stored as JSON and decoded again on replay.
+func Call[T any](r *Recorder, op string, args map[string]any, live func() (T, error)) (T, error) {
+	r.t.Helper()
+	var zero T
+
+	encodedArgs, err := r.redactLocked(args)
+	if err != nil {
+		r.t.Fatalf("%v: unable to encode the arguments: %v", op, err)
+		return zero, err
+	}
+
+	if r.mode == ModeRecord {
+		result, callErr := live()
+		interaction := &Interaction{Op: op, Args: encodedArgs}
+		if callErr != nil {
+			interaction.Error = r.redactString(callErr.Error())
+		} else {
+			interaction.Results, err = r.redactLocked(result)
+			if err != nil {
+				r.t.Fatalf("%v: unable to encode the results: %v", op, err)
+			}
+		}
+		r.mu.Lock()
+		r.interactions = append(r.interactions, interaction)
+		r.mu.Unlock()
+		return result, callErr
+	}
+
+	r.mu.Lock()
+	if r.next >= len(r.interactions) {
+		r.mu.Unlock()
+		r.t.Errorf("unexpected call %v%s: %v", op, encodedArgs, ErrNoRecording)
+		return zero, ErrNoRecording
+	}
+	interaction := r.interactions[r.next]
+	r.next++
+	r.mu.Unlock()
+
+	if interaction.Op != op || !jsonEqual(interaction.Args, encodedArgs) {
+		r.t.Errorf("call %d does not match the recording %v\n%s", r.next, r.filename,
+			callDiff(interaction.Op, interaction.Args, op, encodedArgs))
+		return zero, ErrNoRecording
+	}
+
+	if interaction.Error != "" {
+		return zero, r.replayError(interaction.Error)
+	}
+	var result T
+	if len(interaction.Results) > 0 {
+		if err := json.Unmarshal(interaction.Results, &result); err != nil {
+			r.t.Errorf("%v: unable to decode the recorded results: %v", op, err)
+			return zero, err
+		}
+	}
+	return result, nil
+}
+
+// CallErr records or replays a call that only returns an error
+func CallErr(r *Recorder, op string, args map[string]any, live func() error) error {
+	r.t.Helper()
+	_, err := Call(r, op, args, func() (struct{}, error) {
+		return struct{}{}, live()
+	})
+	return err
+}
+
+func (r *Recorder) redactLocked(v any) (json.RawMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.redact(v)
+}
+
+func (r *Recorder) redactString(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.redactValue(s).(string)
+}
+
+// replayedError is a recorded error that is not one of the known errors
+type replayedError struct {
+	msg string
+}
+
+func (e *replayedError) Error() string { return e.msg }
+
+func (r *Recorder) replayError(msg string) error {
+	for _, known := range r.KnownErrors {
+		if known.Error() == msg {
+			return known
+		}
+		// Errors wrapped as "%w: detail"
+		if detail, found := strings.CutPrefix(msg, known.Error()+": "); found {
+			return fmt.Errorf("%w: %s", known, detail)
+		}
+	}
+	return &replayedError{msg: msg}
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return string(a) == string(b)
+	}
+	ca, _ := json.Marshal(va)
+	cb, _ := json.Marshal(vb)
+	return string(ca) == string(cb)
+}
+
+// callDiff is a unified diff of the recorded and the actual call
+func callDiff(recordedOp string, recordedArgs json.RawMessage, op string, args json.RawMessage) string {
+	format := func(op string, args json.RawMessage) []string {
+		var doc any
+		_ = json.Unmarshal(args, &doc)
+		pretty, _ := json.MarshalIndent(doc, "", "  ")
+		return difflib.SplitLines(fmt.Sprintf("%s %s\n", op, pretty))
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        format(recordedOp, recordedArgs),
+		B:        format(op, args),
+		FromFile: "recorded",
+		ToFile:   "actual",
+		Context:  3,
+	})
+	return diff
+}
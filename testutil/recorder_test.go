@@ -0,0 +1,116 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// liveUsers stands in for a live user manager
+type liveUsers struct {
+	cloudy.UserManager
+	users     map[string]*models.User
+	passwords map[string]string
+}
+
+func (m *liveUsers) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	u, ok := m.users[uid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", cloudy.ErrUserNotFound, uid)
+	}
+	return u, nil
+}
+
+func (m *liveUsers) NewUser(ctx context.Context, u *models.User) (*models.User, error) {
+	m.users[u.UID] = u
+	return u, nil
+}
+
+func (m *liveUsers) SetUserPassword(ctx context.Context, uid string, pwd string, mustChange bool) error {
+	m.passwords[uid] = pwd
+	return nil
+}
+
+func exerciseUsers(t *testing.T, users cloudy.UserManager) {
+	ctx := context.Background()
+
+	_, err := users.GetUser(ctx, "jdoe")
+	assert.ErrorIs(t, err, cloudy.ErrUserNotFound)
+
+	created, err := users.NewUser(ctx, &models.User{UID: "jdoe", FirstName: "Jane", Attributes: map[string]string{"apiToken": "tok-123"}})
+	require.Nil(t, err)
+	assert.Equal(t, "Jane", created.FirstName)
+
+	require.Nil(t, users.SetUserPassword(ctx, "jdoe", "hunter2", true))
+
+	found, err := users.GetUser(ctx, "jdoe")
+	require.Nil(t, err)
+	assert.Equal(t, "Jane", found.FirstName)
+}
+
+func TestRecorder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "recordings", "users.json")
+
+	t.Run("record", func(t *testing.T) {
+		rec := NewRecorderMode(t, filename, ModeRecord)
+		assert.True(t, rec.Recording())
+		live := &liveUsers{users: map[string]*models.User{}, passwords: map[string]string{}}
+		exerciseUsers(t, NewRecordingUserManager(rec, live))
+		assert.Equal(t, "hunter2", live.passwords["jdoe"])
+	})
+
+	// Secrets never reach the recording
+	data, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.NotContains(t, string(data), "tok-123")
+	assert.Contains(t, string(data), RedactedValue)
+
+	t.Run("replay", func(t *testing.T) {
+		rec := NewRecorderMode(t, filename, ModeReplay)
+		exerciseUsers(t, NewRecordingUserManager(rec, nil))
+	})
+}
+
+// captureT records failures instead of failing the test
+type captureT struct {
+	testing.TB
+	errors []string
+}
+
+func (c *captureT) Errorf(format string, args ...any) {
+	c.errors = append(c.errors, fmt.Sprintf(format, args...))
+}
+
+func (c *captureT) Helper() {}
+
+func TestRecorder_Mismatch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.json")
+	require.Nil(t, os.WriteFile(filename, []byte(`[
+  {"op": "GetUser", "args": {"uid": "jdoe"}, "results": {"uid": "jdoe"}},
+  {"op": "Enable", "args": {"uid": "jdoe"}}
+]`), 0644))
+
+	capture := &captureT{TB: t}
+	rec := NewRecorderMode(capture, filename, ModeReplay)
+	users := NewRecordingUserManager(rec, nil)
+
+	_, err := users.GetUser(context.Background(), "asmith")
+	assert.ErrorIs(t, err, ErrNoRecording)
+	require.Len(t, capture.errors, 1)
+	assert.Contains(t, capture.errors[0], "--- recorded")
+	assert.Contains(t, capture.errors[0], `-  "uid": "jdoe"`)
+	assert.Contains(t, capture.errors[0], `+  "uid": "asmith"`)
+
+	// The unused call is reported when the test ends
+	rec.finish()
+	require.Len(t, capture.errors, 2)
+	assert.Contains(t, capture.errors[1], "1 recorded calls were not made: Enable")
+}
@@ -0,0 +1,208 @@
+package testutil
+
+import (
+	"context"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/license"
+	"github.com/appliedres/cloudy/models"
+)
+
+var _ cloudy.UserManager = (*RecordingUserManager)(nil)
+var _ cloudy.GroupManager = (*RecordingGroupManager)(nil)
+var _ license.LicenseManager = (*RecordingLicenseManager)(nil)
+
+// RecordingUserManager records the calls to a UserManager, or replays them when
+// the live manager is nil
+type RecordingUserManager struct {
+	Recorder *Recorder
+	Live     cloudy.UserManager
+}
+
+func NewRecordingUserManager(r *Recorder, live cloudy.UserManager) cloudy.UserManager {
+	return &RecordingUserManager{Recorder: r, Live: live}
+}
+
+func (m *RecordingUserManager) ForceUserName(ctx context.Context, name string) (string, bool, error) {
+	type result struct {
+		Name   string
+		Exists bool
+	}
+	rtn, err := Call(m.Recorder, "ForceUserName", map[string]any{"name": name}, func() (result, error) {
+		v, exists, err := m.Live.ForceUserName(ctx, name)
+		return result{Name: v, Exists: exists}, err
+	})
+	return rtn.Name, rtn.Exists, err
+}
+
+func (m *RecordingUserManager) ListUsers(ctx context.Context, filter string, attrs []string) (*[]models.User, error) {
+	return Call(m.Recorder, "ListUsers", map[string]any{"filter": filter, "attrs": attrs}, func() (*[]models.User, error) {
+		return m.Live.ListUsers(ctx, filter, attrs)
+	})
+}
+
+func (m *RecordingUserManager) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	return Call(m.Recorder, "GetUser", map[string]any{"uid": uid}, func() (*models.User, error) {
+		return m.Live.GetUser(ctx, uid)
+	})
+}
+
+func (m *RecordingUserManager) GetUserByEmail(ctx context.Context, email string, opts *cloudy.UserOptions) (*models.User, error) {
+	return Call(m.Recorder, "GetUserByEmail", map[string]any{"email": email, "opts": opts}, func() (*models.User, error) {
+		return m.Live.GetUserByEmail(ctx, email, opts)
+	})
+}
+
+func (m *RecordingUserManager) GetUserWithAttributes(ctx context.Context, uid string, attrs []string) (*models.User, error) {
+	return Call(m.Recorder, "GetUserWithAttributes", map[string]any{"uid": uid, "attrs": attrs}, func() (*models.User, error) {
+		return m.Live.GetUserWithAttributes(ctx, uid, attrs)
+	})
+}
+
+func (m *RecordingUserManager) NewUser(ctx context.Context, newUser *models.User) (*models.User, error) {
+	return Call(m.Recorder, "NewUser", map[string]any{"user": newUser}, func() (*models.User, error) {
+		return m.Live.NewUser(ctx, newUser)
+	})
+}
+
+func (m *RecordingUserManager) UpdateUser(ctx context.Context, usr *models.User) error {
+	return CallErr(m.Recorder, "UpdateUser", map[string]any{"user": usr}, func() error {
+		return m.Live.UpdateUser(ctx, usr)
+	})
+}
+
+func (m *RecordingUserManager) Enable(ctx context.Context, uid string) error {
+	return CallErr(m.Recorder, "Enable", map[string]any{"uid": uid}, func() error {
+		return m.Live.Enable(ctx, uid)
+	})
+}
+
+func (m *RecordingUserManager) Disable(ctx context.Context, uid string) error {
+	return CallErr(m.Recorder, "Disable", map[string]any{"uid": uid}, func() error {
+		return m.Live.Disable(ctx, uid)
+	})
+}
+
+func (m *RecordingUserManager) DeleteUser(ctx context.Context, uid string) error {
+	return CallErr(m.Recorder, "DeleteUser", map[string]any{"uid": uid}, func() error {
+		return m.Live.DeleteUser(ctx, uid)
+	})
+}
+
+func (m *RecordingUserManager) SetUserPassword(ctx context.Context, uid string, pwd string, mustChange bool) error {
+	return CallErr(m.Recorder, "SetUserPassword", map[string]any{"uid": uid, "pwd": pwd, "mustChange": mustChange}, func() error {
+		return m.Live.SetUserPassword(ctx, uid, pwd, mustChange)
+	})
+}
+
+// RecordingGroupManager records the calls to a GroupManager, or replays them when
+// the live manager is nil
+type RecordingGroupManager struct {
+	Recorder *Recorder
+	Live     cloudy.GroupManager
+}
+
+func NewRecordingGroupManager(r *Recorder, live cloudy.GroupManager) cloudy.GroupManager {
+	return &RecordingGroupManager{Recorder: r, Live: live}
+}
+
+func (m *RecordingGroupManager) ListGroups(ctx context.Context, filter string, attrs []string) (*[]models.Group, error) {
+	return Call(m.Recorder, "ListGroups", map[string]any{"filter": filter, "attrs": attrs}, func() (*[]models.Group, error) {
+		return m.Live.ListGroups(ctx, filter, attrs)
+	})
+}
+
+func (m *RecordingGroupManager) GetGroup(ctx context.Context, id string) (*models.Group, error) {
+	return Call(m.Recorder, "GetGroup", map[string]any{"id": id}, func() (*models.Group, error) {
+		return m.Live.GetGroup(ctx, id)
+	})
+}
+
+func (m *RecordingGroupManager) GetGroupId(ctx context.Context, name string) (string, error) {
+	return Call(m.Recorder, "GetGroupId", map[string]any{"name": name}, func() (string, error) {
+		return m.Live.GetGroupId(ctx, name)
+	})
+}
+
+func (m *RecordingGroupManager) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	return Call(m.Recorder, "GetUserGroups", map[string]any{"uid": uid}, func() ([]*models.Group, error) {
+		return m.Live.GetUserGroups(ctx, uid)
+	})
+}
+
+func (m *RecordingGroupManager) NewGroup(ctx context.Context, grp *models.Group) (*models.Group, error) {
+	return Call(m.Recorder, "NewGroup", map[string]any{"group": grp}, func() (*models.Group, error) {
+		return m.Live.NewGroup(ctx, grp)
+	})
+}
+
+func (m *RecordingGroupManager) UpdateGroup(ctx context.Context, grp *models.Group) (bool, error) {
+	return Call(m.Recorder, "UpdateGroup", map[string]any{"group": grp}, func() (bool, error) {
+		return m.Live.UpdateGroup(ctx, grp)
+	})
+}
+
+func (m *RecordingGroupManager) GetGroupMembers(ctx context.Context, grpId string) ([]*models.User, error) {
+	return Call(m.Recorder, "GetGroupMembers", map[string]any{"groupId": grpId}, func() ([]*models.User, error) {
+		return m.Live.GetGroupMembers(ctx, grpId)
+	})
+}
+
+func (m *RecordingGroupManager) RemoveMembers(ctx context.Context, groupId string, userIds []string) error {
+	return CallErr(m.Recorder, "RemoveMembers", map[string]any{"groupId": groupId, "userIds": userIds}, func() error {
+		return m.Live.RemoveMembers(ctx, groupId, userIds)
+	})
+}
+
+func (m *RecordingGroupManager) AddMembers(ctx context.Context, groupId string, userIds []string) error {
+	return CallErr(m.Recorder, "AddMembers", map[string]any{"groupId": groupId, "userIds": userIds}, func() error {
+		return m.Live.AddMembers(ctx, groupId, userIds)
+	})
+}
+
+func (m *RecordingGroupManager) DeleteGroup(ctx context.Context, groupId string) error {
+	return CallErr(m.Recorder, "DeleteGroup", map[string]any{"groupId": groupId}, func() error {
+		return m.Live.DeleteGroup(ctx, groupId)
+	})
+}
+
+// RecordingLicenseManager records the calls to a LicenseManager, or replays them
+// when the live manager is nil
+type RecordingLicenseManager struct {
+	Recorder *Recorder
+	Live     license.LicenseManager
+}
+
+func NewRecordingLicenseManager(r *Recorder, live license.LicenseManager) license.LicenseManager {
+	return &RecordingLicenseManager{Recorder: r, Live: live}
+}
+
+func (m *RecordingLicenseManager) AssignLicense(ctx context.Context, userdId string, licenseSkus ...string) error {
+	return CallErr(m.Recorder, "AssignLicense", map[string]any{"userId": userdId, "skus": licenseSkus}, func() error {
+		return m.Live.AssignLicense(ctx, userdId, licenseSkus...)
+	})
+}
+
+func (m *RecordingLicenseManager) RemoveLicense(ctx context.Context, userdId string, licenseSkus ...string) error {
+	return CallErr(m.Recorder, "RemoveLicense", map[string]any{"userId": userdId, "skus": licenseSkus}, func() error {
+		return m.Live.RemoveLicense(ctx, userdId, licenseSkus...)
+	})
+}
+
+func (m *RecordingLicenseManager) GetUserAssigned(ctx context.Context, userdId string) ([]*license.LicenseDescription, error) {
+	return Call(m.Recorder, "GetUserAssigned", map[string]any{"userId": userdId}, func() ([]*license.LicenseDescription, error) {
+		return m.Live.GetUserAssigned(ctx, userdId)
+	})
+}
+
+func (m *RecordingLicenseManager) GetAssigned(ctx context.Context, licenseSku string) ([]*models.User, error) {
+	return Call(m.Recorder, "GetAssigned", map[string]any{"sku": licenseSku}, func() ([]*models.User, error) {
+		return m.Live.GetAssigned(ctx, licenseSku)
+	})
+}
+
+func (m *RecordingLicenseManager) ListLicenses(ctx context.Context) ([]*license.LicenseDescription, error) {
+	return Call(m.Recorder, "ListLicenses", nil, func() ([]*license.LicenseDescription, error) {
+		return m.Live.ListLicenses(ctx)
+	})
+}
@@ -0,0 +1,484 @@
+package cloudy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/appliedres/cloudy/models"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Filter operators
+const (
+	FilterEq      = "eq"
+	FilterNe      = "ne"
+	FilterCo      = "co"
+	FilterSw      = "sw"
+	FilterEw      = "ew"
+	FilterGt      = "gt"
+	FilterGe      = "ge"
+	FilterLt      = "lt"
+	FilterLe      = "le"
+	FilterPresent = "pr"
+	FilterAnd     = "and"
+	FilterOr      = "or"
+	FilterNot     = "not"
+)
+
+// Filter is a parsed ListUsers / ListGroups filter. The syntax follows the SCIM
+// filter syntax (RFC 7644 3.4.2.2):
+//
+//	username eq "jane.doe"
+//	email ew "@example.com" and enabled eq true
+//	not (displayName co "test") or attributes.department pr
+//
+// Attribute names and string comparisons are case insensitive. Values compare
+// as numbers when both sides are numbers. An empty filter matches everything.
+type Filter struct {
+	// Op is the operator. Comparisons have an Attr and a Value, "and" / "or"
+	// have two Children and "not" has one.
+	Op       string
+	Attr     string
+	Value    string
+	Children []*Filter
+}
+
+// StandardFilterLister is implemented by user and group managers whose
+// ListUsers and ListGroups filters are ParseFilter expressions. Other managers
+// have their own filter syntax, so parsed filters are only passed to managers
+// that implement it.
+type StandardFilterLister interface {
+	UsesStandardFilters()
+}
+
+// UsesStandardFilters reports whether the manager accepts ParseFilter expressions
+func UsesStandardFilters(manager any) bool {
+	_, ok := manager.(StandardFilterLister)
+	return ok
+}
+
+// FilterValues returns the values of an attribute, found is false when the
+// attribute is not set
+type FilterValues func(attr string) (values []string, found bool)
+
+// ParseFilter parses a filter expression, an empty expression returns nil
+func ParseFilter(expr string) (*Filter, error) {
+	p := &filterParser{input: expr}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 0 {
+		return nil, nil
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+// Match evaluates the filter, a nil filter matches everything
+func (f *Filter) Match(values FilterValues) bool {
+	if f == nil {
+		return true
+	}
+	switch f.Op {
+	case FilterAnd:
+		return f.Children[0].Match(values) && f.Children[1].Match(values)
+	case FilterOr:
+		return f.Children[0].Match(values) || f.Children[1].Match(values)
+	case FilterNot:
+		return !f.Children[0].Match(values)
+	}
+
+	vals, found := values(f.Attr)
+	if f.Op == FilterPresent {
+		for _, v := range vals {
+			if v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if !found || len(vals) == 0 {
+		// A missing attribute only matches "ne"
+		return f.Op == FilterNe
+	}
+	if f.Op == FilterNe {
+		for _, v := range vals {
+			if compareFilterValue(FilterEq, v, f.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range vals {
+		if compareFilterValue(f.Op, v, f.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchUser evaluates the filter against a user
+func (f *Filter) MatchUser(u *models.User) bool {
+	return f.Match(func(attr string) ([]string, bool) {
+		v, ok := UserFilterValue(u, attr)
+		return []string{v}, ok
+	})
+}
+
+// MatchGroup evaluates the filter against a group
+func (f *Filter) MatchGroup(g *models.Group) bool {
+	return f.Match(func(attr string) ([]string, bool) {
+		v, ok := GroupFilterValue(g, attr)
+		return []string{v}, ok
+	})
+}
+
+// Attributes returns the attribute names used in the filter
+func (f *Filter) Attributes() []string {
+	if f == nil {
+		return nil
+	}
+	if f.Attr != "" {
+		return []string{f.Attr}
+	}
+	var rtn []string
+	for _, c := range f.Children {
+		rtn = append(rtn, c.Attributes()...)
+	}
+	return rtn
+}
+
+// RenameAttributes returns a copy of the filter with the attribute names
+// replaced, e.g. to translate a filter for another provider
+func (f *Filter) RenameAttributes(rename func(attr string) string) *Filter {
+	if f == nil {
+		return nil
+	}
+	rtn := &Filter{Op: f.Op, Value: f.Value}
+	if f.Attr != "" {
+		rtn.Attr = rename(f.Attr)
+	}
+	for _, c := range f.Children {
+		rtn.Children = append(rtn.Children, c.RenameAttributes(rename))
+	}
+	return rtn
+}
+
+// String formats the filter in the filter syntax
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	switch f.Op {
+	case FilterAnd, FilterOr:
+		return f.child(0, f.Op) + " " + f.Op + " " + f.child(1, f.Op)
+	case FilterNot:
+		return "not (" + f.Children[0].String() + ")"
+	case FilterPresent:
+		return f.Attr + " pr"
+	}
+	return f.Attr + " " + f.Op + " " + formatFilterValue(f.Value)
+}
+
+// child formats a child, adding parentheses when an "or" is inside an "and"
+func (f *Filter) child(i int, parent string) string {
+	c := f.Children[i]
+	if c.Op == FilterOr && parent == FilterAnd {
+		return "(" + c.String() + ")"
+	}
+	return c.String()
+}
+
+func formatFilterValue(v string) string {
+	switch strings.ToLower(v) {
+	case "true", "false", "null":
+		return strings.ToLower(v)
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return v
+	}
+	return strconv.Quote(v)
+}
+
+// UserFilterValue returns the value of a user attribute by its filter name:
+// uid (or id), username, email, displayName, firstName, lastName, enabled, or
+// attributes.<name> for the extra attributes
+func UserFilterValue(u *models.User, attr string) (string, bool) {
+	if name, ok := cutPrefixFold(attr, "attributes."); ok {
+		for k, v := range u.Attributes {
+			if strings.EqualFold(k, name) {
+				return v, true
+			}
+		}
+		return "", false
+	}
+	switch strings.ToLower(attr) {
+	case "uid", "id":
+		return u.UID, true
+	case "username":
+		return u.Username, true
+	case "email":
+		return u.Email, true
+	case "displayname":
+		return u.DisplayName, true
+	case "firstname":
+		return u.FirstName, true
+	case "lastname":
+		return u.LastName, true
+	case "enabled":
+		return strconv.FormatBool(u.Enabled), true
+	}
+	return "", false
+}
+
+// GroupFilterValue returns the value of a group attribute by its filter name:
+// id, name, source or type
+func GroupFilterValue(g *models.Group, attr string) (string, bool) {
+	switch strings.ToLower(attr) {
+	case "id":
+		return g.ID, true
+	case "name":
+		return g.Name, true
+	case "source":
+		return g.Source, true
+	case "type":
+		return g.Type, true
+	}
+	return "", false
+}
+
+// FilterUserAttributes limits the extra attributes of a user to the names
+// requested. All attributes are kept when no names are given.
+func FilterUserAttributes(u *models.User, attrs []string) *models.User {
+	if len(attrs) == 0 || u.Attributes == nil {
+		return u
+	}
+	rtn := *u
+	rtn.Attributes = make(map[string]string)
+	for k, v := range u.Attributes {
+		for _, a := range attrs {
+			if strings.EqualFold(k, a) {
+				rtn.Attributes[k] = v
+				break
+			}
+		}
+	}
+	return &rtn
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+func compareFilterValue(op string, actual string, expected string) bool {
+	a, aErr := strconv.ParseFloat(actual, 64)
+	e, eErr := strconv.ParseFloat(expected, 64)
+	if aErr == nil && eErr == nil {
+		switch op {
+		case FilterEq:
+			return a == e
+		case FilterGt:
+			return a > e
+		case FilterGe:
+			return a >= e
+		case FilterLt:
+			return a < e
+		case FilterLe:
+			return a <= e
+		}
+	}
+
+	actual = strings.ToLower(actual)
+	expected = strings.ToLower(expected)
+	switch op {
+	case FilterEq:
+		return actual == expected
+	case FilterCo:
+		return strings.Contains(actual, expected)
+	case FilterSw:
+		return strings.HasPrefix(actual, expected)
+	case FilterEw:
+		return strings.HasSuffix(actual, expected)
+	case FilterGt:
+		return actual > expected
+	case FilterGe:
+		return actual >= expected
+	case FilterLt:
+		return actual < expected
+	case FilterLe:
+		return actual <= expected
+	}
+	return false
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+type filterParser struct {
+	input  string
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) tokenize() error {
+	s := p.input
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			p.tokens = append(p.tokens, filterToken{text: string(c)})
+			i++
+		case c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				sb.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			p.tokens = append(p.tokens, filterToken{text: sb.String(), quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\r\n()\"", rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, filterToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *filterParser) peekKeyword(kw string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, kw)
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, fmt.Errorf("%w: unexpected end of filter", ErrInvalidFilter)
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *filterParser) parseOr() (*Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword(FilterOr) {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Filter{Op: FilterOr, Children: []*Filter{left, right}}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (*Filter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword(FilterAnd) {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &Filter{Op: FilterAnd, Children: []*Filter{left, right}}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (*Filter, error) {
+	if p.peekKeyword(FilterNot) {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{Op: FilterNot, Children: []*Filter{inner}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (*Filter, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !t.quoted && t.text == "(" {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, err := p.next()
+		if err != nil || closing.quoted || closing.text != ")" {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidFilter)
+		}
+		return inner, nil
+	}
+	if t.quoted || t.text == ")" {
+		return nil, fmt.Errorf("%w: expected an attribute, found %q", ErrInvalidFilter, t.text)
+	}
+
+	attr := t.text
+	opToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(opToken.text)
+	if opToken.quoted {
+		return nil, fmt.Errorf("%w: expected an operator after %s", ErrInvalidFilter, attr)
+	}
+	switch op {
+	case FilterPresent:
+		return &Filter{Op: op, Attr: attr}, nil
+	case FilterEq, FilterNe, FilterCo, FilterSw, FilterEw, FilterGt, FilterGe, FilterLt, FilterLe:
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, opToken.text)
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !value.quoted {
+		switch strings.ToLower(value.text) {
+		case "true", "false":
+			value.text = strings.ToLower(value.text)
+		case "null":
+			value.text = ""
+		default:
+			if _, err := strconv.ParseFloat(value.text, 64); err != nil {
+				return nil, fmt.Errorf("%w: value %q must be quoted", ErrInvalidFilter, value.text)
+			}
+		}
+	}
+	return &Filter{Op: op, Attr: attr, Value: value.text}, nil
+}
@@ -0,0 +1,99 @@
+package cloudy
+
+import (
+	"testing"
+
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMatchUser(t *testing.T) {
+	u := &models.User{
+		UID:         "1",
+		Username:    "jane.doe",
+		Email:       "Jane.Doe@example.com",
+		DisplayName: "Jane Doe",
+		Enabled:     true,
+		Attributes:  map[string]string{"department": "Engineering", "level": "10"},
+	}
+
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"", true},
+		{`username eq "jane.doe"`, true},
+		{`UserName EQ "JANE.DOE"`, true},
+		{`email ew "@example.com"`, true},
+		{`displayName co "oe"`, true},
+		{`displayName sw "John"`, false},
+		{`enabled eq true`, true},
+		{`enabled eq false`, false},
+		{`attributes.department eq "engineering"`, true},
+		{`attributes.level gt 9`, true},
+		{`attributes.level lt 9`, false},
+		{`attributes.missing pr`, false},
+		{`attributes.missing ne "x"`, true},
+		{`attributes.department pr and not (username eq "bob")`, true},
+		{`username eq "bob" or username eq "jane.doe"`, true},
+		{`username eq "bob" and (enabled eq true or enabled eq false)`, false},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.filter)
+		require.Nil(t, err, tt.filter)
+		assert.Equal(t, tt.match, f.MatchUser(u), tt.filter)
+	}
+}
+
+func TestFilterMatchGroup(t *testing.T) {
+	g := &models.Group{ID: "g1", Name: "Admins", Type: "security"}
+
+	f, err := ParseFilter(`name eq "admins" and type eq "security"`)
+	require.Nil(t, err)
+	assert.True(t, f.MatchGroup(g))
+
+	f, err = ParseFilter(`id eq "g2"`)
+	require.Nil(t, err)
+	assert.False(t, f.MatchGroup(g))
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		`username`,
+		`username eq`,
+		`username like "x"`,
+		`username eq jane`,
+		`(username eq "x"`,
+		`username eq "x" and`,
+		`username eq "unterminated`,
+		`username eq "x")`,
+	} {
+		_, err := ParseFilter(expr)
+		assert.ErrorIs(t, err, ErrInvalidFilter, expr)
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	f, err := ParseFilter(`username eq "a" and (email co "b" or not enabled eq true) and level ge 3 and x pr`)
+	require.Nil(t, err)
+	assert.Equal(t, `username eq "a" and (email co "b" or not (enabled eq true)) and level ge 3 and x pr`, f.String())
+
+	again, err := ParseFilter(f.String())
+	require.Nil(t, err)
+	assert.Equal(t, f, again)
+
+	renamed := f.RenameAttributes(func(attr string) string { return "u." + attr })
+	assert.Equal(t, []string{"u.username", "u.email", "u.enabled", "u.level", "u.x"}, renamed.Attributes())
+	assert.Equal(t, []string{"username", "email", "enabled", "level", "x"}, f.Attributes())
+}
+
+func TestFilterUserAttributes(t *testing.T) {
+	u := &models.User{UID: "1", Attributes: map[string]string{"a": "1", "b": "2"}}
+
+	assert.Equal(t, u, FilterUserAttributes(u, nil))
+
+	limited := FilterUserAttributes(u, []string{"A"})
+	assert.Equal(t, map[string]string{"a": "1"}, limited.Attributes)
+	assert.Len(t, u.Attributes, 2)
+}
@@ -2,12 +2,19 @@ package cloudy
 
 import (
 	"context"
+	"errors"
 
 	"github.com/appliedres/cloudy/models"
 )
 
 var UserProviders = NewProviderRegistry[UserManager]()
 
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrUserExists      = errors.New("user already exists")
+	ErrInvalidPassword = errors.New("password does not meet the password policy")
+)
+
 type UserOptions struct {
 	IncludeLastSignIn *bool
 }
@@ -0,0 +1,101 @@
+package vm
+
+import (
+	"context"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
+)
+
+var _ VirtualDesktopOrchestrator = (*ResilientVirtualDesktopOrchestrator)(nil)
+var _ cloudy.ProviderWrapper = (*ResilientVirtualDesktopOrchestrator)(nil)
+
+// ResilientVirtualDesktopOrchestrator guards the orchestrator calls with a
+// cloudy.Resilience
+type ResilientVirtualDesktopOrchestrator struct {
+	Orchestrator VirtualDesktopOrchestrator
+	Resilience   *cloudy.Resilience
+}
+
+func NewResilientVirtualDesktopOrchestrator(orchestrator VirtualDesktopOrchestrator, r *cloudy.Resilience) VirtualDesktopOrchestrator {
+	return &ResilientVirtualDesktopOrchestrator{Orchestrator: orchestrator, Resilience: r}
+}
+
+// Unwrap returns the orchestrator
+func (o *ResilientVirtualDesktopOrchestrator) Unwrap() any {
+	return o.Orchestrator
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) GetAllVirtualMachines(ctx context.Context, attrs []string, includeState bool) (*[]models.VirtualMachine, error) {
+	return cloudy.ResilientCall(ctx, o.Resilience, "GetAllVirtualMachines", func(ctx context.Context) (*[]models.VirtualMachine, error) {
+		return o.Orchestrator.GetAllVirtualMachines(ctx, attrs, includeState)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) GetVirtualMachine(ctx context.Context, id string, includeState bool) (*models.VirtualMachine, error) {
+	return cloudy.ResilientCall(ctx, o.Resilience, "GetVirtualMachine", func(ctx context.Context) (*models.VirtualMachine, error) {
+		return o.Orchestrator.GetVirtualMachine(ctx, id, includeState)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) CreateVirtualMachine(ctx context.Context, vm *models.VirtualMachine) (*models.VirtualMachine, error) {
+	return cloudy.ResilientWrite(ctx, o.Resilience, "CreateVirtualMachine", func(ctx context.Context) (*models.VirtualMachine, error) {
+		return o.Orchestrator.CreateVirtualMachine(ctx, vm)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) UpdateVirtualMachine(ctx context.Context, vm *models.VirtualMachine) (*models.VirtualMachine, error) {
+	return cloudy.ResilientCall(ctx, o.Resilience, "UpdateVirtualMachine", func(ctx context.Context) (*models.VirtualMachine, error) {
+		return o.Orchestrator.UpdateVirtualMachine(ctx, vm)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) StartVirtualMachine(ctx context.Context, vm *models.VirtualMachine) error {
+	return o.Resilience.Do(ctx, "StartVirtualMachine", func(ctx context.Context) error {
+		return o.Orchestrator.StartVirtualMachine(ctx, vm)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) StopVirtualMachine(ctx context.Context, vm *models.VirtualMachine) error {
+	return o.Resilience.Do(ctx, "StopVirtualMachine", func(ctx context.Context) error {
+		return o.Orchestrator.StopVirtualMachine(ctx, vm)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) DeleteVirtualMachine(ctx context.Context, vm *models.VirtualMachine) error {
+	return o.Resilience.Do(ctx, "DeleteVirtualMachine", func(ctx context.Context) error {
+		return o.Orchestrator.DeleteVirtualMachine(ctx, vm)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) GetAllVirtualMachineSizes(ctx context.Context) (map[string]*models.VirtualMachineSize, error) {
+	return cloudy.ResilientCall(ctx, o.Resilience, "GetAllVirtualMachineSizes", func(ctx context.Context) (map[string]*models.VirtualMachineSize, error) {
+		return o.Orchestrator.GetAllVirtualMachineSizes(ctx)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) GetVirtualMachineSizesForTemplate(ctx context.Context, template models.VirtualMachineTemplate) (
+	matches map[string]*models.VirtualMachineSize,
+	worse map[string]*models.VirtualMachineSize,
+	better map[string]*models.VirtualMachineSize,
+	err error) {
+
+	err = o.Resilience.Do(ctx, "GetVirtualMachineSizesForTemplate", func(ctx context.Context) error {
+		var callErr error
+		matches, worse, better, callErr = o.Orchestrator.GetVirtualMachineSizesForTemplate(ctx, template)
+		return callErr
+	})
+	return matches, worse, better, err
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) GetVirtualMachineSizesWithUsage(ctx context.Context) (map[string]*models.VirtualMachineSize, error) {
+	return cloudy.ResilientCall(ctx, o.Resilience, "GetVirtualMachineSizesWithUsage", func(ctx context.Context) (map[string]*models.VirtualMachineSize, error) {
+		return o.Orchestrator.GetVirtualMachineSizesWithUsage(ctx)
+	})
+}
+
+func (o *ResilientVirtualDesktopOrchestrator) GetVirtualMachineUsage(ctx context.Context) (map[string]models.VirtualMachineFamily, error) {
+	return cloudy.ResilientCall(ctx, o.Resilience, "GetVirtualMachineUsage", func(ctx context.Context) (map[string]models.VirtualMachineFamily, error) {
+		return o.Orchestrator.GetVirtualMachineUsage(ctx)
+	})
+}